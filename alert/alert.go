@@ -0,0 +1,379 @@
+// Package alert implements an alerting engine evaluating declarative
+// rules against the attributes and the error history of Vitotrol™
+// devices, and notifying the resulting alerts through pluggable sinks.
+package alert
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/TomTom68/go-vitotrol"
+)
+
+// DefaultInterval is the default duration between two polls of Run.
+const DefaultInterval = 5 * time.Minute
+
+// Alert is raised when a rule starts firing, or stops firing if the
+// rule has NotifyResolved set.
+type Alert struct {
+	Rule         string    `json:"rule"`
+	LocationID   uint32    `json:"location_id"`
+	DeviceID     uint32    `json:"device_id"`
+	DeviceName   string    `json:"device_name"`
+	Time         time.Time `json:"time"`
+	Attribute    string    `json:"attribute,omitempty"`     // attribute rules
+	Value        string    `json:"value,omitempty"`         // attribute rules, human value
+	Code         string    `json:"code,omitempty"`          // error rules, FehlerCode
+	ErrorMessage string    `json:"error_message,omitempty"` // error rules, FehlerMeldung
+	Resolved     bool      `json:"resolved"`
+	Message      string    `json:"message"`
+}
+
+// String returns a one line description of the alert.
+func (a *Alert) String() string {
+	return fmt.Sprintf("%s %d@%d %s: %s",
+		vitotrol.Time(a.Time), a.DeviceID, a.LocationID, a.Rule, a.Message)
+}
+
+// firingKey identifies a firing rule for a device. code is only set
+// for error rules, so each FehlerCode is alerted once while it stays
+// active.
+type firingKey struct {
+	locationID uint32
+	deviceID   uint32
+	rule       string
+	code       string
+}
+
+// Engine evaluates rules and notifies the resulting alerts to
+// sinks. It remembers which rules are firing for each device, so an
+// alert is only raised when a rule starts (or stops) firing.
+type Engine struct {
+	rules    []Rule
+	sinks    []Sink
+	interval time.Duration
+	refresh  bool
+	logger   vitotrol.Logger
+	registry *vitotrol.AttributeRegistry
+
+	mu     sync.Mutex // protects firing
+	firing map[firingKey]bool
+}
+
+// An Option allows to customize an Engine created by NewEngine.
+type Option func(*Engine)
+
+// WithInterval sets the duration between two polls of Run method
+// instead of DefaultInterval.
+func WithInterval(interval time.Duration) Option {
+	return func(e *Engine) {
+		e.interval = interval
+	}
+}
+
+// WithRefresh tells whether the attributes are refreshed, using
+// RefreshDataWait, before being read by Poll. It is true by default.
+func WithRefresh(refresh bool) Option {
+	return func(e *Engine) {
+		e.refresh = refresh
+	}
+}
+
+// WithLogger sets the logger used to report poll and notification
+// errors. It defaults to vitotrol.StdLogger(false).
+func WithLogger(logger vitotrol.Logger) Option {
+	return func(e *Engine) {
+		e.logger = logger
+	}
+}
+
+// WithRegistry sets the attribute registry used to resolve the
+// attributes of the rules, instead of the built-in attributes (see
+// vitotrol.NewAttributeRegistry).
+func WithRegistry(registry *vitotrol.AttributeRegistry) Option {
+	return func(e *Engine) {
+		e.registry = registry
+	}
+}
+
+// NewEngine returns a new Engine evaluating rules and notifying
+// sinks. The rules are checked the same way as ReadConfig does.
+func NewEngine(rules []Rule, sinks []Sink, opts ...Option) (*Engine, error) {
+	e := &Engine{
+		rules:    make([]Rule, len(rules)),
+		sinks:    sinks,
+		interval: DefaultInterval,
+		refresh:  true,
+		logger:   vitotrol.StdLogger(false),
+		firing:   map[firingKey]bool{},
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.registry == nil {
+		e.registry = vitotrol.NewAttributeRegistry()
+	}
+
+	names := make(map[string]bool, len(rules))
+	for idx, rule := range rules {
+		err := rule.compile(e.registry)
+		if err != nil {
+			return nil, err
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("duplicate rule %s", rule.Name)
+		}
+		names[rule.Name] = true
+		e.rules[idx] = rule
+	}
+	return e, nil
+}
+
+// Attributes returns the attributes needed by the attribute rules.
+func (e *Engine) Attributes() []vitotrol.AttrID {
+	seen := map[vitotrol.AttrID]bool{}
+	var attrs []vitotrol.AttrID
+	for idx := range e.rules {
+		rule := &e.rules[idx]
+		if !rule.Errors && !seen[rule.attrID] {
+			seen[rule.attrID] = true
+			attrs = append(attrs, rule.attrID)
+		}
+	}
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i] < attrs[j] })
+	return attrs
+}
+
+// NeedErrors tells whether at least one rule is an error rule.
+func (e *Engine) NeedErrors() bool {
+	for idx := range e.rules {
+		if e.rules[idx].Errors {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluate evaluates the rules against values and events of device d
+// and returns the raised alerts. Attribute rules are skipped if their
+// attribute is not in values, error rules are skipped if events is
+// nil. Evaluate does not notify the alerts, so it can be used as a
+// dry-run evaluator.
+func (e *Engine) Evaluate(d *vitotrol.Device, values map[vitotrol.AttrID]vitotrol.Value, events []vitotrol.ErrorHistoryEvent) []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var alerts []Alert
+	for idx := range e.rules {
+		rule := &e.rules[idx]
+		if rule.Errors {
+			if events != nil {
+				alerts = e.evaluateErrors(alerts, rule, d, events)
+			}
+			continue
+		}
+
+		value, ok := values[rule.attrID]
+		if !ok {
+			continue
+		}
+
+		key := firingKey{
+			locationID: d.LocationID,
+			deviceID:   d.DeviceID,
+			rule:       rule.Name,
+		}
+		match, human := rule.match(value.Value)
+		if match == e.firing[key] {
+			continue
+		}
+		if match {
+			e.firing[key] = true
+		} else {
+			delete(e.firing, key)
+			if !rule.NotifyResolved {
+				continue
+			}
+		}
+
+		alerts = append(alerts, e.newAlert(rule, d, Alert{
+			Time:      time.Time(value.Time),
+			Attribute: rule.Attribute,
+			Value:     human,
+			Resolved:  !match,
+		}))
+	}
+	return alerts
+}
+
+// evaluateErrors raises an alert for each newly active error code
+// handled by rule, and for each code no longer active if rule has
+// NotifyResolved set.
+func (e *Engine) evaluateErrors(alerts []Alert, rule *Rule, d *vitotrol.Device, events []vitotrol.ErrorHistoryEvent) []Alert {
+	active := map[string]bool{}
+	for _, event := range events {
+		if !event.IsActive || !rule.matchCode(event.Error) || active[event.Error] {
+			continue
+		}
+		active[event.Error] = true
+
+		key := firingKey{
+			locationID: d.LocationID,
+			deviceID:   d.DeviceID,
+			rule:       rule.Name,
+			code:       event.Error,
+		}
+		if e.firing[key] {
+			continue
+		}
+		e.firing[key] = true
+
+		alerts = append(alerts, e.newAlert(rule, d, Alert{
+			Time:         time.Time(event.Time),
+			Code:         event.Error,
+			ErrorMessage: event.Message,
+		}))
+	}
+
+	var resolved []string
+	for key := range e.firing {
+		if key.locationID == d.LocationID && key.deviceID == d.DeviceID &&
+			key.rule == rule.Name && !active[key.code] {
+			delete(e.firing, key)
+			resolved = append(resolved, key.code)
+		}
+	}
+	if rule.NotifyResolved {
+		sort.Strings(resolved)
+		for _, code := range resolved {
+			alerts = append(alerts, e.newAlert(rule, d, Alert{
+				Time:     time.Now(),
+				Code:     code,
+				Resolved: true,
+			}))
+		}
+	}
+	return alerts
+}
+
+func (e *Engine) newAlert(rule *Rule, d *vitotrol.Device, a Alert) Alert {
+	a.Rule = rule.Name
+	a.LocationID = d.LocationID
+	a.DeviceID = d.DeviceID
+	a.DeviceName = d.DeviceName
+	a.Message = rule.message(&a)
+	return a
+}
+
+// Notify sends each alert to all sinks. All sinks are tried even if
+// some fail, the first error is returned.
+func (e *Engine) Notify(ctx context.Context, alerts []Alert) error {
+	var firstErr error
+	for idx := range alerts {
+		for _, sink := range e.sinks {
+			err := sink.Notify(ctx, &alerts[idx])
+			if err != nil {
+				e.logger.Warn("cannot notify alert",
+					"rule", alerts[idx].Rule, "error", err)
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+		}
+	}
+	return firstErr
+}
+
+// Poll reads the attributes and error history needed by the rules
+// for all devices of v, then evaluates the rules and notifies the
+// raised alerts. GetDevices is called first if v has no devices
+// yet. A device whose reading fails is skipped, the first error is
+// returned after all devices are handled.
+func (e *Engine) Poll(ctx context.Context, v *vitotrol.Session) ([]Alert, error) {
+	if len(v.DeviceList()) == 0 {
+		err := v.GetDevicesContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	attrs := e.Attributes()
+	needErrors := e.NeedErrors()
+
+	var (
+		alerts   []Alert
+		firstErr error
+	)
+	setErr := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for _, d := range v.DeviceList() {
+		var values map[vitotrol.AttrID]vitotrol.Value
+		if len(attrs) > 0 {
+			if e.refresh {
+				ch, err := d.RefreshDataWaitContext(ctx, v, attrs)
+				if err == nil {
+					err = <-ch
+				}
+				if err != nil {
+					// Values cached by the server are read anyway
+					setErr(err)
+				}
+			}
+
+			err := d.GetDataContext(ctx, v, attrs)
+			if err != nil {
+				setErr(err)
+			} else {
+				values = d.AttributesSnapshot()
+			}
+		}
+
+		var events []vitotrol.ErrorHistoryEvent
+		if needErrors {
+			err := d.GetErrorHistoryContext(ctx, v)
+			if err != nil {
+				setErr(err)
+			} else {
+				events = d.ErrorsSnapshot()
+				if events == nil {
+					events = []vitotrol.ErrorHistoryEvent{}
+				}
+			}
+		}
+
+		alerts = append(alerts, e.Evaluate(d, values, events)...)
+	}
+
+	err := e.Notify(ctx, alerts)
+	if err != nil {
+		setErr(err)
+	}
+	return alerts, firstErr
+}
+
+// Run polls immediately, then each interval until ctx is done. Poll
+// errors are logged. It always returns ctx.Err().
+func (e *Engine) Run(ctx context.Context, v *vitotrol.Session) error {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := e.Poll(ctx, v)
+		if err != nil && ctx.Err() == nil {
+			e.logger.Warn("alert poll failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
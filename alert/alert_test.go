@@ -0,0 +1,248 @@
+package alert_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	td "github.com/maxatome/go-testdeep"
+
+	"github.com/TomTom68/go-vitotrol"
+	"github.com/TomTom68/go-vitotrol/alert"
+	"github.com/TomTom68/go-vitotrol/vitotroltest"
+)
+
+var testTime = time.Date(2022, 1, 2, 3, 4, 5, 0, time.Local)
+
+var testRules = []alert.Rule{
+	{Name: "frost", Attribute: "AussenTemp", Op: "<", Value: "0"},
+	{
+		Name:           "burner",
+		Attribute:      "BrennerStatus",
+		Op:             "==",
+		Value:          "Ein",
+		Message:        "burner {{.Value}} on {{.DeviceName}}",
+		NotifyResolved: true,
+	},
+	{Name: "faults", Errors: true, NotifyResolved: true},
+}
+
+func TestEvaluate(tt *testing.T) {
+	t := td.NewT(tt)
+
+	e, err := alert.NewEngine(testRules, nil)
+	t.FailureIsFatal().CmpNoError(err)
+
+	t.Cmp(e.Attributes(), []vitotrol.AttrID{vitotrol.BrennerStatus, vitotrol.AussenTemp})
+
+	d := &vitotrol.Device{LocationID: 5678, DeviceID: 1234, DeviceName: "Vitodens"}
+	values := func(temp, burner string) map[vitotrol.AttrID]vitotrol.Value {
+		return map[vitotrol.AttrID]vitotrol.Value{
+			vitotrol.AussenTemp:    {Value: temp, Time: vitotrol.Time(testTime)},
+			vitotrol.BrennerStatus: {Value: burner, Time: vitotrol.Time(testTime)},
+		}
+	}
+	f4 := vitotrol.ErrorHistoryEvent{
+		Error:    "F4",
+		Message:  "Flamme fehlt",
+		Time:     vitotrol.Time(testTime),
+		IsActive: true,
+	}
+
+	t.Nil(e.Evaluate(d, values("5", "0"), []vitotrol.ErrorHistoryEvent{}))
+
+	// Rules start firing
+	t.Cmp(e.Evaluate(d, values("-2.5", "1"), []vitotrol.ErrorHistoryEvent{f4, f4}),
+		[]alert.Alert{
+			{
+				Rule:       "frost",
+				LocationID: 5678,
+				DeviceID:   1234,
+				DeviceName: "Vitodens",
+				Time:       testTime,
+				Attribute:  "AussenTemp",
+				Value:      "-2.5",
+				Message:    "AussenTemp < 0 (value: -2.5)",
+			},
+			{
+				Rule:       "burner",
+				LocationID: 5678,
+				DeviceID:   1234,
+				DeviceName: "Vitodens",
+				Time:       testTime,
+				Attribute:  "BrennerStatus",
+				Value:      "Ein",
+				Message:    "burner Ein on Vitodens",
+			},
+			{
+				Rule:         "faults",
+				LocationID:   5678,
+				DeviceID:     1234,
+				DeviceName:   "Vitodens",
+				Time:         testTime,
+				Code:         "F4",
+				ErrorMessage: "Flamme fehlt",
+				Message:      "error F4: Flamme fehlt",
+			},
+		})
+
+	// Still firing: deduplicated, even with a new F4 event
+	f4bis := f4
+	f4bis.Time = vitotrol.Time(testTime.Add(time.Hour))
+	t.Nil(e.Evaluate(d, values("-3", "1"), []vitotrol.ErrorHistoryEvent{f4bis, f4}))
+
+	// Other devices have their own state
+	other := &vitotrol.Device{LocationID: 5678, DeviceID: 4321}
+	t.Len(e.Evaluate(other, values("-3", "0"), nil), 1)
+
+	// No error history read: error rules untouched
+	t.Nil(e.Evaluate(d, nil, nil))
+
+	// Rules stop firing, frost has no NotifyResolved
+	f4.IsActive = false
+	t.Cmp(e.Evaluate(d, values("1", "0"), []vitotrol.ErrorHistoryEvent{f4}),
+		td.Slice([]alert.Alert{}, td.ArrayEntries{
+			0: alert.Alert{
+				Rule:       "burner",
+				LocationID: 5678,
+				DeviceID:   1234,
+				DeviceName: "Vitodens",
+				Time:       testTime,
+				Attribute:  "BrennerStatus",
+				Value:      "Aus",
+				Resolved:   true,
+				Message:    "burner Aus on Vitodens",
+			},
+			1: td.SStruct(alert.Alert{
+				Rule:       "faults",
+				LocationID: 5678,
+				DeviceID:   1234,
+				DeviceName: "Vitodens",
+				Code:       "F4",
+				Resolved:   true,
+				Message:    "resolved: error F4",
+			}, td.StructFields{"Time": td.Not(time.Time{})}),
+		}))
+
+	// F4 active again: new alert
+	f4.IsActive = true
+	t.Cmp(e.Evaluate(d, nil, []vitotrol.ErrorHistoryEvent{f4}),
+		[]alert.Alert{{
+			Rule:         "faults",
+			LocationID:   5678,
+			DeviceID:     1234,
+			DeviceName:   "Vitodens",
+			Time:         testTime,
+			Code:         "F4",
+			ErrorMessage: "Flamme fehlt",
+			Message:      "error F4: Flamme fehlt",
+		}})
+
+	// Codes restriction
+	e, err = alert.NewEngine([]alert.Rule{{Name: "f2", Errors: true, Codes: []string{"F2"}}}, nil)
+	t.FailureIsFatal().CmpNoError(err)
+	t.Nil(e.Evaluate(d, nil, []vitotrol.ErrorHistoryEvent{f4}))
+	t.True(e.NeedErrors())
+	t.Nil(e.Attributes())
+
+	_, err = alert.NewEngine([]alert.Rule{{Name: "x"}}, nil)
+	t.Cmp(err, td.String("rule x: unknown attribute `'"))
+}
+
+type failingSink struct{}
+
+func (failingSink) Notify(context.Context, *alert.Alert) error {
+	return context.DeadlineExceeded
+}
+
+func TestPoll(tt *testing.T) {
+	t := td.NewT(tt)
+
+	dev := vitotroltest.NewDevice(1234, 5678)
+	dev.Values[vitotrol.AussenTemp] = "-3.5"
+	srv := vitotroltest.NewServer(vitotroltest.WithDevice(dev))
+	defer srv.Close()
+
+	v := vitotrol.NewSession(vitotrol.WithURL(srv.URL),
+		vitotrol.WithRetryPolicy(vitotrol.RetryPolicy{MaxAttempts: 1}))
+	t.FailureIsFatal().CmpNoError(v.Login("login", "password"))
+
+	var out bytes.Buffer
+	e, err := alert.NewEngine(testRules, []alert.Sink{&alert.WriterSink{W: &out}},
+		alert.WithRefresh(false))
+	t.FailureIsFatal().CmpNoError(err)
+
+	ctx := context.Background()
+
+	alerts, err := e.Poll(ctx, v)
+	t.CmpNoError(err)
+	if t.Len(alerts, 1) {
+		t.Cmp(alerts[0].Rule, "frost")
+	}
+	t.Cmp(out.String(), td.Re(`^\S+ \S+ 1234@5678 frost: AussenTemp < 0 \(value: -3\.5\)\n\z`))
+	t.Cmp(srv.Calls("GetData"), 1)
+	t.Cmp(srv.Calls("GetErrorHistory"), 1)
+
+	srv.SetValue(1234, vitotrol.BrennerStatus, "1")
+	dev.Errors = []vitotrol.ErrorHistoryEvent{{
+		Error:    "F4",
+		Message:  "Flamme fehlt",
+		Time:     vitotrol.Time(testTime),
+		IsActive: true,
+	}}
+	alerts, err = e.Poll(ctx, v)
+	t.CmpNoError(err)
+	t.Cmp(alerts, td.Len(2))
+
+	// Nothing new
+	out.Reset()
+	alerts, err = e.Poll(ctx, v)
+	t.CmpNoError(err)
+	t.Nil(alerts)
+	t.Cmp(out.Len(), 0)
+
+	// A failing read does not prevent evaluating the other rules
+	srv.Fail("GetData", 2, 1)
+	dev.Errors = nil
+	alerts, err = e.Poll(ctx, v)
+	t.CmpError(err)
+	if t.Len(alerts, 1) {
+		t.Cmp(alerts[0].Rule, "faults")
+		t.True(alerts[0].Resolved)
+	}
+
+	// Sink failures are reported
+	e, err = alert.NewEngine(testRules, []alert.Sink{failingSink{}},
+		alert.WithRefresh(false), alert.WithLogger(vitotrol.DiscardLogger))
+	t.FailureIsFatal().CmpNoError(err)
+	_, err = e.Poll(ctx, v)
+	t.Cmp(err, context.DeadlineExceeded)
+}
+
+func TestRun(tt *testing.T) {
+	t := td.NewT(tt)
+
+	dev := vitotroltest.NewDevice(1234, 5678)
+	srv := vitotroltest.NewServer(vitotroltest.WithDevice(dev))
+	defer srv.Close()
+
+	v := vitotrol.NewSession(vitotrol.WithURL(srv.URL),
+		vitotrol.WithRetryPolicy(vitotrol.RetryPolicy{MaxAttempts: 1}),
+		vitotrol.WithPollPolicy(vitotrol.PollPolicy{MinInterval: time.Millisecond, Timeout: time.Minute}))
+	t.FailureIsFatal().CmpNoError(v.Login("login", "password"))
+
+	e, err := alert.NewEngine(testRules, nil, alert.WithInterval(time.Millisecond))
+	t.FailureIsFatal().CmpNoError(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- e.Run(ctx, v) }()
+
+	for start := time.Now(); srv.Calls("GetData") < 2 && time.Since(start) < 5*time.Second; {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	t.Cmp(<-done, context.Canceled)
+	t.Gte(srv.Calls("RefreshData"), 2)
+}
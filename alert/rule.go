@@ -0,0 +1,264 @@
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/TomTom68/go-vitotrol"
+)
+
+// Rule is a declarative alerting rule. It is either an attribute
+// rule, firing when the condition "Attribute Op Value" becomes true,
+// or an error rule (Errors is true), firing for each newly active
+// error of the device error history.
+//
+// Numeric attributes (Double and Integer types) are compared
+// numerically with any operator. Other attributes are compared using
+// their human representation (for example "Ein" for BrennerStatus)
+// with == or != operator only.
+type Rule struct {
+	Name string `json:"name"`
+
+	// Attribute rules
+	Attribute string `json:"attribute,omitempty"`
+	Op        string `json:"op,omitempty"` // ==, !=, <, <=, > or >=
+	Value     string `json:"value,omitempty"`
+
+	// Error rules
+	Errors bool     `json:"errors,omitempty"`
+	Codes  []string `json:"codes,omitempty"` // only these error codes if not empty
+
+	// Message is a text/template executed with the Alert, to set
+	// its Message field. A default message is used if empty.
+	Message string `json:"message,omitempty"`
+	// NotifyResolved tells whether an alert is also sent when the
+	// condition stops being true or the error is no longer active.
+	NotifyResolved bool `json:"notify_resolved,omitempty"`
+
+	attrID  vitotrol.AttrID
+	ref     *vitotrol.AttrRef
+	numeric bool
+	num     float64
+	tmpl    *template.Template
+}
+
+var ops = map[string]func(cmp int) bool{
+	"==": func(cmp int) bool { return cmp == 0 },
+	"!=": func(cmp int) bool { return cmp != 0 },
+	"<":  func(cmp int) bool { return cmp < 0 },
+	"<=": func(cmp int) bool { return cmp <= 0 },
+	">":  func(cmp int) bool { return cmp > 0 },
+	">=": func(cmp int) bool { return cmp >= 0 },
+}
+
+// compile checks the rule and prepares its evaluation, resolving its
+// attribute using registry.
+func (r *Rule) compile(registry *vitotrol.AttributeRegistry) error {
+	if r.Name == "" {
+		return errors.New("rule without name")
+	}
+
+	if r.Errors {
+		if r.Attribute != "" || r.Op != "" || r.Value != "" {
+			return fmt.Errorf("rule %s: errors rule cannot have attribute, op or value", r.Name)
+		}
+	} else {
+		if len(r.Codes) > 0 {
+			return fmt.Errorf("rule %s: codes are only allowed in errors rule", r.Name)
+		}
+
+		attrID, ok := registry.ID(r.Attribute)
+		if !ok {
+			return fmt.Errorf("rule %s: unknown attribute `%s'", r.Name, r.Attribute)
+		}
+		r.attrID = attrID
+		r.ref = registry.Ref(attrID)
+		if r.ref.Access&vitotrol.ReadOnly == 0 {
+			return fmt.Errorf("rule %s: attribute `%s' is not %s",
+				r.Name, r.Attribute, vitotrol.AccessToStr[vitotrol.ReadOnly])
+		}
+
+		if ops[r.Op] == nil {
+			return fmt.Errorf("rule %s: unknown op `%s'", r.Name, r.Op)
+		}
+
+		switch r.ref.Type.(type) {
+		case *vitotrol.VitodataDouble, *vitotrol.VitodataInteger:
+			num, err := strconv.ParseFloat(r.Value, 64)
+			if err != nil {
+				return fmt.Errorf("rule %s: value `%s' is not a number", r.Name, r.Value)
+			}
+			r.numeric = true
+			r.num = num
+		default:
+			if r.Op != "==" && r.Op != "!=" {
+				return fmt.Errorf("rule %s: op `%s' needs a numeric attribute", r.Name, r.Op)
+			}
+		}
+	}
+
+	if r.Message != "" {
+		tmpl, err := template.New(r.Name).Parse(r.Message)
+		if err != nil {
+			return fmt.Errorf("rule %s: bad message: %s", r.Name, err)
+		}
+		r.tmpl = tmpl
+	}
+	return nil
+}
+
+// match tells whether the Vitodata™ formatted value matches the rule
+// condition. It returns the human representation of value.
+func (r *Rule) match(value string) (bool, string) {
+	human, err := r.ref.Type.Vitodata2HumanValue(value)
+	if err != nil {
+		human = value
+	}
+
+	if r.numeric {
+		num, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return false, human
+		}
+		cmp := 0
+		if num < r.num {
+			cmp = -1
+		} else if num > r.num {
+			cmp = 1
+		}
+		return ops[r.Op](cmp), human
+	}
+
+	return ops[r.Op](strings.Compare(human, r.Value)), human
+}
+
+// matchCode tells whether error code is handled by the rule.
+func (r *Rule) matchCode(code string) bool {
+	if len(r.Codes) == 0 {
+		return true
+	}
+	for _, c := range r.Codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// message returns the message of alert a.
+func (r *Rule) message(a *Alert) string {
+	if r.tmpl != nil {
+		var buf bytes.Buffer
+		if err := r.tmpl.Execute(&buf, a); err == nil {
+			return buf.String()
+		}
+	}
+
+	var msg string
+	if r.Errors {
+		msg = "error " + a.Code
+		if a.ErrorMessage != "" {
+			msg += ": " + a.ErrorMessage
+		}
+	} else {
+		msg = fmt.Sprintf("%s %s %s (value: %s)", r.Attribute, r.Op, r.Value, a.Value)
+	}
+	if a.Resolved {
+		msg = "resolved: " + msg
+	}
+	return msg
+}
+
+// Config is the content of a rules file.
+type Config struct {
+	Rules []Rule       `json:"rules"`
+	Sinks []SinkConfig `json:"sinks"`
+}
+
+// ReadConfig reads and checks a JSON Config from r, against the
+// built-in attributes.
+func ReadConfig(r io.Reader) (*Config, error) {
+	return ReadConfigRegistry(r, nil)
+}
+
+// ReadConfigRegistry is the same as ReadConfig but checks the rules
+// attributes against registry. A nil registry means the built-in
+// attributes.
+func ReadConfigRegistry(r io.Reader, registry *vitotrol.AttributeRegistry) (*Config, error) {
+	if registry == nil {
+		registry = vitotrol.NewAttributeRegistry()
+	}
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	var c Config
+	err := dec.Decode(&c)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool, len(c.Rules))
+	for idx := range c.Rules {
+		rule := &c.Rules[idx]
+		err = rule.compile(registry)
+		if err != nil {
+			return nil, err
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("duplicate rule %s", rule.Name)
+		}
+		names[rule.Name] = true
+	}
+
+	for idx := range c.Sinks {
+		_, err = c.Sinks[idx].Sink()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
+// LoadConfig reads and checks the JSON Config file, against the
+// built-in attributes.
+func LoadConfig(file string) (*Config, error) {
+	return LoadConfigRegistry(file, nil)
+}
+
+// LoadConfigRegistry is the same as LoadConfig but checks the rules
+// attributes against registry, see ReadConfigRegistry.
+func LoadConfigRegistry(file string, registry *vitotrol.AttributeRegistry) (*Config, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c, err := ReadConfigRegistry(f, registry)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	return c, nil
+}
+
+// NewEngine returns a new Engine evaluating c rules and notifying c
+// sinks.
+func (c *Config) NewEngine(opts ...Option) (*Engine, error) {
+	sinks := make([]Sink, len(c.Sinks))
+	for idx := range c.Sinks {
+		sink, err := c.Sinks[idx].Sink()
+		if err != nil {
+			return nil, err
+		}
+		sinks[idx] = sink
+	}
+	return NewEngine(c.Rules, sinks, opts...)
+}
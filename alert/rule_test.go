@@ -0,0 +1,101 @@
+package alert_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	td "github.com/maxatome/go-testdeep"
+
+	"github.com/TomTom68/go-vitotrol"
+	"github.com/TomTom68/go-vitotrol/alert"
+)
+
+func TestReadConfig(tt *testing.T) {
+	t := td.NewT(tt)
+
+	c, err := alert.ReadConfig(strings.NewReader(`{
+  "rules": [
+    {"name": "frost", "attribute": "AussenTemp", "op": "<", "value": "0"},
+    {"name": "burner", "attribute": "BrennerStatus", "op": "==", "value": "Ein",
+     "message": "burner is {{.Value}}", "notify_resolved": true},
+    {"name": "faults", "errors": true, "codes": ["F4"]}
+  ],
+  "sinks": [
+    {"type": "webhook", "url": "http://localhost:8080/alert"},
+    {"type": "smtp", "from": "vitotrol@localhost", "to": ["root@localhost"]},
+    {"type": "exec", "command": ["logger", "-t", "vitotrol"]}
+  ]
+}`))
+	if t.CmpNoError(err) {
+		t.Cmp(c, td.Struct(&alert.Config{
+			Sinks: []alert.SinkConfig{
+				{Type: "webhook", URL: "http://localhost:8080/alert"},
+				{Type: "smtp", From: "vitotrol@localhost", To: []string{"root@localhost"}},
+				{Type: "exec", Command: []string{"logger", "-t", "vitotrol"}},
+			},
+		}, td.StructFields{
+			"Rules": td.Len(3),
+		}))
+
+		e, err := c.NewEngine()
+		if t.CmpNoError(err) {
+			t.True(e.NeedErrors())
+			t.Len(e.Attributes(), 2)
+		}
+	}
+
+	for config, errMsg := range map[string]td.TestDeep{
+		`{"rules":[{"attribute":"AussenTemp","op":"<","value":"0"}]}`:                           td.String("rule without name"),
+		`{"rules":[{"name":"x","attribute":"Foo","op":"<","value":"0"}]}`:                       td.String("rule x: unknown attribute `Foo'"),
+		`{"rules":[{"name":"x","attribute":"AussenTemp","op":"=~","value":"0"}]}`:               td.String("rule x: unknown op `=~'"),
+		`{"rules":[{"name":"x","attribute":"AussenTemp","op":"<","value":"cold"}]}`:             td.String("rule x: value `cold' is not a number"),
+		`{"rules":[{"name":"x","attribute":"BrennerStatus","op":">","value":"Ein"}]}`:           td.String("rule x: op `>' needs a numeric attribute"),
+		`{"rules":[{"name":"x","attribute":"AussenTemp","op":"<","value":"0","codes":["F4"]}]}`: td.String("rule x: codes are only allowed in errors rule"),
+		`{"rules":[{"name":"x","errors":true,"attribute":"AussenTemp"}]}`:                       td.String("rule x: errors rule cannot have attribute, op or value"),
+		`{"rules":[{"name":"x","errors":true,"message":"{{.Foo"}]}`:                             td.Re(`^rule x: bad message: `),
+		`{"rules":[{"name":"x","errors":true},{"name":"x","errors":true}]}`:                     td.String("duplicate rule x"),
+		`{"sinks":[{"type":"pigeon"}]}`:                                                         td.String("unknown sink type `pigeon'"),
+		`{"sinks":[{"type":"webhook"}]}`:                                                        td.String("webhook sink: url is missing"),
+		`{"sinks":[{"type":"smtp","from":"a@b"}]}`:                                              td.String("smtp sink: from or to is missing"),
+		`{"sinks":[{"type":"exec"}]}`:                                                           td.String("exec sink: command is missing"),
+	} {
+		_, err := alert.ReadConfig(strings.NewReader(config))
+		t.Cmp(err, errMsg, config)
+	}
+
+	_, err = alert.ReadConfig(strings.NewReader(`{"rulez":[]}`))
+	t.CmpError(err)
+	// Attributes resolved using a registry
+	registry := vitotrol.NewAttributeRegistry()
+	registry.Add(0x1234, vitotrol.AttrRef{
+		Type:   vitotrol.TypeDouble,
+		Access: vitotrol.ReadOnly,
+		Name:   "Foo",
+	})
+	config := `{"rules":[{"name":"x","attribute":"Foo","op":"<","value":"0"}]}`
+	c, err = alert.ReadConfigRegistry(strings.NewReader(config), registry)
+	if t.CmpNoError(err) {
+		e, err := c.NewEngine(alert.WithRegistry(registry))
+		if t.CmpNoError(err) {
+			t.Cmp(e.Attributes(), []vitotrol.AttrID{0x1234})
+		}
+		_, err = c.NewEngine()
+		t.Cmp(err, td.String("rule x: unknown attribute `Foo'"))
+	}
+}
+
+func TestLoadConfig(tt *testing.T) {
+	t := td.NewT(tt)
+
+	file := filepath.Join(t.TempDir(), "rules.json")
+	t.FailureIsFatal().CmpNoError(os.WriteFile(file,
+		[]byte(`{"rules":[{"name":"x","attribute":"Foo"}]}`), 0o600))
+
+	_, err := alert.LoadConfig(file)
+	t.Cmp(err, td.String(file+": rule x: unknown attribute `Foo'"))
+
+	_, err = alert.LoadConfig(filepath.Join(t.TempDir(), "missing.json"))
+	t.CmpError(err)
+}
@@ -0,0 +1,193 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Sink notifies alerts.
+type Sink interface {
+	Notify(ctx context.Context, a *Alert) error
+}
+
+// SinkConfig describes a Sink in a rules file.
+type SinkConfig struct {
+	Type string `json:"type"` // webhook, smtp or exec
+
+	URL string `json:"url,omitempty"` // webhook
+
+	Addr string   `json:"addr,omitempty"` // smtp, defaults to localhost:25
+	From string   `json:"from,omitempty"` // smtp
+	To   []string `json:"to,omitempty"`   // smtp
+
+	Command []string `json:"command,omitempty"` // exec
+}
+
+// Sink returns the Sink described by c.
+func (c *SinkConfig) Sink() (Sink, error) {
+	switch c.Type {
+	case "webhook":
+		if c.URL == "" {
+			return nil, errors.New("webhook sink: url is missing")
+		}
+		return &WebhookSink{URL: c.URL}, nil
+
+	case "smtp":
+		if c.From == "" || len(c.To) == 0 {
+			return nil, errors.New("smtp sink: from or to is missing")
+		}
+		return &SMTPSink{Addr: c.Addr, From: c.From, To: c.To}, nil
+
+	case "exec":
+		if len(c.Command) == 0 {
+			return nil, errors.New("exec sink: command is missing")
+		}
+		return &ExecSink{Command: c.Command}, nil
+	}
+	return nil, fmt.Errorf("unknown sink type `%s'", c.Type)
+}
+
+// WebhookSink POSTs each alert JSON encoded to URL.
+type WebhookSink struct {
+	URL    string
+	Client *http.Client // http.DefaultClient if nil
+}
+
+// Notify implements Sink interface.
+func (s *WebhookSink) Notify(ctx context.Context, a *Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body) //nolint: errcheck
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s: %s", s.URL, resp.Status)
+	}
+	return nil
+}
+
+// SMTPSink mails each alert through the SMTP relay at Addr, without
+// authentication, so generally a local relay.
+type SMTPSink struct {
+	Addr string // localhost:25 if empty
+	From string
+	To   []string
+}
+
+// Notify implements Sink interface. ctx is not honoured as net/smtp
+// does not support it.
+func (s *SMTPSink) Notify(ctx context.Context, a *Alert) error {
+	addr := s.Addr
+	if addr == "" {
+		addr = "localhost:25"
+	}
+
+	subject := "[vitotrol] " + a.Message
+	if i := strings.IndexAny(subject, "\r\n"); i >= 0 {
+		subject = subject[:i]
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n\r\n", a.Message)
+	fmt.Fprintf(&msg, "Rule: %s\r\n", a.Rule)
+	fmt.Fprintf(&msg, "Device: %s (%d@%d)\r\n", a.DeviceName, a.DeviceID, a.LocationID)
+	fmt.Fprintf(&msg, "Time: %s\r\n", a.Time.Format(time.RFC3339))
+	if a.Attribute != "" {
+		fmt.Fprintf(&msg, "Attribute: %s = %s\r\n", a.Attribute, a.Value)
+	}
+	if a.Code != "" {
+		fmt.Fprintf(&msg, "Error: %s %s\r\n", a.Code, a.ErrorMessage)
+	}
+
+	return smtp.SendMail(addr, nil, s.From, s.To, msg.Bytes())
+}
+
+// ExecSink runs Command for each alert. The alert is JSON encoded on
+// the command standard input, and its main fields are also available
+// in VITOTROL_ALERT_* environment variables.
+type ExecSink struct {
+	Command []string
+}
+
+// Notify implements Sink interface.
+func (s *ExecSink) Notify(ctx context.Context, a *Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, s.Command[0], s.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"VITOTROL_ALERT_RULE="+a.Rule,
+		"VITOTROL_ALERT_DEVICE_ID="+strconv.FormatUint(uint64(a.DeviceID), 10),
+		"VITOTROL_ALERT_LOCATION_ID="+strconv.FormatUint(uint64(a.LocationID), 10),
+		"VITOTROL_ALERT_DEVICE_NAME="+a.DeviceName,
+		"VITOTROL_ALERT_ATTRIBUTE="+a.Attribute,
+		"VITOTROL_ALERT_VALUE="+a.Value,
+		"VITOTROL_ALERT_CODE="+a.Code,
+		"VITOTROL_ALERT_RESOLVED="+strconv.FormatBool(a.Resolved),
+		"VITOTROL_ALERT_MESSAGE="+a.Message,
+	)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		out = bytes.TrimSpace(out)
+		if len(out) > 0 {
+			return fmt.Errorf("%s: %s: %s", s.Command[0], err, out)
+		}
+		return fmt.Errorf("%s: %s", s.Command[0], err)
+	}
+	return nil
+}
+
+// WriterSink writes each alert as a line of text to W. Used by dry
+// runs.
+type WriterSink struct {
+	W io.Writer
+
+	mu sync.Mutex
+}
+
+// Notify implements Sink interface.
+func (s *WriterSink) Notify(ctx context.Context, a *Alert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := fmt.Fprintln(s.W, a.String())
+	return err
+}
@@ -0,0 +1,168 @@
+package alert_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	td "github.com/maxatome/go-testdeep"
+
+	"github.com/TomTom68/go-vitotrol/alert"
+)
+
+var testAlert = alert.Alert{
+	Rule:         "faults",
+	LocationID:   5678,
+	DeviceID:     1234,
+	DeviceName:   "Vitodens",
+	Time:         testTime,
+	Code:         "F4",
+	ErrorMessage: "Flamme fehlt",
+	Message:      "error F4: Flamme fehlt",
+}
+
+// sameAlert matches an Alert equal to a once JSON encoded and decoded.
+func sameAlert(a alert.Alert) td.TestDeep {
+	tm := a.Time
+	a.Time = time.Time{}
+	return td.Struct(a, td.StructFields{"Time": td.TruncTime(tm)})
+}
+
+func TestWebhookSink(tt *testing.T) {
+	t := td.NewT(tt)
+
+	var (
+		got    alert.Alert
+		status = http.StatusNoContent
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Cmp(r.Method, http.MethodPost)
+		t.Cmp(r.Header.Get("Content-Type"), "application/json")
+		t.CmpNoError(json.NewDecoder(r.Body).Decode(&got))
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	sink := &alert.WebhookSink{URL: srv.URL}
+	a := testAlert
+	t.CmpNoError(sink.Notify(context.Background(), &a))
+	t.Cmp(got, sameAlert(a))
+
+	status = http.StatusInternalServerError
+	t.Cmp(sink.Notify(context.Background(), &a),
+		td.String("webhook "+srv.URL+": 500 Internal Server Error"))
+}
+
+func TestExecSink(tt *testing.T) {
+	t := td.NewT(tt)
+
+	out := filepath.Join(t.TempDir(), "out")
+	sink := &alert.ExecSink{Command: []string{
+		"sh", "-c", `cat > "$1"; echo >> "$1"; echo "$VITOTROL_ALERT_CODE $VITOTROL_ALERT_RESOLVED" >> "$1"`, "sh", out,
+	}}
+	a := testAlert
+	t.CmpNoError(sink.Notify(context.Background(), &a))
+
+	content, err := os.ReadFile(out)
+	if t.CmpNoError(err) {
+		lines := strings.Split(string(content), "\n")
+		if t.Len(lines, 3) {
+			var got alert.Alert
+			t.CmpNoError(json.Unmarshal([]byte(lines[0]), &got))
+			t.Cmp(got, sameAlert(a))
+			t.Cmp(lines[1:], []string{"F4 false", ""})
+		}
+	}
+
+	sink = &alert.ExecSink{Command: []string{"sh", "-c", "echo oops; exit 3"}}
+	t.Cmp(sink.Notify(context.Background(), &a), td.String("sh: exit status 3: oops"))
+}
+
+// smtpServer is a minimal SMTP server recording the received messages.
+func smtpServer(t *td.T) (string, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	t.FailureIsFatal().CmpNoError(err)
+	t.Cleanup(func() { l.Close() })
+
+	msgs := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		fmt.Fprint(conn, "220 localhost ESMTP\r\n")
+		var data *bytes.Buffer
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if data != nil {
+				if line == ".\r\n" {
+					msgs <- data.String()
+					data = nil
+					fmt.Fprint(conn, "250 OK\r\n")
+				} else {
+					data.WriteString(line)
+				}
+				continue
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+			case "DATA":
+				data = &bytes.Buffer{}
+				fmt.Fprint(conn, "354 go ahead\r\n")
+			case "QUIT":
+				fmt.Fprint(conn, "221 bye\r\n")
+				return
+			default:
+				fmt.Fprint(conn, "250 OK\r\n")
+			}
+		}
+	}()
+	return l.Addr().String(), msgs
+}
+
+func TestSMTPSink(tt *testing.T) {
+	t := td.NewT(tt)
+
+	addr, msgs := smtpServer(t)
+	sink := &alert.SMTPSink{
+		Addr: addr,
+		From: "vitotrol@localhost",
+		To:   []string{"root@localhost", "admin@localhost"},
+	}
+	a := testAlert
+	t.CmpNoError(sink.Notify(context.Background(), &a))
+
+	msg := <-msgs
+	t.Cmp(msg, td.All(
+		td.Contains("From: vitotrol@localhost\r\n"),
+		td.Contains("To: root@localhost, admin@localhost\r\n"),
+		td.Contains("Subject: [vitotrol] error F4: Flamme fehlt\r\n"),
+		td.Contains("Device: Vitodens (1234@5678)\r\n"),
+		td.Contains("Error: F4 Flamme fehlt\r\n"),
+	))
+}
+
+func TestWriterSink(tt *testing.T) {
+	t := td.NewT(tt)
+
+	var buf bytes.Buffer
+	sink := &alert.WriterSink{W: &buf}
+	a := testAlert
+	t.CmpNoError(sink.Notify(context.Background(), &a))
+	t.Cmp(buf.String(), "2022-01-02 03:04:05 1234@5678 faults: error F4: Flamme fehlt\n")
+}
@@ -80,7 +80,27 @@ type AttrRef struct {
 	Access AttrAccess
 	Name   string
 	Doc    string
+	Unit   Unit // physical unit of the value, empty if none
 	Custom bool
+
+	// Following fields are only filled for attributes merged from
+	// GetTypeInfo (see AttributeRegistry.Merge). Values are
+	// Vitodata™ formatted, empty if unknown.
+	MinValue         string
+	MaxValue         string
+	DefaultValue     string
+	HeatingCircuitID uint32
+	DataPointGroup   string
+
+	// Constraints checked by Validate on Double and Integer values
+	// before writing them (see Device.WriteData). Min and Max are nil
+	// when unbounded, and are seeded from MinValue and MaxValue by
+	// AttributeRegistry.Merge. Step is ignored if 0, otherwise values
+	// must be multiples of Step starting from Min (or 0 if Min is nil).
+	// They can be overridden using AttributeRegistry.Add.
+	Min  *float64
+	Max  *float64
+	Step float64
 }
 
 // String returns all information contained in this attribute reference.
@@ -89,6 +109,11 @@ func (r *AttrRef) String() string {
 		r.Name, r.Doc, r.Type.Type(), AccessToStr[r.Access])
 }
 
+// limit returns a pointer to v, for AttrRef Min and Max fields.
+func limit(v float64) *float64 {
+	return &v
+}
+
 // AttributesRef lists the reference for each attribute ID.
 var AttributesRef = map[AttrID]*AttrRef{
 	AussenTemp: {
@@ -96,84 +121,112 @@ var AttributesRef = map[AttrID]*AttrRef{
 		Access: ReadOnly,
 		Doc:    "Außen Temperatur",
 		Name:   "AussenTemp",
+		Unit:   UnitCelsius,
 	},
 	AbgasTemp: {
 		Type:   TypeDouble,
 		Access: ReadOnly,
 		Doc:    "Abgas Temperatur",
 		Name:   "AbgasTemp",
+		Unit:   UnitCelsius,
 	},
 	BoilerTemp: {
 		Type:   TypeDouble,
 		Access: ReadOnly,
 		Doc:    "Boiler Temperatur",
 		Name:   "BoilerTemp",
+		Unit:   UnitCelsius,
 	},
 	HeisswasserTemp: {
 		Type:   TypeDouble,
 		Access: ReadOnly,
 		Doc:    "Heißwasser Temperatur",
 		Name:   "HeisswasserTemp",
+		Unit:   UnitCelsius,
 	},
 	HeisswasserAusgangTemp: {
 		Type:   TypeDouble,
 		Access: ReadOnly,
 		Doc:    "Heißwasser Ausgangstemperatur",
 		Name:   "HeisswasserAusgangTemp",
+		Unit:   UnitCelsius,
 	},
 	HeizwasserAusgangTemp: {
 		Type:   TypeDouble,
 		Access: ReadOnly,
 		Doc:    "Heizwasser Ausgangstemperatur",
 		Name:   "HeizwasserAusgangTemp",
+		Unit:   UnitCelsius,
 	},
 	HeizNormalTempM1: {
 		Type:   TypeDouble,
 		Access: ReadWrite,
 		Doc:    "Normale Raumsolltemperatur Heizkörper",
 		Name:   "HeizNormalTempM1",
+		Unit:   UnitCelsius,
+		Min:    limit(3),
+		Max:    limit(37),
 	},
 	HeizNormalTempM2: {
                 Type:   TypeDouble,
                 Access: ReadWrite,
                 Doc:    "Normale Raumsolltemperatur Fußbodenheizung",
                 Name:   "HeizNormalTempM2",
+                Unit:   UnitCelsius,
+                Min:    limit(3),
+                Max:    limit(37),
         },
 	HeizPartyTempM1: {
 		Type:   TypeDouble,
 		Access: ReadWrite,
 		Doc:    "Party Raumtemperatur Heizkörper",
 		Name:   "HeizPartyTempM1",
+		Unit:   UnitCelsius,
+		Min:    limit(3),
+		Max:    limit(37),
 	},
         HeizPartyTempM2: {
                 Type:   TypeDouble,
                 Access: ReadWrite,
                 Doc:    "Party Raumtemperatur Fußbodenheizung",
                 Name:   "HeizPartyTempM2",
+                Unit:   UnitCelsius,
+                Min:    limit(3),
+                Max:    limit(37),
         },
 	HeizReduziertTempM1: {
 		Type:   TypeDouble,
 		Access: ReadWrite,
 		Doc:    "Reduzierte Raumtemperatur Heizkörper",
 		Name:   "HeizReduziertTempM1",
+		Unit:   UnitCelsius,
+		Min:    limit(3),
+		Max:    limit(37),
 	},
         HeizReduziertTempM2: {
                 Type:   TypeDouble,
                 Access: ReadWrite,
                 Doc:    "Reduzierte Raumtemperatur Fußbodenheizung",
                 Name:   "HeizReduziertTempM2",
+                Unit:   UnitCelsius,
+                Min:    limit(3),
+                Max:    limit(37),
         },
 	HeisswasserSollTemp: {
 		Type:   TypeDouble,
 		Access: ReadWrite,
 		Doc:    "Solltemperatur Warmwasser",
 		Name:   "HeisswasserSollTemp",
+		Unit:   UnitCelsius,
+		Min:    limit(10),
+		Max:    limit(60),
 	},
 	AnzahlBrennerstunden: {
 		Type:   TypeDouble,
 		Access: ReadOnly,
 		Doc:    "Brennerstundenanzahl",
 		Name:   "AnzahlBrennerstunden",
+		Unit:   UnitHour,
 	},
 	BrennerStatus: {
 		Type: NewEnum([]string{ // 0 -> 1
@@ -189,6 +242,7 @@ var AttributesRef = map[AttrID]*AttrRef{
 		Access: ReadWrite,
 		Doc:    "Anzahl von Brennerstarts",
 		Name:   "AnzahlBrennerStarts",
+		Unit:   UnitCount,
 	},
 	InternerPumpenStatus: {
 		Type: NewEnum([]string{ // 0 -> 3
@@ -387,24 +441,36 @@ var AttributesRef = map[AttrID]*AttrRef{
                 Access: ReadWrite,
                 Doc:    "Neigung Heizkörper",
                 Name:   "NeigungM1",
+                Min:    limit(0.2),
+                Max:    limit(3.5),
+                Step:   0.1,
 	},
         NeigungM2: {
                 Type:   TypeDouble,
                 Access: ReadWrite,
                 Doc:    "Neigung Fußbodenheizung",
                 Name:   "NeigungM2",
+                Min:    limit(0.2),
+                Max:    limit(3.5),
+                Step:   0.1,
         },
 	NiveauM1: {
                 Type:   TypeDouble,
                 Access: ReadWrite,
                 Doc:    "Niveau Heizkörper",
                 Name:   "NiveauM1",
+                Unit:   UnitKelvin,
+                Min:    limit(-13),
+                Max:    limit(40),
         },
 	NiveauM2: {
                 Type:   TypeDouble,
                 Access: ReadWrite,
                 Doc:    "Niveau Fußbodenheizung",
                 Name:   "NiveauM2",
+                Unit:   UnitKelvin,
+                Min:    limit(-13),
+                Max:    limit(40),
         },
 	Heizungsschema: {
                 Type: NewEnum([]string{ // 0 -> 10
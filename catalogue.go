@@ -0,0 +1,132 @@
+package vitotrol
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// CatalogueVersion is the version of the catalogue format written by
+// Catalogue.Write.
+const CatalogueVersion = 1
+
+// Catalogue is the full datapoint catalogue of a device, as returned
+// by GetTypeInfo. Saved to a file, it allows to build the attribute
+// registry of a device (see Registry method) without calling
+// GetTypeInfo each time, or to support other boiler models by
+// shipping a file. Catalogue files use the JSON format, so they are
+// also valid YAML documents.
+type Catalogue struct {
+	Version    int              `json:"version"`
+	Device     string           `json:"device,omitempty"` // informative
+	Created    time.Time        `json:"created"`
+	Attributes []*AttributeInfo `json:"attributes"`
+}
+
+// NewCatalogue returns a new catalogue containing infos, generally
+// returned by GetTypeInfo. device is informative and can be empty.
+func NewCatalogue(device string, infos []*AttributeInfo) *Catalogue {
+	attrs := append([]*AttributeInfo(nil), infos...)
+	sort.SliceStable(attrs, func(i, j int) bool {
+		return attrs[i].AttributeID < attrs[j].AttributeID
+	})
+	return &Catalogue{
+		Version:    CatalogueVersion,
+		Device:     device,
+		Created:    time.Now().Truncate(time.Second),
+		Attributes: attrs,
+	}
+}
+
+// Catalogue returns the catalogue of d using GetTypeInfo. The unit
+// of each attribute, not sent by the server, is taken from the
+// registry of d.
+func (d *Device) Catalogue(v *Session) (*Catalogue, error) {
+	return d.CatalogueContext(context.Background(), v)
+}
+
+// CatalogueContext is the same as Catalogue but honours ctx
+// cancellation and deadline.
+func (d *Device) CatalogueContext(ctx context.Context, v *Session) (*Catalogue, error) {
+	infos, err := d.GetTypeInfoContext(ctx, v)
+	if err != nil {
+		return nil, err
+	}
+	registry := d.Registry()
+	for _, pInfo := range infos {
+		if pRef := registry.Ref(pInfo.AttributeID); pRef != nil && pInfo.Unit == NoUnit {
+			pInfo.Unit = pRef.Unit
+		}
+	}
+	return NewCatalogue(fmt.Sprintf("%s@%s", d.DeviceName, d.LocationName), infos), nil
+}
+
+// Registry returns a new attribute registry merging the built-in
+// attributes with the catalogue ones (see AttributeRegistry.Merge),
+// and the catalogue attributes ignored because of their unsupported
+// type.
+func (c *Catalogue) Registry() (*AttributeRegistry, []*AttributeInfo) {
+	r := NewAttributeRegistry()
+	return r, r.Merge(c.Attributes)
+}
+
+// ReadCatalogue reads a JSON catalogue from r.
+func ReadCatalogue(r io.Reader) (*Catalogue, error) {
+	var c Catalogue
+	err := json.NewDecoder(r).Decode(&c)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode catalogue: %s", err)
+	}
+	if c.Version < 1 || c.Version > CatalogueVersion {
+		return nil, fmt.Errorf("unsupported catalogue version %d", c.Version)
+	}
+	for idx, info := range c.Attributes {
+		if info == nil || info.AttributeName == "" || info.AttributeType == "" {
+			return nil, fmt.Errorf("catalogue attribute #%d: name or type is missing", idx)
+		}
+	}
+	return &c, nil
+}
+
+// LoadCatalogue reads a JSON catalogue from file.
+func LoadCatalogue(file string) (*Catalogue, error) {
+	fh, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	c, err := ReadCatalogue(fh)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	return c, nil
+}
+
+// Write writes the catalogue in JSON format to w.
+func (c *Catalogue) Write(w io.Writer) error {
+	if c.Version == 0 {
+		c.Version = CatalogueVersion
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(c)
+}
+
+// Save writes the catalogue in JSON format to file.
+func (c *Catalogue) Save(file string) error {
+	fh, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	err = c.Write(fh)
+	if errClose := fh.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
@@ -0,0 +1,143 @@
+package vitotrol
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	td "github.com/maxatome/go-testdeep"
+)
+
+func TestCatalogue(tt *testing.T) {
+	t := td.NewT(tt)
+
+	infos := []*AttributeInfo{
+		{
+			AttributeInfoBase: AttributeInfoBase{
+				AttributeName: "zustand_foo_r",
+				AttributeType: "ENUM",
+				Readable:      true,
+			},
+			AttributeID: 0x3456,
+			EnumValues:  map[uint32]string{0: "Aus", 1: "Ein"},
+		},
+		{
+			AttributeInfoBase: AttributeInfoBase{
+				AttributeName:  "temp_foo_r",
+				AttributeType:  "Double",
+				MinValue:       "-20",
+				MaxValue:       "40",
+				DefaultValue:   "20",
+				DataPointGroup: "HC2",
+				Readable:       true,
+				Unit:           UnitPercent,
+			},
+			AttributeID: 0x2345,
+		},
+	}
+
+	c := NewCatalogue("Vitodens@Home", infos)
+	t.Cmp(c.Version, CatalogueVersion)
+	t.Cmp(c.Created, td.Between(time.Now().Add(-2*time.Second), time.Now()))
+	t.Cmp(c.Attributes, []*AttributeInfo{infos[1], infos[0]}) // sorted by ID
+
+	// Round trip
+	var buf bytes.Buffer
+	t.CmpNoError(c.Write(&buf))
+	t.Cmp(buf.String(), td.Contains(`"enum_values": {`))
+
+	got, err := ReadCatalogue(&buf)
+	if t.CmpNoError(err) {
+		t.Cmp(got.Created, td.TruncTime(c.Created))
+		got.Created = c.Created
+		t.Cmp(got, c)
+	}
+
+	r, ignored := c.Registry()
+	t.Nil(ignored)
+	t.Cmp(r.Ref(0x2345), td.SuperJSONOf(`{"MinValue":"-20","MaxValue":"40","DefaultValue":"20","Unit":"%"}`))
+	if enum := r.Ref(0x3456); t.NotNil(enum) {
+		human, err := enum.Type.Vitodata2HumanValue("1")
+		t.CmpNoError(err)
+		t.Cmp(human, "Ein")
+	}
+
+	// Save & load
+	file := filepath.Join(t.TempDir(), "catalogue.json")
+	t.CmpNoError(c.Save(file))
+	got, err = LoadCatalogue(file)
+	if t.CmpNoError(err) {
+		t.Len(got.Attributes, 2)
+	}
+
+	// Errors
+	for content, expected := range map[string]string{
+		`{`:              "cannot decode catalogue: unexpected EOF",
+		`{"version":0}`:  "unsupported catalogue version 0",
+		`{"version":99}`: "unsupported catalogue version 99",
+		`{"version":1,"attributes":[{"id":1,"type":"Double"}]}`: "catalogue attribute #0: name or type is missing",
+		`{"version":1,"attributes":[null]}`:                     "catalogue attribute #0: name or type is missing",
+	} {
+		_, err := ReadCatalogue(strings.NewReader(content))
+		t.Cmp(err, td.String(expected), content)
+	}
+
+	_, err = LoadCatalogue(file + ".unknown")
+	t.True(os.IsNotExist(err))
+
+	bad := filepath.Join(t.TempDir(), "bad.json")
+	t.CmpNoError(os.WriteFile(bad, []byte(`{"version":2}`), 0644))
+	_, err = LoadCatalogue(bad)
+	t.Cmp(err, td.String(bad+": unsupported catalogue version 2"))
+}
+
+func TestDeviceCatalogue(tt *testing.T) {
+	t := td.NewT(tt)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, respHeader+intoDeviceResponse("GetTypeInfo", `<Ergebnis>0</Ergebnis>
+<TypeInfoListe>
+  <DatenpunktTypInfo>
+    <DatenpunktId>9001</DatenpunktId>
+    <DatenpunktName>temp_bar_r</DatenpunktName>
+    <DatenpunktTyp>Double</DatenpunktTyp>
+    <IstLesbar>true</IstLesbar>
+    <IstSchreibbar>false</IstSchreibbar>
+  </DatenpunktTypInfo>
+  <DatenpunktTypInfo>
+    <DatenpunktId>9000</DatenpunktId>
+    <DatenpunktName>temp_foo_r</DatenpunktName>
+    <DatenpunktTyp>Double</DatenpunktTyp>
+    <IstLesbar>true</IstLesbar>
+    <IstSchreibbar>false</IstSchreibbar>
+  </DatenpunktTypInfo>
+</TypeInfoListe>`)+respFooter)
+	}))
+	defer ts.Close()
+
+	v := NewSession(WithURL(ts.URL))
+	d := &Device{
+		DeviceID:     testDeviceID,
+		LocationID:   testLocationID,
+		DeviceName:   "Vitodens",
+		LocationName: "Home",
+	}
+
+	c, err := d.Catalogue(v)
+	if t.CmpNoError(err) {
+		t.Cmp(c.Device, "Vitodens@Home")
+		t.Cmp(c.Attributes, td.Smuggle(func(infos []*AttributeInfo) []AttrID {
+			ids := make([]AttrID, len(infos))
+			for i, info := range infos {
+				ids[i] = info.AttributeID
+			}
+			return ids
+		}, []AttrID{9000, 9001}))
+	}
+}
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"strconv"
 	"strings"
 
 	"github.com/TomTom68/go-vitotrol"
+	"github.com/TomTom68/go-vitotrol/history"
 )
 
 func existTimesheetName(tsName string) (vitotrol.TimesheetID, error) {
@@ -40,6 +44,13 @@ var actions = map[string]Action{
 	"timesheet":     &timesheetAction{},
 	"set_timesheet": &setTimesheetAction{},
 	"remote_attrs":  &remoteAttrsAction{},
+	"exporter":      &exporterAction{authAction: authAction{noDefaultDev: true}},
+	"mqtt":          &mqttAction{authAction: authAction{noDefaultDev: true}},
+	"poll":          &pollAction{},
+	"serve":         &serveAction{authAction: authAction{noDefaultDev: true}},
+	"history":       &historyAction{},
+	"alert":         &alertAction{authAction: authAction{noDefaultDev: true}},
+	"status":        &statusAction{authAction: authAction{noDefaultDev: true}},
 }
 
 type authAction struct {
@@ -50,9 +61,46 @@ type authAction struct {
 }
 
 func (a *authAction) initVitotrol(pOptions *Options) error {
-	v := &vitotrol.Session{
-		Debug: pOptions.debug,
+	opts := []vitotrol.SessionOption{
+		vitotrol.WithURL(pOptions.url),
+		vitotrol.WithDebug(pOptions.debug),
 	}
+	if pOptions.replay != "" {
+		cassette, err := vitotrol.LoadCassette(pOptions.replay)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, vitotrol.WithReplay(cassette))
+	} else if pOptions.record != "" {
+		pOptions.recorder = vitotrol.NewRecorder(nil)
+		opts = append(opts, vitotrol.WithTransport(pOptions.recorder))
+	}
+	if pOptions.history != "" {
+		store, err := history.Open(pOptions.history,
+			history.WithLogger(vitotrol.StdLogger(pOptions.debug)))
+		if err != nil {
+			return err
+		}
+		pOptions.historyStore = store
+		opts = append(opts, vitotrol.WithDataObserver(store))
+	}
+	if pOptions.journal != "" {
+		journal, err := vitotrol.OpenJournal(pOptions.journal)
+		if err != nil {
+			return err
+		}
+		pOptions.journalStore = journal
+	}
+	// The catalogue is checked before logging in
+	var registry *vitotrol.AttributeRegistry
+	if pOptions.catalogue != "" {
+		var err error
+		registry, err = pOptions.registry()
+		if err != nil {
+			return err
+		}
+	}
+	v := vitotrol.NewSession(opts...)
 
 	err := v.Login(pOptions.login, pOptions.password)
 	if err != nil {
@@ -63,40 +111,47 @@ func (a *authAction) initVitotrol(pOptions *Options) error {
 	if err != nil {
 		return fmt.Errorf("GetDevices failed: %s", err)
 	}
-	if len(v.Devices) == 0 {
+	devices := v.DeviceList()
+	if len(devices) == 0 {
 		return errors.New("No device found")
 	}
 
+	if registry != nil {
+		for _, pDevice := range devices {
+			pDevice.SetRegistry(registry)
+		}
+	}
+
 	if !a.noDefaultDev {
 		var pDevice *vitotrol.Device
 		if pOptions.device == "" {
-			pDevice = &v.Devices[0]
+			pDevice = devices[0]
 		} else if idx, err := strconv.Atoi(pOptions.device); err == nil {
 			// Check if a device exists with this ID
-			for _, device := range v.Devices {
-				if uint32(idx) == device.DeviceID {
-					pDevice = &device //nolint: exportloopref
+			for _, pDev := range devices {
+				if uint32(idx) == pDev.DeviceID {
+					pDevice = pDev
 					break
 				}
 			}
 
 			// Else, take it as an index in devices array
 			if pDevice == nil {
-				if idx >= len(v.Devices) {
+				if idx >= len(devices) {
 					return fmt.Errorf(
 						"%d is not a device ID and too big to be an index "+
 							"(>= %d available devices).",
-						idx, len(v.Devices))
+						idx, len(devices))
 				}
-				pDevice = &v.Devices[idx]
+				pDevice = devices[idx]
 			}
 		} else {
 			checkDevLoc := strings.ContainsRune(pOptions.device, '@')
 
 			// Check if a device exists with this name
-			for _, device := range v.Devices {
-				if pOptions.device == device.DeviceName {
-					pDevice = &device //nolint: exportloopref
+			for _, pDev := range devices {
+				if pOptions.device == pDev.DeviceName {
+					pDevice = pDev
 					break
 				}
 
@@ -104,14 +159,14 @@ func (a *authAction) initVitotrol(pOptions *Options) error {
 				if checkDevLoc {
 					// DeviceId@LocationID
 					if pOptions.device == fmt.Sprintf("%d@%d",
-						device.DeviceID, device.LocationID) {
-						pDevice = &device //nolint: exportloopref
+						pDev.DeviceID, pDev.LocationID) {
+						pDevice = pDev
 						break
 					}
 
 					// DeviceName@LocationName
-					if pOptions.device == device.DeviceName+"@"+device.LocationName {
-						pDevice = &device //nolint: exportloopref
+					if pOptions.device == pDev.DeviceName+"@"+pDev.LocationName {
+						pDevice = pDev
 						break
 					}
 				}
@@ -142,94 +197,77 @@ func (a *authAction) NeedAuth() bool {
 
 type foreignAttrs struct {
 	authAction
-	cachePopulated bool
+	registryLoaded bool
 }
 
 func (f *foreignAttrs) checkAttributeAccess(attrName string, reqAccess vitotrol.AttrAccess) (vitotrol.AttrID, error) {
 	var attrID vitotrol.AttrID
-	var ok bool
+	var pRef *vitotrol.AttrRef
 
 	for {
-		id, err := strconv.ParseUint(attrName, 0, 16)
-		if err == nil {
-			attrID = vitotrol.AttrID(id)
-			_, ok = vitotrol.AttributesRef[attrID]
-		} else {
-			attrID, ok = vitotrol.AttributesNames2IDs[attrName]
-		}
-
-		if ok || f.cachePopulated {
+		attrID, pRef = lookupAttribute(f.d.Registry(), attrName)
+		if pRef != nil || f.registryLoaded {
 			break
 		}
-		f.populateCache()
+		f.loadRegistry()
 	}
 
-	if !ok {
+	if pRef == nil {
 		return vitotrol.NoAttr, fmt.Errorf("unknown attribute `%s'", attrName)
 	}
 
-	if (vitotrol.AttributesRef[attrID].Access & reqAccess) != reqAccess {
-		return vitotrol.NoAttr, fmt.Errorf("attribute `%s' is not %s",
-			attrName, vitotrol.AccessToStr[reqAccess])
+	err := checkAccess(pRef, attrName, reqAccess)
+	if err != nil {
+		return vitotrol.NoAttr, err
 	}
-
 	return attrID, nil
 }
 
-func (f *foreignAttrs) populateCache() {
-	f.cachePopulated = true
-
-	attrs, err := f.d.GetTypeInfo(f.v)
-	if err != nil {
-		fmt.Printf("GetTypeInfo failed: %s", err)
-		return
+// lookupAttribute returns the ID and the reference in registry of
+// attribute attrName, a name or a numeric ID. The reference is nil if
+// the attribute is unknown.
+func lookupAttribute(registry *vitotrol.AttributeRegistry, attrName string) (vitotrol.AttrID, *vitotrol.AttrRef) {
+	id, err := strconv.ParseUint(attrName, 0, 16)
+	if err == nil {
+		return vitotrol.AttrID(id), registry.Ref(vitotrol.AttrID(id))
 	}
+	if attrID, ok := registry.ID(attrName); ok {
+		return attrID, registry.Ref(attrID)
+	}
+	return vitotrol.NoAttr, nil
+}
 
-	for _, pAttrInfo := range attrs {
-		attrID := pAttrInfo.AttributeID
-		if vitotrol.AttributesRef[attrID] == nil {
-			// Unknown attribute
-			pType := vitotrol.TypeNames[pAttrInfo.AttributeType]
-			if pType == nil {
-				if pAttrInfo.AttributeType != "ENUM" {
-					// No warning for type used for timesheets...
-					if pAttrInfo.AttributeType != "CircuitTime" {
-						fmt.Printf("populateCache: unrecognized type %s for attribute "+
-							"%s-0x%04x. Discard it.\n",
-							pAttrInfo.AttributeType, pAttrInfo.AttributeName, attrID)
-					}
-					continue
-				}
-
-				var maxIdx uint32
-				for idx := range pAttrInfo.EnumValues {
-					if idx > maxIdx {
-						maxIdx = idx
-					}
-				}
-				enumValues := make([]string, maxIdx+1)
-				for idx, value := range pAttrInfo.EnumValues {
-					enumValues[idx] = value
-				}
-				pType = vitotrol.NewEnum(enumValues)
-			}
+func checkAccess(pRef *vitotrol.AttrRef, attrName string, reqAccess vitotrol.AttrAccess) error {
+	if (pRef.Access & reqAccess) != reqAccess {
+		return fmt.Errorf("attribute `%s' is not %s",
+			attrName, vitotrol.AccessToStr[reqAccess])
+	}
+	return nil
+}
 
-			ref := vitotrol.AttrRef{
-				Type: pType,
-				Name: fmt.Sprintf("%s-0x%04x", pAttrInfo.AttributeName, attrID),
-				Doc:  pAttrInfo.AttributeName,
-			}
+// loadRegistry completes the device registry with the attributes
+// returned by GetTypeInfo, unless it comes from a catalogue file.
+func (f *foreignAttrs) loadRegistry() {
+	if f.registryLoaded {
+		return
+	}
+	f.registryLoaded = true
+	if f.options.catalogue != "" {
+		return
+	}
 
-			if pAttrInfo.Readable {
-				ref.Access = vitotrol.ReadOnly
-			}
-			if pAttrInfo.Writable {
-				ref.Access |= vitotrol.WriteOnly
-			}
+	ignored, err := f.d.LoadRegistry(f.v)
+	if err != nil {
+		fmt.Printf("GetTypeInfo failed: %s\n", err)
+		return
+	}
 
-			vitotrol.AttributesRef[attrID] = &ref
-			vitotrol.AttributesNames2IDs[ref.Name] = attrID
-			vitotrol.Attributes = append(vitotrol.Attributes, attrID)
+	for _, pAttrInfo := range ignored {
+		// No warning for type used for timesheets...
+		if pAttrInfo.AttributeType != "CircuitTime" {
+			fmt.Printf("loadRegistry: unrecognized type %s for attribute "+
+				"%s-0x%04x. Discard it.\n",
+				pAttrInfo.AttributeType, pAttrInfo.AttributeName, pAttrInfo.AttributeID)
 		}
 	}
 }
@@ -245,7 +283,7 @@ func (a *devicesAction) Do(pOptions *Options, params []string) error {
 		return err
 	}
 
-	for idx, device := range a.v.Devices {
+	for idx, device := range a.v.DeviceList() {
 		fmt.Printf(`Index %d
   LocationName (LocationID): %s (%d)
       DeviceName (DeviceID): %s (%d)
@@ -269,8 +307,12 @@ func (a *listAction) NeedAuth() bool {
 
 func (a *listAction) Do(pOptions *Options, params []string) error {
 	if len(params) == 0 || params[0] == "attrs" {
-		for _, pAttrRef := range vitotrol.AttributesRef {
-			fmt.Println(pAttrRef)
+		registry, err := pOptions.registry()
+		if err != nil {
+			return err
+		}
+		for _, attrID := range registry.IDs() {
+			fmt.Println(registry.Ref(attrID))
 		}
 		return nil
 	}
@@ -307,14 +349,15 @@ func (a *getAction) Do(pOptions *Options, params []string) error {
 	var attrs []vitotrol.AttrID
 	// Special case -> all attributes
 	if len(params) == 1 && params[0] == "all" {
-		a.populateCache()
-		attrs = vitotrol.Attributes
+		a.loadRegistry()
+		attrs = a.d.Registry().IDs()
 	} else {
 		var err error
 		attrs = make([]vitotrol.AttrID, len(params))
 
 		if a.bget {
-			a.populateCache()
+			a.loadRegistry()
+			registry := a.d.Registry()
 
 			var id uint64
 			for idx, attrName := range params {
@@ -325,12 +368,12 @@ func (a *getAction) Do(pOptions *Options, params []string) error {
 				attrs[idx] = vitotrol.AttrID(id)
 
 				// Create a fake String entry for this attribute
-				if _, ok := vitotrol.AttributesRef[vitotrol.AttrID(id)]; !ok {
-					vitotrol.AttributesRef[vitotrol.AttrID(id)] = &vitotrol.AttrRef{
+				if registry.Ref(vitotrol.AttrID(id)) == nil {
+					registry.Add(vitotrol.AttrID(id), vitotrol.AttrRef{
 						Type:   vitotrol.TypeString,
 						Access: vitotrol.ReadOnly,
 						Name:   fmt.Sprintf("0x%04x", id),
-					}
+					})
 				}
 			}
 		} else {
@@ -359,7 +402,7 @@ func (a *getAction) Do(pOptions *Options, params []string) error {
 		return fmt.Errorf("GetData error: %s", err)
 	}
 
-	fmt.Print(a.d.FormatAttributes(attrs))
+	fmt.Print(a.d.FormatAttributesUnits(attrs, pOptions.units()))
 	return nil
 }
 
@@ -369,51 +412,120 @@ type setAction struct {
 }
 
 func (a *setAction) Do(pOptions *Options, params []string) error {
+	fs := flag.NewFlagSet("set", flag.ContinueOnError)
+	rollback := fs.Bool("rollback", false,
+		"if a write fails, set back the already written attributes to their previous value")
+	verify := fs.Bool("verify", false,
+		"read back each written attribute to check the boiler really applied its value")
+	err := fs.Parse(params)
+	if err != nil {
+		return err
+	}
+	params = fs.Args()
+
 	if len(params) == 0 || (len(params)&1) != 0 {
 		return errors.New("PARAMS must be a list of pairs: ATTR_NAME, VALUE")
 	}
 
-	err := a.initVitotrol(pOptions)
+	// Values are checked before logging in, except those of the
+	// attributes only known by the server
+	registry, err := pOptions.registry()
 	if err != nil {
 		return err
 	}
-
-	attrsValues := make(map[vitotrol.AttrID]string, len(params)/2)
 	for idx := 0; idx < len(params); idx += 2 {
-		attrID, err := a.checkAttributeAccess(params[idx], vitotrol.WriteOnly)
+		_, pRef := lookupAttribute(registry, params[idx])
+		if pRef == nil {
+			if pOptions.catalogue != "" {
+				return fmt.Errorf("unknown attribute `%s'", params[idx])
+			}
+			continue
+		}
+		err = checkAccess(pRef, params[idx], vitotrol.WriteOnly)
 		if err != nil {
 			return err
 		}
-
-		value, err := vitotrol.AttributesRef[attrID].Type.Human2VitodataValue(params[idx+1])
+		_, err = setValue(pRef, params[idx], params[idx+1])
 		if err != nil {
-			return fmt.Errorf("value `%s' of attribute %s is invalid: %s",
-				params[idx+1], params[idx], err)
+			return err
 		}
+	}
 
-		attrsValues[attrID] = value
+	err = a.initVitotrol(pOptions)
+	if err != nil {
+		return err
 	}
 
-	// Set them all
-	for attrID, value := range attrsValues {
-		ch, err := a.d.WriteDataWait(a.v, attrID, value)
+	values := make([]vitotrol.AttrValue, 0, len(params)/2)
+	for idx := 0; idx < len(params); idx += 2 {
+		attrID, err := a.checkAttributeAccess(params[idx], vitotrol.WriteOnly)
 		if err != nil {
-			return fmt.Errorf("WriteData error: %s", err)
+			return err
 		}
 
-		if err = <-ch; err != nil {
-			return fmt.Errorf("WriteData failed: %s", err)
+		value, err := setValue(a.d.Registry().Ref(attrID), params[idx], params[idx+1])
+		if err != nil {
+			return err
 		}
+		values = append(values, vitotrol.AttrValue{AttrID: attrID, Value: value})
+	}
 
-		if pOptions.verbose {
-			fmt.Printf("%s attribute successfully set to `%s'\n",
-				vitotrol.AttributesRef[attrID].Name, value)
+	if *rollback {
+		// Read the current values to be able to set them back
+		attrIDs := make([]vitotrol.AttrID, len(values))
+		for idx, av := range values {
+			attrIDs[idx] = av.AttrID
 		}
+		err = a.d.GetData(a.v, attrIDs)
+		if err != nil {
+			return fmt.Errorf("GetData error: %s", err)
+		}
+	}
+
+	// All values are checked before writing the first one
+	report, err := a.d.WriteMany(context.Background(), a.v, values,
+		vitotrol.WithRollback(*rollback),
+		vitotrol.WithOperationOptions(pOptions.operationOptions()...),
+		vitotrol.WithOperationOptions(vitotrol.WithVerify(*verify)))
+	if report == nil {
+		return err
 	}
 
+	for _, res := range report {
+		switch {
+		case res.Err != nil:
+			fmt.Fprintf(os.Stderr, "*** %s attribute not set to `%s': %s\n",
+				res.Name, res.Value, res.Err)
+		case res.RolledBack:
+			fmt.Printf("%s attribute set back to `%s'\n", res.Name, res.Previous.Value)
+		case res.RollbackErr != nil:
+			fmt.Fprintf(os.Stderr, "*** %s attribute set to `%s' but cannot be set back: %s\n",
+				res.Name, res.Value, res.RollbackErr)
+		case pOptions.verbose:
+			fmt.Printf("%s attribute successfully set to `%s'\n", res.Name, res.Value)
+		}
+	}
+	if err != nil {
+		return errors.New("WriteData failed")
+	}
 	return nil
 }
 
+// setValue returns the Vitodata™ formatted value of human value of
+// attribute attrName, after checking it is valid.
+func setValue(pRef *vitotrol.AttrRef, attrName, human string) (string, error) {
+	value, err := pRef.Type.Human2VitodataValue(human)
+	if err != nil {
+		return "", fmt.Errorf("value `%s' of attribute %s is invalid: %s",
+			human, attrName, err)
+	}
+	err = pRef.Validate(value)
+	if err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
 // errorsAction implements the "errors" action.
 type errorsAction struct {
 	authAction
@@ -482,12 +594,13 @@ func (a *setTimesheetAction) Do(pOptions *Options, params []string) error {
 		return err
 	}
 
-	ch, err := a.d.WriteTimesheetDataWait(a.v, tID, tss)
+	op, err := a.d.WriteTimesheetDataAsync(context.Background(), a.v, tID, tss,
+		pOptions.operationOptions()...)
 	if err != nil {
 		return fmt.Errorf("WriteTimesheetData error: %s", err)
 	}
 
-	if err = <-ch; err != nil {
+	if err = op.Wait(context.Background()); err != nil {
 		return fmt.Errorf("WriteTimesheetData failed: %s", err)
 	}
 
@@ -549,17 +662,38 @@ type remoteAttrsAction struct {
 }
 
 func (a *remoteAttrsAction) Do(pOptions *Options, params []string) error {
-	err := a.initVitotrol(pOptions)
+	fs := flag.NewFlagSet("remote_attrs", flag.ContinueOnError)
+	save := fs.String("save", "", "save the attributes to this catalogue file")
+	err := fs.Parse(params)
 	if err != nil {
 		return err
 	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected parameter `%s'", fs.Arg(0))
+	}
 
-	list, err := a.d.GetTypeInfo(a.v)
+	err = a.initVitotrol(pOptions)
 	if err != nil {
 		return err
 	}
 
-	for _, pAttrInfo := range list {
+	catalogue, err := a.d.Catalogue(a.v)
+	if err != nil {
+		return err
+	}
+
+	if *save != "" {
+		err = catalogue.Save(*save)
+		if err != nil {
+			return err
+		}
+		if pOptions.verbose {
+			fmt.Printf("%d attributes saved to %s\n", len(catalogue.Attributes), *save)
+		}
+		return nil
+	}
+
+	for _, pAttrInfo := range catalogue.Attributes {
 		fmt.Printf("- %#v\n", *pAttrInfo)
 	}
 	return nil
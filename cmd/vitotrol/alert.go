@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/TomTom68/go-vitotrol"
+	"github.com/TomTom68/go-vitotrol/alert"
+)
+
+// alertAction implements the "alert" action.
+type alertAction struct {
+	authAction
+}
+
+func (a *alertAction) Do(pOptions *Options, params []string) error {
+	fs := flag.NewFlagSet("alert", flag.ContinueOnError)
+	interval := fs.Duration("interval", alert.DefaultInterval, "duration between two polls")
+	noRefresh := fs.Bool("no-refresh", false, "do not refresh attributes before reading them")
+	once := fs.Bool("once", false, "poll only once then exit")
+	dryRun := fs.Bool("dry-run", false,
+		"poll only once and print the alerts instead of notifying them")
+	err := fs.Parse(params)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return errors.New("RULES_FILE is missing")
+	}
+
+	registry, err := pOptions.registry()
+	if err != nil {
+		return err
+	}
+	config, err := alert.LoadConfigRegistry(fs.Arg(0), registry)
+	if err != nil {
+		return err
+	}
+
+	opts := []alert.Option{
+		alert.WithRegistry(registry),
+		alert.WithInterval(*interval),
+		alert.WithRefresh(!*noRefresh),
+		alert.WithLogger(vitotrol.StdLogger(pOptions.debug)),
+	}
+
+	var engine *alert.Engine
+	if *dryRun {
+		engine, err = alert.NewEngine(config.Rules,
+			[]alert.Sink{&alert.WriterSink{W: os.Stdout}}, opts...)
+	} else {
+		engine, err = config.NewEngine(opts...)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = a.initVitotrol(pOptions)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if *once || *dryRun {
+		alerts, err := engine.Poll(ctx, a.v)
+		if pOptions.verbose {
+			fmt.Printf("%d alerts raised\n", len(alerts))
+		}
+		return err
+	}
+
+	engine.Run(ctx, a.v) //nolint: errcheck
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/TomTom68/go-vitotrol"
+	"github.com/TomTom68/go-vitotrol/exporter"
+)
+
+// exporterAction implements the "exporter" action.
+type exporterAction struct {
+	authAction
+}
+
+func (a *exporterAction) Do(pOptions *Options, params []string) error {
+	fs := flag.NewFlagSet("exporter", flag.ContinueOnError)
+	listen := fs.String("listen", ":9724", "address the /metrics endpoint listens to")
+	interval := fs.Duration("interval", exporter.DefaultInterval,
+		"duration between two polls of the vitodata server")
+	noRefresh := fs.Bool("no-refresh", false,
+		"do not refresh attributes before reading them")
+	err := fs.Parse(params)
+	if err != nil {
+		return err
+	}
+
+	// Attributes are checked before logging in
+	registry, err := pOptions.registry()
+	if err != nil {
+		return err
+	}
+	var attrs []vitotrol.AttrID
+	for _, attrName := range fs.Args() {
+		attrID, ok := registry.ID(attrName)
+		if !ok {
+			return fmt.Errorf("unknown attribute `%s'", attrName)
+		}
+		if registry.Ref(attrID).Access&vitotrol.ReadOnly == 0 {
+			return fmt.Errorf("attribute `%s' is not %s",
+				attrName, vitotrol.AccessToStr[vitotrol.ReadOnly])
+		}
+		attrs = append(attrs, attrID)
+	}
+
+	err = a.initVitotrol(pOptions)
+	if err != nil {
+		return err
+	}
+
+	opts := []exporter.Option{
+		exporter.WithInterval(*interval),
+		exporter.WithRefresh(!*noRefresh),
+		exporter.WithUnits(pOptions.units()),
+		exporter.WithLogger(vitotrol.StdLogger(pOptions.debug)),
+	}
+	if attrs != nil {
+		opts = append(opts, exporter.WithAttributes(attrs...))
+	}
+	exp := exporter.New(a.v, opts...)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", exp)
+	srv := &http.Server{Addr: *listen, Handler: mux}
+
+	go exp.Run(ctx) //nolint: errcheck
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx) //nolint: errcheck
+	}()
+
+	if pOptions.verbose {
+		fmt.Printf("Serving metrics on %s/metrics\n", *listen)
+	}
+
+	err = srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/TomTom68/go-vitotrol"
+	"github.com/TomTom68/go-vitotrol/history"
+)
+
+// historyAction implements the "history" action.
+type historyAction struct{}
+
+func (a *historyAction) NeedAuth() bool {
+	return false
+}
+
+func (a *historyAction) Do(pOptions *Options, params []string) error {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	since := fs.String("since", "1d", "display values newer than this duration (e.g. 7d)")
+	until := fs.String("until", "", "display values older than this duration")
+	bucket := fs.String("bucket", "",
+		"display min/max/avg of values by buckets of this duration (e.g. 1h)")
+	prune := fs.String("prune", "", "first remove values older than this duration")
+
+	if pOptions.history == "" {
+		return errors.New("-history FILE option is missing, it has to precede the action " +
+			"as in: vitotrol -history FILE history ATTR_NAME")
+	}
+
+	// Flags are also accepted after attribute names
+	var attrNames []string
+	for {
+		err := fs.Parse(params)
+		if err != nil {
+			return err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		attrNames = append(attrNames, fs.Arg(0))
+		params = fs.Args()[1:]
+	}
+
+	if len(attrNames) == 0 && *prune == "" {
+		return errors.New("at least one ATTR_NAME is missing")
+	}
+
+	now := time.Now()
+	ago := func(str string) (time.Time, error) {
+		if str == "" {
+			return time.Time{}, nil
+		}
+		d, err := history.ParseDuration(str)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return now.Add(-d), nil
+	}
+
+	var q history.Query
+	var err error
+	q.Since, err = ago(*since)
+	if err != nil {
+		return err
+	}
+	q.Until, err = ago(*until)
+	if err != nil {
+		return err
+	}
+	pruneBefore, err := ago(*prune)
+	if err != nil {
+		return err
+	}
+	var width time.Duration
+	if *bucket != "" {
+		width, err = history.ParseDuration(*bucket)
+		if err != nil {
+			return err
+		}
+		if width <= 0 {
+			return fmt.Errorf("invalid bucket duration `%s'", *bucket)
+		}
+	}
+
+	registry, err := pOptions.registry()
+	if err != nil {
+		return err
+	}
+	if len(attrNames) != 1 || attrNames[0] != "all" {
+		for _, attrName := range attrNames {
+			attrID, ok := registry.ID(attrName)
+			if !ok {
+				return fmt.Errorf("unknown attribute `%s'", attrName)
+			}
+			q.AttrIDs = append(q.AttrIDs, attrID)
+		}
+	}
+
+	store, err := history.Open(pOptions.history, history.WithRegistry(registry))
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if !pruneBefore.IsZero() {
+		removed, err := store.Prune(pruneBefore)
+		if err != nil {
+			return err
+		}
+		if pOptions.verbose {
+			fmt.Printf("%d values removed\n", removed)
+		}
+		if len(attrNames) == 0 {
+			return nil
+		}
+	}
+
+	var output interface{}
+	if width > 0 {
+		buckets, err := store.Downsample(q, width)
+		if err != nil {
+			return err
+		}
+		if !pOptions.jsonOutput {
+			for _, b := range buckets {
+				fmt.Printf("%s %d@%d %s: min=%g max=%g avg=%g (%d values)\n",
+					vitotrol.Time(b.Start.Local()), b.DeviceID, b.LocationID, b.Name(),
+					b.Min, b.Max, b.Avg, b.Count)
+			}
+			return nil
+		}
+		output = buckets
+	} else {
+		records, err := store.Query(q)
+		if err != nil {
+			return err
+		}
+		if !pOptions.jsonOutput {
+			for _, r := range records {
+				fmt.Printf("%s %d@%d %s: %s\n",
+					vitotrol.Time(r.Time.Local()), r.DeviceID, r.LocationID, r.Name(), r.Human())
+			}
+			return nil
+		}
+		output = records
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(output)
+}
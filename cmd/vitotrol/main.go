@@ -6,6 +6,9 @@ import (
 	"fmt"
 	"os"
 	"path"
+
+	"github.com/TomTom68/go-vitotrol"
+	"github.com/TomTom68/go-vitotrol/history"
 )
 
 // Options gathers user parameters together.
@@ -15,7 +18,89 @@ type Options struct {
 	verbose    bool
 	debug      bool
 	jsonOutput bool
+	fahrenheit bool
 	device     string
+	url        string
+	record     string
+	replay     string
+	history    string
+	catalogue  string
+	journal    string
+
+	recorder     *vitotrol.Recorder
+	historyStore *history.Store
+	journalStore *vitotrol.Journal
+	attrRegistry *vitotrol.AttributeRegistry
+}
+
+// operationOptions returns the options of the asynchronous
+// operations required by the user options.
+func (o *Options) operationOptions() []vitotrol.OperationOption {
+	if o.journalStore != nil {
+		return []vitotrol.OperationOption{vitotrol.WithJournal(o.journalStore)}
+	}
+	return nil
+}
+
+// registry returns the attribute registry known before logging in:
+// the one built from the -catalogue file if any, shared by all
+// devices, else the built-in attributes. It is built once.
+func (o *Options) registry() (*vitotrol.AttributeRegistry, error) {
+	if o.attrRegistry != nil {
+		return o.attrRegistry, nil
+	}
+
+	if o.catalogue == "" {
+		o.attrRegistry = vitotrol.NewAttributeRegistry()
+		return o.attrRegistry, nil
+	}
+
+	catalogue, err := vitotrol.LoadCatalogue(o.catalogue)
+	if err != nil {
+		return nil, fmt.Errorf("catalogue: %w", err)
+	}
+	registry, ignored := catalogue.Registry()
+	for _, pAttrInfo := range ignored {
+		// No warning for type used for timesheets...
+		if pAttrInfo.AttributeType != "CircuitTime" {
+			fmt.Printf("catalogue %s: unrecognized type %s for attribute "+
+				"%s-0x%04x. Discard it.\n", o.catalogue,
+				pAttrInfo.AttributeType, pAttrInfo.AttributeName, pAttrInfo.AttributeID)
+		}
+	}
+	o.attrRegistry = registry
+	return registry, nil
+}
+
+// units returns the units conversion required by the user options.
+func (o *Options) units() vitotrol.Units {
+	if o.fahrenheit {
+		return vitotrol.Fahrenheit
+	}
+	return nil
+}
+
+// readConfig reads the login and password of the config file,
+// opened from the config path. It must not be readable by others.
+func readConfig(file *os.File, config string) (string, string, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return "", "", fmt.Errorf("Cannot stat `%s' file: %s", config, err)
+	}
+
+	if (info.Mode() & 06) != 0 {
+		return "", "", fmt.Errorf(
+			"`%s' file readdable and/or writtable by others. Abort!", config)
+	}
+
+	rd := bufio.NewReader(file)
+	login, _ := rd.ReadString('\n')
+	password, err := rd.ReadString('\n')
+	if err != nil {
+		return "", "", fmt.Errorf("Invalid config file `%s' contents, must contain "+
+			"login and password on two separate lines", config)
+	}
+	return login[:len(login)-1], password[:len(password)-1], nil
 }
 
 func main() {
@@ -38,7 +123,13 @@ ACTION & PARAMS can be:
 - rbget ATTR_IDX ...   refresh then get the value of attributes ATTR_IDX, ...
                          on vitodata server without checking their validity
                          before (for developing purpose)
-- set ATTR_NAME VALUE  set the value of attribute ATTR_NAME to VALUE
+- set [-rollback] [-verify] ATTR_NAME VALUE ...
+                       set the value of attributes ATTR_NAME, ... to VALUE, ...
+                         all values being checked before writing the first
+                         one; with -rollback, if a write fails, set back the
+                         already written attributes to their previous value;
+                         with -verify, read back each written attribute to
+                         check the boiler really applied its value
 - timesheet TIMESHEET ...
                        get the timesheet TIMESHEET data
 - set_timesheet TIMESHEET '{"wday":[{"from":630,"to":2200},...],...}'
@@ -47,8 +138,44 @@ ACTION & PARAMS can be:
                        (eg. mon-wed or sat-mon)
                        The JSON content can be in a file with the syntax @file
 - errors               get the error history
-- remote_attrs         list server available attributes
-                         (for developing purpose)`)
+- remote_attrs [-save FILE]
+                       list server available attributes (for developing
+                         purpose), or save them to the catalogue FILE to be
+                         used later with -catalogue option
+- exporter [-listen ADDR] [-interval DURATION] [-no-refresh] [ATTR_NAME ...]
+                       serve the attributes ATTR_NAME, ... (default all
+                         readable ones) of all devices as Prometheus metrics
+                         on http://ADDR/metrics (default :9724)
+- mqtt [-broker ADDR] [-prefix PREFIX] [-interval DURATION] [ATTR_NAME ...]
+                       bridge the attributes ATTR_NAME, ... (default all) of
+                         all devices to a MQTT broker, with Home Assistant
+                         discovery (see -h for all options)
+- poll [-format influx|csv] [-output FILE] [-interval DURATION] [-count N]
+       ATTR_NAME ...|all
+                       periodically refresh then get the value of attributes
+                         ATTR_NAME, ... and append the new ones (in InfluxDB
+                         line protocol or CSV format) to FILE or stdout
+- serve [-listen ADDR] [-max-age DURATION]
+                       serve a local HTTP JSON API (default on
+                         localhost:9725) sharing this session: list devices,
+                         read and write attributes, timesheets and errors
+                         (see gateway package documentation)
+- history [-since DURATION] [-until DURATION] [-bucket DURATION]
+          [-prune DURATION] ATTR_NAME ...|all
+                       display the values of attributes ATTR_NAME, ... stored
+                         in the -history FILE (default since 1d), or their
+                         min/max/avg by bucket; DURATION accepts d and w
+                         units (e.g. 7d)
+- alert [-interval DURATION] [-once] [-dry-run] RULES_FILE
+                       periodically evaluate the JSON rules of RULES_FILE
+                         against the attributes and the active errors of all
+                         devices, and notify the new alerts to the sinks
+                         (webhook, smtp or exec) of RULES_FILE; -dry-run
+                         evaluates once and prints the alerts
+- status [-refresh] [-wait] [REFRESH_ID ...]
+                       display the status of the asynchronous operations
+                         REFRESH_ID, ... (default the pending ones recorded
+                         in the -journal FILE), or wait for their end`)
 	}
 
 	var options Options
@@ -59,10 +186,24 @@ ACTION & PARAMS can be:
 	flag.StringVar(&options.device, "device", "0",
 		"DeviceID, index, DeviceName, "+
 			"DeviceId@LocationID, DeviceName@LocationName (see `devices' action)")
+	flag.StringVar(&options.url, "url", vitotrol.MainURL,
+		"vitotrol API endpoint (e.g. a vitotroltest server)")
+	flag.StringVar(&options.record, "record", "",
+		"record the SOAP exchanges, credentials excluded, into this cassette file")
+	flag.StringVar(&options.replay, "replay", "",
+		"replay the SOAP exchanges of this cassette file instead of using the network")
+	flag.StringVar(&options.catalogue, "catalogue", "",
+		"read the device attributes from this catalogue file instead of the server (see `remote_attrs' action)")
+	flag.StringVar(&options.history, "history", "",
+		"append each read attribute value to this history file (see `history' action)")
+	flag.StringVar(&options.journal, "journal", "",
+		"record the pending writes into this journal file (see `status' action)")
 	flag.BoolVar(&options.verbose, "verbose", false, "print verbose information")
 	flag.BoolVar(&options.debug, "debug", false, "print debug information")
 	flag.BoolVar(&options.jsonOutput, "json", false,
-		"used by `timesheet' action to display timesheets using JSON format")
+		"used by timesheet and history actions to display data using JSON format")
+	flag.BoolVar(&options.fahrenheit, "fahrenheit", false,
+		"used by get, rget and exporter actions to convert temperatures to °F")
 
 	flag.Parse()
 
@@ -81,7 +222,7 @@ ACTION & PARAMS can be:
 	}
 
 	var err error
-	if action.NeedAuth() {
+	if action.NeedAuth() && options.replay == "" {
 		// Load config if login OR password is missing
 		if options.login == "" || options.password == "" {
 			if config == "" {
@@ -97,38 +238,40 @@ ACTION & PARAMS can be:
 				os.Exit(1)
 			}
 
-			info, err := file.Stat()
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Cannot stat `%s' file: %s\n", config, err)
-				os.Exit(1)
-			}
-
-			if (info.Mode() & 06) != 0 {
-				fmt.Fprintf(os.Stderr,
-					"`%s' file readdable and/or writtable by others. Abort!\n", config)
-				os.Exit(1)
-			}
-
-			rd := bufio.NewReader(file)
-			confLogin, _ := rd.ReadString('\n')
-			confPassword, err := rd.ReadString('\n')
+			confLogin, confPassword, err := readConfig(file, config)
 			file.Close()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Invalid config file `%s' contents, must contain "+
-					"login and password on two separate lines\n", config)
+				fmt.Fprintln(os.Stderr, err)
 				os.Exit(1)
 			}
 
 			if options.login == "" {
-				options.login = confLogin[:len(confLogin)-1]
+				options.login = confLogin
 			}
 			if options.password == "" {
-				options.password = confPassword[:len(confPassword)-1]
+				options.password = confPassword
 			}
 		}
 	}
 
 	err = action.Do(&options, params)
+
+	if options.recorder != nil {
+		errSave := options.recorder.Cassette().Save(options.record)
+		if errSave != nil {
+			fmt.Fprintf(os.Stderr, "*** cannot save cassette `%s': %s\n",
+				options.record, errSave)
+		}
+	}
+
+	if options.historyStore != nil {
+		errClose := options.historyStore.Close()
+		if errClose != nil {
+			fmt.Fprintf(os.Stderr, "*** cannot close history `%s': %s\n",
+				options.history, errClose)
+		}
+	}
+
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "***", err)
 		os.Exit(1)
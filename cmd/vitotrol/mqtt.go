@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/TomTom68/go-vitotrol"
+	"github.com/TomTom68/go-vitotrol/mqtt"
+)
+
+// brokerPasswordEnv is the environment variable containing the MQTT
+// broker password, so it does not appear in the command line.
+const brokerPasswordEnv = "VITOTROL_BROKER_PASSWORD"
+
+// mqttAction implements the "mqtt" action.
+type mqttAction struct {
+	authAction
+}
+
+func (a *mqttAction) Do(pOptions *Options, params []string) error {
+	fs := flag.NewFlagSet("mqtt", flag.ContinueOnError)
+	broker := fs.String("broker", "localhost:1883", "MQTT broker address")
+	brokerUser := fs.String("broker-user", "", "MQTT broker user name")
+	brokerConfig := fs.String("broker-config", "",
+		"MQTT broker user+password config file, same format as -config "+
+			"(the password can also be set in $"+brokerPasswordEnv+")")
+	clientID := fs.String("client-id", "vitotrol", "MQTT client identifier")
+	prefix := fs.String("prefix", mqtt.DefaultTopicPrefix, "topics prefix")
+	discoveryPrefix := fs.String("discovery-prefix", mqtt.DefaultDiscoveryPrefix,
+		"Home Assistant discovery prefix, empty to disable discovery")
+	interval := fs.Duration("interval", mqtt.DefaultInterval,
+		"duration between two polls of the vitodata server")
+	noRefresh := fs.Bool("no-refresh", false,
+		"do not refresh attributes before reading them")
+	err := fs.Parse(params)
+	if err != nil {
+		return err
+	}
+
+	user, password := *brokerUser, os.Getenv(brokerPasswordEnv)
+	if *brokerConfig != "" {
+		file, err := os.Open(*brokerConfig)
+		if err != nil {
+			return fmt.Errorf("cannot open MQTT broker config: %s", err)
+		}
+		confUser, confPassword, err := readConfig(file, *brokerConfig)
+		file.Close()
+		if err != nil {
+			return err
+		}
+		if user == "" {
+			user = confUser
+		}
+		if password == "" {
+			password = confPassword
+		}
+	}
+
+	// Attributes are checked before logging in
+	registry, err := pOptions.registry()
+	if err != nil {
+		return err
+	}
+	var attrs []vitotrol.AttrID
+	for _, attrName := range fs.Args() {
+		attrID, ok := registry.ID(attrName)
+		if !ok {
+			return fmt.Errorf("unknown attribute `%s'", attrName)
+		}
+		attrs = append(attrs, attrID)
+	}
+
+	err = a.initVitotrol(pOptions)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	logger := vitotrol.StdLogger(pOptions.debug)
+
+	// bridgeReady is closed once bridge is set
+	var bridge *mqtt.Bridge
+	bridgeReady := make(chan struct{})
+	conn, err := mqtt.DialReconnect(ctx, *broker,
+		mqtt.WithClientID(*clientID),
+		mqtt.WithAuth(user, password),
+		mqtt.WithWill(mqtt.StatusTopic(*prefix), []byte(mqtt.Offline), true),
+		mqtt.WithConnLogger(logger),
+		// The broker published the will message when the connection
+		// was lost, and could have lost the retained messages if it
+		// restarted
+		mqtt.WithOnReconnect(func(ctx context.Context, c mqtt.Client) error {
+			select {
+			case <-bridgeReady:
+				bridge.Reset()
+			default: // nothing published yet
+			}
+			return c.Publish(ctx, mqtt.StatusTopic(*prefix), []byte(mqtt.Online), true)
+		}))
+	if err != nil {
+		return fmt.Errorf("cannot connect to MQTT broker: %s", err)
+	}
+	defer conn.Close()
+
+	opts := []mqtt.BridgeOption{
+		mqtt.WithTopicPrefix(*prefix),
+		mqtt.WithDiscoveryPrefix(*discoveryPrefix),
+		mqtt.WithInterval(*interval),
+		mqtt.WithRefresh(!*noRefresh),
+		mqtt.WithLogger(logger),
+	}
+	if attrs != nil {
+		opts = append(opts, mqtt.WithAttributes(attrs...))
+	}
+	bridge = mqtt.NewBridge(a.v, conn, opts...)
+	close(bridgeReady)
+
+	if pOptions.verbose {
+		fmt.Printf("Bridging to MQTT broker %s\n", *broker)
+	}
+
+	err = bridge.Run(ctx)
+	if err == context.Canceled {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/TomTom68/go-vitotrol"
+	"github.com/TomTom68/go-vitotrol/timeseries"
+)
+
+// pollAction implements the "poll" action.
+type pollAction struct {
+	foreignAttrs
+}
+
+func (a *pollAction) Do(pOptions *Options, params []string) error {
+	fs := flag.NewFlagSet("poll", flag.ContinueOnError)
+	format := fs.String("format", "influx", "output format: influx or csv")
+	output := fs.String("output", "", "file to append points to (default stdout)")
+	measurement := fs.String("measurement", timeseries.DefaultMeasurement,
+		"InfluxDB measurement name")
+	interval := fs.Duration("interval", 5*time.Minute, "duration between two polls")
+	count := fs.Int("count", 0, "number of polls, 0 means until interrupted")
+	noRefresh := fs.Bool("no-refresh", false,
+		"do not refresh attributes before reading them")
+	err := fs.Parse(params)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("at least one ATTR_NAME is missing")
+	}
+	if *format != "influx" && *format != "csv" {
+		return fmt.Errorf("unknown format `%s'", *format)
+	}
+
+	err = a.initVitotrol(pOptions)
+	if err != nil {
+		return err
+	}
+
+	var attrs []vitotrol.AttrID
+	if fs.NArg() == 1 && fs.Arg(0) == "all" {
+		a.loadRegistry()
+		attrs = a.d.Registry().IDs()
+	} else {
+		attrs = make([]vitotrol.AttrID, fs.NArg())
+		for idx, attrName := range fs.Args() {
+			attrs[idx], err = a.checkAttributeAccess(attrName, vitotrol.ReadOnly)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	var out io.Writer = os.Stdout
+	newFile := false
+	if *output != "" {
+		file, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		newFile = info.Size() == 0
+		out = file
+	}
+
+	var w timeseries.Writer
+	if *format == "csv" {
+		w = timeseries.NewCSVWriter(out, *output == "" || newFile)
+	} else {
+		w = timeseries.NewLineProtocolWriter(out, *measurement)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	tracker := timeseries.NewTracker()
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for num := 1; ; num++ {
+		if !*noRefresh {
+			ch, err := a.d.RefreshDataWaitContext(ctx, a.v, attrs)
+			if err == nil {
+				err = <-ch
+			}
+			if err != nil && ctx.Err() == nil {
+				// Values cached by the server are read anyway
+				fmt.Fprintf(os.Stderr, "*** RefreshData failed: %s\n", err)
+			}
+		}
+
+		err = a.d.GetDataContext(ctx, a.v, attrs)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			fmt.Fprintf(os.Stderr, "*** GetData failed: %s\n", err)
+		} else {
+			err = w.WritePoints(tracker.Points(a.d, attrs))
+			if err != nil {
+				return err
+			}
+		}
+
+		if num == *count {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/TomTom68/go-vitotrol"
+	"github.com/TomTom68/go-vitotrol/gateway"
+)
+
+// serveAction implements the "serve" action.
+type serveAction struct {
+	authAction
+}
+
+func (a *serveAction) Do(pOptions *Options, params []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	listen := fs.String("listen", "localhost:9725", "address the JSON API listens to")
+	maxAge := fs.Duration("max-age", gateway.DefaultMaxAge,
+		"duration during which a read attribute is served from the cache")
+	err := fs.Parse(params)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected parameter `%s'", fs.Arg(0))
+	}
+
+	err = a.initVitotrol(pOptions)
+	if err != nil {
+		return err
+	}
+
+	gw := gateway.New(a.v,
+		gateway.WithMaxAge(*maxAge),
+		gateway.WithLogger(vitotrol.StdLogger(pOptions.debug)))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	srv := &http.Server{Addr: *listen, Handler: gw}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx) //nolint: errcheck
+	}()
+
+	if pOptions.verbose {
+		fmt.Printf("Serving JSON API on http://%s/devices\n", *listen)
+	}
+
+	err = srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/TomTom68/go-vitotrol"
+)
+
+// statusAction implements the "status" action.
+type statusAction struct {
+	authAction
+}
+
+func (a *statusAction) Do(pOptions *Options, params []string) error {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	refresh := fs.Bool("refresh", false,
+		"REFRESH_IDs not found in the -journal FILE are refreshes, not writes")
+	wait := fs.Bool("wait", false, "wait for the end of the operations")
+	err := fs.Parse(params)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 && pOptions.journal == "" {
+		return errors.New("REFRESH_ID or -journal FILE option is missing")
+	}
+
+	err = a.initVitotrol(pOptions)
+	if err != nil {
+		return err
+	}
+	journal := pOptions.journalStore
+
+	var infos []vitotrol.OperationInfo
+	if fs.NArg() == 0 {
+		infos = journal.Pending()
+		if len(infos) == 0 {
+			if pOptions.verbose {
+				fmt.Println("No pending operation")
+			}
+			return nil
+		}
+	}
+	for _, refreshID := range fs.Args() {
+		info, ok := vitotrol.OperationInfo{}, false
+		if journal != nil {
+			info, ok = journal.Get(refreshID)
+		}
+		if !ok {
+			info = vitotrol.OperationInfo{RefreshID: refreshID, Kind: vitotrol.OperationWrite}
+			if *refresh {
+				info.Kind = vitotrol.OperationRefresh
+			}
+		}
+		infos = append(infos, info)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if *wait {
+		ops := make([]*vitotrol.Operation, len(infos))
+		for idx, info := range infos {
+			ops[idx] = a.v.ResumeOperation(ctx, info, pOptions.operationOptions()...)
+		}
+		for _, op := range ops {
+			err := op.Wait(ctx)
+			if err != nil && op.State() != vitotrol.OperationTimedOut &&
+				op.State() != vitotrol.OperationFailed {
+				return err
+			}
+			printOperation(op.Info(), op.Status(), op.State(), op.Err())
+		}
+		return nil
+	}
+
+	for _, info := range infos {
+		var status int
+		if info.Kind == vitotrol.OperationRefresh {
+			status, err = a.v.RequestRefreshStatusContext(ctx, info.RefreshID)
+		} else {
+			status, err = a.v.RequestWriteStatusContext(ctx, info.RefreshID)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", info.RefreshID, err)
+		}
+
+		state := vitotrol.StatusState(status)
+		if state != vitotrol.OperationPending && journal != nil {
+			err = journal.Remove(info.RefreshID)
+			if err != nil {
+				return err
+			}
+		}
+		printOperation(info, status, state, nil)
+	}
+	return nil
+}
+
+func printOperation(info vitotrol.OperationInfo, status int, state vitotrol.OperationState, err error) {
+	fmt.Printf("%s %s", info.RefreshID, info.Kind)
+	if info.Attribute != "" {
+		fmt.Printf(" %s", info.Attribute)
+		if info.Value != "" {
+			fmt.Printf("=%s", info.Value)
+		}
+	}
+	if info.DeviceID != 0 {
+		fmt.Printf(" on %d@%d", info.DeviceID, info.LocationID)
+	}
+	if !info.Issued.IsZero() {
+		fmt.Printf(" issued %s", vitotrol.Time(info.Issued.Local()))
+	}
+
+	fmt.Printf(": %s (status %d)", state, status)
+	if err != nil && (state != vitotrol.OperationFailed || status == 0) {
+		fmt.Printf(": %s", err)
+	}
+	fmt.Println()
+}
@@ -0,0 +1,96 @@
+package vitotrol
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	td "github.com/maxatome/go-testdeep"
+)
+
+func TestConcurrentSession(tt *testing.T) {
+	t := td.NewT(tt)
+
+	responses := map[string]string{
+		"GetDevices": `<Ergebnis>0</Ergebnis>
+<AnlageListe><AnlageV2>
+  <AnlageId>5678</AnlageId>
+  <GeraeteListe><GeraetV2><GeraetId>1234</GeraetId></GeraetV2></GeraeteListe>
+</AnlageV2></AnlageListe>`,
+		"GetData": `<Ergebnis>0</Ergebnis>
+<DatenwerteListe><WerteListe>
+  <DatenpunktId>5373</DatenpunktId>
+  <Wert>12.5</Wert>
+  <Zeitstempel>` + testTimeStr + `</Zeitstempel>
+</WerteListe></DatenwerteListe>`,
+		"RefreshData":          `<Ergebnis>0</Ergebnis><AktualisierungsId>42</AktualisierungsId>`,
+		"RequestRefreshStatus": `<Ergebnis>0</Ergebnis><Status>4</Status>`,
+		"GetErrorHistory": `<Ergebnis>0</Ergebnis>
+<FehlerListe><FehlerHistorie><FehlerCode>F4</FehlerCode></FehlerHistorie></FehlerListe>`,
+		"GetTimesheetData": `<Ergebnis>0</Ergebnis>
+<SchaltsatzDaten><Schaltzeiten><Schaltzeit>
+  <Wochentag>Mon</Wochentag><ZeitVon>600</ZeitVon><ZeitBis>2200</ZeitBis>
+</Schaltzeit></Schaltzeiten></SchaltsatzDaten>`,
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			soapAction := r.Header.Get("SOAPAction")
+			soapAction = soapAction[strings.LastIndex(soapAction, "/")+1:]
+			w.Header().Add("Set-Cookie", "session="+soapAction)
+			fmt.Fprintln(w,
+				respHeader+intoDeviceResponse(soapAction, responses[soapAction])+respFooter)
+		}))
+	defer ts.Close()
+
+	v := NewSession(WithURL(ts.URL), withTestPollPolicy(0, time.Minute))
+	if !t.CmpNoError(v.GetDevices()) {
+		return
+	}
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				t.CmpNoError(v.GetDevicesContext(ctx))
+				for _, d := range v.DeviceList() {
+					t.CmpNoError(d.GetDataContext(ctx, v, []AttrID{AussenTemp}))
+					t.CmpNoError(d.GetErrorHistoryContext(ctx, v))
+					t.CmpNoError(d.GetTimesheetDataContext(ctx, v, HeatingTimesheet))
+
+					ch, err := d.RefreshDataWaitContext(ctx, v, []AttrID{AussenTemp})
+					if t.CmpNoError(err) {
+						t.CmpNoError(<-ch)
+					}
+
+					d.FormatAttributes([]AttrID{AussenTemp})
+					d.AttributesSnapshot()
+					d.ErrorsSnapshot()
+					d.Timesheet(HeatingTimesheet)
+					if value, ok := d.Attribute(AussenTemp); ok {
+						t.CmpDeeply(value.Value, "12.5")
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	devices := v.DeviceList()
+	if t.Len(devices, 1) {
+		t.CmpDeeply(devices[0].AttributesSnapshot(),
+			map[AttrID]Value{AussenTemp: {Value: "12.5", Time: testTime}})
+		t.CmpDeeply(devices[0].ErrorsSnapshot(),
+			[]ErrorHistoryEvent{{Error: "F4"}})
+		t.CmpDeeply(devices[0].Timesheet(HeatingTimesheet),
+			map[string]TimeslotSlice{"mon": {{From: 600, To: 2200}}})
+	}
+}
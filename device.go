@@ -2,13 +2,14 @@ package vitotrol
 
 import (
 	"bytes"
+	"context"
+	"encoding/xml"
 	"errors"
 	"fmt"
-	"log"
 	"sort"
 	"strconv"
 	"strings"
-	"time"
+	"sync"
 )
 
 // Device represents one Vitotrol™ device (a priori a boiler).
@@ -26,16 +27,94 @@ type Device struct {
 	Timesheets map[TimesheetID]map[string]TimeslotSlice
 	// cache of last read errors (filled by GetErrorHistory)
 	Errors []ErrorHistoryEvent
+
+	registry *AttributeRegistry // see Registry method
+
+	mu sync.RWMutex // protects Attributes, Timesheets, Errors & registry
+}
+
+// Attribute returns a copy of the last read value of attribute
+// attrID, and false if this attribute has never been read.
+//
+// Contrary to Attributes field, it can be safely called while
+// GetData is running in another goroutine.
+func (d *Device) Attribute(attrID AttrID) (Value, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	pValue := d.Attributes[attrID]
+	if pValue == nil {
+		return Value{}, false
+	}
+	return *pValue, true
+}
+
+// AttributesSnapshot returns a copy of all last read attributes
+// values.
+//
+// Contrary to Attributes field, it can be safely called while
+// GetData is running in another goroutine.
+func (d *Device) AttributesSnapshot() map[AttrID]Value {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	snap := make(map[AttrID]Value, len(d.Attributes))
+	for attrID, pValue := range d.Attributes {
+		if pValue != nil {
+			snap[attrID] = *pValue
+		}
+	}
+	return snap
+}
+
+// Timesheet returns a copy of the last read timesheet id, or nil if
+// it has never been read.
+//
+// Contrary to Timesheets field, it can be safely called while
+// GetTimesheetData is running in another goroutine.
+func (d *Device) Timesheet(id TimesheetID) map[string]TimeslotSlice {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	timesheet := d.Timesheets[id]
+	if timesheet == nil {
+		return nil
+	}
+
+	snap := make(map[string]TimeslotSlice, len(timesheet))
+	for day, slots := range timesheet {
+		snap[day] = append(TimeslotSlice(nil), slots...)
+	}
+	return snap
+}
+
+// ErrorsSnapshot returns a copy of the last read errors.
+//
+// Contrary to Errors field, it can be safely called while
+// GetErrorHistory is running in another goroutine.
+func (d *Device) ErrorsSnapshot() []ErrorHistoryEvent {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return append([]ErrorHistoryEvent(nil), d.Errors...)
 }
 
 // FormatAttributes displays informations about selected
 // attributes. Displays information about all known attributes when a
 // nil slice is passed.
 func (d *Device) FormatAttributes(attrs []AttrID) string {
+	return d.FormatAttributesUnits(attrs, nil)
+}
+
+// FormatAttributesUnits is the same as FormatAttributes but converts
+// the values as required by units. Unless units is nil, values are
+// followed by the symbol of their unit.
+func (d *Device) FormatAttributesUnits(attrs []AttrID, units Units) string {
+	registry := d.Registry()
 	buf := bytes.NewBuffer(nil)
 
 	pConcatFun := func(attrID AttrID, pValue *Value) {
-		pRef := AttributesRef[attrID]
+		pRef := registry.Ref(attrID)
 		if pRef == nil { //nolint: gocritic
 			buf.WriteString(
 				fmt.Sprintf("%d: %s@%s\n", attrID, pValue.Value, pValue.Time))
@@ -43,6 +122,9 @@ func (d *Device) FormatAttributes(attrs []AttrID) string {
 			humanValue, err := pRef.Type.Vitodata2HumanValue(pValue.Value)
 			if err != nil {
 				humanValue = fmt.Sprintf("unknown-value<%s>", pValue.Value)
+			} else if units != nil {
+				value, unit := units.ConvertValue(pRef, humanValue)
+				humanValue = value + unit.Symbol()
 			}
 			buf.WriteString(
 				fmt.Sprintf("%s: %s@%s (%s)\n",
@@ -53,6 +135,9 @@ func (d *Device) FormatAttributes(attrs []AttrID) string {
 		}
 	}
 
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
 	for _, attrID := range attrs {
 		pConcatFun(attrID, d.Attributes[attrID])
 	}
@@ -60,22 +145,31 @@ func (d *Device) FormatAttributes(attrs []AttrID) string {
 	return buf.String()
 }
 
-func (d *Device) buildBody(soapAction string, reqBody string) string {
-	return fmt.Sprintf(`<%s>
-<GeraetId>%d</GeraetId>
-<AnlageId>%d</AnlageId>
-%s
-</%[1]s>`, soapAction, d.DeviceID, d.LocationID, reqBody)
+// DeviceHeader is included at the beginning of each Vitotrol™
+// request targeting a device.
+type DeviceHeader struct {
+	DeviceID   uint32 `xml:"GeraetId"`
+	LocationID uint32 `xml:"AnlageId"`
 }
 
-func (d *Device) sendRequest(v *Session, soapAction string, reqBody string, respBody HasResultHeader) error {
-	return v.sendRequest(soapAction, d.buildBody(soapAction, reqBody), respBody)
+func (d *Device) header() DeviceHeader {
+	return DeviceHeader{
+		DeviceID:   d.DeviceID,
+		LocationID: d.LocationID,
+	}
 }
 
 //
 // GetData
 //
 
+// GetDataRequest is a GetData request.
+type GetDataRequest struct {
+	XMLName xml.Name `xml:"GetData"`
+	DeviceHeader
+	AttrIDs []AttrID `xml:"DatenpunktIds>int"`
+}
+
 type getDataValue struct {
 	ID    uint32 `xml:"DatenpunktId"`
 	Value string `xml:"Wert"`
@@ -95,42 +189,68 @@ func (r *GetDataResponse) ResultHeader() *ResultHeader {
 	return &r.GetDataResult.ResultHeader
 }
 
-func makeDatenpunktIDs(attrIDs []AttrID) string {
-	body := bytes.NewBufferString("<DatenpunktIds>")
-
-	for _, id := range attrIDs {
-		body.WriteString(fmt.Sprintf("<int>%d</int>", id))
-	}
-
-	body.WriteString("</DatenpunktIds>")
-
-	return body.String()
-}
-
 // GetData launches the Vitotrol™ GetData request. Populates the
 // internal cache before returning (see Attributes field).
 func (d *Device) GetData(v *Session, attrIDs []AttrID) error {
+	return d.GetDataContext(context.Background(), v, attrIDs)
+}
+
+// GetDataContext is the same as GetData but honours ctx cancellation
+// and deadline.
+func (d *Device) GetDataContext(ctx context.Context, v *Session, attrIDs []AttrID) error {
+	_, err := d.getData(ctx, v, attrIDs)
+	return err
+}
+
+// getData is GetDataContext also returning the values received in
+// the response, the cache possibly holding older values of the
+// attributes missing from it.
+func (d *Device) getData(ctx context.Context, v *Session, attrIDs []AttrID) (map[AttrID]Value, error) {
 	var resp GetDataResponse
-	err := d.sendRequest(v, "GetData", makeDatenpunktIDs(attrIDs), &resp)
+	err := v.request(ctx, "GetData", &GetDataRequest{
+		DeviceHeader: d.header(),
+		AttrIDs:      attrIDs,
+	}, &resp, true)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	values := make(map[AttrID]Value, len(resp.GetDataResult.Values))
+
+	d.mu.Lock()
+	if d.Attributes == nil {
+		d.Attributes = map[AttrID]*Value{}
 	}
 
 	// On met en cache
 	for _, respValue := range resp.GetDataResult.Values {
-		d.Attributes[AttrID(respValue.ID)] = &Value{
+		value := Value{
 			Time:  respValue.Time,
 			Value: respValue.Value,
 		}
+		d.Attributes[AttrID(respValue.ID)] = &value
+		values[AttrID(respValue.ID)] = value
 	}
+	d.mu.Unlock()
 
-	return nil
+	if len(v.observers) > 0 {
+		v.observeData(d, values)
+	}
+	return values, nil
 }
 
 //
 // WriteData
 //
 
+// WriteDataRequest is a WriteData request.
+type WriteDataRequest struct {
+	XMLName xml.Name `xml:"WriteData"`
+	DeviceHeader
+	AttrID AttrID `xml:"DatapointId"`
+	Value  string `xml:"Wert"`
+}
+
 // WriteDataResponse is a response to a WriteData request.
 type WriteDataResponse struct {
 	WriteDataResult struct {
@@ -146,57 +266,91 @@ func (r *WriteDataResponse) ResultHeader() *ResultHeader {
 
 // WriteData launches the Vitotrol™ WriteData request and returns the
 // "refresh ID" sent back by the server. Use WriteDataWait instead.
+//
+// If attrID is known by the registry of d, value is first validated
+// against its constraints (see AttrRef.Validate) and a
+// *ValidationError is returned without any request if it is rejected.
 func (d *Device) WriteData(v *Session, attrID AttrID, value string) (string, error) {
+	return d.WriteDataContext(context.Background(), v, attrID, value)
+}
+
+// WriteDataContext is the same as WriteData but honours ctx
+// cancellation and deadline.
+func (d *Device) WriteDataContext(ctx context.Context, v *Session, attrID AttrID, value string) (string, error) {
+	if pRef := d.Registry().Ref(attrID); pRef != nil {
+		err := pRef.Validate(value)
+		if err != nil {
+			return "", err
+		}
+	}
+
 	var resp WriteDataResponse
-	err := d.sendRequest(v, "WriteData",
-		fmt.Sprintf("<DatapointId>%d</DatapointId><Wert>%s</Wert>", attrID, value),
-		&resp)
+	err := v.request(ctx, "WriteData", &WriteDataRequest{
+		DeviceHeader: d.header(),
+		AttrID:       attrID,
+		Value:        value,
+	}, &resp, false)
 	if err != nil {
 		return "", err
 	}
 	return resp.WriteDataResult.RefreshID, nil
 }
 
-var (
-	// WriteDataWaitDuration defines the duration to wait in
-	// WriteDataWait after the WriteData call before calling
-	// RequestWriteStatus for the first time. After that first call, the
-	// next pause duration will be divided by 4 and so on.
-	WriteDataWaitDuration = 4 * time.Second
-	// WriteDataWaitMinDuration defines the minimal duration of pauses
-	// between RequestWriteStatus calls.
-	WriteDataWaitMinDuration = 1 * time.Second
-	// WriteDataWaitTimeout is the max amount of time to wait during
-	// WriteDataWait method call before returning a ErrTimeout error.
-	WriteDataWaitTimeout = 60 * time.Second
-)
-
 // WriteDataWait launches the Vitotrol™ WriteData request and returns
 // a channel on which the final error (asynchronous one) will be
 // received (nil if the data has been correctly written).
 //
 // If an error occurs during the WriteData call (synchronous one), a
 // nil channel is returned with an error.
-func (d *Device) WriteDataWait(v *Session, attrID AttrID, value string) (<-chan error, error) {
-	refreshID, err := d.WriteData(v, attrID, value)
+//
+// The completion is polled following the session PollPolicy (see
+// WithPollPolicy option). opts customize the Operation following it,
+// as WithVerify option that reads the attribute back once written
+// and sends a *MismatchError on the channel if its value differs.
+func (d *Device) WriteDataWait(v *Session, attrID AttrID, value string, opts ...OperationOption) (<-chan error, error) {
+	return d.WriteDataWaitContext(context.Background(), v, attrID, value, opts...)
+}
+
+// WriteDataWaitContext is the same as WriteDataWait but honours ctx
+// cancellation and deadline, during the WriteData call as well as
+// during the wait of its completion. In the latter case, ctx.Err() is
+// received on the returned channel.
+func (d *Device) WriteDataWaitContext(ctx context.Context, v *Session, attrID AttrID, value string, opts ...OperationOption) (<-chan error, error) {
+	op, err := d.WriteDataAsync(ctx, v, attrID, value, opts...)
 	if err != nil {
 		return nil, err
 	}
+	return op.errChan(), nil
+}
 
-	ch := make(chan error)
-
-	go waitAsyncStatus(v, refreshID, ch, (*Session).RequestWriteStatus,
-		WriteDataWaitDuration,
-		WriteDataWaitMinDuration,
-		WriteDataWaitTimeout)
+// WriteDataAsync launches the Vitotrol™ WriteData request and returns
+// the Operation following its completion. The wait is the same as
+// WriteDataWaitContext one, and can be canceled using ctx or
+// Operation.Cancel.
+func (d *Device) WriteDataAsync(ctx context.Context, v *Session, attrID AttrID, value string, opts ...OperationOption) (*Operation, error) {
+	refreshID, err := d.WriteDataContext(ctx, v, attrID, value)
+	if err != nil {
+		return nil, err
+	}
 
-	return ch, nil
+	check := withCheck(func(ctx context.Context) error {
+		return d.verifyWrite(ctx, v, attrID, value, opts)
+	})
+	return newOperation(ctx, v, d.operationInfo(OperationWrite, refreshID, d.attrName(attrID), value),
+		false, append(opts[:len(opts):len(opts)], check)), nil
 }
 
 //
 // RefreshData
 //
 
+// RefreshDataRequest is a RefreshData request.
+type RefreshDataRequest struct {
+	XMLName xml.Name `xml:"RefreshData"`
+	DeviceHeader
+	AttrIDs []AttrID `xml:"DatenpunktIds>int"`
+}
+
 // RefreshDataResponse is a response to a RefreshData request.
 type RefreshDataResponse struct {
 	RefreshDataResult struct {
@@ -214,54 +368,72 @@ func (r *RefreshDataResponse) ResultHeader() *ResultHeader {
 // the "refresh ID" sent back by the server. Use RefreshDataWait
 // instead.
 func (d *Device) RefreshData(v *Session, attrIDs []AttrID) (string, error) {
+	return d.RefreshDataContext(context.Background(), v, attrIDs)
+}
+
+// RefreshDataContext is the same as RefreshData but honours ctx
+// cancellation and deadline.
+func (d *Device) RefreshDataContext(ctx context.Context, v *Session, attrIDs []AttrID) (string, error) {
 	var resp RefreshDataResponse
-	err := d.sendRequest(v, "RefreshData", makeDatenpunktIDs(attrIDs), &resp)
+	err := v.request(ctx, "RefreshData", &RefreshDataRequest{
+		DeviceHeader: d.header(),
+		AttrIDs:      attrIDs,
+	}, &resp, false)
 	if err != nil {
 		return "", err
 	}
 	return resp.RefreshDataResult.RefreshID, nil
 }
 
-var (
-	// RefreshDataWaitDuration defines the duration to wait in
-	// RefreshDataWait after the RefreshData call before calling
-	// RequestRefreshStatus for the first time. After that first call, the
-	// next pause duration will be divided by 4 and so on.
-	RefreshDataWaitDuration = 8 * time.Second
-	// RefreshDataWaitMinDuration defines the minimal duration of pauses
-	// between RequestRefreshStatus calls.
-	RefreshDataWaitMinDuration = 1 * time.Second
-	// RefreshDataWaitTimeout is the max amount of time to wait during
-	// RefreshDataWait method call before returning a ErrTimeout error.
-	RefreshDataWaitTimeout = 60 * time.Second
-)
-
 // RefreshDataWait launches the Vitotrol™ RefreshData request and
 // returns a channel on which the final error (asynchronous one) will
 // be received (nil if the data has been correctly written).
 //
 // If an error occurs during the RefreshData call (synchronous one), a
 // nil channel is returned with an error.
+//
+// The completion is polled following the session PollPolicy (see
+// WithPollPolicy option).
 func (d *Device) RefreshDataWait(v *Session, attrIDs []AttrID) (<-chan error, error) {
-	refreshID, err := d.RefreshData(v, attrIDs)
+	return d.RefreshDataWaitContext(context.Background(), v, attrIDs)
+}
+
+// RefreshDataWaitContext is the same as RefreshDataWait but honours
+// ctx cancellation and deadline, during the RefreshData call as well
+// as during the wait of its completion. In the latter case,
+// ctx.Err() is received on the returned channel.
+func (d *Device) RefreshDataWaitContext(ctx context.Context, v *Session, attrIDs []AttrID) (<-chan error, error) {
+	op, err := d.RefreshDataAsync(ctx, v, attrIDs)
 	if err != nil {
 		return nil, err
 	}
+	return op.errChan(), nil
+}
 
-	ch := make(chan error)
-
-	go waitAsyncStatus(v, refreshID, ch, (*Session).RequestRefreshStatus,
-		RefreshDataWaitDuration,
-		RefreshDataWaitMinDuration,
-		RefreshDataWaitTimeout)
-
-	return ch, nil
+// RefreshDataAsync launches the Vitotrol™ RefreshData request and
+// returns the Operation following its completion. The wait is the
+// same as RefreshDataWaitContext one, and can be canceled using ctx
+// or Operation.Cancel.
+func (d *Device) RefreshDataAsync(ctx context.Context, v *Session, attrIDs []AttrID, opts ...OperationOption) (*Operation, error) {
+	refreshID, err := d.RefreshDataContext(ctx, v, attrIDs)
+	if err != nil {
+		return nil, err
+	}
+	return newOperation(ctx, v, d.operationInfo(OperationRefresh, refreshID, "", ""),
+		false, opts), nil
 }
 
 //
 // GetErrorHistory
 //
 
+// GetErrorHistoryRequest is a GetErrorHistory request.
+type GetErrorHistoryRequest struct {
+	XMLName xml.Name `xml:"GetErrorHistory"`
+	DeviceHeader
+	Culture string `xml:"Culture"`
+}
+
 // ErrorHistoryEvent represents a timestamped history event generally
 // found in a GetErrorHistoryResponse.
 type ErrorHistoryEvent struct {
@@ -296,13 +468,24 @@ func (r *GetErrorHistoryResponse) ResultHeader() *ResultHeader {
 // request. Populates the internal cache before returning (see Errors
 // field).
 func (d *Device) GetErrorHistory(v *Session) error {
+	return d.GetErrorHistoryContext(context.Background(), v)
+}
+
+// GetErrorHistoryContext is the same as GetErrorHistory but honours
+// ctx cancellation and deadline.
+func (d *Device) GetErrorHistoryContext(ctx context.Context, v *Session) error {
 	var resp GetErrorHistoryResponse
-	err := d.sendRequest(v, "GetErrorHistory", "<Culture>fr-fr</Culture>", &resp)
+	err := v.request(ctx, "GetErrorHistory", &GetErrorHistoryRequest{
+		DeviceHeader: d.header(),
+		Culture:      "fr-fr",
+	}, &resp, false)
 	if err != nil {
 		return err
 	}
 
+	d.mu.Lock()
 	d.Errors = resp.GetErrorHistoryResult.Events
+	d.mu.Unlock()
 	return nil
 }
 
@@ -310,6 +493,13 @@ func (d *Device) GetErrorHistory(v *Session) error {
 // GetTimesheetData
 //
 
+// GetTimesheetDataRequest is a GetTimesheetData request.
+type GetTimesheetDataRequest struct {
+	XMLName xml.Name `xml:"GetTimesheetData"`
+	DeviceHeader
+	TimesheetID TimesheetID `xml:"DatenpunktId"`
+}
+
 type daySlot struct {
 	Day  string `xml:"Wochentag"`
 	From uint16 `xml:"ZeitVon"`
@@ -334,9 +524,17 @@ func (r *GetTimesheetDataResponse) ResultHeader() *ResultHeader {
 // request. Populates the internal cache before returning (see
 // Timesheets field).
 func (d *Device) GetTimesheetData(v *Session, id TimesheetID) error {
+	return d.GetTimesheetDataContext(context.Background(), v, id)
+}
+
+// GetTimesheetDataContext is the same as GetTimesheetData but honours
+// ctx cancellation and deadline.
+func (d *Device) GetTimesheetDataContext(ctx context.Context, v *Session, id TimesheetID) error {
 	var resp GetTimesheetDataResponse
-	err := d.sendRequest(v, "GetTimesheetData",
-		fmt.Sprintf("<DatenpunktId>%d</DatenpunktId>", id), &resp)
+	err := v.request(ctx, "GetTimesheetData", &GetTimesheetDataRequest{
+		DeviceHeader: d.header(),
+		TimesheetID:  id,
+	}, &resp, true)
 	if err != nil {
 		return err
 	}
@@ -356,7 +554,12 @@ func (d *Device) GetTimesheetData(v *Session, id TimesheetID) error {
 		sort.Sort(daySlots)
 	}
 
+	d.mu.Lock()
+	if d.Timesheets == nil {
+		d.Timesheets = map[TimesheetID]map[string]TimeslotSlice{}
+	}
 	d.Timesheets[id] = timesheet
+	d.mu.Unlock()
 
 	return nil
 }
@@ -365,6 +568,28 @@ func (d *Device) GetTimesheetData(v *Session, id TimesheetID) error {
 // WriteTimesheetData
 //
 
+// WriteTimesheetDataRequest is a WriteTimesheetData request. Oddly,
+// it has a nested layer SchaltsatzData before GeraetId and AnlageId
+// fields.
+type WriteTimesheetDataRequest struct {
+	XMLName xml.Name `xml:"WriteTimesheetData"`
+	Data    struct {
+		DeviceHeader
+		Type        int                  `xml:"SchaltzeitTyp"`
+		TimesheetID TimesheetID          `xml:"DatenpunktId"`
+		Slots       []WriteTimesheetSlot `xml:"Schaltzeiten>Schaltzeit"`
+	} `xml:"SchaltsatzData"`
+}
+
+// WriteTimesheetSlot is one time slot of a WriteTimesheetDataRequest.
+type WriteTimesheetSlot struct {
+	Day      string `xml:"Wochentag"`
+	From     string `xml:"ZeitVon"` // %04d formatted
+	To       string `xml:"ZeitBis"` // %04d formatted
+	Value    int    `xml:"Wert"`
+	Position int    `xml:"Position"`
+}
+
 // WriteTimesheetDataResponse is a response to a WriteTimesheetData request.
 type WriteTimesheetDataResponse struct {
 	WriteTimesheetDataResult struct {
@@ -401,19 +626,18 @@ var timesheetDaysIdx = func() map[string]int {
 // not populate the internal cache before returning (Timesheets
 // field), use WriteTimesheetDataWait instead.
 func (d *Device) WriteTimesheetData(v *Session, id TimesheetID, data map[string]TimeslotSlice) (string, error) {
-	buf := bytes.NewBufferString(
-		`<SchaltzeitTyp>1</SchaltzeitTyp>` +
-			`<DatenpunktId>`)
-	buf.WriteString(strconv.Itoa(int(id)))
-	buf.WriteString(`</DatenpunktId>` +
-		`<Schaltzeiten>`)
+	return d.WriteTimesheetDataContext(context.Background(), v, id, data)
+}
 
+// WriteTimesheetDataContext is the same as WriteTimesheetData but
+// honours ctx cancellation and deadline.
+func (d *Device) WriteTimesheetDataContext(ctx context.Context, v *Session, id TimesheetID, data map[string]TimeslotSlice) (string, error) {
 	dayDone := make(map[string]bool, 7)
 	for _, day := range timesheetDays {
 		dayDone[day] = false
 	}
 
-	preDays := make(map[string]*bytes.Buffer, 7)
+	preDays := make(map[string][]WriteTimesheetSlot, 7)
 	for day, daySlots := range data {
 		day = strings.ToUpper(day)
 
@@ -453,63 +677,38 @@ func (d *Device) WriteTimesheetData(v *Session, id TimesheetID, data map[string]
 			}
 			dayDone[day] = true
 
-			tmpBuf := bytes.NewBuffer(nil)
-			preDays[day] = tmpBuf
-
+			slots := make([]WriteTimesheetSlot, len(daySlots))
 			for idxSlot, slot := range daySlots {
-				tmpBuf.WriteString(fmt.Sprintf(
-					`<Schaltzeit>`+
-						`<Wochentag>%s</Wochentag>`+
-						`<ZeitVon>%04d</ZeitVon>`+
-						`<ZeitBis>%04d</ZeitBis>`+
-						`<Wert>1</Wert>`+
-						`<Position>%d</Position>`+
-						`</Schaltzeit>`,
-					day, slot.From, slot.To, idxSlot))
+				slots[idxSlot] = WriteTimesheetSlot{
+					Day:      day,
+					From:     fmt.Sprintf("%04d", slot.From),
+					To:       fmt.Sprintf("%04d", slot.To),
+					Value:    1,
+					Position: idxSlot,
+				}
 			}
+			preDays[day] = slots
 		}
 	}
 
+	var req WriteTimesheetDataRequest
+	req.Data.DeviceHeader = d.header()
+	req.Data.Type = 1
+	req.Data.TimesheetID = id
+
 	// Write sorted days
 	for _, day := range timesheetDays {
-		tmpBuf := preDays[day]
-		if tmpBuf != nil {
-			buf.Write(tmpBuf.Bytes())
-		}
+		req.Data.Slots = append(req.Data.Slots, preDays[day]...)
 	}
 
-	buf.WriteString(`</Schaltzeiten>`)
-
-	// Oddly, WriteTimesheetData has a nested layer SchaltsatzData
-	// before GeraetId and AnlageId fields, so use the
-	// Session.sendRequest method instead of Device.sendRequest
 	var resp WriteTimesheetDataResponse
-	err := v.sendRequest("WriteTimesheetData",
-		`<WriteTimesheetData>`+
-			d.buildBody("SchaltsatzData", buf.String())+
-			`</WriteTimesheetData>`,
-		&resp)
+	err := v.request(ctx, "WriteTimesheetData", &req, &resp, false)
 	if err != nil {
 		return "", err
 	}
 	return resp.WriteTimesheetDataResult.RefreshID, nil
 }
 
-var (
-	// WriteTimesheetDataWaitDuration defines the duration to wait in
-	// WriteTimesheetDataWait after the WriteTimesheetData call before
-	// calling RequestWriteStatus for the first time. After that first
-	// call, the next pause duration will be divided by 4 and so on.
-	WriteTimesheetDataWaitDuration = 8 * time.Second
-	// WriteTimesheetDataWaitMinDuration defines the minimal duration of pauses
-	// between RequestWriteStatus calls.
-	WriteTimesheetDataWaitMinDuration = 1 * time.Second
-	// WriteTimesheetDataWaitTimeout is the max amount of time to wait
-	// during WriteTimesheetDataWait method call before returning a
-	// ErrTimeout error.
-	WriteTimesheetDataWaitTimeout = 60 * time.Second
-)
-
 // WriteTimesheetDataWait launches the Vitotrol™ WriteTimesheetData
 // request and returns a channel on which the final error
 // (asynchronous one) will be received (nil if the data has been
@@ -517,95 +716,103 @@ var (
 //
 // If an error occurs during the WriteTimesheetData call (synchronous
 // one), a nil channel is returned with an error.
+//
+// The completion is polled following the session PollPolicy (see
+// WithPollPolicy option).
 func (d *Device) WriteTimesheetDataWait(v *Session, id TimesheetID, data map[string]TimeslotSlice) (<-chan error, error) {
-	refreshID, err := d.WriteTimesheetData(v, id, data)
+	return d.WriteTimesheetDataWaitContext(context.Background(), v, id, data)
+}
+
+// WriteTimesheetDataWaitContext is the same as WriteTimesheetDataWait
+// but honours ctx cancellation and deadline, during the
+// WriteTimesheetData call as well as during the wait of its
+// completion. In the latter case, ctx.Err() is received on the
+// returned channel.
+func (d *Device) WriteTimesheetDataWaitContext(ctx context.Context, v *Session, id TimesheetID, data map[string]TimeslotSlice) (<-chan error, error) {
+	op, err := d.WriteTimesheetDataAsync(ctx, v, id, data)
+	if err != nil {
+		return nil, err
+	}
+	return op.errChan(), nil
+}
+
+// WriteTimesheetDataAsync launches the Vitotrol™ WriteTimesheetData
+// request and returns the Operation following its completion. The
+// wait is the same as WriteTimesheetDataWaitContext one, and can be
+// canceled using ctx or Operation.Cancel.
+func (d *Device) WriteTimesheetDataAsync(ctx context.Context, v *Session, id TimesheetID, data map[string]TimeslotSlice, opts ...OperationOption) (*Operation, error) {
+	refreshID, err := d.WriteTimesheetDataContext(ctx, v, id, data)
 	if err != nil {
 		return nil, err
 	}
 
-	ch := make(chan error)
+	var name string
+	if pRef := TimesheetsRef[id]; pRef != nil {
+		name = pRef.Name
+	}
+	return newOperation(ctx, v, d.operationInfo(OperationWriteTimesheet, refreshID, name, ""),
+		false, opts), nil
+}
 
-	go waitAsyncStatus(v, refreshID, ch, (*Session).RequestWriteStatus,
-		WriteTimesheetDataWaitDuration,
-		WriteTimesheetDataWaitMinDuration,
-		WriteTimesheetDataWaitTimeout)
+// attrName returns the name of attribute attrID, or its hexadecimal
+// ID if it is unknown.
+func (d *Device) attrName(attrID AttrID) string {
+	if pRef := d.Registry().Ref(attrID); pRef != nil {
+		return pRef.Name
+	}
+	return fmt.Sprintf("0x%04x", uint16(attrID))
+}
 
-	return ch, nil
+func (d *Device) operationInfo(kind OperationKind, refreshID, attribute, value string) OperationInfo {
+	return OperationInfo{
+		RefreshID:  refreshID,
+		Kind:       kind,
+		LocationID: d.LocationID,
+		DeviceID:   d.DeviceID,
+		Attribute:  attribute,
+		Value:      value,
+	}
 }
 
 // ErrTimeout is the error returned by WriteDataWait,
 // RefreshDataWait and WriteTimesheetDataWait methods when the
-// response wait times out.
+// response wait times out (see PollPolicy.Timeout).
 var ErrTimeout = errors.New("Timeout")
 
-func waitAsyncStatus(v *Session, refreshID string, ch chan error,
-	requestStatus func(*Session, string) (int, error),
-	waitFirstDuration, waitminDuration, timeout time.Duration) {
-	start := time.Now()
-	// Waiting availability of data, yes *8* seconds the first time :(
-	for wait := waitFirstDuration; true; {
-		time.Sleep(wait)
-
-		status, err := requestStatus(v, refreshID)
-		if err != nil {
-			ch <- err
-			break
-		}
-
-		// End when status == 4 || status == 9
-		// Setting DateTime returns status 9 on success
-		if status >= 4 {
-			if status != 4 && status != 9 {
-				ch <- fmt.Errorf("Unexpected status %d", status)
-			}
-			break
-		}
-
-		if time.Until(start) >= timeout {
-			ch <- ErrTimeout
-			break
-		}
-
-		wait /= 4
-		if wait < waitminDuration {
-			wait = waitminDuration
-		}
-
-		if (status != 1 && status != 3) || v.Debug {
-			log.Printf("waitAsyncStatus(%s): status %d, wait %d secs...\n",
-				refreshID, status, wait/time.Second)
-		}
-	}
-	if v.Debug {
-		log.Printf("waitAsyncStatus(%s) done in %s", refreshID, time.Since(start))
-	}
-	close(ch)
-}
-
 //
 // GetTypeInfo
 //
 
+// GetTypeInfoRequest is a GetTypeInfo request.
+type GetTypeInfoRequest struct {
+	XMLName xml.Name `xml:"GetTypeInfo"`
+	DeviceHeader
+}
+
 // AttributeInfo defines an attribute.
 type AttributeInfo struct {
 	AttributeInfoBase
-	AttributeID AttrID
-	EnumValues  map[uint32]string // only if AttributeType == "ENUM"
+	AttributeID AttrID            `json:"id"`
+	EnumValues  map[uint32]string `json:"enum_values,omitempty"` // only if AttributeType == "ENUM"
 }
 
 // AttributeInfoBase defines the base information the GetTypeInfo
-// request returns.
+// request returns. JSON tags are used by Catalogue.
 type AttributeInfoBase struct {
-	AttributeName      string `xml:"DatenpunktName"` // German one, more funny :)
-	AttributeType      string `xml:"DatenpunktTyp"`
-	AttributeTypeValue uint32 `xml:"DatenpunktTypWert"` // ???
-	MinValue           string `xml:"MinimalWert"`
-	MaxValue           string `xml:"MaximalWert"`
-	DataPointGroup     string `xml:"DatenpunktGruppe"`
-	HeatingCircuitID   uint32 `xml:"HeizkreisId"`
-	DefaultValue       string `xml:"Auslieferungswert"`
-	Readable           bool   `xml:"IstLesbar"`
-	Writable           bool   `xml:"IstSchreibbar"`
+	AttributeName      string `xml:"DatenpunktName" json:"name"` // German one, more funny :)
+	AttributeType      string `xml:"DatenpunktTyp" json:"type"`
+	AttributeTypeValue uint32 `xml:"DatenpunktTypWert" json:"type_value,omitempty"` // ???
+	MinValue           string `xml:"MinimalWert" json:"min_value,omitempty"`
+	MaxValue           string `xml:"MaximalWert" json:"max_value,omitempty"`
+	DataPointGroup     string `xml:"DatenpunktGruppe" json:"data_point_group,omitempty"`
+	HeatingCircuitID   uint32 `xml:"HeizkreisId" json:"heating_circuit_id,omitempty"`
+	DefaultValue       string `xml:"Auslieferungswert" json:"default_value,omitempty"`
+	Readable           bool   `xml:"IstLesbar" json:"readable"`
+	Writable           bool   `xml:"IstSchreibbar" json:"writable"`
+
+	// Unit is not sent by the server, but can be set in catalogue
+	// files (see Catalogue).
+	Unit Unit `xml:"-" json:"unit,omitempty"`
 }
 
 type attributeInfo struct {
@@ -628,8 +835,15 @@ func (r *GetTypeInfoResponse) ResultHeader() *ResultHeader {
 
 // GetTypeInfo launches the Vitotrol™ GetTypeInfo request.
 func (d *Device) GetTypeInfo(v *Session) ([]*AttributeInfo, error) {
+	return d.GetTypeInfoContext(context.Background(), v)
+}
+
+// GetTypeInfoContext is the same as GetTypeInfo but honours ctx
+// cancellation and deadline.
+func (d *Device) GetTypeInfoContext(ctx context.Context, v *Session) ([]*AttributeInfo, error) {
 	var resp GetTypeInfoResponse
-	err := d.sendRequest(v, "GetTypeInfo", "", &resp)
+	err := v.request(ctx, "GetTypeInfo",
+		&GetTypeInfoRequest{DeviceHeader: d.header()}, &resp, true)
 	if err != nil {
 		return nil, err
 	}
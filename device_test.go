@@ -1,6 +1,7 @@
 package vitotrol
 
 import (
+	"encoding/xml"
 	"fmt"
 	"testing"
 
@@ -39,35 +40,55 @@ func TestFormatAttributes(tt *testing.T) {
 				Value: "unknown-attr",
 				Time:  testTime,
 			},
-			BurnerState: {
+			BrennerStatus: {
 				Value: "invalid-value",
 				Time:  testTime,
 			},
-			IndoorTemp: {
+			BoilerTemp: {
 				Value: "22",
 				Time:  testTime,
 			},
-			OutdoorTemp: nil,
+			AussenTemp: nil,
 		},
 	}
 
 	t.CmpDeeply(
 		pDevice.FormatAttributes(
-			[]AttrID{NoAttr, BurnerState, IndoorTemp, OutdoorTemp}),
+			[]AttrID{NoAttr, BrennerStatus, BoilerTemp, AussenTemp}),
 		fmt.Sprintf("%d: unknown-attr@%s\n", NoAttr, testTime)+
-			fmt.Sprintf("BurnerState: unknown-value<invalid-value>@%s (%s)\n",
-				testTime, AttributesRef[BurnerState].Doc)+
-			fmt.Sprintf("IndoorTemp: 22@%s (%s)\n",
-				testTime, AttributesRef[IndoorTemp].Doc)+
-			fmt.Sprintf("OutdoorTemp: uninitialized (%s)\n",
-				AttributesRef[OutdoorTemp].Doc))
+			fmt.Sprintf("BrennerStatus: unknown-value<invalid-value>@%s (%s)\n",
+				testTime, AttributesRef[BrennerStatus].Doc)+
+			fmt.Sprintf("BoilerTemp: 22@%s (%s)\n",
+				testTime, AttributesRef[BoilerTemp].Doc)+
+			fmt.Sprintf("AussenTemp: uninitialized (%s)\n",
+				AttributesRef[AussenTemp].Doc))
 }
 
-func TestMakeDatenpunktIDs(tt *testing.T) {
+func TestDeviceRequests(tt *testing.T) {
 	t := td.NewT(tt)
 
-	t.CmpDeeply(makeDatenpunktIDs([]AttrID{11, 22}),
-		`<DatenpunktIds><int>11</int><int>22</int></DatenpunktIds>`)
+	d := Device{DeviceID: testDeviceID, LocationID: testLocationID}
+
+	raw, err := xml.Marshal(&GetDataRequest{
+		DeviceHeader: d.header(),
+		AttrIDs:      []AttrID{11, 22},
+	})
+	if t.CmpNoError(err) {
+		t.CmpDeeply(string(raw),
+			`<GetData><GeraetId>1234</GeraetId><AnlageId>5678</AnlageId>`+
+				`<DatenpunktIds><int>11</int><int>22</int></DatenpunktIds></GetData>`)
+	}
+
+	raw, err = xml.Marshal(&WriteDataRequest{
+		DeviceHeader: d.header(),
+		AttrID:       12,
+		Value:        "<&>",
+	})
+	if t.CmpNoError(err) {
+		t.CmpDeeply(string(raw),
+			`<WriteData><GeraetId>1234</GeraetId><AnlageId>5678</AnlageId>`+
+				`<DatapointId>12</DatapointId><Wert>&lt;&amp;&gt;</Wert></WriteData>`)
+	}
 }
 
 type requestDeviceCommon struct {
@@ -1,6 +1,8 @@
 package vitotrol
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -18,7 +20,7 @@ type testAction struct {
 
 func testSendRequestAnyMulti(t *td.T,
 	sendReqs func(v *Session, d *Device) bool,
-	actions map[string]*testAction, testName string) bool {
+	actions map[string]*testAction, testName string, opts ...SessionOption) bool {
 	ts := httptest.NewServer(http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
 			soapActionURL := r.Header.Get("SOAPAction")
@@ -56,15 +58,15 @@ func testSendRequestAnyMulti(t *td.T,
 		}))
 	defer ts.Close()
 
-	MainURL = ts.URL
-	v := &Session{
-		Devices: []Device{
-			{
-				DeviceID:   testDeviceID,
-				LocationID: testLocationID,
-				Attributes: map[AttrID]*Value{},
-				Timesheets: map[TimesheetID]map[string]TimeslotSlice{},
-			},
+	v := NewSession(append([]SessionOption{
+		WithURL(ts.URL), withTestPollPolicy(0, time.Minute),
+	}, opts...)...)
+	v.Devices = []Device{
+		{
+			DeviceID:   testDeviceID,
+			LocationID: testLocationID,
+			Attributes: map[AttrID]*Value{},
+			Timesheets: map[TimesheetID]map[string]TimeslotSlice{},
 		},
 	}
 	return sendReqs(v, &v.Devices[0])
@@ -80,8 +82,6 @@ func TestWriteDataWait(tt *testing.T) {
 	// No problem
 	testSendRequestAnyMulti(t,
 		func(v *Session, d *Device) bool {
-			WriteDataWaitDuration = 0
-			WriteDataWaitMinDuration = 0
 			ch, err := d.WriteDataWait(v, writeDataTestID, writeDataTestValue)
 			if !t.CmpNoError(err) {
 				return false
@@ -110,7 +110,6 @@ func TestWriteDataWait(tt *testing.T) {
 	// Error during WriteData
 	testSendRequestAnyMulti(t,
 		func(v *Session, d *Device) bool {
-			WriteDataWaitDuration = 0
 			ch, err := d.WriteDataWait(v, writeDataTestID, writeDataTestValue)
 			t.CmpError(err)
 			return t.Nil(ch)
@@ -166,8 +165,6 @@ func TestRefreshDataWait(tt *testing.T) {
 	// No problem
 	testSendRequestAnyMulti(t,
 		func(v *Session, d *Device) bool {
-			RefreshDataWaitDuration = 0
-			RefreshDataWaitMinDuration = 0
 			ch, err := d.RefreshDataWait(v, refreshDataTestIDs)
 			if !t.CmpNoError(err) {
 				return false
@@ -196,7 +193,6 @@ func TestRefreshDataWait(tt *testing.T) {
 	// Error during RefreshData
 	testSendRequestAnyMulti(t,
 		func(v *Session, d *Device) bool {
-			RefreshDataWaitDuration = 0
 			ch, err := d.RefreshDataWait(v, refreshDataTestIDs)
 			t.CmpError(err)
 			return t.Nil(ch)
@@ -241,3 +237,55 @@ func TestRefreshDataWait(tt *testing.T) {
 		},
 		"RefreshDataWait, error during RequestRefreshStatus")
 }
+
+func TestRefreshDataWaitContext(tt *testing.T) {
+	t := td.NewT(tt)
+
+	// Cancellation during the wait
+	testSendRequestAnyMulti(t,
+		func(v *Session, d *Device) bool {
+			ctx, cancel := context.WithCancel(context.Background())
+			ch, err := d.RefreshDataWaitContext(ctx, v, refreshDataTestIDs)
+			if !t.CmpNoError(err) {
+				cancel()
+				return false
+			}
+			cancel()
+
+			timeoutTicker := time.NewTicker(100 * time.Millisecond)
+			defer timeoutTicker.Stop()
+
+			select {
+			case err = <-ch:
+				return t.CmpDeeply(err, context.Canceled)
+			case <-timeoutTicker.C:
+				t.Error("TIMEOUT!")
+				return false
+			}
+		},
+		map[string]*testAction{
+			"RefreshData": {
+				expectedRequest: refreshDataTest.expectedRequest,
+				serverResponse: intoDeviceResponse(
+					"RefreshData", refreshDataTest.serverResponse),
+			},
+			"RequestRefreshStatus": &requestRefreshStatusTest,
+		},
+		"RefreshDataWaitContext, cancel during wait",
+		withTestPollPolicy(time.Hour, time.Hour))
+
+	// Context already canceled before RefreshData
+	testSendRequestAnyMulti(t,
+		func(v *Session, d *Device) bool {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			ch, err := d.RefreshDataWaitContext(ctx, v, refreshDataTestIDs)
+			t.CmpDeeply(err, td.Smuggle(func(err error) bool {
+				return errors.Is(err, context.Canceled)
+			}, true))
+			return t.Nil(ch)
+		},
+		map[string]*testAction{},
+		"RefreshDataWaitContext, canceled context")
+}
@@ -0,0 +1,226 @@
+// Package exporter periodically reads the attributes of all the
+// devices of a Vitotrol™ session and exposes them as Prometheus
+// metrics.
+package exporter
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/TomTom68/go-vitotrol"
+)
+
+// Operations counted by vitotrol_scrape_errors_total metric.
+const (
+	OpGetDevices = "get_devices"
+	OpRefresh    = "refresh"
+	OpGetData    = "get_data"
+)
+
+// DefaultInterval is the default duration between two polls of the
+// Vitotrol™ server.
+const DefaultInterval = 5 * time.Minute
+
+// Exporter polls the Vitotrol™ server and serves the last read
+// values as Prometheus metrics. It implements http.Handler.
+type Exporter struct {
+	session  *vitotrol.Session
+	attrs    []vitotrol.AttrID
+	interval time.Duration
+	refresh  bool
+	units    vitotrol.Units
+	logger   vitotrol.Logger
+
+	mu           sync.Mutex
+	devices      []*vitotrol.Device
+	values       map[deviceKey]map[vitotrol.AttrID]vitotrol.Value
+	errors       map[string]uint64
+	lastPoll     time.Time
+	lastDuration time.Duration
+}
+
+type deviceKey struct {
+	locationID, deviceID uint32
+}
+
+func keyOf(d *vitotrol.Device) deviceKey {
+	return deviceKey{locationID: d.LocationID, deviceID: d.DeviceID}
+}
+
+// An Option allows to customize an Exporter created by New.
+type Option func(*Exporter)
+
+// WithAttributes sets the exported attributes. Without this option,
+// all readable attributes of the registry of each device are exported
+// (see vitotrol.Device.Registry).
+func WithAttributes(attrs ...vitotrol.AttrID) Option {
+	return func(e *Exporter) {
+		e.attrs = attrs
+	}
+}
+
+// WithInterval sets the duration between two polls of the Vitotrol™
+// server by Run method instead of DefaultInterval.
+func WithInterval(interval time.Duration) Option {
+	return func(e *Exporter) {
+		e.interval = interval
+	}
+}
+
+// WithRefresh tells whether the attributes are refreshed, using
+// RefreshDataWait, before being read. It is true by default.
+func WithRefresh(refresh bool) Option {
+	return func(e *Exporter) {
+		e.refresh = refresh
+	}
+}
+
+// WithUnits sets the units conversion applied to the exported
+// values, as vitotrol.Fahrenheit. Without this option, values are
+// exported in their original unit.
+func WithUnits(units vitotrol.Units) Option {
+	return func(e *Exporter) {
+		e.units = units
+	}
+}
+
+// WithLogger sets the logger used to report poll errors. Without
+// this option, poll errors are not logged, only counted.
+func WithLogger(logger vitotrol.Logger) Option {
+	return func(e *Exporter) {
+		e.logger = logger
+	}
+}
+
+// New returns a new Exporter using session v, that must be already
+// logged in (or created with vitotrol.WithCredentials option).
+func New(v *vitotrol.Session, opts ...Option) *Exporter {
+	e := &Exporter{
+		session:  v,
+		interval: DefaultInterval,
+		refresh:  true,
+		logger:   vitotrol.DiscardLogger,
+		values:   map[deviceKey]map[vitotrol.AttrID]vitotrol.Value{},
+		errors:   map[string]uint64{},
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// deviceAttrs returns the exported attributes of device d.
+func (e *Exporter) deviceAttrs(d *vitotrol.Device) []vitotrol.AttrID {
+	if e.attrs != nil {
+		return e.attrs
+	}
+
+	registry := d.Registry()
+	var attrs []vitotrol.AttrID
+	for _, attrID := range registry.IDs() {
+		if registry.Ref(attrID).Access&vitotrol.ReadOnly != 0 {
+			attrs = append(attrs, attrID)
+		}
+	}
+	return attrs
+}
+
+// Run polls the Vitotrol™ server immediately, then each interval
+// until ctx is done. It always returns ctx.Err().
+func (e *Exporter) Run(ctx context.Context) error {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		e.Poll(ctx) //nolint: errcheck
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll reads once the devices and the exported attributes of each
+// of them. Errors are counted in vitotrol_scrape_errors_total metric
+// and the first one is returned. Values already read are not
+// forgotten if a later poll fails.
+func (e *Exporter) Poll(ctx context.Context) error {
+	start := time.Now()
+
+	var firstErr error
+	fail := func(op string, err error, args ...interface{}) {
+		e.mu.Lock()
+		e.errors[op]++
+		e.mu.Unlock()
+
+		e.logger.Warn("poll failed",
+			append([]interface{}{"operation", op, "error", err}, args...)...)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	err := e.session.GetDevicesContext(ctx)
+	if err != nil {
+		fail(OpGetDevices, err)
+		e.pollDone(start, nil)
+		return firstErr
+	}
+
+	devices := e.session.DeviceList()
+	for _, d := range devices {
+		attrs := e.deviceAttrs(d)
+		if e.refresh {
+			ch, err := d.RefreshDataWaitContext(ctx, e.session, attrs)
+			if err == nil {
+				err = <-ch
+			}
+			if err != nil {
+				// Values cached by the server are read anyway
+				fail(OpRefresh, err, "device", d.DeviceID)
+			}
+		}
+
+		err = d.GetDataContext(ctx, e.session, attrs)
+		if err != nil {
+			fail(OpGetData, err, "device", d.DeviceID)
+			continue
+		}
+
+		e.mu.Lock()
+		values := e.values[keyOf(d)]
+		if values == nil {
+			values = map[vitotrol.AttrID]vitotrol.Value{}
+			e.values[keyOf(d)] = values
+		}
+		for attrID, value := range d.AttributesSnapshot() {
+			values[attrID] = value
+		}
+		e.mu.Unlock()
+	}
+
+	e.pollDone(start, devices)
+	return firstErr
+}
+
+func (e *Exporter) pollDone(start time.Time, devices []*vitotrol.Device) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if devices != nil {
+		e.devices = devices
+	}
+	e.lastPoll = start
+	e.lastDuration = time.Since(start)
+}
+
+// ServeHTTP implements http.Handler, serving the metrics in
+// Prometheus text format.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	e.WriteMetrics(w) //nolint: errcheck
+}
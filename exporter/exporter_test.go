@@ -0,0 +1,145 @@
+package exporter_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	td "github.com/maxatome/go-testdeep"
+
+	"github.com/TomTom68/go-vitotrol"
+	"github.com/TomTom68/go-vitotrol/exporter"
+	"github.com/TomTom68/go-vitotrol/vitotroltest"
+)
+
+func TestMetricName(tt *testing.T) {
+	t := td.NewT(tt)
+
+	t.CmpDeeply(exporter.MetricName("AussenTemp"), "vitotrol_aussen_temp")
+	t.CmpDeeply(exporter.MetricName("HeizNormalTempM1"), "vitotrol_heiz_normal_temp_m1")
+	t.CmpDeeply(exporter.MetricName("Foo Bar-0x1234"), "vitotrol_foo_bar_0x1234")
+	t.CmpDeeply(exporter.MetricName("ABC"), "vitotrol_abc")
+}
+
+func TestExporter(tt *testing.T) {
+	t := td.NewT(tt)
+
+	dev := vitotroltest.NewDevice(1234, 5678)
+	dev.DeviceName = `Vito"dens`
+	dev.Values[vitotrol.AussenTemp] = "-3.5"
+	dev.Values[vitotrol.BrennerStatus] = "1"
+	srv := vitotroltest.NewServer(vitotroltest.WithDevice(dev))
+	defer srv.Close()
+
+	v := vitotrol.NewSession(vitotrol.WithURL(srv.URL),
+		vitotrol.WithRetryPolicy(vitotrol.RetryPolicy{MaxAttempts: 1}),
+		vitotrol.WithPollPolicy(vitotrol.PollPolicy{MinInterval: time.Millisecond, Timeout: time.Minute}))
+	t.FailureIsFatal().CmpNoError(v.Login("login", "password"))
+
+	e := exporter.New(v, exporter.WithAttributes(
+		vitotrol.AussenTemp, vitotrol.BrennerStatus, vitotrol.DatumUhrzeit))
+
+	// Nothing polled yet
+	var buf bytes.Buffer
+	t.CmpNoError(e.WriteMetrics(&buf))
+	t.CmpDeeply(buf.String(), `# HELP vitotrol_scrape_errors_total Number of failed requests to the Vitodata server, by operation.
+# TYPE vitotrol_scrape_errors_total counter
+vitotrol_scrape_errors_total{operation="get_devices"} 0
+vitotrol_scrape_errors_total{operation="refresh"} 0
+vitotrol_scrape_errors_total{operation="get_data"} 0
+`)
+
+	t.CmpNoError(e.Poll(context.Background()))
+	t.CmpDeeply(srv.Calls("RefreshData"), 1)
+
+	labels := `location_id="5678",location="Location 5678",device_id="1234",device="Vito\"dens"`
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	t.CmpDeeply(rec.Header().Get("Content-Type"), td.HasPrefix("text/plain; version=0.0.4"))
+	t.CmpDeeply(rec.Body.String(), td.All(
+		td.Contains("# TYPE vitotrol_aussen_temp gauge\n"+
+			"vitotrol_aussen_temp{"+labels+"} -3.5\n"),
+		td.Contains("# TYPE vitotrol_brenner_status gauge\n"+
+			"vitotrol_brenner_status{"+labels+`,state="Aus"} 0`+"\n"+
+			"vitotrol_brenner_status{"+labels+`,state="Ein"} 1`+"\n"),
+		td.Contains("vitotrol_attribute_timestamp_seconds{"+labels+`,attribute="AussenTemp"} `),
+		td.Not(td.Contains("vitotrol_datum_uhrzeit")), // dates are not exported
+		td.Contains("vitotrol_device_connected{"+labels+"} 1\n"),
+		td.Contains("vitotrol_device_has_error{"+labels+"} 0\n"),
+		td.Contains("# TYPE vitotrol_last_poll_timestamp_seconds gauge\n"),
+		td.Contains("# TYPE vitotrol_last_poll_duration_seconds gauge\n"),
+	))
+
+	// Failures are counted, previous values are kept
+	srv.Fail("GetData", vitotrol.ResultDeviceNotConnected, 1)
+	srv.FailHTTP("GetDevices", 503, 1)
+	err := e.Poll(context.Background())
+	t.True(errors.Is(err, vitotrol.ErrServerUnavailable))
+
+	srv.SetValue(1234, vitotrol.AussenTemp, "1.5")
+	err = e.Poll(context.Background())
+	t.True(errors.Is(err, vitotrol.ErrDeviceNotConnected))
+
+	buf.Reset()
+	t.CmpNoError(e.WriteMetrics(&buf))
+	t.CmpDeeply(buf.String(), td.All(
+		td.Contains("vitotrol_aussen_temp{"+labels+"} -3.5\n"),
+		td.Contains(`vitotrol_scrape_errors_total{operation="get_devices"} 1`+"\n"),
+		td.Contains(`vitotrol_scrape_errors_total{operation="refresh"} 0`+"\n"),
+		td.Contains(`vitotrol_scrape_errors_total{operation="get_data"} 1`+"\n"),
+	))
+}
+
+func TestExporterRun(tt *testing.T) {
+	t := td.NewT(tt)
+
+	srv := vitotroltest.NewServer(
+		vitotroltest.WithDevice(vitotroltest.NewDevice(1234, 5678)))
+	defer srv.Close()
+
+	v := vitotrol.NewSession(vitotrol.WithURL(srv.URL))
+	t.FailureIsFatal().CmpNoError(v.Login("login", "password"))
+
+	e := exporter.New(v,
+		exporter.WithAttributes(vitotrol.AussenTemp),
+		exporter.WithRefresh(false),
+		exporter.WithInterval(10*time.Millisecond))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 35*time.Millisecond)
+	defer cancel()
+	t.CmpDeeply(e.Run(ctx), context.DeadlineExceeded)
+	t.Gte(srv.Calls("GetData"), 2)
+	t.CmpDeeply(srv.Calls("RefreshData"), 0)
+}
+
+func TestExporterUnits(tt *testing.T) {
+	t := td.NewT(tt)
+
+	dev := vitotroltest.NewDevice(1234, 5678)
+	dev.Values[vitotrol.AussenTemp] = "-5"
+	dev.Values[vitotrol.AnzahlBrennerstunden] = "1234.5"
+	srv := vitotroltest.NewServer(vitotroltest.WithDevice(dev))
+	defer srv.Close()
+
+	v := vitotrol.NewSession(vitotrol.WithURL(srv.URL))
+	t.FailureIsFatal().CmpNoError(v.Login("login", "password"))
+
+	e := exporter.New(v,
+		exporter.WithAttributes(vitotrol.AussenTemp, vitotrol.AnzahlBrennerstunden),
+		exporter.WithRefresh(false),
+		exporter.WithUnits(vitotrol.Fahrenheit))
+	t.CmpNoError(e.Poll(context.Background()))
+
+	var buf bytes.Buffer
+	t.CmpNoError(e.WriteMetrics(&buf))
+	t.CmpDeeply(buf.String(), td.All(
+		td.Contains("# HELP vitotrol_aussen_temp Außen Temperatur (°F)\n"),
+		td.Re(`\nvitotrol_aussen_temp\{[^}]+\} 23\n`),
+		td.Contains("# HELP vitotrol_anzahl_brennerstunden Brennerstundenanzahl (h)\n"),
+		td.Re(`\nvitotrol_anzahl_brennerstunden\{[^}]+\} 1234.5\n`),
+	))
+}
@@ -0,0 +1,223 @@
+package exporter
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/TomTom68/go-vitotrol"
+)
+
+const namespace = "vitotrol"
+
+type label struct {
+	name, value string
+}
+
+type sample struct {
+	labels []label
+	value  float64
+}
+
+type family struct {
+	name    string
+	help    string
+	typ     string // gauge or counter
+	samples []sample
+}
+
+// MetricName returns the name of the gauge exposing attribute
+// attrName, as "vitotrol_" followed by attrName in snake case. For
+// example "HeizNormalTempM1" gives "vitotrol_heiz_normal_temp_m1".
+func MetricName(attrName string) string {
+	var buf strings.Builder
+	buf.WriteString(namespace)
+	buf.WriteByte('_')
+
+	prev := '_'
+	for _, r := range attrName {
+		switch {
+		case unicode.IsUpper(r):
+			if prev != '_' && !unicode.IsUpper(prev) {
+				buf.WriteByte('_')
+			}
+			buf.WriteRune(unicode.ToLower(r))
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			buf.WriteRune(r)
+		default:
+			if prev == '_' {
+				continue
+			}
+			r = '_'
+			buf.WriteByte('_')
+		}
+		prev = r
+	}
+	return strings.TrimSuffix(buf.String(), "_")
+}
+
+func deviceLabels(d *vitotrol.Device) []label {
+	return []label{
+		{"location_id", strconv.FormatUint(uint64(d.LocationID), 10)},
+		{"location", d.LocationName},
+		{"device_id", strconv.FormatUint(uint64(d.DeviceID), 10)},
+		{"device", d.DeviceName},
+	}
+}
+
+func withLabels(labels []label, more ...label) []label {
+	return append(append([]label(nil), labels...), more...)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// families returns all the metric families, sorted by name.
+func (e *Exporter) families() []*family {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	byName := map[string]*family{}
+	add := func(name, help, typ string, s sample) {
+		f := byName[name]
+		if f == nil {
+			f = &family{name: name, help: help, typ: typ}
+			byName[name] = f
+		}
+		f.samples = append(f.samples, s)
+	}
+
+	for _, d := range e.devices {
+		labels := deviceLabels(d)
+		values := e.values[keyOf(d)]
+
+		add(namespace+"_device_has_error",
+			"Whether the device reports an error (1) or not (0).", "gauge",
+			sample{labels: labels, value: boolValue(d.HasError)})
+		add(namespace+"_device_connected",
+			"Whether the device is connected to the Vitodata server (1) or not (0).", "gauge",
+			sample{labels: labels, value: boolValue(d.IsConnected)})
+
+		registry := d.Registry()
+		for _, attrID := range e.deviceAttrs(d) {
+			ref := registry.Ref(attrID)
+			value, ok := values[attrID]
+			if ref == nil || !ok {
+				continue
+			}
+
+			help := ref.Doc
+			if help == "" {
+				help = ref.Name
+			}
+
+			if enum, ok := ref.Type.(*vitotrol.VitodataEnum); ok {
+				num, err := enum.Vitodata2NativeValue(value.Value)
+				if err != nil {
+					continue
+				}
+				for idx, state := range enum.Values() {
+					if state == "" {
+						continue
+					}
+					add(MetricName(ref.Name), help+" (1 for the current state)", "gauge",
+						sample{
+							labels: withLabels(labels, label{"state", state}),
+							value:  boolValue(uint64(idx) == num.(uint64)),
+						})
+				}
+			} else {
+				native, err := ref.Type.Vitodata2NativeValue(value.Value)
+				if err != nil {
+					continue
+				}
+				var num float64
+				switch native := native.(type) {
+				case float64:
+					num = native
+				case int64:
+					num = float64(native)
+				default:
+					continue // neither numeric nor enum
+				}
+				num, unit := e.units.Convert(num, ref.Unit)
+				if unit != vitotrol.NoUnit {
+					help += " (" + string(unit) + ")"
+				}
+				add(MetricName(ref.Name), help, "gauge",
+					sample{labels: labels, value: num})
+			}
+
+			add(namespace+"_attribute_timestamp_seconds",
+				"Server timestamp of the last read value of the attribute.", "gauge",
+				sample{
+					labels: withLabels(labels, label{"attribute", ref.Name}),
+					value:  float64(time.Time(value.Time).UnixNano()) / 1e9,
+				})
+		}
+	}
+
+	for _, op := range []string{OpGetDevices, OpRefresh, OpGetData} {
+		add(namespace+"_scrape_errors_total",
+			"Number of failed requests to the Vitodata server, by operation.", "counter",
+			sample{labels: []label{{"operation", op}}, value: float64(e.errors[op])})
+	}
+
+	if !e.lastPoll.IsZero() {
+		add(namespace+"_last_poll_timestamp_seconds",
+			"Time of the last poll of the Vitodata server.", "gauge",
+			sample{value: float64(e.lastPoll.UnixNano()) / 1e9})
+		add(namespace+"_last_poll_duration_seconds",
+			"Duration of the last poll of the Vitodata server.", "gauge",
+			sample{value: e.lastDuration.Seconds()})
+	}
+
+	families := make([]*family, 0, len(byName))
+	for _, f := range byName {
+		families = append(families, f)
+	}
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+	return families
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// WriteMetrics writes the metrics in Prometheus text format to w.
+func (e *Exporter) WriteMetrics(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range e.families() {
+		bw.WriteString("# HELP " + f.name + " " + helpEscaper.Replace(f.help) + "\n")
+		bw.WriteString("# TYPE " + f.name + " " + f.typ + "\n")
+
+		for _, s := range f.samples {
+			bw.WriteString(f.name)
+			if len(s.labels) > 0 {
+				bw.WriteByte('{')
+				for idx, l := range s.labels {
+					if idx > 0 {
+						bw.WriteByte(',')
+					}
+					bw.WriteString(l.name + `="` + labelEscaper.Replace(l.value) + `"`)
+				}
+				bw.WriteByte('}')
+			}
+			bw.WriteByte(' ')
+			bw.WriteString(strconv.FormatFloat(s.value, 'g', -1, 64))
+			bw.WriteByte('\n')
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,651 @@
+// Package gateway exposes one authenticated Vitotrol™ session as a
+// local HTTP JSON API, so that several tools can share it instead of
+// each one logging into the Vitodata™ server.
+//
+// The API is:
+//
+//	GET /devices[?refresh=true]
+//	GET /devices/DEV/attributes[?name=ATTR&name=ATTR...][&refresh=true]
+//	GET /devices/DEV/attributes/ATTR[?refresh=true]
+//	PUT /devices/DEV/attributes/ATTR        {"value":"21.5"}
+//	GET /devices/DEV/timesheets/TIMESHEET
+//	PUT /devices/DEV/timesheets/TIMESHEET   {"mon-fri":[{"from":600,"to":2200}],...}
+//	GET /devices/DEV/errors
+//	GET /operations/ID
+//
+// DEV is the ID field of a device returned by /devices, that is
+// DEVICE_ID@LOCATION_ID, or only its DEVICE_ID or its name.
+//
+// Attributes read less than MaxAge ago are served from the session
+// cache, others are read using GetData. With refresh=true they are
+// always refreshed then read.
+//
+// Writes return immediately with 202 Accepted and an Operation,
+// whose ID is the refresh ID returned by the Vitodata™ server. It is
+// followed in the background according to the session PollPolicy,
+// and its state is available at /operations/ID.
+//
+// Request bodies are limited to MaxBodySize bytes.
+//
+// Errors are reported as {"error":"message"}.
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TomTom68/go-vitotrol"
+)
+
+// DefaultMaxAge is the default duration during which an attribute
+// read from the Vitodata™ server is served from the session cache.
+const DefaultMaxAge = time.Minute
+
+// MaxBodySize is the max size of request bodies.
+const MaxBodySize = 1 << 20
+
+// operationTTL is the duration during which a finished operation is
+// kept, so it can still be queried.
+const operationTTL = time.Hour
+
+// Gateway serves the HTTP JSON API. It implements http.Handler.
+type Gateway struct {
+	session *vitotrol.Session
+	maxAge  time.Duration
+	logger  vitotrol.Logger
+
+	mu         sync.Mutex
+	fetched    map[deviceKey]map[vitotrol.AttrID]time.Time
+	operations map[string]*vitotrol.Operation
+}
+
+type deviceKey struct {
+	locationID, deviceID uint32
+}
+
+func keyOf(d *vitotrol.Device) deviceKey {
+	return deviceKey{locationID: d.LocationID, deviceID: d.DeviceID}
+}
+
+// An Option allows to customize a Gateway created by New.
+type Option func(*Gateway)
+
+// WithMaxAge sets the duration during which an attribute read from
+// the Vitodata™ server is served from the session cache instead of
+// DefaultMaxAge. 0 means attributes are read from the server each
+// time.
+func WithMaxAge(maxAge time.Duration) Option {
+	return func(g *Gateway) {
+		g.maxAge = maxAge
+	}
+}
+
+// WithLogger sets the logger used to report failed requests.
+// Without this option, nothing is logged.
+func WithLogger(logger vitotrol.Logger) Option {
+	return func(g *Gateway) {
+		g.logger = logger
+	}
+}
+
+// New returns a new Gateway using session v, that must be already
+// logged in (or created with vitotrol.WithCredentials option).
+func New(v *vitotrol.Session, opts ...Option) *Gateway {
+	g := &Gateway{
+		session:    v,
+		maxAge:     DefaultMaxAge,
+		logger:     vitotrol.DiscardLogger,
+		fetched:    map[deviceKey]map[vitotrol.AttrID]time.Time{},
+		operations: map[string]*vitotrol.Operation{},
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+// Device is the JSON representation of a device.
+type Device struct {
+	ID           string `json:"id"` // DEVICE_ID@LOCATION_ID
+	LocationID   uint32 `json:"location_id"`
+	LocationName string `json:"location_name"`
+	DeviceID     uint32 `json:"device_id"`
+	DeviceName   string `json:"device_name"`
+	HasError     bool   `json:"has_error"`
+	IsConnected  bool   `json:"is_connected"`
+}
+
+// Attribute is the JSON representation of an attribute value.
+type Attribute struct {
+	Name  string        `json:"name"`
+	Value string        `json:"value"`          // human representation
+	Unit  vitotrol.Unit `json:"unit,omitempty"` // unit of Value, if any
+	Raw   string        `json:"raw"`            // Vitodata™ formatted value
+	Time  time.Time     `json:"time"`           // server-side time of the value
+}
+
+// ErrorEvent is the JSON representation of an error history event.
+type ErrorEvent struct {
+	Code    string    `json:"code"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+	Active  bool      `json:"active"`
+}
+
+// Operation is the JSON representation of a write operation.
+type Operation struct {
+	ID        string    `json:"id"` // refresh ID of the write request
+	Device    string    `json:"device"`
+	Attribute string    `json:"attribute,omitempty"`
+	Timesheet string    `json:"timesheet,omitempty"`
+	Status    string    `json:"status"` // see vitotrol.OperationState
+	Error     string    `json:"error,omitempty"`
+	Created   time.Time `json:"created"`
+}
+
+// newOperation returns the JSON representation of op.
+func newOperation(op *vitotrol.Operation) *Operation {
+	info := op.Info()
+	ret := Operation{
+		ID:      info.RefreshID,
+		Device:  fmt.Sprintf("%d@%d", info.DeviceID, info.LocationID),
+		Status:  op.State().String(),
+		Created: info.Issued,
+	}
+	if info.Kind == vitotrol.OperationWriteTimesheet {
+		ret.Timesheet = info.Attribute
+	} else {
+		ret.Attribute = info.Attribute
+	}
+	if err := op.Err(); err != nil {
+		ret.Error = err.Error()
+	}
+	return &ret
+}
+
+// httpError is an error carrying the HTTP status to respond with.
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+func errorf(status int, format string, args ...interface{}) error {
+	return &httpError{status: status, err: fmt.Errorf(format, args...)}
+}
+
+// upstream wraps err, returned by the Vitodata™ server, so it is
+// reported with a 502 Bad Gateway or 504 Gateway Timeout status.
+func upstream(err error) error {
+	status := http.StatusBadGateway
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, vitotrol.ErrTimeout) {
+		status = http.StatusGatewayTimeout
+	}
+	return &httpError{status: status, err: err}
+}
+
+// ServeHTTP implements http.Handler interface.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, MaxBodySize)
+	status, body, err := g.route(r)
+	if err != nil {
+		status = http.StatusInternalServerError
+		var hErr *httpError
+		if errors.As(err, &hErr) {
+			status = hErr.status
+		}
+		if status >= http.StatusInternalServerError {
+			g.logger.Warn("request failed",
+				"method", r.Method, "path", r.URL.Path, "error", err)
+		}
+		body = map[string]string{"error": err.Error()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if op, ok := body.(*Operation); ok && status == http.StatusAccepted {
+		w.Header().Set("Location", "/operations/"+op.ID)
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body) //nolint: errcheck
+}
+
+func (g *Gateway) route(r *http.Request) (int, interface{}, error) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	method := func(allowed ...string) error {
+		for _, m := range allowed {
+			if r.Method == m {
+				return nil
+			}
+		}
+		return errorf(http.StatusMethodNotAllowed,
+			"method %s not allowed on %s", r.Method, r.URL.Path)
+	}
+
+	switch {
+	case len(parts) == 1 && parts[0] == "devices":
+		if err := method(http.MethodGet); err != nil {
+			return 0, nil, err
+		}
+		return g.getDevices(r)
+
+	case len(parts) == 2 && parts[0] == "operations":
+		if err := method(http.MethodGet); err != nil {
+			return 0, nil, err
+		}
+		return g.getOperation(parts[1])
+
+	case len(parts) >= 3 && len(parts) <= 4 && parts[0] == "devices":
+		d, err := g.device(r.Context(), parts[1])
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch {
+		case parts[2] == "attributes" && len(parts) == 3:
+			if err := method(http.MethodGet); err != nil {
+				return 0, nil, err
+			}
+			return g.getAttributes(r, d, r.URL.Query()["name"], false)
+
+		case parts[2] == "attributes":
+			if err := method(http.MethodGet, http.MethodPut); err != nil {
+				return 0, nil, err
+			}
+			if r.Method == http.MethodPut {
+				return g.putAttribute(r, d, parts[3])
+			}
+			return g.getAttributes(r, d, parts[3:], true)
+
+		case parts[2] == "timesheets" && len(parts) == 4:
+			if err := method(http.MethodGet, http.MethodPut); err != nil {
+				return 0, nil, err
+			}
+			if r.Method == http.MethodPut {
+				return g.putTimesheet(r, d, parts[3])
+			}
+			return g.getTimesheet(r, d, parts[3])
+
+		case parts[2] == "errors" && len(parts) == 3:
+			if err := method(http.MethodGet); err != nil {
+				return 0, nil, err
+			}
+			return g.getErrors(r, d)
+		}
+	}
+
+	return 0, nil, errorf(http.StatusNotFound, "unknown path %s", r.URL.Path)
+}
+
+func deviceID(d *vitotrol.Device) string {
+	return fmt.Sprintf("%d@%d", d.DeviceID, d.LocationID)
+}
+
+func isTrue(r *http.Request, param string) bool {
+	b, _ := strconv.ParseBool(r.URL.Query().Get(param))
+	return b
+}
+
+// devices returns the devices of the session, reading them from the
+// Vitodata™ server if they never have been or if refresh is true.
+func (g *Gateway) devices(ctx context.Context, refresh bool) ([]*vitotrol.Device, error) {
+	devices := g.session.DeviceList()
+	if len(devices) == 0 || refresh {
+		err := g.session.GetDevicesContext(ctx)
+		if err != nil {
+			return nil, upstream(err)
+		}
+		devices = g.session.DeviceList()
+	}
+	return devices, nil
+}
+
+// device returns the device matching id, see package documentation.
+func (g *Gateway) device(ctx context.Context, id string) (*vitotrol.Device, error) {
+	devices, err := g.devices(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range devices {
+		if id == deviceID(d) || id == d.DeviceName ||
+			id == strconv.FormatUint(uint64(d.DeviceID), 10) {
+			return d, nil
+		}
+	}
+	return nil, errorf(http.StatusNotFound, "unknown device `%s'", id)
+}
+
+func (g *Gateway) getDevices(r *http.Request) (int, interface{}, error) {
+	devices, err := g.devices(r.Context(), isTrue(r, "refresh"))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	list := make([]Device, len(devices))
+	for idx, d := range devices {
+		list[idx] = Device{
+			ID:           deviceID(d),
+			LocationID:   d.LocationID,
+			LocationName: d.LocationName,
+			DeviceID:     d.DeviceID,
+			DeviceName:   d.DeviceName,
+			HasError:     d.HasError,
+			IsConnected:  d.IsConnected,
+		}
+	}
+	return http.StatusOK, list, nil
+}
+
+// attribute returns the ID and the reference of attribute name of
+// device d, checking it allows access.
+func attribute(d *vitotrol.Device, name string, access vitotrol.AttrAccess) (vitotrol.AttrID, *vitotrol.AttrRef, error) {
+	registry := d.Registry()
+	attrID, ok := registry.ID(name)
+	if !ok {
+		return 0, nil, errorf(http.StatusNotFound, "unknown attribute `%s'", name)
+	}
+	ref := registry.Ref(attrID)
+	if ref.Access&access == 0 {
+		return 0, nil, errorf(http.StatusBadRequest, "attribute `%s' is not %s",
+			name, vitotrol.AccessToStr[access])
+	}
+	return attrID, ref, nil
+}
+
+// getAttributes returns the attributes names of device d, or all
+// readable ones if names is empty. If single is true, only one
+// attribute is returned instead of a list.
+func (g *Gateway) getAttributes(r *http.Request, d *vitotrol.Device, names []string, single bool) (int, interface{}, error) {
+	registry := d.Registry()
+
+	var attrs []vitotrol.AttrID
+	if len(names) == 0 {
+		for _, attrID := range registry.IDs() {
+			if registry.Ref(attrID).Access&vitotrol.ReadOnly != 0 {
+				attrs = append(attrs, attrID)
+			}
+		}
+	} else {
+		attrs = make([]vitotrol.AttrID, len(names))
+		for idx, name := range names {
+			attrID, _, err := attribute(d, name, vitotrol.ReadOnly)
+			if err != nil {
+				return 0, nil, err
+			}
+			attrs[idx] = attrID
+		}
+	}
+
+	err := g.read(r.Context(), d, attrs, isTrue(r, "refresh"))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	list := make([]Attribute, 0, len(attrs))
+	for _, attrID := range attrs {
+		value, ok := d.Attribute(attrID)
+		if !ok {
+			continue
+		}
+		ref := registry.Ref(attrID)
+		human, err := ref.Type.Vitodata2HumanValue(value.Value)
+		if err != nil {
+			human = value.Value
+		}
+		list = append(list, Attribute{
+			Name:  ref.Name,
+			Value: human,
+			Unit:  ref.Unit,
+			Raw:   value.Value,
+			Time:  time.Time(value.Time),
+		})
+	}
+
+	if single {
+		if len(list) == 0 {
+			return 0, nil, errorf(http.StatusNotFound,
+				"attribute `%s' has no value", names[0])
+		}
+		return http.StatusOK, list[0], nil
+	}
+	return http.StatusOK, list, nil
+}
+
+// read reads the attributes attrs of device d that are not in the
+// session cache or are older than g.maxAge. If refresh is true, all
+// of them are refreshed then read.
+func (g *Gateway) read(ctx context.Context, d *vitotrol.Device, attrs []vitotrol.AttrID, refresh bool) error {
+	key := keyOf(d)
+	now := time.Now()
+
+	var stale []vitotrol.AttrID
+	if refresh {
+		stale = attrs
+	} else {
+		g.mu.Lock()
+		fetched := g.fetched[key]
+		for _, attrID := range attrs {
+			_, ok := d.Attribute(attrID)
+			if !ok || now.Sub(fetched[attrID]) >= g.maxAge {
+				stale = append(stale, attrID)
+			}
+		}
+		g.mu.Unlock()
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+
+	if refresh {
+		ch, err := d.RefreshDataWaitContext(ctx, g.session, stale)
+		if err == nil {
+			err = <-ch
+		}
+		if err != nil {
+			return upstream(err)
+		}
+	}
+
+	err := d.GetDataContext(ctx, g.session, stale)
+	if err != nil {
+		return upstream(err)
+	}
+
+	g.mu.Lock()
+	fetched := g.fetched[key]
+	if fetched == nil {
+		fetched = map[vitotrol.AttrID]time.Time{}
+		g.fetched[key] = fetched
+	}
+	for _, attrID := range stale {
+		fetched[attrID] = now
+	}
+	g.mu.Unlock()
+	return nil
+}
+
+func decodeBody(r *http.Request, body interface{}) error {
+	err := json.NewDecoder(r.Body).Decode(body)
+	if err != nil {
+		return errorf(http.StatusBadRequest, "invalid JSON body: %s", err)
+	}
+	return nil
+}
+
+func (g *Gateway) putAttribute(r *http.Request, d *vitotrol.Device, name string) (int, interface{}, error) {
+	attrID, ref, err := attribute(d, name, vitotrol.WriteOnly)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var body struct {
+		Value *string `json:"value"`
+	}
+	err = decodeBody(r, &body)
+	if err != nil {
+		return 0, nil, err
+	}
+	if body.Value == nil {
+		return 0, nil, errorf(http.StatusBadRequest, "value is missing")
+	}
+
+	value, err := ref.Type.Human2VitodataValue(*body.Value)
+	if err != nil {
+		return 0, nil, errorf(http.StatusBadRequest,
+			"value `%s' of attribute %s is invalid: %s", *body.Value, name, err)
+	}
+	err = ref.Validate(value)
+	if err != nil {
+		return 0, nil, &httpError{status: http.StatusBadRequest, err: err}
+	}
+
+	key := keyOf(d)
+	ctx, issued := detach(r.Context())
+	op, err := d.WriteDataAsync(ctx, g.session, attrID, value,
+		vitotrol.WithProgress(func(op *vitotrol.Operation) {
+			if op.State() == vitotrol.OperationDone {
+				// Next read comes from the server
+				g.mu.Lock()
+				delete(g.fetched[key], attrID)
+				g.mu.Unlock()
+			}
+		}))
+	issued()
+	if err != nil {
+		return 0, nil, upstream(err)
+	}
+	return g.addOperation(op)
+}
+
+func timesheet(name string) (vitotrol.TimesheetID, error) {
+	id, ok := vitotrol.TimesheetsNames2IDs[name]
+	if !ok {
+		return 0, errorf(http.StatusNotFound, "unknown timesheet `%s'", name)
+	}
+	return id, nil
+}
+
+func (g *Gateway) getTimesheet(r *http.Request, d *vitotrol.Device, name string) (int, interface{}, error) {
+	id, err := timesheet(name)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	err = d.GetTimesheetDataContext(r.Context(), g.session, id)
+	if err != nil {
+		return 0, nil, upstream(err)
+	}
+	return http.StatusOK, d.Timesheet(id), nil
+}
+
+func (g *Gateway) putTimesheet(r *http.Request, d *vitotrol.Device, name string) (int, interface{}, error) {
+	id, err := timesheet(name)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var data map[string]vitotrol.TimeslotSlice
+	err = decodeBody(r, &data)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	ctx, issued := detach(r.Context())
+	op, err := d.WriteTimesheetDataAsync(ctx, g.session, id, data)
+	issued()
+	if err != nil {
+		var rErr *vitotrol.ResultHeader
+		var hErr *vitotrol.HTTPError
+		var uErr *url.Error
+		if !errors.As(err, &rErr) && !errors.As(err, &hErr) && !errors.As(err, &uErr) &&
+			r.Context().Err() == nil {
+			// Bad days, detected before sending anything
+			return 0, nil, &httpError{status: http.StatusBadRequest, err: err}
+		}
+		return 0, nil, upstream(err)
+	}
+	return g.addOperation(op)
+}
+
+func (g *Gateway) getErrors(r *http.Request, d *vitotrol.Device) (int, interface{}, error) {
+	err := d.GetErrorHistoryContext(r.Context(), g.session)
+	if err != nil {
+		return 0, nil, upstream(err)
+	}
+
+	events := d.ErrorsSnapshot()
+	list := make([]ErrorEvent, len(events))
+	for idx, event := range events {
+		list[idx] = ErrorEvent{
+			Code:    event.Error,
+			Message: event.Message,
+			Time:    time.Time(event.Time),
+			Active:  event.IsActive,
+		}
+	}
+	return http.StatusOK, list, nil
+}
+
+// detach returns a context canceled if ctx is done before issued is
+// called, so an operation can be issued during a request but
+// followed after its end.
+func detach(ctx context.Context) (context.Context, func()) {
+	opCtx, cancel := context.WithCancel(context.Background())
+	issued := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			cancel()
+		case <-issued:
+		}
+	}()
+	return opCtx, func() {
+		close(issued)
+		<-stopped
+	}
+}
+
+// addOperation registers op and forgets the operations finished for
+// more than operationTTL.
+func (g *Gateway) addOperation(op *vitotrol.Operation) (int, interface{}, error) {
+	now := time.Now()
+
+	g.mu.Lock()
+	for id, old := range g.operations {
+		if old.State() != vitotrol.OperationPending &&
+			now.Sub(old.Started().Add(old.Elapsed())) >= operationTTL {
+			delete(g.operations, id)
+		}
+	}
+	g.operations[op.RefreshID()] = op
+	g.mu.Unlock()
+
+	return http.StatusAccepted, newOperation(op), nil
+}
+
+// getOperation returns the operation id, as followed in the
+// background.
+func (g *Gateway) getOperation(id string) (int, interface{}, error) {
+	g.mu.Lock()
+	op := g.operations[id]
+	g.mu.Unlock()
+
+	if op == nil {
+		return 0, nil, errorf(http.StatusNotFound, "unknown operation `%s'", id)
+	}
+	return http.StatusOK, newOperation(op), nil
+}
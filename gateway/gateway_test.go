@@ -0,0 +1,317 @@
+package gateway_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	td "github.com/maxatome/go-testdeep"
+
+	"github.com/TomTom68/go-vitotrol"
+	"github.com/TomTom68/go-vitotrol/gateway"
+	"github.com/TomTom68/go-vitotrol/vitotroltest"
+)
+
+type client struct {
+	t *td.T
+	g *gateway.Gateway
+}
+
+// do sends a method request to path with body, checks the response
+// status is status and decodes the JSON response body in out.
+func (c *client) do(method, path, body string, status int, out interface{}) *httptest.ResponseRecorder {
+	c.t.Helper()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	c.g.ServeHTTP(w, req)
+
+	c.t.Cmp(w.Code, status, "%s %s status", method, path)
+	c.t.Cmp(w.Header().Get("Content-Type"), "application/json")
+	if out != nil {
+		c.t.CmpNoError(json.Unmarshal(w.Body.Bytes(), out), "%s %s body", method, path)
+	}
+	return w
+}
+
+func (c *client) fails(method, path, body string, status int, errMsg interface{}) {
+	c.t.Helper()
+
+	var resp map[string]string
+	c.do(method, path, body, status, &resp)
+	c.t.Cmp(resp, td.Map(map[string]string{}, td.MapEntries{"error": errMsg}))
+}
+
+// wait polls the operation id until it is over and returns it.
+func (c *client) wait(id string) gateway.Operation {
+	c.t.Helper()
+
+	var op gateway.Operation
+	for end := time.Now().Add(5 * time.Second); time.Now().Before(end); {
+		c.do("GET", "/operations/"+id, "", http.StatusOK, &op)
+		if op.Status != vitotrol.OperationPending.String() {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return op
+}
+
+func newGateway(t *td.T, opts ...gateway.Option) (*vitotroltest.Server, *client) {
+	dev := vitotroltest.NewDevice(1234, 5678)
+	dev.DeviceName = "Vitodens"
+	dev.Values[vitotrol.AussenTemp] = "-3.5"
+	dev.Errors = []vitotrol.ErrorHistoryEvent{{
+		Error:    "F4",
+		Message:  "Flamme fehlt",
+		Time:     vitotrol.Time(time.Date(2022, 1, 2, 3, 4, 5, 0, time.Local)),
+		IsActive: true,
+	}}
+	srv := vitotroltest.NewServer(vitotroltest.WithDevice(dev))
+
+	v := vitotrol.NewSession(vitotrol.WithURL(srv.URL),
+		vitotrol.WithRetryPolicy(vitotrol.RetryPolicy{MaxAttempts: 1}),
+		vitotrol.WithPollPolicy(vitotrol.PollPolicy{
+			InitialDelay: 20 * time.Millisecond,
+			MinInterval:  time.Millisecond,
+			Timeout:      time.Minute,
+		}))
+	t.FailureIsFatal().CmpNoError(v.Login("login", "password"))
+
+	return srv, &client{t: t, g: gateway.New(v, opts...)}
+}
+
+func TestDevices(tt *testing.T) {
+	t := td.NewT(tt)
+
+	srv, c := newGateway(t)
+	defer srv.Close()
+
+	var devices []gateway.Device
+	c.do("GET", "/devices", "", http.StatusOK, &devices)
+	t.Cmp(devices, []gateway.Device{{
+		ID:           "1234@5678",
+		LocationID:   5678,
+		LocationName: "Location 5678",
+		DeviceID:     1234,
+		DeviceName:   "Vitodens",
+		IsConnected:  true,
+	}})
+	t.Cmp(srv.Calls("GetDevices"), 1)
+
+	// Devices are cached
+	c.do("GET", "/devices", "", http.StatusOK, &devices)
+	t.Cmp(srv.Calls("GetDevices"), 1)
+
+	c.do("GET", "/devices?refresh=true", "", http.StatusOK, &devices)
+	t.Cmp(srv.Calls("GetDevices"), 2)
+	t.Len(devices, 1)
+
+	c.fails("POST", "/devices", "", http.StatusMethodNotAllowed,
+		"method POST not allowed on /devices")
+	c.fails("GET", "/foo", "", http.StatusNotFound, "unknown path /foo")
+	c.fails("GET", "/devices/42/attributes", "", http.StatusNotFound,
+		"unknown device `42'")
+
+	srv.Fail("GetDevices", vitotrol.ResultDeviceNotConnected, 1)
+	c.fails("GET", "/devices?refresh=1", "", http.StatusBadGateway, td.NotEmpty())
+}
+
+func TestAttributes(tt *testing.T) {
+	t := td.NewT(tt)
+
+	srv, c := newGateway(t)
+	defer srv.Close()
+
+	var attrs []gateway.Attribute
+	c.do("GET", "/devices/1234/attributes?name=AussenTemp&name=BrennerStatus", "",
+		http.StatusOK, &attrs)
+	t.Cmp(attrs, []gateway.Attribute{
+		{Name: "AussenTemp", Value: "-3.5", Unit: vitotrol.UnitCelsius, Raw: "-3.5", Time: attrs[0].Time},
+		{Name: "BrennerStatus", Value: "Aus", Raw: "0", Time: attrs[1].Time},
+	})
+	t.Cmp(srv.Calls("GetData"), 1)
+
+	// Served from the cache
+	var attr gateway.Attribute
+	c.do("GET", "/devices/Vitodens/attributes/AussenTemp", "", http.StatusOK, &attr)
+	t.Cmp(attr, td.Struct(gateway.Attribute{Name: "AussenTemp", Value: "-3.5"}, nil))
+	t.Cmp(srv.Calls("GetData"), 1)
+
+	srv.SetValue(1234, vitotrol.AussenTemp, "-4")
+	c.do("GET", "/devices/1234@5678/attributes/AussenTemp?refresh=true", "",
+		http.StatusOK, &attr)
+	t.Cmp(attr, td.Struct(gateway.Attribute{Name: "AussenTemp", Value: "-4"}, nil))
+	t.Cmp(srv.Calls("RefreshData"), 1)
+	t.Cmp(srv.Calls("GetData"), 2)
+
+	// All readable attributes
+	c.do("GET", "/devices/1234/attributes", "", http.StatusOK, &attrs)
+	t.Cmp(len(attrs), td.Gt(10))
+	t.Cmp(srv.Calls("GetData"), 3)
+
+	c.fails("GET", "/devices/1234/attributes/Foo", "", http.StatusNotFound,
+		"unknown attribute `Foo'")
+	c.fails("GET", "/devices/1234/attributes?name=AussenTemp&name=Foo", "",
+		http.StatusNotFound, "unknown attribute `Foo'")
+	c.fails("DELETE", "/devices/1234/attributes/AussenTemp", "",
+		http.StatusMethodNotAllowed, td.HasPrefix("method DELETE not allowed"))
+
+	srv.Fail("GetData", vitotrol.ResultDeviceNotConnected, 1)
+	c.fails("GET", "/devices/1234/attributes/AussenTemp?refresh=true", "",
+		http.StatusBadGateway, td.NotEmpty())
+}
+
+func TestAttributesMaxAge(tt *testing.T) {
+	t := td.NewT(tt)
+
+	srv, c := newGateway(t, gateway.WithMaxAge(0))
+	defer srv.Close()
+
+	var attr gateway.Attribute
+	c.do("GET", "/devices/1234/attributes/AussenTemp", "", http.StatusOK, &attr)
+	c.do("GET", "/devices/1234/attributes/AussenTemp", "", http.StatusOK, &attr)
+	t.Cmp(srv.Calls("GetData"), 2)
+}
+
+func TestWriteAttribute(tt *testing.T) {
+	t := td.NewT(tt)
+
+	srv, c := newGateway(t)
+	defer srv.Close()
+
+	var attr gateway.Attribute
+	c.do("GET", "/devices/1234/attributes/HeizNormalTempM1", "", http.StatusOK, &attr)
+	t.Cmp(attr.Value, "20.5")
+
+	var op gateway.Operation
+	w := c.do("PUT", "/devices/1234/attributes/HeizNormalTempM1", `{"value":"21"}`,
+		http.StatusAccepted, &op)
+	t.Cmp(op, td.Struct(gateway.Operation{
+		Device:    "1234@5678",
+		Attribute: "HeizNormalTempM1",
+		Status:    vitotrol.OperationPending.String(),
+	}, td.StructFields{
+		"ID":      td.NotEmpty(),
+		"Created": td.NotZero(),
+	}))
+	t.Cmp(w.Header().Get("Location"), "/operations/"+op.ID)
+	t.Cmp(srv.Calls("WriteData"), 1)
+
+	t.Cmp(c.wait(op.ID), td.Struct(gateway.Operation{
+		ID:        op.ID,
+		Device:    "1234@5678",
+		Attribute: "HeizNormalTempM1",
+		Status:    vitotrol.OperationDone.String(),
+	}, nil))
+	t.Cmp(srv.Calls("RequestWriteStatus"), 1)
+
+	// Finished operations are not requested again
+	var status gateway.Operation
+	c.do("GET", "/operations/"+op.ID, "", http.StatusOK, &status)
+	t.Cmp(status.Status, vitotrol.OperationDone.String())
+	t.Cmp(srv.Calls("RequestWriteStatus"), 1)
+
+	// Written value is read from the server
+	c.do("GET", "/devices/1234/attributes/HeizNormalTempM1", "", http.StatusOK, &attr)
+	t.Cmp(attr.Value, "21")
+	t.Cmp(srv.Calls("GetData"), 2)
+
+	c.fails("PUT", "/devices/1234/attributes/AussenTemp", `{"value":"21"}`,
+		http.StatusBadRequest, "attribute `AussenTemp' is not write-only")
+	c.fails("PUT", "/devices/1234/attributes/HeizNormalTempM1", `{"value":"abc"}`,
+		http.StatusBadRequest, td.HasPrefix("value `abc' of attribute HeizNormalTempM1 is invalid"))
+	c.fails("PUT", "/devices/1234/attributes/HeizNormalTempM1", `{"value":"45"}`,
+		http.StatusBadRequest,
+		"value `45' of attribute HeizNormalTempM1 is invalid: greater than maximum 37")
+	c.fails("PUT", "/devices/1234/attributes/HeizNormalTempM1", `{}`,
+		http.StatusBadRequest, "value is missing")
+	c.fails("PUT", "/devices/1234/attributes/HeizNormalTempM1", `{`,
+		http.StatusBadRequest, td.HasPrefix("invalid JSON body: "))
+	c.fails("PUT", "/devices/1234/attributes/HeizNormalTempM1",
+		`{"value":"`+strings.Repeat(" ", gateway.MaxBodySize)+`"}`,
+		http.StatusBadRequest, td.Contains("request body too large"))
+	c.fails("GET", "/operations/foo", "", http.StatusNotFound, "unknown operation `foo'")
+	t.Cmp(srv.Calls("WriteData"), 1)
+}
+
+func TestWriteAttributePending(tt *testing.T) {
+	t := td.NewT(tt)
+
+	dev := vitotroltest.NewDevice(1234, 5678)
+	srv := vitotroltest.NewServer(vitotroltest.WithDevice(dev),
+		vitotroltest.WithWriteLatency(time.Hour))
+	defer srv.Close()
+
+	v := vitotrol.NewSession(vitotrol.WithURL(srv.URL),
+		vitotrol.WithRetryPolicy(vitotrol.RetryPolicy{MaxAttempts: 1}))
+	t.FailureIsFatal().CmpNoError(v.Login("login", "password"))
+	c := &client{t: t, g: gateway.New(v)}
+
+	var op gateway.Operation
+	c.do("PUT", "/devices/1234/attributes/HeizNormalTempM1", `{"value":"21"}`,
+		http.StatusAccepted, &op)
+
+	// Status is requested in the background, after the policy
+	// InitialDelay, not on each GET
+	var status gateway.Operation
+	c.do("GET", "/operations/"+op.ID, "", http.StatusOK, &status)
+	t.Cmp(status.Status, vitotrol.OperationPending.String())
+	c.do("GET", "/operations/"+op.ID, "", http.StatusOK, &status)
+	t.Cmp(status.Status, vitotrol.OperationPending.String())
+	t.Cmp(srv.Calls("RequestWriteStatus"), 0)
+}
+
+func TestTimesheets(tt *testing.T) {
+	t := td.NewT(tt)
+
+	srv, c := newGateway(t)
+	defer srv.Close()
+
+	var ts map[string]vitotrol.TimeslotSlice
+	c.do("GET", "/devices/1234/timesheets/HeatingTimesheet", "", http.StatusOK, &ts)
+	t.Cmp(ts, td.Len(7))
+	t.Cmp(ts["mon"], vitotrol.TimeslotSlice{{From: 600, To: 2200}})
+
+	var op gateway.Operation
+	c.do("PUT", "/devices/1234/timesheets/HeatingTimesheet",
+		`{"mon-sun":[{"from":700,"to":2100}]}`, http.StatusAccepted, &op)
+	t.Cmp(op, td.Struct(gateway.Operation{
+		Device:    "1234@5678",
+		Timesheet: "HeatingTimesheet",
+		Status:    vitotrol.OperationPending.String(),
+	}, nil))
+
+	t.Cmp(c.wait(op.ID).Status, vitotrol.OperationDone.String())
+	t.Cmp(srv.Timesheet(1234, vitotrol.HeatingTimesheet)["sun"],
+		vitotrol.TimeslotSlice{{From: 700, To: 2100}})
+
+	c.fails("GET", "/devices/1234/timesheets/Foo", "", http.StatusNotFound,
+		"unknown timesheet `Foo'")
+	c.fails("PUT", "/devices/1234/timesheets/HeatingTimesheet",
+		`{"mon-foo":[{"from":700,"to":2100}]}`, http.StatusBadRequest,
+		"Bad timesheet range of days `MON-FOO'")
+	t.Cmp(srv.Calls("WriteTimesheetData"), 1)
+}
+
+func TestErrors(tt *testing.T) {
+	t := td.NewT(tt)
+
+	srv, c := newGateway(t)
+	defer srv.Close()
+
+	var events []gateway.ErrorEvent
+	c.do("GET", "/devices/1234/errors", "", http.StatusOK, &events)
+	t.Cmp(events, td.Bag(
+		td.Struct(gateway.ErrorEvent{
+			Code:    "F4",
+			Message: "Flamme fehlt",
+			Active:  true,
+		}, td.StructFields{
+			"Time": td.TruncTime(time.Date(2022, 1, 2, 3, 4, 5, 0, time.Local)),
+		}),
+	))
+}
@@ -0,0 +1,431 @@
+// Package history records the attributes values read from Vitotrol™
+// devices in an append-only file and allows to query them.
+//
+// The file contains one JSON Record per line, so it can be inspected
+// with usual tools. A Store implements vitotrol.DataObserver, so
+// creating a session with vitotrol.WithDataObserver(store) records
+// the result of each GetData call. A value is only recorded once per
+// server timestamp.
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/TomTom68/go-vitotrol"
+)
+
+// pruneInterval is the minimal duration between two automatic prunes
+// of a Store opened with WithRetention option.
+const pruneInterval = time.Hour
+
+// Record is the value of an attribute of a device at a given time.
+type Record struct {
+	Time       time.Time       `json:"time"` // server-side time of the value
+	LocationID uint32          `json:"location_id"`
+	DeviceID   uint32          `json:"device_id"`
+	AttrID     vitotrol.AttrID `json:"attr_id"`
+	Value      string          `json:"value"` // Vitodata™ formatted value
+	// Ref is the reference of the attribute in the registry of the
+	// Store (see WithRegistry option), nil if it is unknown.
+	Ref *vitotrol.AttrRef `json:"-"`
+}
+
+// Name returns the name of the record attribute.
+func (r *Record) Name() string {
+	if r.Ref != nil {
+		return r.Ref.Name
+	}
+	return fmt.Sprintf("0x%04x", uint16(r.AttrID))
+}
+
+// Human returns the human representation of the record value (see
+// VitodataType.Vitodata2HumanValue) or, if it fails, the value as is.
+func (r *Record) Human() string {
+	if r.Ref != nil {
+		if human, err := r.Ref.Type.Vitodata2HumanValue(r.Value); err == nil {
+			return human
+		}
+	}
+	return r.Value
+}
+
+// Num returns the numeric value of the record and true, or false if
+// the value is not a number. Enums are numbered by their index.
+func (r *Record) Num() (float64, bool) {
+	if r.Ref == nil {
+		num, err := strconv.ParseFloat(r.Value, 64)
+		return num, err == nil
+	}
+
+	native, err := r.Ref.Type.Vitodata2NativeValue(r.Value)
+	if err != nil {
+		return 0, false
+	}
+	switch num := native.(type) {
+	case float64:
+		return num, true
+	case int64:
+		return float64(num), true
+	case uint64:
+		return float64(num), true
+	}
+	return 0, false
+}
+
+type recordKey struct {
+	locationID, deviceID uint32
+	attrID               vitotrol.AttrID
+}
+
+// Store is an history file opened by Open.
+type Store struct {
+	path      string
+	retention time.Duration
+	logger    vitotrol.Logger
+	registry  *vitotrol.AttributeRegistry
+
+	mu        sync.Mutex
+	file      *os.File
+	last      map[recordKey]time.Time
+	lastPrune time.Time
+}
+
+// An Option allows to customize a Store opened by Open.
+type Option func(*Store)
+
+// WithRetention makes the Store remove the records older than
+// retention, when opened then regularly during appends.
+func WithRetention(retention time.Duration) Option {
+	return func(s *Store) {
+		s.retention = retention
+	}
+}
+
+// WithLogger sets the logger used to report ObserveData errors.
+// Without this option, they are reported using vitotrol.StdLogger.
+func WithLogger(logger vitotrol.Logger) Option {
+	return func(s *Store) {
+		s.logger = logger
+	}
+}
+
+// WithRegistry sets the attribute registry used to resolve the
+// attributes of the records returned by Store.Query, instead of the
+// built-in attributes (see vitotrol.NewAttributeRegistry).
+func WithRegistry(registry *vitotrol.AttributeRegistry) Option {
+	return func(s *Store) {
+		s.registry = registry
+	}
+}
+
+// Open opens the history file path, creating it if needed. Close
+// has to be called when the Store is not needed anymore.
+func Open(path string, opts ...Option) (*Store, error) {
+	s := &Store{
+		path:   path,
+		logger: vitotrol.StdLogger(false),
+		last:   map[recordKey]time.Time{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.registry == nil {
+		s.registry = vitotrol.NewAttributeRegistry()
+	}
+
+	err := s.scan(func(r *Record) {
+		key := recordKey{locationID: r.LocationID, deviceID: r.DeviceID, attrID: r.AttrID}
+		if r.Time.After(s.last[key]) {
+			s.last[key] = r.Time
+		}
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if s.retention > 0 {
+		_, err = s.prune(time.Now().Add(-s.retention))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.open()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// open opens the file for appending, removing its last line if it
+// has been truncated by an interrupted write.
+func (s *Store) open() error {
+	file, err := os.OpenFile(s.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	size, err := completeSize(file)
+	if err == nil {
+		err = file.Truncate(size)
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	s.file = file
+	return nil
+}
+
+// completeSize returns the size of file up to its last newline.
+func completeSize(file *os.File) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, 4096)
+	for end := info.Size(); end > 0; {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+		n, err := file.ReadAt(buf[:end-start], start)
+		if err != nil {
+			return 0, err
+		}
+		if idx := bytes.LastIndexByte(buf[:n], '\n'); idx >= 0 {
+			return start + int64(idx) + 1, nil
+		}
+		end = start
+	}
+	return 0, nil
+}
+
+// Close closes the history file.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// Path returns the path of the history file.
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Append appends the values of device d whose server timestamp
+// changed since the last recorded ones.
+func (s *Store) Append(d *vitotrol.Device, values map[vitotrol.AttrID]vitotrol.Value) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return os.ErrClosed
+	}
+
+	registry := d.Registry()
+	records := make([]Record, 0, len(values))
+	for attrID, value := range values {
+		key := recordKey{locationID: d.LocationID, deviceID: d.DeviceID, attrID: attrID}
+		tm := time.Time(value.Time)
+		if last, ok := s.last[key]; ok && !tm.After(last) {
+			continue
+		}
+		records = append(records, Record{
+			Time:       tm,
+			LocationID: d.LocationID,
+			DeviceID:   d.DeviceID,
+			AttrID:     attrID,
+			Value:      value.Value,
+			Ref:        registry.Ref(attrID),
+		})
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	sortRecords(records)
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for idx := range records {
+		err := enc.Encode(&records[idx])
+		if err != nil {
+			return err
+		}
+	}
+
+	// One write so concurrent readers never see interleaved records
+	_, err := s.file.Write(buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	for _, r := range records {
+		s.last[recordKey{locationID: r.LocationID, deviceID: r.DeviceID, attrID: r.AttrID}] = r.Time
+	}
+
+	if s.retention > 0 && time.Since(s.lastPrune) >= pruneInterval {
+		_, err = s.prune(time.Now().Add(-s.retention))
+		if s.file == nil {
+			if errOpen := s.open(); err == nil {
+				err = errOpen
+			}
+		}
+	}
+	return err
+}
+
+// ObserveData implements vitotrol.DataObserver interface. Errors are
+// logged, see WithLogger option.
+func (s *Store) ObserveData(d *vitotrol.Device, values map[vitotrol.AttrID]vitotrol.Value) {
+	err := s.Append(d, values)
+	if err != nil {
+		s.logger.Warn("cannot append history",
+			"file", s.path, "device", d.DeviceID, "error", err)
+	}
+}
+
+// Prune removes the records older than before and returns how many
+// have been removed.
+func (s *Store) Prune(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	removed, err := s.prune(before)
+	if s.file == nil {
+		if errOpen := s.open(); err == nil {
+			err = errOpen
+		}
+	}
+	return removed, err
+}
+
+// prune rewrites the history file without the records older than
+// before. If it was opened, the file is then closed, so the caller
+// has to reopen it.
+func (s *Store) prune(before time.Time) (int, error) {
+	s.lastPrune = time.Now()
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name()) //nolint: errcheck
+
+	bw := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(bw)
+	removed := 0
+	var errEnc error
+	err = s.scan(func(r *Record) {
+		if r.Time.Before(before) {
+			removed++
+		} else if errEnc == nil {
+			errEnc = enc.Encode(r)
+		}
+	})
+	if err == nil {
+		err = errEnc
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if errClose := tmp.Close(); err == nil {
+		err = errClose
+	}
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil || removed == 0 {
+		return 0, err
+	}
+
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+	err = os.Chmod(tmp.Name(), 0644)
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// scan calls fn for each record of the history file. A truncated
+// last line, as left by an interrupted write, is ignored.
+func (s *Store) scan(fn func(*Record)) error {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return readRecords(file, s.path, fn)
+}
+
+func readRecords(r io.Reader, name string, fn func(*Record)) error {
+	scanner := bufio.NewScanner(r)
+	var (
+		line       int
+		pendingErr error
+	)
+	for scanner.Scan() {
+		line++
+		if pendingErr != nil {
+			return pendingErr
+		}
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var record Record
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			// Only an error if it is not the last line
+			pendingErr = fmt.Errorf("%s:%d: %s", name, line, err)
+			continue
+		}
+		fn(&record)
+	}
+	return scanner.Err()
+}
+
+func sortRecords(records []Record) {
+	sort.SliceStable(records, func(i, j int) bool {
+		ri, rj := &records[i], &records[j]
+		if !ri.Time.Equal(rj.Time) {
+			return ri.Time.Before(rj.Time)
+		}
+		if ri.LocationID != rj.LocationID {
+			return ri.LocationID < rj.LocationID
+		}
+		if ri.DeviceID != rj.DeviceID {
+			return ri.DeviceID < rj.DeviceID
+		}
+		return ri.AttrID < rj.AttrID
+	})
+}
@@ -0,0 +1,236 @@
+package history_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	td "github.com/maxatome/go-testdeep"
+
+	"github.com/TomTom68/go-vitotrol"
+	"github.com/TomTom68/go-vitotrol/history"
+	"github.com/TomTom68/go-vitotrol/vitotroltest"
+)
+
+var (
+	testDevice = &vitotrol.Device{LocationID: 5678, DeviceID: 1234}
+	testTime   = time.Date(2022, 1, 2, 12, 0, 0, 0, time.UTC)
+	registry   = vitotrol.NewAttributeRegistry()
+)
+
+func values(tm time.Time, kv ...interface{}) map[vitotrol.AttrID]vitotrol.Value {
+	ret := map[vitotrol.AttrID]vitotrol.Value{}
+	for i := 0; i < len(kv); i += 2 {
+		ret[kv[i].(vitotrol.AttrID)] = vitotrol.Value{
+			Time:  vitotrol.Time(tm),
+			Value: kv[i+1].(string),
+		}
+	}
+	return ret
+}
+
+func record(tm time.Time, attrID vitotrol.AttrID, value string) history.Record {
+	return history.Record{
+		Time:       tm,
+		LocationID: 5678,
+		DeviceID:   1234,
+		AttrID:     attrID,
+		Value:      value,
+		Ref:        registry.Ref(attrID),
+	}
+}
+
+func TestRecord(tt *testing.T) {
+	t := td.NewT(tt)
+
+	r := record(testTime, vitotrol.AussenTemp, "12.5")
+	t.Cmp(r.Name(), "AussenTemp")
+	t.Cmp(r.Human(), "12.5")
+	num, ok := r.Num()
+	t.True(ok)
+	t.Cmp(num, 12.5)
+
+	r = record(testTime, vitotrol.BrennerStatus, "1")
+	t.Cmp(r.Human(), "Ein")
+	num, ok = r.Num()
+	t.True(ok)
+	t.Cmp(num, 1.0)
+
+	r = record(testTime, vitotrol.DatumUhrzeit, "2022-01-02 12:00:00")
+	_, ok = r.Num()
+	t.False(ok)
+
+	r = record(testTime, 0x1234, "42")
+	t.Cmp(r.Name(), "0x1234")
+	t.Cmp(r.Human(), "42")
+	num, ok = r.Num()
+	t.True(ok)
+	t.Cmp(num, 42.0)
+}
+
+func TestStore(tt *testing.T) {
+	t := td.NewT(tt)
+
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+
+	require := t.FailureIsFatal()
+	s, err := history.Open(path)
+	require.CmpNoError(err)
+	t.Cmp(s.Path(), path)
+
+	t.CmpNoError(s.Append(testDevice,
+		values(testTime, vitotrol.AussenTemp, "12.5", vitotrol.BrennerStatus, "0")))
+	// Same server time: not recorded again
+	t.CmpNoError(s.Append(testDevice,
+		values(testTime, vitotrol.AussenTemp, "12.5", vitotrol.BrennerStatus, "0")))
+	t.CmpNoError(s.Append(testDevice, values(testTime.Add(time.Minute),
+		vitotrol.AussenTemp, "13", vitotrol.BrennerStatus, "0")))
+
+	expected := []history.Record{
+		record(testTime, vitotrol.BrennerStatus, "0"),
+		record(testTime, vitotrol.AussenTemp, "12.5"),
+		record(testTime.Add(time.Minute), vitotrol.BrennerStatus, "0"),
+		record(testTime.Add(time.Minute), vitotrol.AussenTemp, "13"),
+	}
+	records, err := s.Query(history.Query{})
+	t.CmpNoError(err)
+	t.Cmp(records, td.Len(4))
+	t.Cmp(records, td.Smuggle(utc, expected))
+	t.CmpNoError(s.Close())
+	t.Cmp(s.Append(testDevice, values(testTime, vitotrol.AussenTemp, "1")), os.ErrClosed)
+
+	// Interrupted write
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	require.CmpNoError(err)
+	_, err = file.WriteString(`{"time":"2022-01`)
+	require.CmpNoError(err)
+	file.Close()
+
+	// Reopen: truncated record is dropped and last times are known
+	s, err = history.Open(path)
+	require.CmpNoError(err)
+	defer s.Close()
+
+	t.CmpNoError(s.Append(testDevice, values(testTime.Add(time.Minute),
+		vitotrol.AussenTemp, "13", vitotrol.BrennerStatus, "0")))
+	t.CmpNoError(s.Append(testDevice, values(testTime.Add(2*time.Minute),
+		vitotrol.AussenTemp, "14")))
+
+	records, err = s.Query(history.Query{})
+	t.CmpNoError(err)
+	t.Cmp(records, td.Smuggle(utc,
+		append(expected, record(testTime.Add(2*time.Minute), vitotrol.AussenTemp, "14"))))
+
+	content, err := os.ReadFile(path)
+	require.CmpNoError(err)
+	t.Cmp(strings.Count(string(content), "\n"), 5)
+	t.False(strings.Contains(string(content), `"2022-01\n`))
+
+	// Truncated line not being the last one is an error
+	_, err = history.Open(path)
+	t.CmpNoError(err)
+	require.CmpNoError(os.WriteFile(path, []byte("{\n{}\n"), 0644))
+	_, err = history.Open(path)
+	t.Cmp(err, td.String(path+":1: unexpected end of JSON input"))
+}
+
+func TestPrune(tt *testing.T) {
+	t := td.NewT(tt)
+
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+
+	require := t.FailureIsFatal()
+	s, err := history.Open(path)
+	require.CmpNoError(err)
+	defer s.Close()
+
+	for i := 0; i < 5; i++ {
+		require.CmpNoError(s.Append(testDevice,
+			values(testTime.Add(time.Duration(i)*time.Hour), vitotrol.AussenTemp, "1")))
+	}
+
+	removed, err := s.Prune(testTime.Add(2 * time.Hour))
+	t.CmpNoError(err)
+	t.Cmp(removed, 2)
+
+	removed, err = s.Prune(testTime.Add(2 * time.Hour))
+	t.CmpNoError(err)
+	t.Cmp(removed, 0)
+
+	// Still appendable after the prune
+	t.CmpNoError(s.Append(testDevice,
+		values(testTime.Add(5*time.Hour), vitotrol.AussenTemp, "2")))
+
+	records, err := s.Query(history.Query{})
+	t.CmpNoError(err)
+	t.Cmp(records, td.Len(4))
+	t.Cmp(records[0].Time, td.TruncTime(testTime.Add(2*time.Hour)))
+	t.Cmp(records[3].Value, "2")
+
+	// Retention is applied when opening
+	t.CmpNoError(s.Close())
+	s, err = history.Open(path, history.WithRetention(time.Hour))
+	require.CmpNoError(err)
+	records, err = s.Query(history.Query{})
+	t.CmpNoError(err)
+	t.Len(records, 0)
+}
+
+func TestObserveData(tt *testing.T) {
+	t := td.NewT(tt)
+
+	srv := vitotroltest.NewServer(vitotroltest.WithDevice(vitotroltest.NewDevice(1234, 5678)))
+	defer srv.Close()
+
+	s, err := history.Open(filepath.Join(t.TempDir(), "history.jsonl"))
+	t.FailureIsFatal().CmpNoError(err)
+	defer s.Close()
+
+	v := vitotrol.NewSession(vitotrol.WithURL(srv.URL), vitotrol.WithDataObserver(s))
+
+	require := t.FailureIsFatal()
+	require.CmpNoError(v.Login("login", "password"))
+	require.CmpNoError(v.GetDevices())
+	d := v.DeviceList()[0]
+	require.CmpNoError(d.GetData(v, []vitotrol.AttrID{vitotrol.AussenTemp}))
+	require.CmpNoError(d.GetData(v, []vitotrol.AttrID{vitotrol.AussenTemp}))
+
+	records, err := s.Query(history.Query{})
+	t.CmpNoError(err)
+	t.Cmp(records, []history.Record{{
+		Time:       records[0].Time,
+		LocationID: 5678,
+		DeviceID:   1234,
+		AttrID:     vitotrol.AussenTemp,
+		Value:      "20.5",
+		Ref:        registry.Ref(vitotrol.AussenTemp),
+	}})
+
+	// Errors are logged
+	s.Close()
+	var logged []string
+	s, err = history.Open(s.Path(), history.WithLogger(logger(func(msg string) {
+		logged = append(logged, msg)
+	})))
+	require.CmpNoError(err)
+	s.Close()
+	s.ObserveData(d, values(testTime.Add(time.Hour), vitotrol.AussenTemp, "1"))
+	t.Cmp(logged, []string{"cannot append history"})
+}
+
+// utc converts records times to UTC, as records read back from the
+// file have the local time zone of their writer.
+func utc(records []history.Record) []history.Record {
+	for idx := range records {
+		records[idx].Time = records[idx].Time.UTC()
+	}
+	return records
+}
+
+type logger func(msg string)
+
+func (l logger) Debug(msg string, args ...interface{}) { l(msg) }
+func (l logger) Info(msg string, args ...interface{})  { l(msg) }
+func (l logger) Warn(msg string, args ...interface{})  { l(msg) }
@@ -0,0 +1,222 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TomTom68/go-vitotrol"
+)
+
+// Query selects records of a Store.
+type Query struct {
+	LocationID uint32            // 0 means any location
+	DeviceID   uint32            // 0 means any device
+	AttrIDs    []vitotrol.AttrID // nil means any attribute
+	Since      time.Time         // inclusive, zero means no lower bound
+	Until      time.Time         // exclusive, zero means no upper bound
+}
+
+func (q *Query) match(r *Record) bool {
+	if (q.LocationID != 0 && r.LocationID != q.LocationID) ||
+		(q.DeviceID != 0 && r.DeviceID != q.DeviceID) ||
+		(!q.Since.IsZero() && r.Time.Before(q.Since)) ||
+		(!q.Until.IsZero() && !r.Time.Before(q.Until)) {
+		return false
+	}
+
+	if q.AttrIDs == nil {
+		return true
+	}
+	for _, attrID := range q.AttrIDs {
+		if r.AttrID == attrID {
+			return true
+		}
+	}
+	return false
+}
+
+// Query returns the records matching q, sorted by time, then
+// location, device and attribute IDs. Their attributes are resolved
+// using the Store registry (see WithRegistry option).
+func (s *Store) Query(q Query) ([]Record, error) {
+	var records []Record
+	err := s.scan(func(r *Record) {
+		if q.match(r) {
+			r.Ref = s.registry.Ref(r.AttrID)
+			records = append(records, *r)
+		}
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	sortRecords(records)
+	return records, nil
+}
+
+// Bucket gathers the numeric values of an attribute of a device
+// during a time interval.
+type Bucket struct {
+	Start      time.Time       `json:"start"`
+	LocationID uint32          `json:"location_id"`
+	DeviceID   uint32          `json:"device_id"`
+	AttrID     vitotrol.AttrID `json:"attr_id"`
+	Count      int             `json:"count"`
+	Min        float64         `json:"min"`
+	Max        float64         `json:"max"`
+	Avg        float64         `json:"avg"`
+	// Ref is the reference of the attribute, as in the bucket records.
+	Ref *vitotrol.AttrRef `json:"-"`
+}
+
+// Name returns the name of the bucket attribute.
+func (b *Bucket) Name() string {
+	r := Record{AttrID: b.AttrID, Ref: b.Ref}
+	return r.Name()
+}
+
+// Downsample gathers records in buckets of width duration, one per
+// device and attribute, aligned on the zero time (so on midnight UTC
+// for days). Records whose value is not a number (see Record.Num)
+// are ignored. Buckets are sorted by start time, then location,
+// device and attribute IDs.
+func Downsample(records []Record, width time.Duration) []Bucket {
+	type bucketKey struct {
+		recordKey
+		start time.Time
+	}
+
+	index := map[bucketKey]int{}
+	var (
+		buckets []Bucket
+		sums    []float64
+	)
+	for idx := range records {
+		r := &records[idx]
+		num, ok := r.Num()
+		if !ok {
+			continue
+		}
+
+		start := r.Time.Truncate(width)
+		key := bucketKey{
+			recordKey: recordKey{locationID: r.LocationID, deviceID: r.DeviceID, attrID: r.AttrID},
+			start:     start.UTC(),
+		}
+		bIdx, ok := index[key]
+		if !ok {
+			bIdx = len(buckets)
+			index[key] = bIdx
+			buckets = append(buckets, Bucket{
+				Start:      start,
+				LocationID: r.LocationID,
+				DeviceID:   r.DeviceID,
+				AttrID:     r.AttrID,
+				Ref:        r.Ref,
+				Min:        num,
+				Max:        num,
+			})
+			sums = append(sums, 0)
+		}
+
+		b := &buckets[bIdx]
+		b.Count++
+		if num < b.Min {
+			b.Min = num
+		}
+		if num > b.Max {
+			b.Max = num
+		}
+		sums[bIdx] += num
+	}
+
+	for idx := range buckets {
+		buckets[idx].Avg = sums[idx] / float64(buckets[idx].Count)
+	}
+
+	sort.SliceStable(buckets, func(i, j int) bool {
+		bi, bj := &buckets[i], &buckets[j]
+		if !bi.Start.Equal(bj.Start) {
+			return bi.Start.Before(bj.Start)
+		}
+		if bi.LocationID != bj.LocationID {
+			return bi.LocationID < bj.LocationID
+		}
+		if bi.DeviceID != bj.DeviceID {
+			return bi.DeviceID < bj.DeviceID
+		}
+		return bi.AttrID < bj.AttrID
+	})
+	return buckets
+}
+
+// Downsample returns the records matching q gathered in buckets of
+// width duration, see Downsample function.
+func (s *Store) Downsample(q Query, width time.Duration) ([]Bucket, error) {
+	records, err := s.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	return Downsample(records, width), nil
+}
+
+// ParseDuration is the same as time.ParseDuration but also accepts
+// "d" (24h) and "w" (7d) units, as in "7d" or "1w2d12h".
+func ParseDuration(str string) (time.Duration, error) {
+	var total time.Duration
+	rest := strings.TrimSpace(str)
+	if rest == "" {
+		return 0, fmt.Errorf("invalid duration %q", str)
+	}
+
+	for rest != "" {
+		numEnd := strings.IndexFunc(rest, func(r rune) bool {
+			return (r < '0' || r > '9') && r != '.'
+		})
+		if numEnd <= 0 {
+			// No unit (numEnd < 0) is handled by time.ParseDuration
+			d, err := time.ParseDuration(rest)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", str)
+			}
+			return total + d, nil
+		}
+
+		unitEnd := strings.IndexFunc(rest[numEnd:], func(r rune) bool {
+			return (r >= '0' && r <= '9') || r == '.'
+		})
+		if unitEnd < 0 {
+			unitEnd = len(rest)
+		} else {
+			unitEnd += numEnd
+		}
+
+		var unit time.Duration
+		switch rest[numEnd:unitEnd] {
+		case "d":
+			unit = 24 * time.Hour
+		case "w":
+			unit = 7 * 24 * time.Hour
+		default:
+			d, err := time.ParseDuration(rest[:unitEnd])
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", str)
+			}
+			total += d
+			rest = rest[unitEnd:]
+			continue
+		}
+
+		num, err := strconv.ParseFloat(rest[:numEnd], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", str)
+		}
+		total += time.Duration(num * float64(unit))
+		rest = rest[unitEnd:]
+	}
+	return total, nil
+}
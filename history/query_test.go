@@ -0,0 +1,154 @@
+package history_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	td "github.com/maxatome/go-testdeep"
+
+	"github.com/TomTom68/go-vitotrol"
+	"github.com/TomTom68/go-vitotrol/history"
+)
+
+func TestQuery(tt *testing.T) {
+	t := td.NewT(tt)
+
+	s, err := history.Open(filepath.Join(t.TempDir(), "history.jsonl"))
+	t.FailureIsFatal().CmpNoError(err)
+	defer s.Close()
+
+	other := &vitotrol.Device{LocationID: 5678, DeviceID: 4321}
+	for i := 0; i < 4; i++ {
+		tm := testTime.Add(time.Duration(i) * time.Hour)
+		t.CmpNoError(s.Append(testDevice,
+			values(tm, vitotrol.AussenTemp, "1", vitotrol.BrennerStatus, "0")))
+		t.CmpNoError(s.Append(other, values(tm, vitotrol.AussenTemp, "2")))
+	}
+
+	count := func(q history.Query) int {
+		t.Helper()
+		records, err := s.Query(q)
+		t.CmpNoError(err)
+		return len(records)
+	}
+
+	t.Cmp(count(history.Query{}), 12)
+	t.Cmp(count(history.Query{DeviceID: 4321}), 4)
+	t.Cmp(count(history.Query{LocationID: 1}), 0)
+	t.Cmp(count(history.Query{AttrIDs: []vitotrol.AttrID{vitotrol.AussenTemp}}), 8)
+	t.Cmp(count(history.Query{
+		DeviceID: 1234,
+		AttrIDs:  []vitotrol.AttrID{vitotrol.AussenTemp},
+		Since:    testTime.Add(time.Hour),
+		Until:    testTime.Add(3 * time.Hour),
+	}), 2)
+
+	records, err := s.Query(history.Query{Since: testTime.Add(3 * time.Hour)})
+	t.CmpNoError(err)
+	t.Cmp(records, td.Smuggle(utc, []history.Record{
+		record(testTime.Add(3*time.Hour), vitotrol.BrennerStatus, "0"),
+		record(testTime.Add(3*time.Hour), vitotrol.AussenTemp, "1"),
+		{
+			Time:       testTime.Add(3 * time.Hour),
+			LocationID: 5678,
+			DeviceID:   4321,
+			AttrID:     vitotrol.AussenTemp,
+			Value:      "2",
+			Ref:        registry.Ref(vitotrol.AussenTemp),
+		},
+	}))
+
+	// Attributes resolved using the Store registry
+	custom := vitotrol.NewAttributeRegistry()
+	custom.Add(vitotrol.AussenTemp, vitotrol.AttrRef{Type: vitotrol.TypeDouble, Name: "Outside"})
+	s2, err := history.Open(s.Path(), history.WithRegistry(custom))
+	t.FailureIsFatal().CmpNoError(err)
+	defer s2.Close()
+	records, err = s2.Query(history.Query{DeviceID: 4321, Since: testTime.Add(3 * time.Hour)})
+	t.CmpNoError(err)
+	if t.Cmp(records, td.Len(1)) {
+		t.Cmp(records[0].Name(), "Outside")
+	}
+}
+
+func TestDownsample(tt *testing.T) {
+	t := td.NewT(tt)
+
+	records := []history.Record{
+		record(testTime, vitotrol.AussenTemp, "1"),
+		record(testTime.Add(10*time.Minute), vitotrol.AussenTemp, "3"),
+		record(testTime.Add(20*time.Minute), vitotrol.DatumUhrzeit, "2022-01-02 12:00:00"),
+		record(testTime.Add(59*time.Minute), vitotrol.AussenTemp, "8"),
+		record(testTime.Add(60*time.Minute), vitotrol.AussenTemp, "-2"),
+		record(testTime.Add(30*time.Minute), vitotrol.BrennerStatus, "1"),
+	}
+
+	t.Cmp(history.Downsample(records, time.Hour), []history.Bucket{
+		{
+			Start:      testTime,
+			LocationID: 5678,
+			DeviceID:   1234,
+			AttrID:     vitotrol.BrennerStatus,
+			Ref:        registry.Ref(vitotrol.BrennerStatus),
+			Count:      1,
+			Min:        1,
+			Max:        1,
+			Avg:        1,
+		},
+		{
+			Start:      testTime,
+			LocationID: 5678,
+			DeviceID:   1234,
+			AttrID:     vitotrol.AussenTemp,
+			Ref:        registry.Ref(vitotrol.AussenTemp),
+			Count:      3,
+			Min:        1,
+			Max:        8,
+			Avg:        4,
+		},
+		{
+			Start:      testTime.Add(time.Hour),
+			LocationID: 5678,
+			DeviceID:   1234,
+			AttrID:     vitotrol.AussenTemp,
+			Ref:        registry.Ref(vitotrol.AussenTemp),
+			Count:      1,
+			Min:        -2,
+			Max:        -2,
+			Avg:        -2,
+		},
+	})
+
+	t.Nil(history.Downsample(nil, time.Hour))
+
+	b := history.Bucket{AttrID: vitotrol.AussenTemp, Ref: registry.Ref(vitotrol.AussenTemp)}
+	t.Cmp(b.Name(), "AussenTemp")
+	b.Ref = nil
+	t.Cmp(b.Name(), "0x14fd")
+}
+
+func TestParseDuration(tt *testing.T) {
+	t := td.NewT(tt)
+
+	for str, expected := range map[string]time.Duration{
+		"7d":      7 * 24 * time.Hour,
+		"1w2d12h": 9*24*time.Hour + 12*time.Hour,
+		"1.5d":    36 * time.Hour,
+		"90m":     90 * time.Minute,
+		"1h30m":   90 * time.Minute,
+		"2d30s":   48*time.Hour + 30*time.Second,
+		"0":       0,
+		"-1h":     -time.Hour,
+	} {
+		d, err := history.ParseDuration(str)
+		if t.CmpNoError(err, str) {
+			t.Cmp(d, expected, str)
+		}
+	}
+
+	for _, str := range []string{"", "7", "d", "7x", "1d7", "1..5d"} {
+		_, err := history.ParseDuration(str)
+		t.Cmp(err, td.String(`invalid duration "`+str+`"`), str)
+	}
+}
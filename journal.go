@@ -0,0 +1,141 @@
+package vitotrol
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Journal records the pending asynchronous operations in a small
+// JSON file, so they can be resumed after a restart of the program
+// (see WithJournal option and Resume method). The file is atomically
+// rewritten at each change.
+//
+// It can be safely used by several goroutines.
+type Journal struct {
+	file string
+
+	mu      sync.Mutex
+	pending map[string]OperationInfo
+}
+
+// OpenJournal opens the journal file, that is created at the first
+// recorded operation if it does not exist yet.
+func OpenJournal(file string) (*Journal, error) {
+	j := &Journal{
+		file:    file,
+		pending: map[string]OperationInfo{},
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return j, nil
+		}
+		return nil, err
+	}
+
+	var infos []OperationInfo
+	err = json.Unmarshal(content, &infos)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	for _, info := range infos {
+		j.pending[info.RefreshID] = info
+	}
+	return j, nil
+}
+
+// Pending returns the pending operations, sorted by issue time.
+func (j *Journal) Pending() []OperationInfo {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.sorted()
+}
+
+// Get returns the pending operation refreshID, and false if it is not
+// in the journal.
+func (j *Journal) Get(refreshID string) (OperationInfo, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	info, ok := j.pending[refreshID]
+	return info, ok
+}
+
+// Add adds or replaces the pending operation info.
+func (j *Journal) Add(info OperationInfo) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.pending[info.RefreshID] = info
+	return j.save()
+}
+
+// Remove removes the operation refreshID. It is not an error if it
+// is not in the journal.
+func (j *Journal) Remove(refreshID string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, ok := j.pending[refreshID]; !ok {
+		return nil
+	}
+	delete(j.pending, refreshID)
+	return j.save()
+}
+
+// Resume follows again all the pending operations of the journal
+// (see Session.ResumeOperation). Each of them is removed from the
+// journal once over, unless canceled.
+func (j *Journal) Resume(ctx context.Context, v *Session, opts ...OperationOption) []*Operation {
+	opts = append(opts[:len(opts):len(opts)], WithJournal(j))
+
+	pending := j.Pending()
+	ops := make([]*Operation, len(pending))
+	for idx, info := range pending {
+		ops[idx] = v.ResumeOperation(ctx, info, opts...)
+	}
+	return ops
+}
+
+func (j *Journal) sorted() []OperationInfo {
+	infos := make([]OperationInfo, 0, len(j.pending))
+	for _, info := range j.pending {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, k int) bool {
+		if infos[i].Issued.Equal(infos[k].Issued) {
+			return infos[i].RefreshID < infos[k].RefreshID
+		}
+		return infos[i].Issued.Before(infos[k].Issued)
+	})
+	return infos
+}
+
+// save atomically rewrites the journal file. j.mu must be locked.
+func (j *Journal) save() error {
+	content, err := json.MarshalIndent(j.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(j.file), filepath.Base(j.file)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(append(content, '\n'))
+	if errClose := tmp.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), j.file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name()) //nolint: errcheck
+	}
+	return err
+}
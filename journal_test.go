@@ -0,0 +1,185 @@
+package vitotrol
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	td "github.com/maxatome/go-testdeep"
+)
+
+func TestJournal(tt *testing.T) {
+	t := td.NewT(tt)
+
+	file := filepath.Join(t.TempDir(), "journal.json")
+
+	j, err := OpenJournal(file)
+	t.FailureIsFatal().CmpNoError(err)
+	t.Empty(j.Pending())
+
+	issued := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	w1 := OperationInfo{
+		RefreshID:  "12",
+		Kind:       OperationWrite,
+		LocationID: testLocationID,
+		DeviceID:   testDeviceID,
+		Attribute:  "HeisswasserSollTemp",
+		Value:      "50",
+		Issued:     issued.Add(time.Second),
+	}
+	r1 := OperationInfo{
+		RefreshID:  "11",
+		Kind:       OperationRefresh,
+		LocationID: testLocationID,
+		DeviceID:   testDeviceID,
+		Issued:     issued,
+	}
+	t.CmpNoError(j.Add(w1))
+	t.CmpNoError(j.Add(r1))
+	t.Cmp(j.Pending(), []OperationInfo{r1, w1})
+
+	info, ok := j.Get("12")
+	t.True(ok)
+	t.Cmp(info, w1)
+	_, ok = j.Get("13")
+	t.False(ok)
+
+	// Reopened
+	j, err = OpenJournal(file)
+	t.FailureIsFatal().CmpNoError(err)
+	t.Cmp(j.Pending(), []OperationInfo{r1, w1})
+
+	t.CmpNoError(j.Remove("11"))
+	t.CmpNoError(j.Remove("11"), "not an error if absent")
+	t.Cmp(j.Pending(), []OperationInfo{w1})
+
+	var content []map[string]interface{}
+	buf, err := os.ReadFile(file)
+	t.FailureIsFatal().CmpNoError(err)
+	t.CmpNoError(json.Unmarshal(buf, &content))
+	t.Cmp(content, []map[string]interface{}{
+		{
+			"refresh_id":  "12",
+			"kind":        "write",
+			"location_id": float64(testLocationID),
+			"device_id":   float64(testDeviceID),
+			"attribute":   "HeisswasserSollTemp",
+			"value":       "50",
+			"issued":      "2026-10-17T12:00:01Z",
+		},
+	})
+
+	entries, err := os.ReadDir(filepath.Dir(file))
+	t.CmpNoError(err)
+	t.Len(entries, 1, "no temporary file left")
+
+	// Errors
+	t.CmpNoError(os.WriteFile(file, []byte(`[{"kind":"foo"}]`), 0o600))
+	_, err = OpenJournal(file)
+	t.Cmp(err, td.HasPrefix(file+": "))
+
+	_, err = OpenJournal(t.TempDir())
+	t.CmpError(err)
+}
+
+func TestOperationJournal(tt *testing.T) {
+	t := td.NewT(tt)
+
+	d := &Device{DeviceID: testDeviceID, LocationID: testLocationID}
+	ctx := context.Background()
+
+	j, err := OpenJournal(filepath.Join(t.TempDir(), "journal.json"))
+	t.FailureIsFatal().CmpNoError(err)
+
+	t.Run("recorded while pending", func(t *td.T) {
+		ts := newStatusServer(1, 4)
+		defer ts.Close()
+		v := NewSession(WithURL(ts.URL), withTestPollPolicy(0, time.Minute))
+
+		var pending []OperationInfo
+		op, err := d.WriteDataAsync(ctx, v, HeisswasserSollTemp, "50",
+			WithJournal(j),
+			WithProgress(func(op *Operation) {
+				if op.State() == OperationPending {
+					pending = j.Pending()
+				}
+			}))
+		t.FailureIsFatal().CmpNoError(err)
+		t.CmpNoError(op.Wait(ctx))
+
+		t.Cmp(pending, []OperationInfo{{
+			RefreshID:  "42",
+			Kind:       OperationWrite,
+			LocationID: testLocationID,
+			DeviceID:   testDeviceID,
+			Attribute:  "HeisswasserSollTemp",
+			Value:      "50",
+			Issued:     op.Started(),
+		}})
+		t.Empty(j.Pending(), "removed once done")
+	})
+
+	t.Run("canceled", func(t *td.T) {
+		ts := newStatusServer(1)
+		defer ts.Close()
+		v := NewSession(WithURL(ts.URL), withTestPollPolicy(time.Hour, time.Hour))
+
+		op, err := d.WriteDataAsync(ctx, v, HeisswasserSollTemp, "50", WithJournal(j))
+		t.FailureIsFatal().CmpNoError(err)
+		op.Cancel()
+		t.Cmp(j.Pending(), []OperationInfo{op.Info()}, "kept once canceled")
+	})
+
+	t.Run("resume", func(t *td.T) {
+		ts := newStatusServer(3, 4)
+		defer ts.Close()
+		v := NewSession(WithURL(ts.URL), withTestPollPolicy(time.Hour, time.Hour))
+
+		ops := j.Resume(ctx, v)
+		t.FailureIsFatal().Len(ops, 1)
+		t.Cmp(ops[0].RefreshID(), "42")
+
+		waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		t.CmpNoError(ops[0].Wait(waitCtx), "first wait is the minimal one")
+		t.Cmp(ops[0].State(), OperationDone)
+		t.Empty(j.Pending())
+	})
+
+	t.Run("resume too late", func(t *td.T) {
+		ts := newStatusServer(1)
+		defer ts.Close()
+		v := NewSession(WithURL(ts.URL), withTestPollPolicy(time.Hour, time.Minute))
+
+		op := v.ResumeOperation(ctx, OperationInfo{
+			RefreshID: "42",
+			Kind:      OperationWrite,
+			Issued:    time.Now().Add(-time.Hour),
+		})
+
+		waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		t.Cmp(op.Wait(waitCtx), ErrTimeout)
+		t.Cmp(op.Status(), 1, "checked once")
+	})
+}
+
+func TestOperationKindJSON(tt *testing.T) {
+	t := td.NewT(tt)
+
+	for _, kind := range []OperationKind{OperationRefresh, OperationWrite, OperationWriteTimesheet} {
+		buf, err := json.Marshal(kind)
+		t.CmpNoError(err)
+		t.Cmp(string(buf), `"`+kind.String()+`"`)
+
+		var got OperationKind
+		t.CmpNoError(json.Unmarshal(buf, &got))
+		t.Cmp(got, kind)
+	}
+
+	var kind OperationKind
+	t.Cmp(json.Unmarshal([]byte(`"foo"`), &kind), td.Contains(`unknown operation kind "foo"`))
+}
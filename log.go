@@ -0,0 +1,131 @@
+package vitotrol
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Logger is the interface used by a Session to emit structured
+// events. args are alternating keys and values. *slog.Logger
+// implements it.
+//
+// Passwords and cookies are never logged.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+}
+
+// DiscardLogger is a Logger discarding all events.
+var DiscardLogger Logger = discardLogger{}
+
+type discardLogger struct{}
+
+func (discardLogger) Debug(string, ...interface{}) {}
+func (discardLogger) Info(string, ...interface{})  {}
+func (discardLogger) Warn(string, ...interface{})  {}
+
+// StdLogger returns a Logger writing to the standard log package:
+// warnings are always written, other events only if debug is
+// true. It is the Logger used by sessions not created with
+// WithLogger option.
+func StdLogger(debug bool) Logger {
+	return stdLogger{debug: debug}
+}
+
+type stdLogger struct {
+	debug bool
+}
+
+func (l stdLogger) Debug(msg string, args ...interface{}) {
+	if l.debug {
+		l.print("DEBUG", msg, args)
+	}
+}
+
+func (l stdLogger) Info(msg string, args ...interface{}) {
+	if l.debug {
+		l.print("INFO", msg, args)
+	}
+}
+
+func (l stdLogger) Warn(msg string, args ...interface{}) {
+	l.print("WARN", msg, args)
+}
+
+func (stdLogger) print(level, msg string, args []interface{}) {
+	var buf strings.Builder
+	buf.WriteString(level)
+	buf.WriteByte(' ')
+	buf.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 < len(args) {
+			fmt.Fprintf(&buf, " %v=%v", args[i], args[i+1])
+		} else {
+			fmt.Fprintf(&buf, " !BADKEY=%v", args[i])
+		}
+	}
+	log.Print(buf.String())
+}
+
+// WithLogger sets the Logger used by the session. Without this
+// option, events are written to the standard log package: warnings
+// always, other events only when the Debug field is true.
+func WithLogger(logger Logger) SessionOption {
+	return func(c *sessionConfig) {
+		c.logger = logger
+	}
+}
+
+func (v *Session) logger() Logger {
+	if v.log != nil {
+		return v.log
+	}
+	return stdLogger{debug: v.Debug}
+}
+
+// Requests about a device and responses or requests about an
+// asynchronous operation implement these interfaces, so their
+// device ID and refresh ID can be logged.
+type (
+	loggedDevice interface {
+		logDeviceID() uint32
+	}
+	loggedRefreshID interface {
+		logRefreshID() string
+	}
+)
+
+// requestLogArgs returns the structured logging arguments describing
+// req and resp.
+func requestLogArgs(req interface{}, resp HasResultHeader) []interface{} {
+	var args []interface{}
+	if dev, ok := req.(loggedDevice); ok {
+		args = append(args, "device", dev.logDeviceID())
+	}
+	if refresh, ok := req.(loggedRefreshID); ok {
+		args = append(args, "refresh_id", refresh.logRefreshID())
+	} else if refresh, ok := resp.(loggedRefreshID); ok {
+		if id := refresh.logRefreshID(); id != "" {
+			args = append(args, "refresh_id", id)
+		}
+	}
+	return args
+}
+
+func (h DeviceHeader) logDeviceID() uint32 { return h.DeviceID }
+
+func (r *WriteTimesheetDataRequest) logDeviceID() uint32 { return r.Data.DeviceID }
+
+func (r *RequestRefreshStatusRequest) logRefreshID() string { return r.RefreshID }
+
+func (r *RequestWriteStatusRequest) logRefreshID() string { return r.RefreshID }
+
+func (r *WriteDataResponse) logRefreshID() string { return r.WriteDataResult.RefreshID }
+
+func (r *RefreshDataResponse) logRefreshID() string { return r.RefreshDataResult.RefreshID }
+
+func (r *WriteTimesheetDataResponse) logRefreshID() string {
+	return r.WriteTimesheetDataResult.RefreshID
+}
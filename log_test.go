@@ -0,0 +1,126 @@
+package vitotrol
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	td "github.com/maxatome/go-testdeep"
+)
+
+type logEvent struct {
+	Level string
+	Msg   string
+	Args  map[string]interface{}
+}
+
+type testLogger struct {
+	mu     sync.Mutex
+	events []logEvent
+}
+
+func (l *testLogger) add(level, msg string, args []interface{}) {
+	event := logEvent{Level: level, Msg: msg, Args: map[string]interface{}{}}
+	for i := 0; i+1 < len(args); i += 2 {
+		event.Args[args[i].(string)] = args[i+1]
+	}
+
+	l.mu.Lock()
+	l.events = append(l.events, event)
+	l.mu.Unlock()
+}
+
+func (l *testLogger) Debug(msg string, args ...interface{}) { l.add("DEBUG", msg, args) }
+func (l *testLogger) Info(msg string, args ...interface{})  { l.add("INFO", msg, args) }
+func (l *testLogger) Warn(msg string, args ...interface{})  { l.add("WARN", msg, args) }
+
+func TestLogger(tt *testing.T) {
+	t := td.NewT(tt)
+
+	rs := newRetryServer()
+	defer rs.Close()
+
+	logger := &testLogger{}
+	v := NewSession(WithURL(rs.URL), WithLogger(logger), WithDebug(true),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 2}))
+	d := &Device{DeviceID: testDeviceID, LocationID: testLocationID}
+
+	require := t.FailureIsFatal()
+	require.CmpNoError(v.Login("pipo", "bingo"))
+	require.CmpNoError(d.GetData(v, []AttrID{AussenTemp}))
+	_, err := d.WriteData(v, HeizNormalTempM1, "20")
+	require.CmpNoError(err)
+
+	rs.expire()
+	require.CmpNoError(d.GetData(v, []AttrID{AussenTemp}))
+
+	soapEvent := func(action string, args td.MapEntries) td.TestDeep {
+		return td.Struct(logEvent{Level: "DEBUG", Msg: "soap request"},
+			td.StructFields{
+				"Args": td.SuperMapOf(map[string]interface{}{
+					"action":   action,
+					"duration": td.Isa(time.Duration(0)),
+					"status":   200,
+				}, args),
+			})
+	}
+
+	t.CmpDeeply(logger.events, td.Slice([]logEvent{}, td.ArrayEntries{
+		0: soapEvent("Login", td.MapEntries{"result": 0}),
+		1: soapEvent("GetData", td.MapEntries{"result": 0, "device": uint32(testDeviceID)}),
+		2: soapEvent("WriteData", td.MapEntries{
+			"result":     0,
+			"device":     uint32(testDeviceID),
+			"refresh_id": "42",
+		}),
+		3: soapEvent("GetData", td.MapEntries{
+			"result": ResultSessionExpired,
+			"error":  td.Smuggle(isSessionExpired, true),
+		}),
+		4: soapEvent("Login", nil),
+		5: td.Struct(logEvent{Level: "INFO", Msg: "session re-authenticated"}, nil),
+		6: td.Struct(logEvent{Level: "DEBUG", Msg: "retrying request"}, td.StructFields{
+			"Args": td.SuperMapOf(map[string]interface{}{"action": "GetData", "attempt": 2}, nil),
+		}),
+		7: soapEvent("GetData", td.MapEntries{"result": 0}),
+	}))
+
+	// Neither password nor cookie are logged
+	t.CmpDeeply(fmt.Sprint(logger.events), td.All(
+		td.Not(td.Contains("bingo")),
+		td.Not(td.Contains("session=")),
+	))
+}
+
+func TestStdLogger(tt *testing.T) {
+	t := td.NewT(tt)
+
+	var buf bytes.Buffer
+	out, flags := log.Writer(), log.Flags()
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(out)
+		log.SetFlags(flags)
+	}()
+
+	v := &Session{}
+	v.logger().Debug("hidden", "foo", 1)
+	v.logger().Info("hidden")
+	v.logger().Warn("shown", "foo", 1, "bar")
+	t.CmpDeeply(buf.String(), "WARN shown foo=1 !BADKEY=bar\n")
+
+	buf.Reset()
+	v.Debug = true
+	v.logger().Debug("shown", "foo", 1)
+	v.logger().Info("shown")
+	t.CmpDeeply(buf.String(), "DEBUG shown foo=1\nINFO shown\n")
+
+	buf.Reset()
+	v = NewSession(WithLogger(DiscardLogger), WithDebug(true))
+	v.logger().Warn("hidden")
+	t.CmpDeeply(buf.String(), "")
+}
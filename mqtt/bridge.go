@@ -0,0 +1,564 @@
+// Package mqtt bridges the devices of a Vitotrol™ session to an MQTT
+// broker, with Home Assistant discovery.
+//
+// Each readable attribute of each device is published as a retained
+// message on topic
+//
+//	PREFIX/LOCATION_ID/DEVICE_ID/ATTR_NAME
+//
+// using its human representation (see VitodataType.Vitodata2HumanValue).
+// Messages published on
+//
+//	PREFIX/LOCATION_ID/DEVICE_ID/ATTR_NAME/set
+//
+// for writable attributes are written to the device using
+// WriteDataWait. The availability of the bridge and of each device
+// is published on PREFIX/status and PREFIX/LOCATION_ID/DEVICE_ID/status.
+//
+// The bridge uses any Client, typically a ReconnectClient (see
+// DialReconnect) to survive broker connection losses.
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TomTom68/go-vitotrol"
+)
+
+// Defaults used by NewBridge.
+const (
+	DefaultTopicPrefix     = "vitotrol"
+	DefaultDiscoveryPrefix = "homeassistant"
+	DefaultInterval        = 5 * time.Minute
+)
+
+// Availability payloads.
+const (
+	Online  = "online"
+	Offline = "offline"
+)
+
+// Bridge publishes the attributes of the devices of a session to an
+// MQTT broker and writes the values received on set topics.
+type Bridge struct {
+	session         *vitotrol.Session
+	client          Client
+	prefix          string
+	discoveryPrefix string
+	attrs           []vitotrol.AttrID
+	interval        time.Duration
+	refresh         bool
+	logger          vitotrol.Logger
+
+	commands chan command
+
+	mu         sync.Mutex
+	published  map[string]string // last payload published on each topic
+	discovered map[string]bool   // devices already announced to Home Assistant
+}
+
+type command struct {
+	locationID, deviceID uint32
+	attrName             string
+	value                string
+}
+
+// A BridgeOption allows to customize a Bridge created by NewBridge.
+type BridgeOption func(*Bridge)
+
+// WithTopicPrefix sets the prefix of all the topics used by the
+// bridge instead of DefaultTopicPrefix.
+func WithTopicPrefix(prefix string) BridgeOption {
+	return func(b *Bridge) {
+		b.prefix = prefix
+	}
+}
+
+// WithDiscoveryPrefix sets the Home Assistant discovery prefix
+// instead of DefaultDiscoveryPrefix. An empty prefix disables
+// the discovery.
+func WithDiscoveryPrefix(prefix string) BridgeOption {
+	return func(b *Bridge) {
+		b.discoveryPrefix = prefix
+	}
+}
+
+// WithAttributes sets the bridged attributes. Without this option,
+// all attributes of the registry of each device are bridged (see
+// vitotrol.Device.Registry).
+func WithAttributes(attrs ...vitotrol.AttrID) BridgeOption {
+	return func(b *Bridge) {
+		b.attrs = attrs
+	}
+}
+
+// WithInterval sets the duration between two polls of the Vitotrol™
+// server instead of DefaultInterval.
+func WithInterval(interval time.Duration) BridgeOption {
+	return func(b *Bridge) {
+		b.interval = interval
+	}
+}
+
+// WithRefresh tells whether the attributes are refreshed, using
+// RefreshDataWait, before being read. It is true by default.
+func WithRefresh(refresh bool) BridgeOption {
+	return func(b *Bridge) {
+		b.refresh = refresh
+	}
+}
+
+// WithLogger sets the logger used to report errors. Without this
+// option, errors are not logged.
+func WithLogger(logger vitotrol.Logger) BridgeOption {
+	return func(b *Bridge) {
+		b.logger = logger
+	}
+}
+
+// NewBridge returns a new Bridge between session v, that must be
+// already logged in (or created with vitotrol.WithCredentials
+// option), and client.
+func NewBridge(v *vitotrol.Session, client Client, opts ...BridgeOption) *Bridge {
+	b := &Bridge{
+		session:         v,
+		client:          client,
+		prefix:          DefaultTopicPrefix,
+		discoveryPrefix: DefaultDiscoveryPrefix,
+		interval:        DefaultInterval,
+		refresh:         true,
+		logger:          vitotrol.DiscardLogger,
+		commands:        make(chan command, 16),
+		published:       map[string]string{},
+		discovered:      map[string]bool{},
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// StatusTopic returns the topic on which the availability of a
+// bridge using topic prefix prefix is published. It can be used
+// with WithWill option.
+func StatusTopic(prefix string) string {
+	return prefix + "/status"
+}
+
+// StatusTopic returns the topic on which the bridge availability is
+// published.
+func (b *Bridge) StatusTopic() string {
+	return StatusTopic(b.prefix)
+}
+
+func (b *Bridge) deviceTopic(d *vitotrol.Device) string {
+	return fmt.Sprintf("%s/%d/%d", b.prefix, d.LocationID, d.DeviceID)
+}
+
+// deviceAttrs returns the bridged attributes of device d.
+func (b *Bridge) deviceAttrs(d *vitotrol.Device) []vitotrol.AttrID {
+	if b.attrs != nil {
+		return b.attrs
+	}
+	return d.Registry().IDs()
+}
+
+func (b *Bridge) readableAttrs(d *vitotrol.Device) []vitotrol.AttrID {
+	registry := d.Registry()
+	all := b.deviceAttrs(d)
+	attrs := make([]vitotrol.AttrID, 0, len(all))
+	for _, attrID := range all {
+		if ref := registry.Ref(attrID); ref != nil && ref.Access&vitotrol.ReadOnly != 0 {
+			attrs = append(attrs, attrID)
+		}
+	}
+	return attrs
+}
+
+// Run subscribes to the set topics, then polls the Vitotrol™ server
+// immediately and each interval, until ctx is done. It returns
+// ctx.Err() or the error preventing to subscribe.
+func (b *Bridge) Run(ctx context.Context) error {
+	err := b.client.Subscribe(ctx, b.prefix+"/+/+/+/set", b.handleSet)
+	if err != nil {
+		return err
+	}
+
+	err = b.client.Publish(ctx, b.StatusTopic(), []byte(Online), true)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	b.Poll(ctx) //nolint: errcheck
+	for {
+		select {
+		case <-ctx.Done():
+			// Best effort, as ctx is done
+			pubCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+			b.client.Publish(pubCtx, b.StatusTopic(), []byte(Offline), true) //nolint: errcheck
+			cancel()
+			return ctx.Err()
+
+		case <-ticker.C:
+			b.Poll(ctx) //nolint: errcheck
+
+		case cmd := <-b.commands:
+			b.Set(ctx, cmd.locationID, cmd.deviceID, cmd.attrName, cmd.value) //nolint: errcheck
+		}
+	}
+}
+
+// handleSet is the Handler of set topics. The command is processed
+// by the Run goroutine.
+func (b *Bridge) handleSet(topic string, payload []byte) {
+	levels := strings.Split(strings.TrimPrefix(topic, b.prefix+"/"), "/")
+	if len(levels) != 4 {
+		return
+	}
+
+	locationID, err1 := strconv.ParseUint(levels[0], 10, 32)
+	deviceID, err2 := strconv.ParseUint(levels[1], 10, 32)
+	if err1 != nil || err2 != nil {
+		b.logger.Warn("invalid set topic", "topic", topic)
+		return
+	}
+
+	select {
+	case b.commands <- command{
+		locationID: uint32(locationID),
+		deviceID:   uint32(deviceID),
+		attrName:   levels[2],
+		value:      string(payload),
+	}:
+	default:
+		b.logger.Warn("too many pending set commands, discarded", "topic", topic)
+	}
+}
+
+func (b *Bridge) publish(ctx context.Context, topic, payload string) error {
+	b.mu.Lock()
+	last, ok := b.published[topic]
+	b.mu.Unlock()
+	if ok && last == payload {
+		return nil
+	}
+
+	err := b.client.Publish(ctx, topic, []byte(payload), true)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.published[topic] = payload
+	b.mu.Unlock()
+	return nil
+}
+
+// Reset forgets the values and Home Assistant discovery configs
+// already published, so they are all published again by the next
+// poll. It is typically called after a reconnection to a broker
+// which could have lost its retained messages.
+func (b *Bridge) Reset() {
+	b.mu.Lock()
+	b.published = map[string]string{}
+	b.discovered = map[string]bool{}
+	b.mu.Unlock()
+}
+
+// Poll reads once the devices and their attributes, then publishes
+// the changed values. The first error encountered is returned.
+func (b *Bridge) Poll(ctx context.Context) error {
+	var firstErr error
+	fail := func(msg string, err error, args ...interface{}) {
+		b.logger.Warn(msg, append([]interface{}{"error", err}, args...)...)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	err := b.session.GetDevicesContext(ctx)
+	if err != nil {
+		fail("cannot get devices", err)
+		return firstErr
+	}
+
+	for _, d := range b.session.DeviceList() {
+		if b.discoveryPrefix != "" {
+			err = b.announce(ctx, d)
+			if err != nil {
+				fail("cannot publish discovery", err, "device", d.DeviceID)
+			}
+		}
+
+		status := Offline
+		if d.IsConnected {
+			status = Online
+		}
+		err = b.publish(ctx, b.deviceTopic(d)+"/status", status)
+		if err != nil {
+			fail("cannot publish", err, "device", d.DeviceID)
+		}
+
+		attrs := b.readableAttrs(d)
+		if len(attrs) == 0 {
+			continue
+		}
+
+		if b.refresh {
+			ch, err := d.RefreshDataWaitContext(ctx, b.session, attrs)
+			if err == nil {
+				err = <-ch
+			}
+			if err != nil {
+				// Values cached by the server are read anyway
+				fail("cannot refresh attributes", err, "device", d.DeviceID)
+			}
+		}
+
+		err = d.GetDataContext(ctx, b.session, attrs)
+		if err != nil {
+			fail("cannot get attributes", err, "device", d.DeviceID)
+			continue
+		}
+
+		err = b.publishAttrs(ctx, d, attrs)
+		if err != nil {
+			fail("cannot publish", err, "device", d.DeviceID)
+		}
+	}
+
+	return firstErr
+}
+
+func (b *Bridge) publishAttrs(ctx context.Context, d *vitotrol.Device, attrs []vitotrol.AttrID) error {
+	registry := d.Registry()
+	for _, attrID := range attrs {
+		value, ok := d.Attribute(attrID)
+		if !ok {
+			continue
+		}
+		ref := registry.Ref(attrID)
+		if ref == nil {
+			continue
+		}
+		human, err := ref.Type.Vitodata2HumanValue(value.Value)
+		if err != nil {
+			human = value.Value
+		}
+
+		err = b.publish(ctx, b.deviceTopic(d)+"/"+ref.Name, human)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Set writes value (in its human representation) to attribute
+// attrName of device deviceID in location locationID using
+// WriteDataWait, then publishes the new value.
+func (b *Bridge) Set(ctx context.Context, locationID, deviceID uint32, attrName, value string) error {
+	err := b.set(ctx, locationID, deviceID, attrName, value)
+	if err != nil {
+		b.logger.Warn("cannot set attribute", "error", err,
+			"device", deviceID, "attribute", attrName, "value", value)
+	}
+	return err
+}
+
+func (b *Bridge) set(ctx context.Context, locationID, deviceID uint32, attrName, value string) error {
+	var d *vitotrol.Device
+	for _, dev := range b.session.DeviceList() {
+		if dev.LocationID == locationID && dev.DeviceID == deviceID {
+			d = dev
+			break
+		}
+	}
+	if d == nil {
+		return fmt.Errorf("unknown device %d@%d", deviceID, locationID)
+	}
+
+	registry := d.Registry()
+	attrID, ok := registry.ID(attrName)
+	if !ok {
+		return fmt.Errorf("unknown attribute `%s'", attrName)
+	}
+	ref := registry.Ref(attrID)
+	if ref.Access&vitotrol.WriteOnly == 0 {
+		return fmt.Errorf("attribute `%s' is not %s",
+			attrName, vitotrol.AccessToStr[vitotrol.WriteOnly])
+	}
+
+	vitodataValue, err := ref.Type.Human2VitodataValue(value)
+	if err != nil {
+		return fmt.Errorf("value `%s' of attribute %s is invalid: %s", value, attrName, err)
+	}
+
+	ch, err := d.WriteDataWaitContext(ctx, b.session, attrID, vitodataValue)
+	if err == nil {
+		err = <-ch
+	}
+	if err != nil {
+		return err
+	}
+
+	if ref.Access&vitotrol.ReadOnly == 0 {
+		return nil
+	}
+	err = d.GetDataContext(ctx, b.session, []vitotrol.AttrID{attrID})
+	if err != nil {
+		return err
+	}
+	return b.publishAttrs(ctx, d, []vitotrol.AttrID{attrID})
+}
+
+// announce publishes the Home Assistant discovery payloads of d
+// attributes, once per device.
+func (b *Bridge) announce(ctx context.Context, d *vitotrol.Device) error {
+	devTopic := b.deviceTopic(d)
+
+	b.mu.Lock()
+	done := b.discovered[devTopic]
+	b.mu.Unlock()
+	if done {
+		return nil
+	}
+
+	registry := d.Registry()
+	for _, attrID := range b.deviceAttrs(d) {
+		ref := registry.Ref(attrID)
+		if ref == nil {
+			continue
+		}
+		component, config := b.DiscoveryConfig(d, ref)
+		payload, err := json.Marshal(config)
+		if err != nil {
+			return err
+		}
+
+		err = b.client.Publish(ctx,
+			fmt.Sprintf("%s/%s/%s/%s/config",
+				b.discoveryPrefix, component, uniqueID(d, ""), ref.Name),
+			payload, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	b.mu.Lock()
+	b.discovered[devTopic] = true
+	b.mu.Unlock()
+	return nil
+}
+
+func uniqueID(d *vitotrol.Device, attrName string) string {
+	id := fmt.Sprintf("vitotrol_%d_%d", d.LocationID, d.DeviceID)
+	if attrName != "" {
+		id += "_" + attrName
+	}
+	return id
+}
+
+// DiscoveryConfig returns the Home Assistant component and discovery
+// payload of attribute ref of device d:
+//   - an enum is a "select" if writable, a "sensor" of "enum" device
+//     class otherwise;
+//   - a number is a "number" if writable, a "sensor" otherwise;
+//   - others are "text" if writable, "sensor" otherwise.
+func (b *Bridge) DiscoveryConfig(d *vitotrol.Device, ref *vitotrol.AttrRef) (string, map[string]interface{}) {
+	devTopic := b.deviceTopic(d)
+	writable := ref.Access&vitotrol.WriteOnly != 0
+
+	name := ref.Doc
+	if name == "" {
+		name = ref.Name
+	}
+
+	config := map[string]interface{}{
+		"name":      name,
+		"unique_id": uniqueID(d, ref.Name),
+		"device": map[string]interface{}{
+			"identifiers":    []string{uniqueID(d, "")},
+			"name":           d.DeviceName,
+			"manufacturer":   "Viessmann",
+			"suggested_area": d.LocationName,
+		},
+		"availability": []map[string]string{
+			{"topic": b.StatusTopic()},
+			{"topic": devTopic + "/status"},
+		},
+		"availability_mode": "all",
+	}
+	if ref.Access&vitotrol.ReadOnly != 0 {
+		config["state_topic"] = devTopic + "/" + ref.Name
+	}
+	if writable {
+		config["command_topic"] = devTopic + "/" + ref.Name + "/set"
+	}
+
+	component := "sensor"
+	switch typ := ref.Type.(type) {
+	case *vitotrol.VitodataEnum:
+		var options []string
+		for _, value := range typ.Values() {
+			if value != "" {
+				options = append(options, value)
+			}
+		}
+		config["options"] = options
+		if writable {
+			component = "select"
+		} else {
+			config["device_class"] = "enum"
+		}
+
+	case *vitotrol.VitodataDouble, *vitotrol.VitodataInteger:
+		// Home Assistant converts the values to the units configured
+		// by the user
+		if symbol := ref.Unit.Symbol(); symbol != "" {
+			config["unit_of_measurement"] = symbol
+		}
+		switch ref.Unit {
+		case vitotrol.UnitCelsius:
+			config["device_class"] = "temperature"
+		case vitotrol.UnitHour:
+			config["device_class"] = "duration"
+		}
+		if writable {
+			component = "number"
+			config["mode"] = "box"
+			if _, ok := typ.(*vitotrol.VitodataDouble); ok {
+				config["step"] = 0.1
+			}
+			if ref.Step > 0 {
+				config["step"] = ref.Step
+			}
+			if ref.Min != nil {
+				config["min"] = *ref.Min
+			}
+			if ref.Max != nil {
+				config["max"] = *ref.Max
+			}
+		} else if ref.Unit == vitotrol.UnitCount || ref.Unit == vitotrol.UnitHour {
+			config["state_class"] = "total_increasing"
+		} else {
+			config["state_class"] = "measurement"
+		}
+
+	default:
+		if writable {
+			component = "text"
+		}
+	}
+
+	return component, config
+}
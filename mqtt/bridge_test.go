@@ -0,0 +1,199 @@
+package mqtt_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	td "github.com/maxatome/go-testdeep"
+
+	"github.com/TomTom68/go-vitotrol"
+	"github.com/TomTom68/go-vitotrol/mqtt"
+	"github.com/TomTom68/go-vitotrol/vitotroltest"
+)
+
+func TestBridge(tt *testing.T) {
+	t := td.NewT(tt)
+
+	dev := vitotroltest.NewDevice(1234, 5678)
+	dev.DeviceName = "Vitodens"
+	dev.Values[vitotrol.BrennerStatus] = "1"
+	srv := vitotroltest.NewServer(vitotroltest.WithDevice(dev))
+	defer srv.Close()
+
+	v := vitotrol.NewSession(vitotrol.WithURL(srv.URL),
+		vitotrol.WithPollPolicy(vitotrol.PollPolicy{MinInterval: time.Millisecond, Timeout: time.Minute}))
+	t.FailureIsFatal().CmpNoError(v.Login("login", "password"))
+
+	broker := mqtt.NewMemoryBroker()
+	b := mqtt.NewBridge(v, broker,
+		mqtt.WithAttributes(vitotrol.AussenTemp, vitotrol.BrennerStatus,
+			vitotrol.HeizNormalTempM1, vitotrol.BetriebsartM1),
+		mqtt.WithInterval(time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error)
+	go func() { runErr <- b.Run(ctx) }()
+
+	waitRetained := func(topic, expected string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if value, _ := broker.Retained(topic); value == expected {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		value, _ := broker.Retained(topic)
+		t.CmpDeeply(value, expected, "retained value of %s", topic)
+	}
+
+	// Initial poll
+	waitRetained("vitotrol/status", "online")
+	waitRetained("vitotrol/5678/1234/status", "online")
+	waitRetained("vitotrol/5678/1234/AussenTemp", "20.5")
+	waitRetained("vitotrol/5678/1234/BrennerStatus", "Ein")
+	waitRetained("vitotrol/5678/1234/HeizNormalTempM1", "20.5")
+
+	// Discovery
+	discovery := func(topic string) map[string]interface{} {
+		payload, ok := broker.Retained(topic)
+		if !t.True(ok, "discovery topic %s", topic) {
+			return nil
+		}
+		var config map[string]interface{}
+		t.CmpNoError(json.Unmarshal([]byte(payload), &config))
+		return config
+	}
+
+	device := map[string]interface{}{
+		"identifiers":    []interface{}{"vitotrol_5678_1234"},
+		"name":           "Vitodens",
+		"manufacturer":   "Viessmann",
+		"suggested_area": "Location 5678",
+	}
+
+	t.CmpDeeply(discovery("homeassistant/sensor/vitotrol_5678_1234/AussenTemp/config"),
+		td.SuperMapOf(map[string]interface{}{
+			"name":                "Außen Temperatur",
+			"unique_id":           "vitotrol_5678_1234_AussenTemp",
+			"state_topic":         "vitotrol/5678/1234/AussenTemp",
+			"state_class":         "measurement",
+			"device_class":        "temperature",
+			"unit_of_measurement": "°C",
+			"device":              device,
+			"availability": []interface{}{
+				map[string]interface{}{"topic": "vitotrol/status"},
+				map[string]interface{}{"topic": "vitotrol/5678/1234/status"},
+			},
+		}, nil))
+	t.CmpDeeply(discovery("homeassistant/sensor/vitotrol_5678_1234/BrennerStatus/config"),
+		td.SuperMapOf(map[string]interface{}{
+			"device_class": "enum",
+			"options":      []interface{}{"Aus", "Ein"},
+		}, nil))
+	t.CmpDeeply(discovery("homeassistant/number/vitotrol_5678_1234/HeizNormalTempM1/config"),
+		td.SuperMapOf(map[string]interface{}{
+			"state_topic":         "vitotrol/5678/1234/HeizNormalTempM1",
+			"command_topic":       "vitotrol/5678/1234/HeizNormalTempM1/set",
+			"step":                0.1,
+			"min":                 3.0,
+			"max":                 37.0,
+			"unit_of_measurement": "°C",
+		}, nil))
+	t.CmpDeeply(discovery("homeassistant/select/vitotrol_5678_1234/BetriebsartM1/config"),
+		td.SuperMapOf(map[string]interface{}{
+			"command_topic": "vitotrol/5678/1234/BetriebsartM1/set",
+			"options":       td.NotEmpty(),
+		}, nil))
+
+	// Set through MQTT
+	t.CmpNoError(broker.Publish(ctx, "vitotrol/5678/1234/HeizNormalTempM1/set", []byte("21.5"), false))
+	waitRetained("vitotrol/5678/1234/HeizNormalTempM1", "21.5")
+	value, _ := srv.Value(1234, vitotrol.HeizNormalTempM1)
+	t.CmpDeeply(value, "21.5")
+
+	// Read-only attribute and invalid value are refused
+	t.CmpNoError(broker.Publish(ctx, "vitotrol/5678/1234/AussenTemp/set", []byte("30"), false))
+	t.CmpNoError(broker.Publish(ctx, "vitotrol/5678/1234/HeizNormalTempM1/set", []byte("hot"), false))
+	t.CmpNoError(broker.Publish(ctx, "vitotrol/5678/1234/HeizNormalTempM1/set", []byte("19"), false))
+	waitRetained("vitotrol/5678/1234/HeizNormalTempM1", "19")
+	value, _ = srv.Value(1234, vitotrol.AussenTemp)
+	t.CmpDeeply(value, "20.5")
+
+	cancel()
+	t.CmpDeeply(<-runErr, context.Canceled)
+	waitRetained("vitotrol/status", "offline")
+
+	// Unchanged values are not published again
+	published := map[string]int{}
+	for _, msg := range broker.Messages() {
+		published[msg.Topic]++
+	}
+	t.CmpDeeply(published["vitotrol/5678/1234/AussenTemp"], 1)
+}
+
+func TestBridgeReset(tt *testing.T) {
+	t := td.NewT(tt)
+
+	srv := vitotroltest.NewServer(vitotroltest.WithDevice(vitotroltest.NewDevice(1234, 5678)))
+	defer srv.Close()
+
+	v := vitotrol.NewSession(vitotrol.WithURL(srv.URL))
+	t.FailureIsFatal().CmpNoError(v.Login("login", "password"))
+
+	broker := mqtt.NewMemoryBroker()
+	b := mqtt.NewBridge(v, broker,
+		mqtt.WithAttributes(vitotrol.AussenTemp),
+		mqtt.WithRefresh(false))
+
+	count := func() map[string]int {
+		published := map[string]int{}
+		for _, msg := range broker.Messages() {
+			published[msg.Topic]++
+		}
+		return published
+	}
+
+	ctx := context.Background()
+	t.CmpNoError(b.Poll(ctx))
+	t.CmpNoError(b.Poll(ctx))
+	t.Cmp(count(), td.SuperMapOf(map[string]int{
+		"vitotrol/5678/1234/AussenTemp":                             1,
+		"homeassistant/sensor/vitotrol_5678_1234/AussenTemp/config": 1,
+	}, nil))
+
+	// Everything is published again after a reset
+	b.Reset()
+	t.CmpNoError(b.Poll(ctx))
+	t.Cmp(count(), td.SuperMapOf(map[string]int{
+		"vitotrol/5678/1234/AussenTemp":                             2,
+		"homeassistant/sensor/vitotrol_5678_1234/AussenTemp/config": 2,
+	}, nil))
+}
+
+func TestBridgeSet(tt *testing.T) {
+	t := td.NewT(tt)
+
+	srv := vitotroltest.NewServer(
+		vitotroltest.WithDevice(vitotroltest.NewDevice(1234, 5678)))
+	defer srv.Close()
+
+	v := vitotrol.NewSession(vitotrol.WithURL(srv.URL),
+		vitotrol.WithPollPolicy(vitotrol.PollPolicy{MinInterval: time.Millisecond, Timeout: time.Minute}))
+	t.FailureIsFatal().CmpNoError(v.Login("login", "password"))
+	t.FailureIsFatal().CmpNoError(v.GetDevices())
+
+	b := mqtt.NewBridge(v, mqtt.NewMemoryBroker())
+	ctx := context.Background()
+
+	t.CmpDeeply(b.Set(ctx, 5678, 9999, "HeizNormalTempM1", "20"),
+		td.String("unknown device 9999@5678"))
+	t.CmpDeeply(b.Set(ctx, 5678, 1234, "Unknown", "20"),
+		td.String("unknown attribute `Unknown'"))
+	t.CmpDeeply(b.Set(ctx, 5678, 1234, "AussenTemp", "20"),
+		td.String("attribute `AussenTemp' is not write-only"))
+	t.CmpDeeply(b.Set(ctx, 5678, 1234, "HeizNormalTempM1", "hot"),
+		td.HasPrefix("value `hot' of attribute HeizNormalTempM1 is invalid: "))
+}
@@ -0,0 +1,142 @@
+package mqtt
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// A Handler is called for each message received on a subscribed
+// topic. It is called from the client receiving goroutine, so it
+// should not block.
+type Handler func(topic string, payload []byte)
+
+// Client is the interface of an MQTT client used by Bridge. It is
+// implemented by *Conn and, for tests, by *MemoryBroker.
+type Client interface {
+	// Publish publishes payload to topic, with the retain flag set
+	// if retained is true.
+	Publish(ctx context.Context, topic string, payload []byte, retained bool) error
+	// Subscribe calls handler for each message published on a topic
+	// matching filter, in which "+" and "#" wildcards can be used.
+	Subscribe(ctx context.Context, filter string, handler Handler) error
+}
+
+// MatchTopic reports whether topic matches the MQTT topic filter
+// filter, in which "+" matches exactly one level and a trailing "#"
+// matches any number of levels, including the parent one.
+func MatchTopic(filter, topic string) bool {
+	// Wildcards do not match topics beginning with $
+	if strings.HasPrefix(topic, "$") && !strings.HasPrefix(filter, "$") {
+		return false
+	}
+
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for idx, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if idx >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[idx] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
+
+type subscription struct {
+	filter  string
+	handler Handler
+}
+
+// MemoryBroker is an in-process MQTT broker stand-in implementing
+// Client, so a Bridge can be tested without any network. Retained
+// messages are kept and delivered to later subscribers, as a real
+// broker does.
+type MemoryBroker struct {
+	mu       sync.Mutex
+	subs     []subscription
+	retained map[string][]byte
+	messages []Message
+}
+
+// Message is a message published on a MemoryBroker.
+type Message struct {
+	Topic    string
+	Payload  []byte
+	Retained bool
+}
+
+// NewMemoryBroker returns a new empty MemoryBroker.
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{retained: map[string][]byte{}}
+}
+
+// Publish implements Client interface. Handlers are called
+// synchronously. As for a real broker, a retained message with an
+// empty payload deletes the retained message of topic.
+func (b *MemoryBroker) Publish(ctx context.Context, topic string, payload []byte, retained bool) error {
+	payload = append([]byte(nil), payload...)
+
+	b.mu.Lock()
+	b.messages = append(b.messages, Message{Topic: topic, Payload: payload, Retained: retained})
+	if retained {
+		if len(payload) == 0 {
+			delete(b.retained, topic)
+		} else {
+			b.retained[topic] = payload
+		}
+	}
+	var handlers []Handler
+	for _, sub := range b.subs {
+		if MatchTopic(sub.filter, topic) {
+			handlers = append(handlers, sub.handler)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, handler := range handlers {
+		handler(topic, payload)
+	}
+	return nil
+}
+
+// Subscribe implements Client interface. Matching retained messages
+// are delivered immediately.
+func (b *MemoryBroker) Subscribe(ctx context.Context, filter string, handler Handler) error {
+	b.mu.Lock()
+	b.subs = append(b.subs, subscription{filter: filter, handler: handler})
+	var retained []Message
+	for topic, payload := range b.retained {
+		if MatchTopic(filter, topic) {
+			retained = append(retained, Message{Topic: topic, Payload: payload, Retained: true})
+		}
+	}
+	b.mu.Unlock()
+
+	for _, msg := range retained {
+		handler(msg.Topic, msg.Payload)
+	}
+	return nil
+}
+
+// Retained returns the retained message of topic, and false if
+// there is none.
+func (b *MemoryBroker) Retained(topic string) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	payload, ok := b.retained[topic]
+	return string(payload), ok
+}
+
+// Messages returns all the messages published until now.
+func (b *MemoryBroker) Messages() []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]Message(nil), b.messages...)
+}
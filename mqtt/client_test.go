@@ -0,0 +1,66 @@
+package mqtt_test
+
+import (
+	"context"
+	"testing"
+
+	td "github.com/maxatome/go-testdeep"
+
+	"github.com/TomTom68/go-vitotrol/mqtt"
+)
+
+func TestMatchTopic(tt *testing.T) {
+	t := td.NewT(tt)
+
+	for _, tst := range []struct {
+		filter, topic string
+		match         bool
+	}{
+		{"a/b/c", "a/b/c", true},
+		{"a/b/c", "a/b", false},
+		{"a/b", "a/b/c", false},
+		{"a/+/c", "a/b/c", true},
+		{"a/+/c", "a/b/d", false},
+		{"a/+", "a/b/c", false},
+		{"a/#", "a/b/c", true},
+		{"a/#", "a", true},
+		{"#", "a/b", true},
+		{"+/+/+/+/set", "x/1/2/Foo/set", true},
+		{"#", "$SYS/uptime", false},
+		{"$SYS/#", "$SYS/uptime", true},
+	} {
+		t.CmpDeeply(mqtt.MatchTopic(tst.filter, tst.topic), tst.match,
+			"%s vs %s", tst.filter, tst.topic)
+	}
+}
+
+func TestMemoryBroker(tt *testing.T) {
+	t := td.NewT(tt)
+
+	ctx := context.Background()
+	broker := mqtt.NewMemoryBroker()
+
+	t.CmpNoError(broker.Publish(ctx, "a/retained", []byte("1"), true))
+	t.CmpNoError(broker.Publish(ctx, "a/volatile", []byte("2"), false))
+
+	var got []string
+	t.CmpNoError(broker.Subscribe(ctx, "a/+", func(topic string, payload []byte) {
+		got = append(got, topic+"="+string(payload))
+	}))
+	t.CmpDeeply(got, []string{"a/retained=1"})
+
+	t.CmpNoError(broker.Publish(ctx, "a/volatile", []byte("3"), false))
+	t.CmpNoError(broker.Publish(ctx, "b/other", []byte("4"), false))
+	t.CmpDeeply(got, []string{"a/retained=1", "a/volatile=3"})
+
+	value, ok := broker.Retained("a/retained")
+	t.True(ok)
+	t.CmpDeeply(value, "1")
+
+	// Empty retained payload deletes it
+	t.CmpNoError(broker.Publish(ctx, "a/retained", nil, true))
+	_, ok = broker.Retained("a/retained")
+	t.False(ok)
+
+	t.Len(broker.Messages(), 5)
+}
@@ -0,0 +1,490 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/TomTom68/go-vitotrol"
+)
+
+// MQTT 3.1.1 control packet types.
+const (
+	packetConnect     = 1
+	packetConnack     = 2
+	packetPublish     = 3
+	packetPuback      = 4
+	packetPubrec      = 5
+	packetPubrel      = 6
+	packetPubcomp     = 7
+	packetSubscribe   = 8
+	packetSuback      = 9
+	packetPingreq     = 12
+	packetPingresp    = 13
+	packetDisconnect  = 14
+	maxRemainingBytes = 4
+)
+
+// ErrClosed is returned when using a closed Conn.
+var ErrClosed = errors.New("mqtt connection closed")
+
+// Conn is a minimal MQTT 3.1.1 client connection implementing
+// Client. Messages are published and subscribed with QoS 0, but
+// messages received with QoS 1 or 2 are acknowledged as expected by
+// the broker. See Dial, and DialReconnect to survive connection
+// losses.
+type Conn struct {
+	conn      net.Conn
+	keepAlive time.Duration
+
+	wmu sync.Mutex // serializes writes
+
+	mu      sync.Mutex
+	subs    []subscription
+	nextID  uint16
+	acks    map[uint16]chan byte
+	qos2    map[uint16]bool // QoS 2 messages received, waiting for PUBREL
+	err     error
+	closing bool // Close has been called
+
+	done chan struct{}
+}
+
+type dialConfig struct {
+	clientID  string
+	username  string
+	password  string
+	keepAlive time.Duration
+
+	willTopic    string
+	willPayload  []byte
+	willRetained bool
+
+	// Used by DialReconnect only
+	backoff     func(attempt int) time.Duration
+	onReconnect []func(ctx context.Context, c Client) error
+	logger      vitotrol.Logger
+}
+
+// A DialOption allows to customize a Conn created by Dial or
+// NewConn.
+type DialOption func(*dialConfig)
+
+// WithClientID sets the client identifier sent to the broker. Without
+// this option, the broker assigns one.
+func WithClientID(clientID string) DialOption {
+	return func(c *dialConfig) {
+		c.clientID = clientID
+	}
+}
+
+// WithAuth sets the user name and password sent to the broker.
+func WithAuth(username, password string) DialOption {
+	return func(c *dialConfig) {
+		c.username = username
+		c.password = password
+	}
+}
+
+// WithKeepAlive sets the keep alive interval negotiated with the
+// broker, 60 seconds by default. The connection is considered lost
+// if nothing is received from the broker during 1.5 times this
+// interval, as PINGREQ packets are sent every half interval.
+func WithKeepAlive(keepAlive time.Duration) DialOption {
+	return func(c *dialConfig) {
+		c.keepAlive = keepAlive
+	}
+}
+
+// WithWill sets the message published by the broker on behalf of the
+// client when the connection is lost.
+func WithWill(topic string, payload []byte, retained bool) DialOption {
+	return func(c *dialConfig) {
+		c.willTopic = topic
+		c.willPayload = payload
+		c.willRetained = retained
+	}
+}
+
+// Dial connects to the MQTT broker listening on TCP address addr.
+func Dial(ctx context.Context, addr string, opts ...DialOption) (*Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := NewConn(ctx, conn, opts...)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewConn establishes an MQTT session over the already opened
+// connection conn.
+func NewConn(ctx context.Context, conn net.Conn, opts ...DialOption) (*Conn, error) {
+	conf := dialConfig{keepAlive: time.Minute}
+	for _, opt := range opts {
+		opt(&conf)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline) //nolint: errcheck
+	}
+
+	err := writePacket(conn, packetConnect<<4, connectPayload(&conf))
+	if err != nil {
+		return nil, err
+	}
+
+	rd := bufio.NewReader(conn)
+	header, body, err := readPacket(rd)
+	if err != nil {
+		return nil, err
+	}
+	if header>>4 != packetConnack || len(body) != 2 {
+		return nil, fmt.Errorf("unexpected packet type %d instead of CONNACK", header>>4)
+	}
+	if body[1] != 0 {
+		return nil, fmt.Errorf("connection refused by broker (return code %d)", body[1])
+	}
+
+	// readLoop handles the read deadline from now
+	conn.SetDeadline(time.Time{}) //nolint: errcheck
+
+	c := &Conn{
+		conn:      conn,
+		keepAlive: conf.keepAlive,
+		acks:      map[uint16]chan byte{},
+		qos2:      map[uint16]bool{},
+		done:      make(chan struct{}),
+	}
+	go c.readLoop(rd)
+	if conf.keepAlive > 0 {
+		go c.pingLoop(conf.keepAlive / 2)
+	}
+	return c, nil
+}
+
+func appendUint16(buf []byte, n uint16) []byte {
+	return append(buf, byte(n>>8), byte(n))
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = appendUint16(buf, uint16(len(s)))
+	return append(buf, s...)
+}
+
+func connectPayload(conf *dialConfig) []byte {
+	var flags byte = 0x02 // clean session
+	if conf.willTopic != "" {
+		flags |= 0x04
+		if conf.willRetained {
+			flags |= 0x20
+		}
+	}
+	if conf.username != "" {
+		flags |= 0x80
+		if conf.password != "" {
+			flags |= 0x40
+		}
+	}
+
+	buf := appendString(nil, "MQTT")
+	buf = append(buf, 4, flags) // protocol level 4 = 3.1.1
+	buf = appendUint16(buf, uint16(conf.keepAlive/time.Second))
+	buf = appendString(buf, conf.clientID)
+	if conf.willTopic != "" {
+		buf = appendString(buf, conf.willTopic)
+		buf = appendString(buf, string(conf.willPayload))
+	}
+	if conf.username != "" {
+		buf = appendString(buf, conf.username)
+		if conf.password != "" {
+			buf = appendString(buf, conf.password)
+		}
+	}
+	return buf
+}
+
+func writePacket(w io.Writer, header byte, body []byte) error {
+	buf := make([]byte, 0, 1+maxRemainingBytes+len(body))
+	buf = append(buf, header)
+	for length := len(body); ; {
+		b := byte(length & 0x7f)
+		length >>= 7
+		if length > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if length == 0 {
+			break
+		}
+	}
+	buf = append(buf, body...)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+func readPacket(rd *bufio.Reader) (byte, []byte, error) {
+	header, err := rd.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var length, shift int
+	for idx := 0; ; idx++ {
+		if idx == maxRemainingBytes {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+		b, err := rd.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		shift += 7
+	}
+
+	body := make([]byte, length)
+	_, err = io.ReadFull(rd, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+func (c *Conn) write(ctx context.Context, header byte, body []byte) error {
+	select {
+	case <-c.done:
+		return c.Err()
+	default:
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetWriteDeadline(deadline)          //nolint: errcheck
+		defer c.conn.SetWriteDeadline(time.Time{}) //nolint: errcheck
+	}
+	return writePacket(c.conn, header, body)
+}
+
+// Publish implements Client interface.
+func (c *Conn) Publish(ctx context.Context, topic string, payload []byte, retained bool) error {
+	header := byte(packetPublish << 4)
+	if retained {
+		header |= 0x01
+	}
+	return c.write(ctx, header, append(appendString(nil, topic), payload...))
+}
+
+// Subscribe implements Client interface. It returns once the broker
+// acknowledged the subscription.
+func (c *Conn) Subscribe(ctx context.Context, filter string, handler Handler) error {
+	ack := make(chan byte, 1)
+
+	c.mu.Lock()
+	c.subs = append(c.subs, subscription{filter: filter, handler: handler})
+	c.nextID++
+	if c.nextID == 0 {
+		c.nextID++
+	}
+	id := c.nextID
+	c.acks[id] = ack
+	c.mu.Unlock()
+
+	body := appendUint16(nil, id)
+	body = appendString(body, filter)
+	body = append(body, 0) // QoS 0
+	err := c.write(ctx, packetSubscribe<<4|0x02, body)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case code := <-ack:
+		if code == 0x80 {
+			return fmt.Errorf("subscription to %s refused by broker", filter)
+		}
+		return nil
+	case <-c.done:
+		return c.Err()
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.acks, id)
+		c.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (c *Conn) readLoop(rd *bufio.Reader) {
+	// As pingLoop sends PINGREQ every half keep alive interval, a
+	// silent broker means the connection is half-open
+	timeout := c.keepAlive * 3 / 2
+	for {
+		if timeout > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(timeout)) //nolint: errcheck
+		}
+		header, body, err := readPacket(rd)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				err = fmt.Errorf("no packet received from broker for %s", timeout)
+			}
+			c.close(err)
+			return
+		}
+
+		switch header >> 4 {
+		case packetPublish:
+			err = c.handlePublish(header, body)
+		case packetPingresp:
+			// Nothing to do, the read deadline is pushed back
+		case packetPubrel:
+			// Second step of QoS 2 message reception
+			if len(body) >= 2 {
+				c.mu.Lock()
+				delete(c.qos2, binary.BigEndian.Uint16(body))
+				c.mu.Unlock()
+				err = c.write(context.Background(), packetPubcomp<<4, body[:2])
+			}
+		case packetSuback:
+			if len(body) >= 3 {
+				id := binary.BigEndian.Uint16(body)
+				c.mu.Lock()
+				ack := c.acks[id]
+				delete(c.acks, id)
+				c.mu.Unlock()
+				if ack != nil {
+					ack <- body[2]
+				}
+			}
+		}
+		if err != nil {
+			c.close(err)
+			return
+		}
+	}
+}
+
+func (c *Conn) handlePublish(header byte, body []byte) error {
+	if len(body) < 2 {
+		return errors.New("malformed PUBLISH packet")
+	}
+	topicLen := int(binary.BigEndian.Uint16(body))
+	if len(body) < 2+topicLen {
+		return errors.New("malformed PUBLISH packet")
+	}
+	topic := string(body[2 : 2+topicLen])
+	payload := body[2+topicLen:]
+
+	qos := (header >> 1) & 0x03
+	if qos > 0 {
+		if len(payload) < 2 {
+			return errors.New("malformed PUBLISH packet")
+		}
+		id := payload[:2]
+		payload = payload[2:]
+
+		if qos == 1 {
+			err := c.write(context.Background(), packetPuback<<4, id)
+			if err != nil {
+				return err
+			}
+		} else {
+			// QoS 2: the message is delivered once, even if the broker
+			// sends it again before receiving PUBREC
+			c.mu.Lock()
+			dup := c.qos2[binary.BigEndian.Uint16(id)]
+			c.qos2[binary.BigEndian.Uint16(id)] = true
+			c.mu.Unlock()
+
+			err := c.write(context.Background(), packetPubrec<<4, id)
+			if err != nil || dup {
+				return err
+			}
+		}
+	}
+
+	c.mu.Lock()
+	var handlers []Handler
+	for _, sub := range c.subs {
+		if MatchTopic(sub.filter, topic) {
+			handlers = append(handlers, sub.handler)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, handler := range handlers {
+		handler(topic, payload)
+	}
+	return nil
+}
+
+func (c *Conn) pingLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if c.write(context.Background(), packetPingreq<<4, nil) != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *Conn) close(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err == nil {
+		if c.closing {
+			err = ErrClosed
+		}
+		c.err = err
+		close(c.done)
+		c.conn.Close()
+	}
+}
+
+// Done returns a channel closed when the connection is lost or
+// closed. See Err to know why.
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the reason the connection has been lost, or nil if it
+// is still alive.
+func (c *Conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Close gracefully disconnects from the broker.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	c.closing = true
+	c.mu.Unlock()
+
+	err := c.write(context.Background(), packetDisconnect<<4, nil)
+	c.close(ErrClosed)
+	return err
+}
@@ -0,0 +1,216 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	td "github.com/maxatome/go-testdeep"
+)
+
+// fakeBroker serves one MQTT client connection: it acknowledges
+// CONNECT and SUBSCRIBE packets, answers PINGREQ packets and sends
+// back each PUBLISH packet it receives, so subscriptions can be
+// tested. PINGREQ packets are not reported in received.
+type fakeBroker struct {
+	conn     net.Conn
+	received chan []byte
+	connect  chan []byte
+}
+
+func newFakeBroker(conn net.Conn) *fakeBroker {
+	fb := &fakeBroker{
+		conn:     conn,
+		received: make(chan []byte, 16),
+		connect:  make(chan []byte, 1),
+	}
+	go fb.serve()
+	return fb
+}
+
+func (fb *fakeBroker) serve() {
+	rd := bufio.NewReader(fb.conn)
+	for {
+		header, body, err := readPacket(rd)
+		if err != nil {
+			close(fb.received)
+			return
+		}
+		if header>>4 == packetPingreq {
+			writePacket(fb.conn, packetPingresp<<4, nil) //nolint: errcheck
+			continue
+		}
+		fb.received <- append([]byte{header}, body...)
+
+		switch header >> 4 {
+		case packetConnect:
+			fb.connect <- body
+			writePacket(fb.conn, packetConnack<<4, []byte{0, 0}) //nolint: errcheck
+		case packetSubscribe:
+			// packet ID + granted QoS 0
+			writePacket(fb.conn, packetSuback<<4, []byte{body[0], body[1], 0}) //nolint: errcheck
+		case packetPublish:
+			writePacket(fb.conn, header, body) //nolint: errcheck
+		case packetDisconnect:
+			fb.conn.Close()
+		}
+	}
+}
+
+func TestConn(tt *testing.T) {
+	t := td.NewT(tt)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client, server := net.Pipe()
+	fb := newFakeBroker(server)
+
+	c, err := NewConn(ctx, client,
+		WithClientID("vitotrol"),
+		WithAuth("user", "pass"),
+		WithKeepAlive(30*time.Second),
+		WithWill("vitotrol/status", []byte("offline"), true))
+	t.FailureIsFatal().CmpNoError(err)
+
+	t.CmpDeeply(<-fb.connect, []byte(
+		"\x00\x04MQTT\x04"+
+			"\xe6"+ // user, password, will retain, will, clean session
+			"\x00\x1e"+ // keep alive 30s
+			"\x00\x08vitotrol"+
+			"\x00\x0fvitotrol/status"+
+			"\x00\x07offline"+
+			"\x00\x04user"+
+			"\x00\x04pass"))
+	<-fb.received // CONNECT
+
+	messages := make(chan string, 1)
+	t.CmpNoError(c.Subscribe(ctx, "vitotrol/+/set", func(topic string, payload []byte) {
+		messages <- topic + "=" + string(payload)
+	}))
+	t.CmpDeeply(<-fb.received, []byte("\x82\x00\x01\x00\x0evitotrol/+/set\x00"))
+
+	t.CmpNoError(c.Publish(ctx, "vitotrol/Foo/set", []byte("42"), true))
+	t.CmpDeeply(<-fb.received, []byte("\x31\x00\x10vitotrol/Foo/set42"))
+	t.CmpDeeply(<-messages, "vitotrol/Foo/set=42")
+
+	t.CmpNoError(c.Close())
+	t.CmpDeeply(c.Err(), ErrClosed)
+	t.CmpDeeply(c.Publish(ctx, "a", nil, false), ErrClosed)
+}
+
+func TestConnRefused(tt *testing.T) {
+	t := td.NewT(tt)
+
+	client, server := net.Pipe()
+	go func() {
+		rd := bufio.NewReader(server)
+		readPacket(rd)                                      //nolint: errcheck
+		writePacket(server, packetConnack<<4, []byte{0, 5}) //nolint: errcheck
+	}()
+
+	_, err := NewConn(context.Background(), client)
+	t.CmpDeeply(err, td.String("connection refused by broker (return code 5)"))
+}
+
+func TestRemainingLength(tt *testing.T) {
+	t := td.NewT(tt)
+
+	client, server := net.Pipe()
+	defer client.Close()
+
+	body := make([]byte, 321)
+	go writePacket(client, packetPublish<<4, body) //nolint: errcheck
+
+	header, got, err := readPacket(bufio.NewReader(server))
+	t.CmpNoError(err)
+	t.CmpDeeply(header, byte(packetPublish<<4))
+	t.Len(got, 321)
+}
+
+func TestConnQoS(tt *testing.T) {
+	t := td.NewT(tt)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client, server := net.Pipe()
+	fb := newFakeBroker(server)
+
+	c, err := NewConn(ctx, client)
+	t.FailureIsFatal().CmpNoError(err)
+	defer c.Close()
+	<-fb.received // CONNECT
+
+	messages := make(chan string, 4)
+	t.CmpNoError(c.Subscribe(ctx, "a/#", func(topic string, payload []byte) {
+		messages <- topic + "=" + string(payload)
+	}))
+	<-fb.received // SUBSCRIBE
+
+	// QoS 1
+	writePacket(server, packetPublish<<4|0x02, []byte("\x00\x03a/b\x00\x07one")) //nolint: errcheck
+	t.CmpDeeply(<-fb.received, []byte("\x40\x00\x07"))                           // PUBACK
+	t.CmpDeeply(<-messages, "a/b=one")
+
+	// QoS 2, sent twice by the broker before PUBREL
+	writePacket(server, packetPublish<<4|0x04, []byte("\x00\x03a/b\x00\x08two")) //nolint: errcheck
+	t.CmpDeeply(<-fb.received, []byte("\x50\x00\x08"))                           // PUBREC
+	writePacket(server, packetPublish<<4|0x0c, []byte("\x00\x03a/b\x00\x08two")) //nolint: errcheck
+	t.CmpDeeply(<-fb.received, []byte("\x50\x00\x08"))                           // PUBREC
+	writePacket(server, packetPubrel<<4|0x02, []byte("\x00\x08"))                //nolint: errcheck
+	t.CmpDeeply(<-fb.received, []byte("\x70\x00\x08"))                           // PUBCOMP
+	t.CmpDeeply(<-messages, "a/b=two")
+	t.Empty(messages, "delivered once")
+}
+
+func TestConnKeepAlive(tt *testing.T) {
+	t := td.NewT(tt)
+
+	client, server := net.Pipe()
+	defer server.Close()
+
+	// Broker answering CONNECT, then nothing as if the connection was
+	// half-open
+	go func() {
+		rd := bufio.NewReader(server)
+		readPacket(rd)                                      //nolint: errcheck
+		writePacket(server, packetConnack<<4, []byte{0, 0}) //nolint: errcheck
+		for {
+			if _, _, err := readPacket(rd); err != nil {
+				return
+			}
+		}
+	}()
+
+	c, err := NewConn(context.Background(), client, WithKeepAlive(20*time.Millisecond))
+	t.FailureIsFatal().CmpNoError(err)
+	defer c.Close()
+
+	select {
+	case <-c.Done():
+		t.CmpDeeply(c.Err(), td.String("no packet received from broker for 30ms"))
+	case <-time.After(5 * time.Second):
+		t.Error("connection not closed")
+	}
+}
+
+func TestConnKeepAliveAnswered(tt *testing.T) {
+	t := td.NewT(tt)
+
+	client, server := net.Pipe()
+	fb := newFakeBroker(server)
+
+	c, err := NewConn(context.Background(), client, WithKeepAlive(20*time.Millisecond))
+	t.FailureIsFatal().CmpNoError(err)
+	<-fb.received // CONNECT
+
+	select {
+	case <-c.Done():
+		t.Errorf("connection lost: %s", c.Err())
+	case <-time.After(200 * time.Millisecond):
+	}
+	t.CmpNoError(c.Close())
+}
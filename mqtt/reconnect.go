@@ -0,0 +1,194 @@
+package mqtt
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/TomTom68/go-vitotrol"
+)
+
+// DefaultReconnectBackoff is the pause duration function used by
+// DialReconnect without the WithReconnectBackoff option.
+var DefaultReconnectBackoff = vitotrol.ExponentialBackoff(time.Second, time.Minute)
+
+// dialTimeout is the max duration of a reconnection attempt.
+const dialTimeout = 30 * time.Second
+
+// WithReconnectBackoff sets the function returning the pause duration
+// before the attempt-th (starting at 1) reconnection attempt of a
+// ReconnectClient, DefaultReconnectBackoff by default.
+func WithReconnectBackoff(backoff func(attempt int) time.Duration) DialOption {
+	return func(c *dialConfig) {
+		c.backoff = backoff
+	}
+}
+
+// WithOnReconnect adds a callback called by a ReconnectClient each
+// time it is connected again to the broker, once the subscriptions
+// are restored. It typically publishes again the messages cleared by
+// the will message (see WithWill). If it returns an error, the
+// connection is closed and dialed again.
+func WithOnReconnect(fn func(ctx context.Context, c Client) error) DialOption {
+	return func(c *dialConfig) {
+		c.onReconnect = append(c.onReconnect, fn)
+	}
+}
+
+// WithConnLogger sets the logger used by a ReconnectClient to report
+// connection losses and reconnection failures. Without this option,
+// nothing is logged.
+func WithConnLogger(logger vitotrol.Logger) DialOption {
+	return func(c *dialConfig) {
+		c.logger = logger
+	}
+}
+
+// ReconnectClient is a Client keeping a connection to an MQTT broker:
+// when the connection is lost, the broker is dialed again, pausing
+// between attempts (see WithReconnectBackoff), then the filters are
+// subscribed again and the WithOnReconnect callbacks are called.
+//
+// Messages published while disconnected are lost. See DialReconnect.
+type ReconnectClient struct {
+	addr   string
+	opts   []DialOption
+	conf   dialConfig
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu   sync.Mutex
+	conn *Conn
+	subs []subscription
+}
+
+// DialReconnect connects to the MQTT broker listening on TCP address
+// addr, as Dial does, and returns a ReconnectClient following the
+// connection. Only this first connection failure is returned.
+func DialReconnect(ctx context.Context, addr string, opts ...DialOption) (*ReconnectClient, error) {
+	conn, err := Dial(ctx, addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &ReconnectClient{
+		addr: addr,
+		opts: opts,
+		conf: dialConfig{
+			backoff: DefaultReconnectBackoff,
+			logger:  vitotrol.DiscardLogger,
+		},
+		done: make(chan struct{}),
+		conn: conn,
+	}
+	for _, opt := range opts {
+		opt(&c.conf)
+	}
+
+	var runCtx context.Context
+	runCtx, c.cancel = context.WithCancel(context.Background())
+	go c.run(runCtx)
+	return c, nil
+}
+
+func (c *ReconnectClient) current() *Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
+}
+
+// Publish implements Client interface. It fails if the connection is
+// lost and not established again yet.
+func (c *ReconnectClient) Publish(ctx context.Context, topic string, payload []byte, retained bool) error {
+	return c.current().Publish(ctx, topic, payload, retained)
+}
+
+// Subscribe implements Client interface. The subscription is restored
+// after each reconnection. If the connection is lost during the
+// subscription, it is only done after the reconnection.
+func (c *ReconnectClient) Subscribe(ctx context.Context, filter string, handler Handler) error {
+	c.mu.Lock()
+	c.subs = append(c.subs, subscription{filter: filter, handler: handler})
+	conn := c.conn
+	c.mu.Unlock()
+
+	err := conn.Subscribe(ctx, filter, handler)
+	if err != nil && conn.Err() != nil && ctx.Err() == nil {
+		return nil
+	}
+	return err
+}
+
+// connect dials the broker and restores the subscriptions.
+func (c *ReconnectClient) connect(ctx context.Context) (*Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+
+	conn, err := Dial(ctx, c.addr, c.opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// New subscriptions are done on the new connection from now
+	c.mu.Lock()
+	c.conn = conn
+	subs := c.subs
+	c.mu.Unlock()
+
+	for _, sub := range subs {
+		err = conn.Subscribe(ctx, sub.filter, sub.handler)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	for _, fn := range c.conf.onReconnect {
+		err = fn(ctx, c)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (c *ReconnectClient) run(ctx context.Context) {
+	defer close(c.done)
+
+	conn := c.current()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-conn.Done():
+		}
+		c.conf.logger.Warn("MQTT broker connection lost", "error", conn.Err())
+
+		for attempt := 1; ; attempt++ {
+			timer := time.NewTimer(c.conf.backoff(attempt))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+
+			newConn, err := c.connect(ctx)
+			if err == nil {
+				conn = newConn
+				c.conf.logger.Info("MQTT broker connection restored", "attempts", attempt)
+				break
+			}
+			c.conf.logger.Warn("cannot reconnect to MQTT broker",
+				"attempt", attempt, "error", err)
+		}
+	}
+}
+
+// Close stops reconnecting and gracefully disconnects from the
+// broker.
+func (c *ReconnectClient) Close() error {
+	c.cancel()
+	<-c.done
+	return c.current().Close()
+}
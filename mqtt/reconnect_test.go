@@ -0,0 +1,64 @@
+package mqtt
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	td "github.com/maxatome/go-testdeep"
+)
+
+func TestReconnectClient(tt *testing.T) {
+	t := td.NewT(tt)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	t.FailureIsFatal().CmpNoError(err)
+	defer ln.Close()
+
+	brokers := make(chan *fakeBroker, 2)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			brokers <- newFakeBroker(conn)
+		}
+	}()
+
+	reconnected := make(chan struct{}, 1)
+	c, err := DialReconnect(ctx, ln.Addr().String(),
+		WithReconnectBackoff(func(int) time.Duration { return time.Millisecond }),
+		WithOnReconnect(func(ctx context.Context, cl Client) error {
+			reconnected <- struct{}{}
+			return cl.Publish(ctx, "vitotrol/status", []byte("online"), true)
+		}))
+	t.FailureIsFatal().CmpNoError(err)
+	fb := <-brokers
+	<-fb.received // CONNECT
+	defer c.Close()
+
+	messages := make(chan string, 1)
+	t.CmpNoError(c.Subscribe(ctx, "vitotrol/+/set", func(topic string, payload []byte) {
+		messages <- topic + "=" + string(payload)
+	}))
+	t.CmpDeeply(<-fb.received, []byte("\x82\x00\x01\x00\x0evitotrol/+/set\x00"))
+
+	// Connection lost
+	fb.conn.Close()
+
+	fb = <-brokers
+	<-fb.received // CONNECT
+	t.CmpDeeply(<-fb.received, []byte("\x82\x00\x01\x00\x0evitotrol/+/set\x00"))
+	<-reconnected
+	t.CmpDeeply(<-fb.received, []byte("\x31\x00\x0fvitotrol/statusonline"))
+
+	// Subscription restored
+	t.CmpNoError(c.Publish(ctx, "vitotrol/Foo/set", []byte("42"), false))
+	t.CmpDeeply(<-fb.received, []byte("\x30\x00\x10vitotrol/Foo/set42"))
+	t.CmpDeeply(<-messages, "vitotrol/Foo/set=42")
+}
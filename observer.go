@@ -0,0 +1,36 @@
+package vitotrol
+
+// A DataObserver is notified of the values returned by each
+// successful GetData call of a Session, see WithDataObserver
+// option. It allows to record attributes history or to detect
+// changes whoever calls GetData.
+type DataObserver interface {
+	// ObserveData is called synchronously, after the cache of d has
+	// been updated, with the values returned by the server. It must
+	// not modify values and should return quickly.
+	ObserveData(d *Device, values map[AttrID]Value)
+}
+
+// DataObserverFunc is an adapter allowing to use an ordinary
+// function as a DataObserver.
+type DataObserverFunc func(d *Device, values map[AttrID]Value)
+
+// ObserveData implements DataObserver interface.
+func (f DataObserverFunc) ObserveData(d *Device, values map[AttrID]Value) {
+	f(d, values)
+}
+
+// WithDataObserver adds observers notified of the values returned by
+// each successful GetData call. This option can be used several
+// times.
+func WithDataObserver(observers ...DataObserver) SessionOption {
+	return func(c *sessionConfig) {
+		c.observers = append(c.observers, observers...)
+	}
+}
+
+func (v *Session) observeData(d *Device, values map[AttrID]Value) {
+	for _, obs := range v.observers {
+		obs.ObserveData(d, values)
+	}
+}
@@ -0,0 +1,49 @@
+package vitotrol
+
+import (
+	"testing"
+
+	td "github.com/maxatome/go-testdeep"
+)
+
+func TestDataObserver(tt *testing.T) {
+	t := td.NewT(tt)
+
+	rs := newRetryServer()
+	defer rs.Close()
+
+	type observed struct {
+		device *Device
+		values map[AttrID]Value
+	}
+	var calls []observed
+	obs := DataObserverFunc(func(d *Device, values map[AttrID]Value) {
+		// The cache is already updated
+		value, ok := d.Attribute(AussenTemp)
+		t.True(ok)
+		t.Cmp(value.Value, "12.5")
+
+		calls = append(calls, observed{device: d, values: values})
+	})
+
+	v := NewSession(WithURL(rs.URL), WithDataObserver(obs, obs),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+	d := &Device{DeviceID: testDeviceID, LocationID: testLocationID}
+
+	require := t.FailureIsFatal()
+	require.CmpNoError(v.Login("pipo", "bingo"))
+	require.CmpNoError(d.GetData(v, []AttrID{AussenTemp}))
+
+	expected := observed{
+		device: d,
+		values: map[AttrID]Value{AussenTemp: {Value: "12.5", Time: testTime}},
+	}
+	t.Cmp(calls, []observed{expected, expected})
+
+	// Failed GetData calls are not observed
+	rs.mu.Lock()
+	rs.failures = 1
+	rs.mu.Unlock()
+	t.CmpError(d.GetData(v, []AttrID{AussenTemp}))
+	t.Len(calls, 2)
+}
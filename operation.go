@@ -0,0 +1,431 @@
+package vitotrol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// OperationKind is the kind of an asynchronous operation, telling
+// which request is used to follow its status.
+type OperationKind int
+
+// Kinds of asynchronous operations.
+const (
+	OperationRefresh        OperationKind = iota // followed by RequestRefreshStatus
+	OperationWrite                               // followed by RequestWriteStatus
+	OperationWriteTimesheet                      // followed by RequestWriteStatus
+)
+
+// String returns the name of the operation kind.
+func (k OperationKind) String() string {
+	switch k {
+	case OperationRefresh:
+		return "refresh"
+	case OperationWriteTimesheet:
+		return "timesheet"
+	}
+	return "write"
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (k OperationKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (k *OperationKind) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "refresh":
+		*k = OperationRefresh
+	case "write":
+		*k = OperationWrite
+	case "timesheet":
+		*k = OperationWriteTimesheet
+	default:
+		return fmt.Errorf("unknown operation kind %q", text)
+	}
+	return nil
+}
+
+// OperationState is the state of an asynchronous operation.
+type OperationState int
+
+// States of an asynchronous operation. See StatusState for the
+// mapping of the Vitodata™ status codes.
+const (
+	OperationPending  OperationState = iota // still in progress
+	OperationDone                           // successfully completed
+	OperationFailed                         // rejected by the server, or status request error
+	OperationTimedOut                       // not completed before its timeout
+	OperationCanceled                       // canceled, or its context is done
+)
+
+var operationStates = [...]string{
+	OperationPending:  "pending",
+	OperationDone:     "done",
+	OperationFailed:   "failed",
+	OperationTimedOut: "timeout",
+	OperationCanceled: "canceled",
+}
+
+// String returns the name of the state.
+func (s OperationState) String() string {
+	if s >= 0 && int(s) < len(operationStates) {
+		return operationStates[s]
+	}
+	return fmt.Sprintf("OperationState(%d)", int(s))
+}
+
+// StatusState maps a status returned by RequestRefreshStatus or
+// RequestWriteStatus to its state: 4 and 9 (returned when setting
+// DatumUhrzeit) mean done, other statuses greater than 4 mean
+// failed, others mean pending (1 and 3 being the usual ones).
+func StatusState(status int) OperationState {
+	switch {
+	case status == 4 || status == 9:
+		return OperationDone
+	case status > 4:
+		return OperationFailed
+	default:
+		return OperationPending
+	}
+}
+
+// OperationInfo describes an asynchronous operation, so it can be
+// followed again after a restart (see Journal and
+// Session.ResumeOperation).
+type OperationInfo struct {
+	RefreshID  string        `json:"refresh_id"`
+	Kind       OperationKind `json:"kind"`
+	LocationID uint32        `json:"location_id"`
+	DeviceID   uint32        `json:"device_id"`
+	Attribute  string        `json:"attribute,omitempty"` // attribute or timesheet name
+	Value      string        `json:"value,omitempty"`     // written Vitodata™ formatted value
+	Issued     time.Time     `json:"issued"`
+}
+
+// Operation follows an asynchronous operation (RefreshData, WriteData
+// or WriteTimesheetData) until its completion, polling its status in
+// a background goroutine. See Device.RefreshDataAsync,
+// Device.WriteDataAsync and Device.WriteTimesheetDataAsync.
+//
+// It can be safely used by several goroutines.
+type Operation struct {
+	info     OperationInfo
+	policy   PollPolicy
+	clock    Clock
+	progress []func(*Operation)
+	journal  *Journal
+	verify   bool
+	check    func(ctx context.Context) error
+	cancel   context.CancelFunc
+	done     chan struct{}
+
+	mu     sync.Mutex
+	status int
+	state  OperationState
+	err    error
+	end    time.Time
+}
+
+// An OperationOption allows to customize an Operation.
+type OperationOption func(*Operation)
+
+// WithProgress adds a callback called each time a status is received
+// from the server, then once the operation is over. The callback is
+// called from the goroutine following the operation and must not
+// block.
+func WithProgress(fn func(*Operation)) OperationOption {
+	return func(o *Operation) {
+		o.progress = append(o.progress, fn)
+	}
+}
+
+// WithJournal records the operation in j as long as it is pending,
+// so it can be resumed after a restart (see Journal.Resume). An
+// operation canceled, or whose context is done, stays in j.
+func WithJournal(j *Journal) OperationOption {
+	return func(o *Operation) {
+		o.journal = j
+	}
+}
+
+// WithOperationPollPolicy sets the PollPolicy used to follow the
+// operation instead of the session one (see Session.PollPolicy).
+func WithOperationPollPolicy(policy PollPolicy) OperationOption {
+	return func(o *Operation) {
+		o.policy = policy
+	}
+}
+
+func newOperation(ctx context.Context, v *Session, info OperationInfo,
+	resume bool, opts []OperationOption) *Operation {
+	o := &Operation{
+		policy: v.PollPolicy(info.Kind),
+		done:   make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if resume {
+		o.policy.InitialDelay = o.policy.MinInterval
+	}
+	o.clock = o.policy.clock()
+
+	if info.Issued.IsZero() {
+		info.Issued = o.clock.Now()
+	}
+	o.info = info
+
+	if o.journal != nil {
+		err := o.journal.Add(info)
+		if err != nil {
+			v.logger().Warn("cannot record operation in journal",
+				"refresh_id", info.RefreshID, "error", err)
+		}
+	}
+
+	ctx, o.cancel = context.WithCancel(ctx)
+	go o.run(ctx, v)
+	return o
+}
+
+// ResumeOperation follows again the operation described by info,
+// generally read from a Journal after a restart. Its first status
+// request is done after the PollPolicy MinInterval, and its timeout
+// counts from info.Issued, so an operation issued too long ago is
+// checked once before timing out.
+func (v *Session) ResumeOperation(ctx context.Context, info OperationInfo, opts ...OperationOption) *Operation {
+	return newOperation(ctx, v, info, true, opts)
+}
+
+// Info returns the description of the operation.
+func (o *Operation) Info() OperationInfo {
+	return o.info
+}
+
+// Kind returns the kind of the operation.
+func (o *Operation) Kind() OperationKind {
+	return o.info.Kind
+}
+
+// RefreshID returns the "refresh ID" of the operation, as returned by
+// the server.
+func (o *Operation) RefreshID() string {
+	return o.info.RefreshID
+}
+
+// Started returns the time the operation has been issued.
+func (o *Operation) Started() time.Time {
+	return o.info.Issued
+}
+
+// Elapsed returns the duration of the operation, until now if it is
+// still pending.
+func (o *Operation) Elapsed() time.Duration {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.end.IsZero() {
+		return o.clock.Now().Sub(o.info.Issued)
+	}
+	return o.end.Sub(o.info.Issued)
+}
+
+// Status returns the last status received from the server, 0 if none
+// has been received yet.
+func (o *Operation) Status() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.status
+}
+
+// State returns the current state of the operation.
+func (o *Operation) State() OperationState {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.state
+}
+
+// Err returns the error of the operation, nil if it is still pending
+// or successfully done. It is ErrTimeout if the operation timed out,
+// and the context error if it has been canceled.
+func (o *Operation) Err() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.err
+}
+
+// Done returns a channel closed when the operation is over.
+func (o *Operation) Done() <-chan struct{} {
+	return o.done
+}
+
+// Wait waits for the end of the operation and returns its error (see
+// Err). If ctx is done before, ctx.Err() is returned, but the
+// operation is not canceled.
+func (o *Operation) Wait(ctx context.Context) error {
+	select {
+	case <-o.done:
+		return o.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Cancel stops following the operation, and returns once it is
+// over. Note that the server can still complete it.
+func (o *Operation) Cancel() {
+	o.cancel()
+	<-o.done
+}
+
+// errChan returns a channel on which the error of the operation is
+// sent, if any, before being closed at the end of the operation, as
+// expected by the *Wait methods.
+func (o *Operation) errChan() <-chan error {
+	ch := make(chan error, 1)
+	go func() {
+		<-o.done
+		if err := o.Err(); err != nil {
+			ch <- err
+		}
+		close(ch)
+	}()
+	return ch
+}
+
+func (o *Operation) notify() {
+	for _, fn := range o.progress {
+		fn(o)
+	}
+}
+
+func (o *Operation) finish(v *Session, state OperationState, err error) {
+	o.mu.Lock()
+	o.state = state
+	o.err = err
+	o.end = o.clock.Now()
+	o.mu.Unlock()
+
+	v.logger().Debug("async operation done",
+		"refresh_id", o.info.RefreshID, "state", state, "duration", o.Elapsed())
+
+	if o.journal != nil && state != OperationCanceled {
+		err := o.journal.Remove(o.info.RefreshID)
+		if err != nil {
+			v.logger().Warn("cannot remove operation from journal",
+				"refresh_id", o.info.RefreshID, "error", err)
+		}
+	}
+
+	o.notify()
+	o.cancel()
+	close(o.done)
+}
+
+// finishDone ends the operation reported as done by the server,
+// after its verification if WithVerify option is enabled.
+func (o *Operation) finishDone(ctx context.Context, v *Session) {
+	if o.verify && o.check != nil {
+		err := o.check(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				o.finish(v, OperationCanceled, ctx.Err())
+			} else {
+				o.finish(v, OperationFailed, err)
+			}
+			return
+		}
+	}
+	o.finish(v, OperationDone, nil)
+}
+
+// requestStatus requests the status of the operation, not past its
+// deadline (if not zero) but during at least the PollPolicy
+// MinInterval, so the status can be checked one last time at the
+// deadline.
+func (o *Operation) requestStatus(ctx context.Context, v *Session,
+	request func(*Session, context.Context, string) (int, error),
+	deadline time.Time) (int, error) {
+	if !deadline.IsZero() {
+		timeout := deadline.Sub(o.clock.Now())
+		if timeout < o.policy.MinInterval {
+			timeout = o.policy.MinInterval
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return request(v, ctx, o.info.RefreshID)
+}
+
+func (o *Operation) run(ctx context.Context, v *Session) {
+	requestStatus := (*Session).RequestWriteStatusContext
+	if o.info.Kind == OperationRefresh {
+		requestStatus = (*Session).RequestRefreshStatusContext
+	}
+
+	policy := &o.policy
+	var deadline time.Time
+	if policy.Timeout > 0 {
+		deadline = o.info.Issued.Add(policy.Timeout)
+	}
+
+	for interval := policy.InitialDelay; ; {
+		pause := policy.jitter(interval)
+		// Do not sleep past the timeout, to check the status one last time
+		if !deadline.IsZero() {
+			if remaining := deadline.Sub(o.clock.Now()); pause > remaining {
+				pause = remaining
+			}
+		}
+		if !o.clock.Sleep(ctx, pause) {
+			o.finish(v, OperationCanceled, ctx.Err())
+			return
+		}
+
+		status, err := o.requestStatus(ctx, v, requestStatus, deadline)
+		if err != nil {
+			switch {
+			case ctx.Err() != nil:
+				o.finish(v, OperationCanceled, ctx.Err())
+			case errors.Is(err, context.DeadlineExceeded):
+				o.finish(v, OperationTimedOut, ErrTimeout)
+			default:
+				o.finish(v, OperationFailed, err)
+			}
+			return
+		}
+
+		o.mu.Lock()
+		o.status = status
+		o.mu.Unlock()
+
+		switch state := StatusState(status); {
+		case state == OperationDone:
+			o.finishDone(ctx, v)
+			return
+		case state == OperationFailed:
+			o.finish(v, OperationFailed, fmt.Errorf("Unexpected status %d", status))
+			return
+		case !deadline.IsZero() && !o.clock.Now().Before(deadline):
+			o.finish(v, OperationTimedOut, ErrTimeout)
+			return
+		}
+
+		interval = policy.next(interval)
+
+		if status != 1 && status != 3 {
+			v.logger().Warn("unexpected async status",
+				"refresh_id", o.info.RefreshID, "status", status, "wait", interval)
+		} else {
+			v.logger().Debug("async status",
+				"refresh_id", o.info.RefreshID, "status", status, "wait", interval)
+		}
+		o.notify()
+	}
+}
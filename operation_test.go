@@ -0,0 +1,220 @@
+package vitotrol
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	td "github.com/maxatome/go-testdeep"
+)
+
+// newStatusServer returns a server accepting WriteData and
+// RefreshData requests, then replying to status requests using
+// statuses, the last one being repeated.
+func newStatusServer(statuses ...int) *httptest.Server {
+	var mu sync.Mutex
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action := r.Header.Get("SOAPAction")
+		action = action[strings.LastIndex(action, "/")+1:]
+
+		switch action {
+		case "WriteData", "RefreshData", "WriteTimesheetData":
+			fmt.Fprintln(w, respHeader+intoDeviceResponse(action,
+				`<Ergebnis>0</Ergebnis><AktualisierungsId>42</AktualisierungsId>`)+respFooter)
+		default:
+			mu.Lock()
+			status := statuses[0]
+			if len(statuses) > 1 {
+				statuses = statuses[1:]
+			}
+			mu.Unlock()
+			fmt.Fprintln(w, respHeader+intoDeviceResponse(action,
+				fmt.Sprintf(`<Ergebnis>0</Ergebnis><Status>%d</Status>`, status))+respFooter)
+		}
+	}))
+}
+
+// withTestPollPolicy returns a session option polling the status
+// after initialDelay, then each millisecond until timeout.
+func withTestPollPolicy(initialDelay, timeout time.Duration) SessionOption {
+	return WithPollPolicy(PollPolicy{
+		InitialDelay: initialDelay,
+		MinInterval:  time.Millisecond,
+		Timeout:      timeout,
+	})
+}
+
+func TestStatusState(tt *testing.T) {
+	t := td.NewT(tt)
+
+	for status, state := range map[int]OperationState{
+		0: OperationPending,
+		1: OperationPending,
+		3: OperationPending,
+		4: OperationDone,
+		9: OperationDone,
+		5: OperationFailed,
+		8: OperationFailed,
+	} {
+		t.Cmp(StatusState(status), state, "status %d", status)
+	}
+
+	t.Cmp(OperationPending.String(), "pending")
+	t.Cmp(OperationDone.String(), "done")
+	t.Cmp(OperationFailed.String(), "failed")
+	t.Cmp(OperationTimedOut.String(), "timeout")
+	t.Cmp(OperationCanceled.String(), "canceled")
+	t.Cmp(OperationState(42).String(), "OperationState(42)")
+	t.Cmp(OperationRefresh.String(), "refresh")
+	t.Cmp(OperationWrite.String(), "write")
+	t.Cmp(OperationWriteTimesheet.String(), "timesheet")
+}
+
+func TestOperation(tt *testing.T) {
+	t := td.NewT(tt)
+
+	d := &Device{DeviceID: testDeviceID, LocationID: testLocationID}
+	ctx := context.Background()
+
+	t.Run("done", func(t *td.T) {
+		ts := newStatusServer(1, 3, 4)
+		defer ts.Close()
+		v := NewSession(WithURL(ts.URL), withTestPollPolicy(0, time.Minute))
+
+		var mu sync.Mutex
+		var progress []string
+		op, err := d.WriteDataAsync(ctx, v, 0x1234, "12", WithProgress(func(op *Operation) {
+			mu.Lock()
+			progress = append(progress, fmt.Sprintf("%d:%s", op.Status(), op.State()))
+			mu.Unlock()
+		}))
+		t.FailureIsFatal().CmpNoError(err)
+		t.Cmp(op.RefreshID(), "42")
+		t.Cmp(op.Kind(), OperationWrite)
+
+		t.CmpNoError(op.Wait(ctx))
+		t.Cmp(op.State(), OperationDone)
+		t.Cmp(op.Status(), 4)
+		t.Gt(op.Elapsed(), time.Duration(0))
+		t.Cmp(op.Elapsed(), op.Elapsed(), "frozen once over")
+		t.Cmp(op.Started(), td.Lte(time.Now()))
+
+		mu.Lock()
+		t.Cmp(progress, []string{"1:pending", "3:pending", "4:done"})
+		mu.Unlock()
+	})
+
+	t.Run("failed", func(t *td.T) {
+		ts := newStatusServer(1, 7)
+		defer ts.Close()
+		v := NewSession(WithURL(ts.URL), withTestPollPolicy(0, time.Minute))
+
+		op, err := d.WriteDataAsync(ctx, v, 0x1234, "12")
+		t.FailureIsFatal().CmpNoError(err)
+		t.Cmp(op.Wait(ctx), td.String("Unexpected status 7"))
+		t.Cmp(op.State(), OperationFailed)
+		t.Cmp(op.Status(), 7)
+
+		// Through the *Wait channel
+		ch, err := d.WriteDataWait(v, 0x1234, "12")
+		t.FailureIsFatal().CmpNoError(err)
+		t.Cmp(<-ch, td.String("Unexpected status 7"))
+	})
+
+	t.Run("timeout", func(t *td.T) {
+		ts := newStatusServer(1)
+		defer ts.Close()
+		v := NewSession(WithURL(ts.URL), withTestPollPolicy(time.Hour, 30*time.Millisecond))
+
+		op, err := d.WriteDataAsync(ctx, v, 0x1234, "12")
+		t.FailureIsFatal().CmpNoError(err)
+
+		waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		t.Cmp(op.Wait(waitCtx), ErrTimeout)
+		t.Cmp(op.State(), OperationTimedOut)
+		t.Cmp(op.Status(), 1)
+		t.Cmp(op.Elapsed(), td.Between(30*time.Millisecond, time.Second))
+	})
+
+	t.Run("hung status request", func(t *td.T) {
+		hung := make(chan struct{})
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			action := r.Header.Get("SOAPAction")
+			action = action[strings.LastIndex(action, "/")+1:]
+			if action == "RequestWriteStatus" {
+				<-hung
+				return
+			}
+			fmt.Fprintln(w, respHeader+intoDeviceResponse(action,
+				`<Ergebnis>0</Ergebnis><AktualisierungsId>42</AktualisierungsId>`)+respFooter)
+		}))
+		defer ts.Close()
+		defer close(hung)
+		v := NewSession(WithURL(ts.URL), withTestPollPolicy(0, 30*time.Millisecond))
+
+		op, err := d.WriteDataAsync(ctx, v, 0x1234, "12")
+		t.FailureIsFatal().CmpNoError(err)
+
+		waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		t.Cmp(op.Wait(waitCtx), ErrTimeout)
+		t.Cmp(op.State(), OperationTimedOut)
+		t.Cmp(op.Elapsed(), td.Between(30*time.Millisecond, time.Second))
+	})
+
+	t.Run("cancel", func(t *td.T) {
+		ts := newStatusServer(1)
+		defer ts.Close()
+		v := NewSession(WithURL(ts.URL), withTestPollPolicy(time.Hour, time.Hour))
+
+		op, err := d.WriteDataAsync(ctx, v, 0x1234, "12")
+		t.FailureIsFatal().CmpNoError(err)
+
+		// Wait does not cancel the operation
+		waitCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		t.Cmp(op.Wait(waitCtx), context.DeadlineExceeded)
+		t.Cmp(op.State(), OperationPending)
+		t.CmpNoError(op.Err())
+
+		op.Cancel()
+		t.Cmp(op.State(), OperationCanceled)
+		t.Cmp(op.Err(), context.Canceled)
+		select {
+		case <-op.Done():
+		default:
+			t.Error("operation not over")
+		}
+	})
+
+	t.Run("refresh", func(t *td.T) {
+		ts := newStatusServer(9)
+		defer ts.Close()
+		v := NewSession(WithURL(ts.URL), withTestPollPolicy(0, time.Minute))
+
+		op, err := d.RefreshDataAsync(ctx, v, []AttrID{AussenTemp})
+		t.FailureIsFatal().CmpNoError(err)
+		t.Cmp(op.Kind(), OperationRefresh)
+		t.CmpNoError(op.Wait(ctx))
+		t.Cmp(op.State(), OperationDone)
+	})
+
+	t.Run("timesheet", func(t *td.T) {
+		ts := newStatusServer(4)
+		defer ts.Close()
+		v := NewSession(WithURL(ts.URL),
+			WithPollPolicy(PollPolicy{MinInterval: time.Millisecond}, OperationWriteTimesheet))
+
+		op, err := d.WriteTimesheetDataAsync(ctx, v, 0x1234, map[string]TimeslotSlice{})
+		t.FailureIsFatal().CmpNoError(err)
+		t.Cmp(op.Kind(), OperationWriteTimesheet)
+		t.CmpNoError(op.Wait(ctx))
+		t.Cmp(op.State(), OperationDone)
+	})
+}
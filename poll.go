@@ -0,0 +1,173 @@
+package vitotrol
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Clock gives the current time and pauses the polling of
+// asynchronous operations (see PollPolicy.Clock). It allows to test
+// the polling without real sleeps.
+type Clock interface {
+	Now() time.Time
+	// Sleep pauses the current goroutine for duration d. It returns
+	// false if ctx is done before the end of the pause.
+	Sleep(ctx context.Context, d time.Duration) bool
+}
+
+// SystemClock is the Clock using the system time.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Sleep(ctx context.Context, d time.Duration) bool {
+	return sleepContext(ctx, d)
+}
+
+// PollPolicy defines how the status of an asynchronous operation
+// (RefreshData, WriteData or WriteTimesheetData) is polled until its
+// completion. See WithPollPolicy and WithOperationPollPolicy options.
+type PollPolicy struct {
+	// InitialDelay is the pause between the issue of the operation and
+	// the first status request.
+	InitialDelay time.Duration
+	// Backoff returns the pause following pause prev. nil means the
+	// status is requested each MinInterval.
+	Backoff func(prev time.Duration) time.Duration
+	// MinInterval and MaxInterval bound the pauses returned by
+	// Backoff. 0 MaxInterval means no upper bound.
+	MinInterval, MaxInterval time.Duration
+	// Jitter randomly shortens or lengthens each pause by up to this
+	// fraction of it, 0.1 meaning ±10%. 0 means no jitter.
+	Jitter float64
+	// Timeout is the max duration of the operation since its issue,
+	// after which it times out with ErrTimeout. Each status request
+	// is also bounded by it, but lasts at least MinInterval, so the
+	// status is checked one last time at the deadline. 0 means no
+	// timeout.
+	Timeout time.Duration
+	// Clock gives the current time and pauses the polling. nil means
+	// SystemClock.
+	Clock Clock
+}
+
+// DefaultRefreshPollPolicy is the PollPolicy of refresh operations
+// (RefreshData) of sessions not created with WithPollPolicy option.
+var DefaultRefreshPollPolicy = PollPolicy{
+	// Waiting availability of data, yes *8* seconds the first time :(
+	InitialDelay: 8 * time.Second,
+	Backoff:      ScaledBackoff(0.25),
+	MinInterval:  time.Second,
+	Timeout:      60 * time.Second,
+}
+
+// DefaultWritePollPolicy is the PollPolicy of write operations
+// (WriteData) of sessions not created with WithPollPolicy option.
+var DefaultWritePollPolicy = PollPolicy{
+	InitialDelay: 4 * time.Second,
+	Backoff:      ScaledBackoff(0.25),
+	MinInterval:  time.Second,
+	Timeout:      60 * time.Second,
+}
+
+// DefaultWriteTimesheetPollPolicy is the PollPolicy of timesheet
+// write operations (WriteTimesheetData) of sessions not created with
+// WithPollPolicy option.
+var DefaultWriteTimesheetPollPolicy = PollPolicy{
+	InitialDelay: 8 * time.Second,
+	Backoff:      ScaledBackoff(0.25),
+	MinInterval:  time.Second,
+	Timeout:      60 * time.Second,
+}
+
+// ScaledBackoff returns a PollPolicy.Backoff function multiplying the
+// previous pause by factor. A factor lower than 1, as 0.25 used by
+// the default policies, polls more and more often, a factor greater
+// than 1 less and less often.
+func ScaledBackoff(factor float64) func(prev time.Duration) time.Duration {
+	return func(prev time.Duration) time.Duration {
+		return time.Duration(float64(prev) * factor)
+	}
+}
+
+// WithPollPolicy sets the PollPolicy of the session operations of
+// kinds, or of all its operations if no kind is given, instead of
+// DefaultRefreshPollPolicy, DefaultWritePollPolicy and
+// DefaultWriteTimesheetPollPolicy.
+func WithPollPolicy(policy PollPolicy, kinds ...OperationKind) SessionOption {
+	return func(c *sessionConfig) {
+		if len(kinds) == 0 {
+			kinds = []OperationKind{OperationRefresh, OperationWrite, OperationWriteTimesheet}
+		}
+		if c.pollPolicies == nil {
+			c.pollPolicies = map[OperationKind]*PollPolicy{}
+		}
+		for _, kind := range kinds {
+			c.pollPolicies[kind] = &policy
+		}
+	}
+}
+
+// PollPolicy returns the PollPolicy used by the session to follow
+// operations of kind.
+func (v *Session) PollPolicy(kind OperationKind) PollPolicy {
+	if policy := v.pollPolicies[kind]; policy != nil {
+		return *policy
+	}
+	switch kind {
+	case OperationRefresh:
+		return DefaultRefreshPollPolicy
+	case OperationWriteTimesheet:
+		return DefaultWriteTimesheetPollPolicy
+	}
+	return DefaultWritePollPolicy
+}
+
+func (p *PollPolicy) clock() Clock {
+	if p.Clock == nil {
+		return SystemClock
+	}
+	return p.Clock
+}
+
+// next returns the pause following pause prev, without jitter.
+func (p *PollPolicy) next(prev time.Duration) time.Duration {
+	next := p.MinInterval
+	if p.Backoff != nil {
+		next = p.Backoff(prev)
+	}
+	if next < p.MinInterval {
+		next = p.MinInterval
+	}
+	if p.MaxInterval > 0 && next > p.MaxInterval {
+		next = p.MaxInterval
+	}
+	return next
+}
+
+// jitter returns pause randomly modified according to p.Jitter.
+func (p *PollPolicy) jitter(pause time.Duration) time.Duration {
+	if p.Jitter <= 0 {
+		return pause
+	}
+	return pause + time.Duration((2*rand.Float64()-1)*p.Jitter*float64(pause))
+}
+
+// sleepContext pauses the current goroutine for at least duration
+// d. It returns false if ctx is done before the end of the pause.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,161 @@
+package vitotrol
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	td "github.com/maxatome/go-testdeep"
+)
+
+// fakeClock is a Clock whose pauses only advance its current time.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.sleeps = append(c.sleeps, d)
+	return true
+}
+
+func (c *fakeClock) Sleeps() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sleeps
+}
+
+func TestPollPolicy(tt *testing.T) {
+	t := td.NewT(tt)
+
+	// Defaults
+	for _, v := range []*Session{{}, NewSession()} {
+		t.Cmp(v.PollPolicy(OperationRefresh).InitialDelay, 8*time.Second)
+		t.Cmp(v.PollPolicy(OperationWrite).InitialDelay, 4*time.Second)
+		t.Cmp(v.PollPolicy(OperationWriteTimesheet).InitialDelay, 8*time.Second)
+	}
+
+	v := NewSession(WithPollPolicy(PollPolicy{InitialDelay: time.Minute}))
+	t.Cmp(v.PollPolicy(OperationRefresh).InitialDelay, time.Minute)
+	t.Cmp(v.PollPolicy(OperationWrite).InitialDelay, time.Minute)
+	t.Cmp(v.PollPolicy(OperationWriteTimesheet).InitialDelay, time.Minute)
+
+	v = NewSession(WithPollPolicy(PollPolicy{InitialDelay: time.Minute}, OperationWrite))
+	t.Cmp(v.PollPolicy(OperationRefresh).InitialDelay, 8*time.Second)
+	t.Cmp(v.PollPolicy(OperationWrite).InitialDelay, time.Minute)
+	t.Cmp(v.PollPolicy(OperationWriteTimesheet).InitialDelay, 8*time.Second)
+
+	t.Cmp(ScaledBackoff(0.25)(4*time.Second), time.Second)
+	t.Cmp(ScaledBackoff(2)(4*time.Second), 8*time.Second)
+
+	p := PollPolicy{MinInterval: time.Second}
+	t.Cmp(p.next(time.Hour), time.Second, "no backoff")
+
+	p = PollPolicy{
+		Backoff:     ScaledBackoff(2),
+		MinInterval: time.Second,
+		MaxInterval: 5 * time.Second,
+	}
+	t.Cmp(p.next(0), time.Second)
+	t.Cmp(p.next(2*time.Second), 4*time.Second)
+	t.Cmp(p.next(4*time.Second), 5*time.Second)
+
+	t.Cmp(p.jitter(time.Second), time.Second, "no jitter")
+	p.Jitter = 0.1
+	for i := 0; i < 100; i++ {
+		t.Cmp(p.jitter(time.Second), td.Between(900*time.Millisecond, 1100*time.Millisecond))
+	}
+}
+
+func TestOperationPollPolicy(tt *testing.T) {
+	t := td.NewT(tt)
+
+	d := &Device{DeviceID: testDeviceID, LocationID: testLocationID}
+	ctx := context.Background()
+	start := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	t.Run("default backoff", func(t *td.T) {
+		ts := newStatusServer(1, 1, 1, 4)
+		defer ts.Close()
+
+		clock := &fakeClock{now: start}
+		policy := DefaultWritePollPolicy
+		policy.Clock = clock
+		v := NewSession(WithURL(ts.URL), WithPollPolicy(policy))
+
+		op, err := d.WriteDataAsync(ctx, v, 0x1234, "12")
+		t.FailureIsFatal().CmpNoError(err)
+		t.CmpNoError(op.Wait(ctx))
+		t.Cmp(op.Started(), start)
+		t.Cmp(op.Elapsed(), 7*time.Second)
+		t.Cmp(clock.Sleeps(), []time.Duration{
+			4 * time.Second, time.Second, time.Second, time.Second,
+		})
+	})
+
+	t.Run("per call", func(t *td.T) {
+		ts := newStatusServer(1, 1, 1, 1, 4)
+		defer ts.Close()
+		v := NewSession(WithURL(ts.URL), withTestPollPolicy(time.Hour, time.Hour))
+
+		clock := &fakeClock{now: start}
+		op, err := d.RefreshDataAsync(ctx, v, []AttrID{AussenTemp},
+			WithOperationPollPolicy(PollPolicy{
+				InitialDelay: time.Second,
+				Backoff:      ScaledBackoff(2),
+				MinInterval:  time.Second,
+				MaxInterval:  5 * time.Second,
+				Clock:        clock,
+			}))
+		t.FailureIsFatal().CmpNoError(err)
+		t.CmpNoError(op.Wait(ctx))
+		t.Cmp(clock.Sleeps(), []time.Duration{
+			time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second,
+		})
+	})
+
+	t.Run("timeout", func(t *td.T) {
+		ts := newStatusServer(1)
+		defer ts.Close()
+
+		clock := &fakeClock{now: start}
+		v := NewSession(WithURL(ts.URL), WithPollPolicy(PollPolicy{
+			InitialDelay: 30 * time.Second,
+			MinInterval:  20 * time.Second,
+			Timeout:      time.Minute,
+			Clock:        clock,
+		}))
+
+		op, err := d.WriteDataAsync(ctx, v, 0x1234, "12")
+		t.FailureIsFatal().CmpNoError(err)
+		t.Cmp(op.Wait(ctx), ErrTimeout)
+		t.Cmp(op.Elapsed(), time.Minute)
+		t.Cmp(clock.Sleeps(), []time.Duration{
+			30 * time.Second, 20 * time.Second, 10 * time.Second,
+		}, "last pause shortened to check the status at the timeout")
+
+		// Resumed: first status request after MinInterval
+		clock.sleeps = nil
+		op = v.ResumeOperation(ctx, OperationInfo{
+			RefreshID: "42",
+			Kind:      OperationWrite,
+			Issued:    clock.Now().Add(-50 * time.Second),
+		})
+		t.Cmp(op.Wait(ctx), ErrTimeout)
+		t.Cmp(clock.Sleeps(), []time.Duration{10 * time.Second})
+	})
+}
@@ -0,0 +1,224 @@
+package vitotrol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// CassetteVersion is the version of the cassette format written by
+// Cassette.Write.
+const CassetteVersion = 1
+
+// Redacted replaces the redacted contents of recorded interactions.
+const Redacted = "REDACTED"
+
+// Interaction is a SOAP request/response exchange recorded in a
+// Cassette.
+type Interaction struct {
+	Action     string `json:"action"`
+	Request    string `json:"request"`
+	StatusCode int    `json:"status_code"`
+	Response   string `json:"response"`
+}
+
+// Cassette is a set of recorded interactions with the Vitotrol™
+// server. See Recorder and Replayer.
+type Cassette struct {
+	Version      int           `json:"version"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// ReadCassette reads a JSON cassette from r.
+func ReadCassette(r io.Reader) (*Cassette, error) {
+	var c Cassette
+	err := json.NewDecoder(r).Decode(&c)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode cassette: %s", err)
+	}
+	if c.Version != CassetteVersion {
+		return nil, fmt.Errorf("unsupported cassette version %d", c.Version)
+	}
+	return &c, nil
+}
+
+// LoadCassette reads a JSON cassette from file.
+func LoadCassette(file string) (*Cassette, error) {
+	fh, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	return ReadCassette(fh)
+}
+
+// Write writes the cassette in JSON format to w.
+func (c *Cassette) Write(w io.Writer) error {
+	if c.Version == 0 {
+		c.Version = CassetteVersion
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(c)
+}
+
+// Save writes the cassette in JSON format to file.
+func (c *Cassette) Save(file string) error {
+	fh, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	err = c.Write(fh)
+	if errClose := fh.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+// redactedTags lists the XML elements whose contents never appear
+// in a cassette: credentials and user identity.
+var redactedTags = regexp.MustCompile(
+	`(<(Benutzer|Passwort|Vorname|Nachname)>)[^<]*(</(Benutzer|Passwort|Vorname|Nachname)>)`)
+
+// Redact returns body with the contents of credential and user
+// identity elements replaced by Redacted.
+func Redact(body string) string {
+	return redactedTags.ReplaceAllString(body, "${1}"+Redacted+"${3}")
+}
+
+func soapActionName(req *http.Request) string {
+	action := req.Header.Get("SOAPAction")
+	return action[strings.LastIndex(action, "/")+1:]
+}
+
+// Recorder is an http.RoundTripper recording each exchange done
+// through it, credentials and cookies excluded. Use it with the
+// WithTransport option, then save its Cassette.
+type Recorder struct {
+	next http.RoundTripper
+
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// NewRecorder returns a new Recorder forwarding requests to next. If
+// next is nil, http.DefaultTransport is used.
+func NewRecorder(next http.RoundTripper) *Recorder {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Recorder{
+		next:     next,
+		cassette: Cassette{Version: CassetteVersion},
+	}
+}
+
+// RoundTrip implements http.RoundTripper interface.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		reqBody, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+		Action:     soapActionName(req),
+		Request:    Redact(string(reqBody)),
+		StatusCode: resp.StatusCode,
+		Response:   Redact(string(respBody)),
+	})
+	r.mu.Unlock()
+
+	return resp, nil
+}
+
+// Cassette returns a copy of the interactions recorded so far.
+func (r *Recorder) Cassette() *Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c := r.cassette
+	c.Interactions = append([]Interaction(nil), r.cassette.Interactions...)
+	return &c
+}
+
+// Replayer is an http.RoundTripper serving the interactions of a
+// Cassette without any network access. For each SOAP action, the
+// recorded responses are served in the order they were recorded,
+// whatever the request contents.
+type Replayer struct {
+	mu           sync.Mutex
+	interactions map[string][]Interaction
+}
+
+// NewReplayer returns a new Replayer serving c interactions.
+func NewReplayer(c *Cassette) *Replayer {
+	r := &Replayer{interactions: map[string][]Interaction{}}
+	for _, inter := range c.Interactions {
+		r.interactions[inter.Action] = append(r.interactions[inter.Action], inter)
+	}
+	return r
+}
+
+// WithReplay makes the session replay the interactions of c instead
+// of talking to the Vitotrol™ server. See Replayer.
+func WithReplay(c *Cassette) SessionOption {
+	return WithTransport(NewReplayer(c))
+}
+
+// RoundTrip implements http.RoundTripper interface.
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	action := soapActionName(req)
+
+	r.mu.Lock()
+	queue := r.interactions[action]
+	if len(queue) == 0 {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("no more recorded %s interaction to replay", action)
+	}
+	inter := queue[0]
+	r.interactions[action] = queue[1:]
+	r.mu.Unlock()
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", inter.StatusCode, http.StatusText(inter.StatusCode)),
+		StatusCode:    inter.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"text/xml; charset=utf-8"}},
+		Body:          ioutil.NopCloser(strings.NewReader(inter.Response)),
+		ContentLength: int64(len(inter.Response)),
+		Request:       req,
+	}, nil
+}
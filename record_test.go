@@ -0,0 +1,76 @@
+package vitotrol
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	td "github.com/maxatome/go-testdeep"
+)
+
+func TestRedact(tt *testing.T) {
+	t := td.NewT(tt)
+
+	t.CmpDeeply(
+		Redact(`<Login><Passwort>s3cr&amp;t</Passwort><Benutzer>pipo</Benutzer></Login>`),
+		`<Login><Passwort>REDACTED</Passwort><Benutzer>REDACTED</Benutzer></Login>`)
+	t.CmpDeeply(
+		Redact(`<Vorname>Max</Vorname><Nachname>Mustermann</Nachname><Wert>12</Wert>`),
+		`<Vorname>REDACTED</Vorname><Nachname>REDACTED</Nachname><Wert>12</Wert>`)
+}
+
+func TestRecordReplay(tt *testing.T) {
+	t := td.NewT(tt)
+
+	rs := newRetryServer()
+
+	// Record
+	rec := NewRecorder(nil)
+	v := NewSession(WithURL(rs.URL), WithTransport(rec))
+	d := &Device{DeviceID: testDeviceID, LocationID: testLocationID}
+
+	require := t.FailureIsFatal()
+	require.CmpNoError(v.Login("pipo", "bingo"))
+	require.CmpNoError(d.GetData(v, []AttrID{AussenTemp}))
+	rs.Close()
+
+	cassette := rec.Cassette()
+	t.CmpDeeply(cassette.Version, CassetteVersion)
+	t.CmpDeeply(cassette.Interactions, td.Slice([]Interaction{}, td.ArrayEntries{
+		0: td.Struct(Interaction{Action: "Login", StatusCode: 200}, td.StructFields{
+			"Request": td.All(
+				td.Contains("<Passwort>REDACTED</Passwort>"),
+				td.Contains("<Benutzer>REDACTED</Benutzer>"),
+				td.Not(td.Contains("pipo")),
+				td.Not(td.Contains("bingo"))),
+			"Response": td.Contains("<Ergebnis>0</Ergebnis>"),
+		}),
+		1: td.Struct(Interaction{Action: "GetData", StatusCode: 200}, td.StructFields{
+			"Request":  td.Contains("<int>5373</int>"),
+			"Response": td.Contains("<Wert>12.5</Wert>"),
+		}),
+	}))
+
+	// Save & load
+	file := filepath.Join(tt.TempDir(), "cassette.json")
+	require.CmpNoError(cassette.Save(file))
+	loaded, err := LoadCassette(file)
+	require.CmpNoError(err)
+	t.CmpDeeply(loaded, cassette)
+
+	_, err = ReadCassette(bytes.NewBufferString(`{"version":42}`))
+	t.CmpDeeply(err, td.String("unsupported cassette version 42"))
+
+	// Replay, the server is closed
+	v = NewSession(WithReplay(loaded), WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+	d = &Device{DeviceID: testDeviceID, LocationID: testLocationID}
+	t.CmpNoError(v.Login("other", "password"))
+	t.CmpNoError(d.GetData(v, []AttrID{AussenTemp}))
+	t.CmpDeeply(d.AttributesSnapshot(),
+		map[AttrID]Value{AussenTemp: {Value: "12.5", Time: testTime}})
+
+	// Exhausted
+	t.CmpDeeply(d.GetData(v, []AttrID{AussenTemp}),
+		td.Smuggle(func(err error) string { return err.Error() },
+			td.Contains("no more recorded GetData interaction to replay")))
+}
@@ -0,0 +1,224 @@
+package vitotrol
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// AttributeRegistry maps attribute IDs and names to their reference
+// for a device. It starts as a copy of the built-in attributes (see
+// AttributesRef) and can be completed with the attributes discovered
+// on the server using GetTypeInfo (see Merge and
+// Device.LoadRegistry), so each device can have its own set of
+// attributes without altering the package globals.
+//
+// It can be safely used by several goroutines.
+type AttributeRegistry struct {
+	mu    sync.RWMutex
+	refs  map[AttrID]*AttrRef
+	names map[string]AttrID
+}
+
+// NewAttributeRegistry returns a new registry containing a copy of
+// all built-in attributes of AttributesRef, including the ones added
+// by AddAttributeRef before this call.
+func NewAttributeRegistry() *AttributeRegistry {
+	r := &AttributeRegistry{
+		refs:  make(map[AttrID]*AttrRef, len(AttributesRef)),
+		names: make(map[string]AttrID, len(AttributesRef)),
+	}
+	for attrID, pRef := range AttributesRef {
+		ref := *pRef
+		r.refs[attrID] = &ref
+		r.names[ref.Name] = attrID
+	}
+	return r
+}
+
+// Ref returns the reference of attribute attrID, or nil if it is
+// unknown. The returned AttrRef must not be modified.
+func (r *AttributeRegistry) Ref(attrID AttrID) *AttrRef {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.refs[attrID]
+}
+
+// ID returns the ID of the attribute named name, and false if it is
+// unknown.
+func (r *AttributeRegistry) ID(name string) (AttrID, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	attrID, ok := r.names[name]
+	return attrID, ok
+}
+
+// IDs returns the sorted IDs of all known attributes.
+func (r *AttributeRegistry) IDs() []AttrID {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids := make([]AttrID, 0, len(r.refs))
+	for attrID := range r.refs {
+		ids = append(ids, attrID)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Len returns the number of known attributes.
+func (r *AttributeRegistry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.refs)
+}
+
+// Add adds or replaces attribute attrID.
+func (r *AttributeRegistry) Add(attrID AttrID, ref AttrRef) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.add(attrID, &ref)
+}
+
+func (r *AttributeRegistry) add(attrID AttrID, pRef *AttrRef) {
+	if old := r.refs[attrID]; old != nil && r.names[old.Name] == attrID {
+		delete(r.names, old.Name)
+	}
+	r.refs[attrID] = pRef
+	r.names[pRef.Name] = attrID
+}
+
+// Merge merges attributes infos returned by Device.GetTypeInfo into
+// the registry.
+//
+// For already known attributes, name, type and documentation are
+// kept, but access rights, bounds, default value, heating circuit
+// and data point group are taken from infos, as well as the unit if
+// it is set (only in catalogue files). Numeric bounds also replace
+// the Min and Max constraints, unless they are equal (as returned by
+// the server for unbounded attributes).
+//
+// Unknown attributes are added, named "DatenpunktName-0xID" to avoid
+// collisions, using the type of infos. ENUM attributes get their
+// values from infos. Infos of unsupported types are ignored and
+// returned.
+func (r *AttributeRegistry) Merge(infos []*AttributeInfo) []*AttributeInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var ignored []*AttributeInfo
+	for _, pInfo := range infos {
+		ref, ok := r.mergedRef(pInfo)
+		if !ok {
+			ignored = append(ignored, pInfo)
+			continue
+		}
+		r.add(pInfo.AttributeID, ref)
+	}
+	return ignored
+}
+
+func (r *AttributeRegistry) mergedRef(pInfo *AttributeInfo) (*AttrRef, bool) {
+	var ref AttrRef
+	if old := r.refs[pInfo.AttributeID]; old != nil {
+		ref = *old
+	} else {
+		pType := TypeNames[pInfo.AttributeType]
+		if pType == nil {
+			if pInfo.AttributeType != "ENUM" {
+				return nil, false
+			}
+
+			var maxIdx uint32
+			for idx := range pInfo.EnumValues {
+				if idx > maxIdx {
+					maxIdx = idx
+				}
+			}
+			enumValues := make([]string, maxIdx+1)
+			for idx, value := range pInfo.EnumValues {
+				enumValues[idx] = value
+			}
+			pType = NewEnum(enumValues)
+		}
+
+		ref = AttrRef{
+			Type: pType,
+			Name: fmt.Sprintf("%s-0x%04x", pInfo.AttributeName, pInfo.AttributeID),
+			Doc:  pInfo.AttributeName,
+		}
+	}
+
+	var access AttrAccess
+	if pInfo.Readable {
+		access = ReadOnly
+	}
+	if pInfo.Writable {
+		access |= WriteOnly
+	}
+	if access != 0 {
+		ref.Access = access
+	}
+
+	if pInfo.AttributeType != "ENUM" {
+		ref.MinValue = pInfo.MinValue
+		ref.MaxValue = pInfo.MaxValue
+
+		low, errLow := strconv.ParseFloat(pInfo.MinValue, 64)
+		high, errHigh := strconv.ParseFloat(pInfo.MaxValue, 64)
+		if errLow == nil && errHigh == nil && low < high {
+			ref.Min, ref.Max = &low, &high
+		}
+	}
+	if pInfo.Unit != NoUnit {
+		ref.Unit = pInfo.Unit
+	}
+	ref.DefaultValue = pInfo.DefaultValue
+	ref.HeatingCircuitID = pInfo.HeatingCircuitID
+	ref.DataPointGroup = pInfo.DataPointGroup
+	return &ref, true
+}
+
+// Registry returns the attribute registry of d. Unless SetRegistry
+// or LoadRegistry has been called before, a registry containing the
+// built-in attributes is created on the first call.
+func (d *Device) Registry() *AttributeRegistry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.registry == nil {
+		d.registry = NewAttributeRegistry()
+	}
+	return d.registry
+}
+
+// SetRegistry sets the attribute registry of d.
+func (d *Device) SetRegistry(r *AttributeRegistry) {
+	d.mu.Lock()
+	d.registry = r
+	d.mu.Unlock()
+}
+
+// LoadRegistry builds the attribute registry of d by merging the
+// built-in attributes with the ones returned by GetTypeInfo, then
+// sets it as the registry of d. It returns the infos ignored because
+// of their unsupported type (see AttributeRegistry.Merge).
+func (d *Device) LoadRegistry(v *Session) ([]*AttributeInfo, error) {
+	return d.LoadRegistryContext(context.Background(), v)
+}
+
+// LoadRegistryContext is the same as LoadRegistry but honours ctx
+// cancellation and deadline.
+func (d *Device) LoadRegistryContext(ctx context.Context, v *Session) ([]*AttributeInfo, error) {
+	infos, err := d.GetTypeInfoContext(ctx, v)
+	if err != nil {
+		return nil, err
+	}
+
+	r := NewAttributeRegistry()
+	ignored := r.Merge(infos)
+	d.SetRegistry(r)
+	return ignored, nil
+}
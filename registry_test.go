@@ -0,0 +1,185 @@
+package vitotrol
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	td "github.com/maxatome/go-testdeep"
+)
+
+func TestAttributeRegistry(tt *testing.T) {
+	t := td.NewT(tt)
+
+	r := NewAttributeRegistry()
+	t.Cmp(r.Len(), len(AttributesRef))
+	t.Cmp(r.Ref(AussenTemp), AttributesRef[AussenTemp])
+	t.Cmp(r.Ref(AussenTemp), td.Not(td.Shallow(AttributesRef[AussenTemp])))
+	t.Nil(r.Ref(0x1234))
+
+	attrID, ok := r.ID("BrennerStatus")
+	t.True(ok)
+	t.Cmp(attrID, BrennerStatus)
+	_, ok = r.ID("Foo")
+	t.False(ok)
+
+	ids := r.IDs()
+	t.Len(ids, len(AttributesRef))
+	t.Cmp(ids, td.Smuggle(func(ids []AttrID) bool {
+		for i := 1; i < len(ids); i++ {
+			if ids[i-1] >= ids[i] {
+				return false
+			}
+		}
+		return true
+	}, true))
+
+	// Add does not alter the globals
+	r.Add(0x1234, AttrRef{Type: TypeString, Access: ReadOnly, Name: "Foo"})
+	t.Cmp(r.Ref(0x1234).Name, "Foo")
+	t.Nil(AttributesRef[0x1234])
+	_, ok = AttributesNames2IDs["Foo"]
+	t.False(ok)
+
+	// Renaming an attribute frees its old name
+	r.Add(0x1234, AttrRef{Type: TypeString, Access: ReadOnly, Name: "Bar"})
+	_, ok = r.ID("Foo")
+	t.False(ok)
+	attrID, ok = r.ID("Bar")
+	t.True(ok)
+	t.Cmp(attrID, AttrID(0x1234))
+
+	ignored := r.Merge([]*AttributeInfo{
+		{ // known attribute
+			AttributeInfoBase: AttributeInfoBase{
+				AttributeName:    "konf_ww_solltemp_rw",
+				AttributeType:    "Integer",
+				MinValue:         "10",
+				MaxValue:         "60",
+				DefaultValue:     "50",
+				DataPointGroup:   "HC1",
+				HeatingCircuitID: 19179,
+				Readable:         true,
+			},
+			AttributeID: HeisswasserSollTemp,
+		},
+		{ // unknown attribute
+			AttributeInfoBase: AttributeInfoBase{
+				AttributeName:    "temp_foo_r",
+				AttributeType:    "Double",
+				MinValue:         "-20",
+				MaxValue:         "40",
+				DataPointGroup:   "HC2",
+				HeatingCircuitID: 19180,
+				Readable:         true,
+			},
+			AttributeID: 0x2345,
+		},
+		{ // unknown enum attribute
+			AttributeInfoBase: AttributeInfoBase{
+				AttributeName: "zustand_foo_r",
+				AttributeType: "ENUM",
+				Readable:      true,
+				Writable:      true,
+			},
+			AttributeID: 0x3456,
+			EnumValues:  map[uint32]string{0: "Aus", 2: "Ein"},
+		},
+		{ // unsupported type
+			AttributeInfoBase: AttributeInfoBase{
+				AttributeName: "schaltzeiten",
+				AttributeType: "CircuitTime",
+			},
+			AttributeID: 0x4567,
+		},
+	})
+	if t.Len(ignored, 1) {
+		t.Cmp(ignored[0].AttributeID, AttrID(0x4567))
+	}
+	t.Nil(r.Ref(0x4567))
+
+	t.Cmp(r.Ref(HeisswasserSollTemp), &AttrRef{
+		Type:             TypeDouble,
+		Access:           ReadOnly, // as told by the server
+		Name:             "HeisswasserSollTemp",
+		Doc:              AttributesRef[HeisswasserSollTemp].Doc,
+		Unit:             UnitCelsius,
+		MinValue:         "10",
+		MaxValue:         "60",
+		DefaultValue:     "50",
+		HeatingCircuitID: 19179,
+		DataPointGroup:   "HC1",
+		Min:              limit(10),
+		Max:              limit(60),
+	})
+	t.Cmp(AttributesRef[HeisswasserSollTemp].Access, ReadWrite)
+	t.Cmp(AttributesRef[HeisswasserSollTemp].MaxValue, "")
+
+	t.Cmp(r.Ref(0x2345), &AttrRef{
+		Type:             TypeDouble,
+		Access:           ReadOnly,
+		Name:             "temp_foo_r-0x2345",
+		Doc:              "temp_foo_r",
+		MinValue:         "-20",
+		MaxValue:         "40",
+		HeatingCircuitID: 19180,
+		DataPointGroup:   "HC2",
+		Min:              limit(-20),
+		Max:              limit(40),
+	})
+	attrID, ok = r.ID("temp_foo_r-0x2345")
+	t.True(ok)
+	t.Cmp(attrID, AttrID(0x2345))
+
+	enum := r.Ref(0x3456)
+	if t.NotNil(enum) {
+		t.Cmp(enum.Access, ReadWrite)
+		t.Cmp(enum.Type.Type(), "Enum3")
+		human, err := enum.Type.Vitodata2HumanValue("2")
+		t.CmpNoError(err)
+		t.Cmp(human, "Ein")
+	}
+}
+
+func TestDeviceRegistry(tt *testing.T) {
+	t := td.NewT(tt)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, respHeader+intoDeviceResponse("GetTypeInfo", `<Ergebnis>0</Ergebnis>
+<TypeInfoListe>
+  <DatenpunktTypInfo>
+    <DatenpunktId>9000</DatenpunktId>
+    <DatenpunktName>temp_foo_r</DatenpunktName>
+    <DatenpunktTyp>Double</DatenpunktTyp>
+    <IstLesbar>true</IstLesbar>
+    <IstSchreibbar>false</IstSchreibbar>
+  </DatenpunktTypInfo>
+</TypeInfoListe>`)+respFooter)
+	}))
+	defer ts.Close()
+
+	v := NewSession(WithURL(ts.URL))
+	d := &Device{DeviceID: testDeviceID, LocationID: testLocationID}
+
+	// Built-in registry by default
+	r := d.Registry()
+	t.Cmp(r.Len(), len(AttributesRef))
+	t.Shallow(d.Registry(), r)
+
+	ignored, err := d.LoadRegistry(v)
+	t.CmpNoError(err)
+	t.Nil(ignored)
+	t.Not(d.Registry(), td.Shallow(r))
+	t.Cmp(d.Registry().Len(), len(AttributesRef)+1)
+	t.Cmp(d.Registry().Ref(9000).Name, "temp_foo_r-0x2328")
+
+	// Used by FormatAttributes
+	d.Attributes = map[AttrID]*Value{9000: {Value: "12.5", Time: testTime}}
+	t.Cmp(d.FormatAttributes([]AttrID{9000}),
+		"temp_foo_r-0x2328: 12.5@2016-10-30 12:13:14 (temp_foo_r)\n")
+
+	other := NewAttributeRegistry()
+	d.SetRegistry(other)
+	t.Shallow(d.Registry(), other)
+}
@@ -1,14 +1,57 @@
 package vitotrol
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
 )
 
+// ResultHeader error numbers assumed by this package. Only ResultOK
+// is confirmed by real server responses: the others are guesses, and
+// the vitotroltest simulator relies on the same ones, so tests cannot
+// catch a wrong number. If the server returns other numbers, use
+// WithResultErrors to supply the right mapping.
+const (
+	ResultOK                 = 0  // Kein Fehler
+	ResultAuthFailed         = 1  // bad login and/or password
+	ResultSessionExpired     = 2  // session not (or no more) authenticated
+	ResultDeviceNotConnected = 3  // device not connected to the server
+	ResultUnknownDatapoint   = 4  // unknown attribute/timesheet ID
+	ResultRateLimited        = 5  // too many requests
+	ResultServerBusy         = 99 // temporary server-side failure
+)
+
+// Sentinel errors matching, using errors.Is, a *ResultHeader (see
+// ResultErrors) or a *HTTPError.
+var (
+	ErrAuthFailed         = errors.New("authentication failed")
+	ErrSessionExpired     = errors.New("session expired")
+	ErrDeviceNotConnected = errors.New("device not connected")
+	ErrUnknownDatapoint   = errors.New("unknown datapoint")
+	ErrRateLimited        = errors.New("rate limited")
+	ErrServerUnavailable  = errors.New("server unavailable")
+)
+
+// ResultErrors is the default mapping of the ResultHeader error
+// numbers to the sentinel error they match using errors.Is. As these
+// numbers are assumptions (see ResultOK), a session can use its own
+// mapping thanks to WithResultErrors.
+var ResultErrors = map[int]error{
+	ResultAuthFailed:         ErrAuthFailed,
+	ResultSessionExpired:     ErrSessionExpired,
+	ResultDeviceNotConnected: ErrDeviceNotConnected,
+	ResultUnknownDatapoint:   ErrUnknownDatapoint,
+	ResultRateLimited:        ErrRateLimited,
+	ResultServerBusy:         ErrServerUnavailable,
+}
+
 // ResultHeader included in each Result part of each Vitotrol™ Response
 // message.
 type ResultHeader struct {
 	ErrorNum int    `xml:"Ergebnis"`
 	ErrorStr string `xml:"ErgebnisText"`
+
+	errors map[int]error // nil means ResultErrors
 }
 
 // Error returns the result as a string.
@@ -16,10 +59,45 @@ func (e *ResultHeader) Error() string {
 	return fmt.Sprintf("%s [#%d]", e.ErrorStr, e.ErrorNum)
 }
 
+// Is allows errors.Is to match the sentinel error associated to the
+// result error number in ResultErrors, or in the mapping of the
+// session returning e (see WithResultErrors).
+func (e *ResultHeader) Is(target error) bool {
+	errs := e.errors
+	if errs == nil {
+		errs = ResultErrors
+	}
+	sentinel := errs[e.ErrorNum]
+	return sentinel != nil && sentinel == target
+}
+
 // IsError allows to know if this result is an error or not from the
 // Vitotrol™ point of view.
 func (e *ResultHeader) IsError() bool {
-	return e.ErrorNum != 0
+	return e.ErrorNum != ResultOK
+}
+
+// IsSessionExpired allows to know if this result means the session
+// has to be authenticated again using Login.
+func (e *ResultHeader) IsSessionExpired() bool {
+	return e.Is(ErrSessionExpired)
+}
+
+// IsTransient allows to know if this result is a temporary failure,
+// so the request can be retried later.
+func (e *ResultHeader) IsTransient() bool {
+	return e.Is(ErrServerUnavailable) || e.Is(ErrRateLimited)
+}
+
+// WithResultErrors sets the mapping of the ResultHeader error numbers
+// to the sentinel errors used for the responses of the session,
+// instead of ResultErrors. It decides which results trigger a
+// re-authentication (ErrSessionExpired) or a retry
+// (ErrServerUnavailable and ErrRateLimited).
+func WithResultErrors(errs map[int]error) SessionOption {
+	return func(c *sessionConfig) {
+		c.resultErrors = errs
+	}
 }
 
 // HasResultHeader is the interface for abstrating Result part of each
@@ -27,3 +105,41 @@ func (e *ResultHeader) IsError() bool {
 type HasResultHeader interface {
 	ResultHeader() *ResultHeader
 }
+
+// HTTPError is returned when the Vitotrol™ server replies with a
+// non-200 HTTP status.
+type HTTPError struct {
+	StatusCode int
+	Body       []byte
+}
+
+// Error returns the HTTP error as a string, including the beginning
+// of the response body.
+func (e *HTTPError) Error() string {
+	const maxBody = 128
+
+	body := e.Body
+	ellipsis := ""
+	if len(body) > maxBody {
+		body = body[:maxBody]
+		ellipsis = "..."
+	}
+	return fmt.Sprintf("HTTP error: [status=%d] %q%s", e.StatusCode, body, ellipsis)
+}
+
+// Is allows errors.Is to match ErrRateLimited for a 429 HTTP status,
+// ErrServerUnavailable for 502, 503 & 504 HTTP statuses and
+// ErrAuthFailed for 401 & 403 HTTP statuses.
+func (e *HTTPError) Is(target error) bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests:
+		return target == ErrRateLimited
+	case http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return target == ErrServerUnavailable
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return target == ErrAuthFailed
+	}
+	return false
+}
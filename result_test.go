@@ -1,6 +1,9 @@
 package vitotrol
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
 
 	td "github.com/maxatome/go-testdeep"
@@ -26,3 +29,73 @@ func TestResultHeader(tt *testing.T) {
 	t.CmpDeeply(rh.Error(), "Big error [#42]")
 	t.True(rh.IsError())
 }
+
+func TestResultHeaderKind(tt *testing.T) {
+	t := td.NewT(tt)
+
+	rh := &ResultHeader{ErrorNum: ResultSessionExpired}
+	t.True(rh.IsSessionExpired())
+	t.False(rh.IsTransient())
+
+	rh = &ResultHeader{ErrorNum: ResultServerBusy}
+	t.False(rh.IsSessionExpired())
+	t.True(rh.IsTransient())
+
+	rh = &ResultHeader{ErrorNum: 42}
+	t.False(rh.IsSessionExpired())
+	t.False(rh.IsTransient())
+}
+
+func TestResultHeaderErrors(tt *testing.T) {
+	t := td.NewT(tt)
+
+	for num, sentinel := range ResultErrors {
+		var err error = &ResultHeader{ErrorNum: num, ErrorStr: "Fehler"}
+		err = fmt.Errorf("GetData: %w", err)
+
+		t.True(errors.Is(err, sentinel), "#%d is %s", num, sentinel)
+		for _, other := range ResultErrors {
+			if other != sentinel {
+				t.False(errors.Is(err, other), "#%d is not %s", num, other)
+			}
+		}
+
+		var rh *ResultHeader
+		if t.True(errors.As(err, &rh)) {
+			t.CmpDeeply(rh.ErrorNum, num)
+		}
+	}
+
+	t.False(errors.Is(&ResultHeader{ErrorNum: 42}, ErrAuthFailed))
+	t.False(errors.Is(&ResultHeader{ErrorNum: ResultOK}, ErrAuthFailed))
+}
+
+func TestHTTPError(tt *testing.T) {
+	t := td.NewT(tt)
+
+	err := &HTTPError{StatusCode: 500, Body: []byte("oops")}
+	t.CmpDeeply(err.Error(), `HTTP error: [status=500] "oops"`)
+	t.False(errors.Is(err, ErrServerUnavailable))
+
+	err = &HTTPError{StatusCode: 503, Body: []byte(strings.Repeat("x", 200))}
+	t.CmpDeeply(err.Error(),
+		`HTTP error: [status=503] "`+strings.Repeat("x", 128)+`"...`)
+
+	for status, sentinel := range map[int]error{
+		429: ErrRateLimited,
+		502: ErrServerUnavailable,
+		503: ErrServerUnavailable,
+		504: ErrServerUnavailable,
+		401: ErrAuthFailed,
+		403: ErrAuthFailed,
+	} {
+		var err error = &HTTPError{StatusCode: status}
+		err = fmt.Errorf("Login: %w", err)
+		t.True(errors.Is(err, sentinel), "status %d is %s", status, sentinel)
+
+		var httpErr *HTTPError
+		if t.True(errors.As(err, &httpErr)) {
+			t.CmpDeeply(httpErr.StatusCode, status)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package vitotrol
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"time"
+)
+
+// Credentials returns the login and password used to (re-)authenticate
+// a session. See WithCredentials option.
+type Credentials func(ctx context.Context) (login, password string, err error)
+
+// StaticCredentials returns a Credentials always returning login and
+// password.
+func StaticCredentials(login, password string) Credentials {
+	return func(context.Context) (string, string, error) {
+		return login, password, nil
+	}
+}
+
+// WithCredentials sets the credentials used by the session to
+// transparently re-authenticate when the server reports it as
+// expired. Without this option, the credentials passed to the last
+// successful Login call are used.
+func WithCredentials(creds Credentials) SessionOption {
+	return func(c *sessionConfig) {
+		c.credentials = creds
+	}
+}
+
+// RetryPolicy defines how idempotent requests (GetData, GetDevices,
+// GetTimesheetData and GetTypeInfo) are retried after a transient
+// error or after a re-authentication. Requests modifying the
+// device (WriteData and WriteTimesheetData) are never replayed.
+type RetryPolicy struct {
+	// MaxAttempts is the max number of times an idempotent request is
+	// sent. 0 or 1 means no retry.
+	MaxAttempts int
+	// Backoff returns the pause duration before the attempt following
+	// the attempt-th one (starting at 1). nil means no pause.
+	Backoff func(attempt int) time.Duration
+}
+
+// DefaultRetryPolicy is the RetryPolicy used by sessions not created
+// with the WithRetryPolicy option.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	Backoff:     ExponentialBackoff(time.Second, 30*time.Second),
+}
+
+// ExponentialBackoff returns a RetryPolicy.Backoff function starting
+// at base and doubling at each attempt without exceeding max.
+func ExponentialBackoff(base, max time.Duration) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		wait := base
+		for ; attempt > 1 && wait < max; attempt-- {
+			wait *= 2
+		}
+		if wait > max {
+			wait = max
+		}
+		return wait
+	}
+}
+
+// WithRetryPolicy sets the RetryPolicy of the session instead of
+// DefaultRetryPolicy.
+func WithRetryPolicy(policy RetryPolicy) SessionOption {
+	return func(c *sessionConfig) {
+		c.retryPolicy = &policy
+	}
+}
+
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	if p.Backoff == nil {
+		return 0
+	}
+	return p.Backoff(attempt)
+}
+
+func isSessionExpired(err error) bool {
+	return errors.Is(err, ErrSessionExpired)
+}
+
+func isTransient(err error) bool {
+	if errors.Is(err, ErrServerUnavailable) || errors.Is(err, ErrRateLimited) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// request sends a request using sendRequest. If the server reports
+// the session as expired and credentials are known, the session is
+// authenticated again. Then, only if idempotent is true, the
+// request is sent again, as it is after a transient error, following
+// the session RetryPolicy.
+func (v *Session) request(ctx context.Context, soapAction string, reqBody interface{}, respBody HasResultHeader, idempotent bool) error {
+	policy := v.retryPolicy
+	if policy == nil {
+		policy = &DefaultRetryPolicy
+	}
+
+	for attempt := 1; ; attempt++ {
+		v.mu.RLock()
+		loginGen := v.loginGen
+		v.mu.RUnlock()
+
+		err := v.sendRequest(ctx, soapAction, reqBody, respBody)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+
+		var wait time.Duration
+		switch {
+		case isSessionExpired(err):
+			if v.relogin(ctx, loginGen) != nil {
+				return err
+			}
+		case isTransient(err):
+			wait = policy.backoff(attempt)
+		default:
+			return err
+		}
+
+		if !idempotent || attempt >= policy.MaxAttempts {
+			return err
+		}
+
+		v.logger().Debug("retrying request", "action", soapAction,
+			"attempt", attempt+1, "wait", wait, "error", err)
+		if !sleepContext(ctx, wait) {
+			return ctx.Err()
+		}
+
+		// Reset the response before reusing it
+		resp := reflect.ValueOf(respBody).Elem()
+		resp.Set(reflect.Zero(resp.Type()))
+	}
+}
+
+// relogin authenticates the session again using its credentials,
+// except if another goroutine already did it since loginGen.
+func (v *Session) relogin(ctx context.Context, loginGen uint64) error {
+	v.loginMu.Lock()
+	defer v.loginMu.Unlock()
+
+	v.mu.RLock()
+	creds, curGen := v.credentials, v.loginGen
+	v.mu.RUnlock()
+
+	if curGen != loginGen {
+		return nil // already done
+	}
+	if creds == nil {
+		return errors.New("no credentials to authenticate the session again")
+	}
+
+	login, password, err := creds(ctx)
+	if err != nil {
+		v.logger().Warn("cannot get credentials to re-authenticate", "error", err)
+		return err
+	}
+
+	err = v.login(ctx, login, password)
+	if err != nil {
+		v.logger().Warn("re-authentication failed", "error", err)
+		return err
+	}
+	v.logger().Info("session re-authenticated")
+	return nil
+}
@@ -0,0 +1,202 @@
+package vitotrol
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	td "github.com/maxatome/go-testdeep"
+)
+
+func TestExponentialBackoff(tt *testing.T) {
+	t := td.NewT(tt)
+
+	backoff := ExponentialBackoff(time.Second, 5*time.Second)
+	t.CmpDeeply(backoff(1), time.Second)
+	t.CmpDeeply(backoff(2), 2*time.Second)
+	t.CmpDeeply(backoff(3), 4*time.Second)
+	t.CmpDeeply(backoff(4), 5*time.Second)
+	t.CmpDeeply(backoff(100), 5*time.Second)
+}
+
+// retryServer simulates a Vitotrol™ server whose session expires
+// each time expire is called, and which fails transiently
+// failures times before answering correctly.
+type retryServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	session  int
+	failures int
+	calls    map[string]int
+	logins   []string
+}
+
+func newRetryServer() *retryServer {
+	rs := &retryServer{calls: map[string]int{}}
+	rs.Server = httptest.NewServer(http.HandlerFunc(rs.handle))
+	return rs
+}
+
+func (rs *retryServer) expire() {
+	rs.mu.Lock()
+	rs.session++
+	rs.mu.Unlock()
+}
+
+func (rs *retryServer) handle(w http.ResponseWriter, r *http.Request) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	soapAction := r.Header.Get("SOAPAction")
+	soapAction = soapAction[strings.LastIndex(soapAction, "/")+1:]
+	rs.calls[soapAction]++
+
+	cookie := fmt.Sprintf("session=%d", rs.session)
+
+	var content string
+	switch {
+	case soapAction == "Login":
+		var req struct {
+			Login string `xml:"Body>Login>Benutzer"`
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		_ = xml.Unmarshal(body, &req)
+		rs.logins = append(rs.logins, req.Login)
+
+		w.Header().Add("Set-Cookie", cookie)
+		content = `<Ergebnis>0</Ergebnis>`
+
+	case r.Header.Get("Cookie") != cookie:
+		content = fmt.Sprintf(`<Ergebnis>%d</Ergebnis><ErgebnisText>expired</ErgebnisText>`,
+			ResultSessionExpired)
+
+	case rs.failures > 0:
+		rs.failures--
+		content = fmt.Sprintf(`<Ergebnis>%d</Ergebnis><ErgebnisText>busy</ErgebnisText>`,
+			ResultServerBusy)
+
+	case soapAction == "GetData":
+		content = `<Ergebnis>0</Ergebnis>
+<DatenwerteListe><WerteListe>
+  <DatenpunktId>5373</DatenpunktId>
+  <Wert>12.5</Wert>
+  <Zeitstempel>` + testTimeStr + `</Zeitstempel>
+</WerteListe></DatenwerteListe>`
+
+	default:
+		content = `<Ergebnis>0</Ergebnis><AktualisierungsId>42</AktualisierungsId>`
+	}
+
+	fmt.Fprintln(w, respHeader+intoDeviceResponse(soapAction, content)+respFooter)
+}
+
+func TestSessionRelogin(tt *testing.T) {
+	t := td.NewT(tt)
+
+	rs := newRetryServer()
+	defer rs.Close()
+
+	v := NewSession(WithURL(rs.URL), WithRetryPolicy(RetryPolicy{MaxAttempts: 3}))
+	d := &Device{DeviceID: testDeviceID, LocationID: testLocationID}
+
+	require := t.FailureIsFatal()
+	require.CmpNoError(v.Login("pipo", "bingo"))
+
+	// Idempotent request: re-login then retry
+	rs.expire()
+	require.CmpNoError(d.GetData(v, []AttrID{AussenTemp}))
+	t.CmpDeeply(d.AttributesSnapshot(),
+		map[AttrID]Value{AussenTemp: {Value: "12.5", Time: testTime}})
+	t.CmpDeeply(rs.logins, []string{"pipo", "pipo"})
+	t.CmpDeeply(rs.calls["GetData"], 2)
+
+	// Non idempotent request: re-login but no replay
+	rs.expire()
+	_, err := d.WriteData(v, HeizNormalTempM1, "20")
+	t.CmpDeeply(err, td.Smuggle(isSessionExpired, true))
+	t.CmpDeeply(rs.logins, []string{"pipo", "pipo", "pipo"})
+	t.CmpDeeply(rs.calls["WriteData"], 1)
+
+	// Next one succeeds as the session is authenticated again
+	_, err = d.WriteData(v, HeizNormalTempM1, "20")
+	t.CmpNoError(err)
+	t.CmpDeeply(rs.calls["WriteData"], 2)
+
+	// Transient errors on idempotent request
+	rs.failures = 2
+	t.CmpNoError(d.GetData(v, []AttrID{AussenTemp}))
+	t.CmpDeeply(rs.calls["GetData"], 5)
+
+	// Too many transient errors
+	rs.failures = 3
+	err = d.GetData(v, []AttrID{AussenTemp})
+	t.CmpDeeply(err, td.Smuggle(isTransient, true))
+	t.CmpDeeply(rs.calls["GetData"], 8)
+	rs.failures = 0
+
+	// Transient errors on non-idempotent request
+	rs.failures = 1
+	_, err = d.RefreshData(v, []AttrID{AussenTemp})
+	t.CmpDeeply(err, td.Smuggle(isTransient, true))
+	t.CmpDeeply(rs.calls["RefreshData"], 1)
+}
+
+func TestSessionResultErrors(tt *testing.T) {
+	t := td.NewT(tt)
+
+	rs := newRetryServer()
+	defer rs.Close()
+
+	// ResultSessionExpired number means something else for this session
+	v := NewSession(WithURL(rs.URL),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3}),
+		WithResultErrors(map[int]error{ResultSessionExpired: ErrDeviceNotConnected}))
+	d := &Device{DeviceID: testDeviceID, LocationID: testLocationID}
+	t.FailureIsFatal().CmpNoError(v.Login("pipo", "bingo"))
+
+	rs.expire()
+	err := d.GetData(v, []AttrID{AussenTemp})
+	t.True(errors.Is(err, ErrDeviceNotConnected))
+	t.False(errors.Is(err, ErrSessionExpired))
+	t.CmpDeeply(rs.logins, []string{"pipo"}, "no re-login")
+	t.CmpDeeply(rs.calls["GetData"], 1, "no retry")
+}
+
+func TestSessionCredentials(tt *testing.T) {
+	t := td.NewT(tt)
+
+	rs := newRetryServer()
+	defer rs.Close()
+
+	// Without calling Login
+	v := NewSession(WithURL(rs.URL),
+		WithCredentials(StaticCredentials("provided", "pass")))
+	t.CmpNoError(v.GetDevices())
+	t.CmpDeeply(rs.logins, []string{"provided"})
+
+	// Login does not override a credentials provider
+	t.CmpNoError(v.Login("pipo", "bingo"))
+	rs.expire()
+	t.CmpNoError(v.GetDevices())
+	t.CmpDeeply(rs.logins, []string{"provided", "pipo", "provided"})
+
+	// Failing provider
+	v = NewSession(WithURL(rs.URL),
+		WithCredentials(func(context.Context) (string, string, error) {
+			return "", "", errors.New("vault unavailable")
+		}))
+	t.CmpDeeply(v.GetDevices(), td.Smuggle(isSessionExpired, true))
+
+	// No credentials at all
+	v = NewSession(WithURL(rs.URL))
+	t.CmpDeeply(v.GetDevices(), td.Smuggle(isSessionExpired, true))
+}
@@ -0,0 +1,58 @@
+package timeseries
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+)
+
+// CSVHeader is the header line written by CSVWriter.
+var CSVHeader = []string{
+	"time", "location_id", "device_id", "device", "attribute", "value", "human",
+}
+
+// CSVWriter writes points in CSV format, one record per point. See
+// CSVHeader for the columns. Times are RFC 3339 formatted, value is
+// the Vitodata™ formatted value and human its human representation.
+type CSVWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+// NewCSVWriter returns a new CSVWriter writing to w. If header is
+// true, CSVHeader is written before the first point, typically when
+// w is a new file.
+func NewCSVWriter(w io.Writer, header bool) *CSVWriter {
+	return &CSVWriter{w: csv.NewWriter(w), header: header}
+}
+
+// WritePoints implements Writer interface.
+func (cw *CSVWriter) WritePoints(points []Point) error {
+	if cw.header {
+		cw.header = false
+		err := cw.w.Write(CSVHeader)
+		if err != nil {
+			return err
+		}
+	}
+
+	for idx := range points {
+		p := &points[idx]
+		err := cw.w.Write([]string{
+			p.Time.Format(time.RFC3339),
+			strconv.FormatUint(uint64(p.LocationID), 10),
+			strconv.FormatUint(uint64(p.DeviceID), 10),
+			p.DeviceName,
+			p.Name(),
+			p.Value,
+			p.Human(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.w.Flush()
+	return cw.w.Error()
+}
@@ -0,0 +1,39 @@
+package timeseries_test
+
+import (
+	"bytes"
+	"testing"
+
+	td "github.com/maxatome/go-testdeep"
+
+	"github.com/TomTom68/go-vitotrol"
+	"github.com/TomTom68/go-vitotrol/timeseries"
+)
+
+func TestCSVWriter(tt *testing.T) {
+	t := td.NewT(tt)
+
+	var buf bytes.Buffer
+	w := timeseries.NewCSVWriter(&buf, true)
+	t.CmpNoError(w.WritePoints([]timeseries.Point{
+		{Time: time1, LocationID: 5678, DeviceID: 1234, DeviceName: "Vitodens, 1",
+			AttrID: vitotrol.AussenTemp, Value: "-3.5", Ref: registry.Ref(vitotrol.AussenTemp)},
+	}))
+	t.CmpNoError(w.WritePoints([]timeseries.Point{
+		{Time: time2, LocationID: 5678, DeviceID: 1234, DeviceName: "Vitodens, 1",
+			AttrID: vitotrol.BrennerStatus, Value: "1", Ref: registry.Ref(vitotrol.BrennerStatus)},
+	}))
+	t.CmpDeeply(buf.String(), `time,location_id,device_id,device,attribute,value,human
+2022-01-01T12:00:00Z,5678,1234,"Vitodens, 1",AussenTemp,-3.5,-3.5
+2022-01-01T12:10:00Z,5678,1234,"Vitodens, 1",BrennerStatus,1,Ein
+`)
+
+	// Without header
+	buf.Reset()
+	w = timeseries.NewCSVWriter(&buf, false)
+	t.CmpNoError(w.WritePoints([]timeseries.Point{
+		{Time: time1, LocationID: 1, DeviceID: 2,
+			AttrID: vitotrol.AussenTemp, Value: "1", Ref: registry.Ref(vitotrol.AussenTemp)},
+	}))
+	t.CmpDeeply(buf.String(), "2022-01-01T12:00:00Z,1,2,,AussenTemp,1,1\n")
+}
@@ -0,0 +1,77 @@
+package timeseries
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/TomTom68/go-vitotrol"
+)
+
+// DefaultMeasurement is the measurement name used by
+// NewLineProtocolWriter when none is given.
+const DefaultMeasurement = "vitotrol"
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	fieldEscaper       = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+// LineProtocolWriter writes points using InfluxDB line protocol, one
+// line per point:
+//
+//	vitotrol,location_id=5678,device_id=1234,device=Vitodens,attribute=AussenTemp value=20.5 1641038400000000000
+//
+// As InfluxDB requires a field to keep the same type in a
+// measurement, each type has its own field: floats are written as
+// value field, integers as value_int field, enums as value_int field
+// plus a state string field, and others as a text string field.
+// Timestamps have a nanosecond precision.
+type LineProtocolWriter struct {
+	w           io.Writer
+	measurement string
+}
+
+// NewLineProtocolWriter returns a new LineProtocolWriter writing to
+// w. If measurement is empty, DefaultMeasurement is used.
+func NewLineProtocolWriter(w io.Writer, measurement string) *LineProtocolWriter {
+	if measurement == "" {
+		measurement = DefaultMeasurement
+	}
+	return &LineProtocolWriter{w: w, measurement: measurement}
+}
+
+// WritePoints implements Writer interface.
+func (lw *LineProtocolWriter) WritePoints(points []Point) error {
+	bw := bufio.NewWriter(lw.w)
+	for idx := range points {
+		p := &points[idx]
+
+		fmt.Fprintf(bw, "%s,location_id=%d,device_id=%d",
+			measurementEscaper.Replace(lw.measurement), p.LocationID, p.DeviceID)
+		if p.DeviceName != "" {
+			bw.WriteString(",device=" + tagEscaper.Replace(p.DeviceName))
+		}
+		bw.WriteString(",attribute=" + tagEscaper.Replace(p.Name()) + " ")
+
+		switch native := p.Native().(type) {
+		case float64:
+			bw.WriteString("value=" + strconv.FormatFloat(native, 'f', -1, 64))
+		case int64:
+			bw.WriteString("value_int=" + strconv.FormatInt(native, 10) + "i")
+		case uint64: // enum
+			bw.WriteString("value_int=" + strconv.FormatUint(native, 10) + "i")
+			bw.WriteString(`,state="` + fieldEscaper.Replace(p.Human()) + `"`)
+		case vitotrol.Time:
+			bw.WriteString(`text="` + native.String() + `"`)
+		default:
+			bw.WriteString(`text="` + fieldEscaper.Replace(p.Value) + `"`)
+		}
+
+		fmt.Fprintf(bw, " %d\n", p.Time.UnixNano())
+	}
+	return bw.Flush()
+}
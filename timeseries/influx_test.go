@@ -0,0 +1,68 @@
+package timeseries_test
+
+import (
+	"bytes"
+	"testing"
+
+	td "github.com/maxatome/go-testdeep"
+
+	"github.com/TomTom68/go-vitotrol"
+	"github.com/TomTom68/go-vitotrol/timeseries"
+)
+
+func TestLineProtocolWriter(tt *testing.T) {
+	t := td.NewT(tt)
+
+	var buf bytes.Buffer
+	w := timeseries.NewLineProtocolWriter(&buf, "")
+	t.CmpNoError(w.WritePoints([]timeseries.Point{
+		{Time: time1, LocationID: 5678, DeviceID: 1234, DeviceName: "Vito dens, 1",
+			AttrID: vitotrol.AussenTemp, Value: "-3.5", Ref: registry.Ref(vitotrol.AussenTemp)},
+		{Time: time1, LocationID: 5678, DeviceID: 1234,
+			AttrID: vitotrol.BrennerStatus, Value: "1", Ref: registry.Ref(vitotrol.BrennerStatus)},
+		{Time: time2, LocationID: 5678, DeviceID: 1234,
+			AttrID: vitotrol.FerienStartM1, Value: "2022-01-02 03:04:05", Ref: registry.Ref(vitotrol.FerienStartM1)},
+		{Time: time2, LocationID: 5678, DeviceID: 1234,
+			AttrID: 0x1234, Value: `say "hi"`},
+	}))
+	t.CmpDeeply(buf.String(), `vitotrol,location_id=5678,device_id=1234,device=Vito\ dens\,\ 1,attribute=AussenTemp value=-3.5 1641038400000000000
+vitotrol,location_id=5678,device_id=1234,attribute=BrennerStatus value_int=1i,state="Ein" 1641038400000000000
+vitotrol,location_id=5678,device_id=1234,attribute=FerienStartM1 text="2022-01-02 03:04:05" 1641039000000000000
+vitotrol,location_id=5678,device_id=1234,attribute=0x1234 text="say \"hi\"" 1641039000000000000
+`)
+
+	buf.Reset()
+	w = timeseries.NewLineProtocolWriter(&buf, "boiler")
+	t.CmpNoError(w.WritePoints([]timeseries.Point{
+		{Time: time1, LocationID: 1, DeviceID: 2, AttrID: vitotrol.AnzahlBrennerStarts,
+			Value: "42", Ref: registry.Ref(vitotrol.AnzahlBrennerStarts)},
+	}))
+	t.CmpDeeply(buf.String(),
+		"boiler,location_id=1,device_id=2,attribute=AnzahlBrennerStarts value=42 1641038400000000000\n")
+}
+
+func TestLineProtocolWriterFieldTypes(tt *testing.T) {
+	t := td.NewT(tt)
+
+	// Each field keeps the same type whatever the attribute type, as
+	// required by InfluxDB in a measurement
+	counter := &vitotrol.AttrRef{Name: "Counter", Type: vitotrol.TypeInteger}
+
+	var buf bytes.Buffer
+	w := timeseries.NewLineProtocolWriter(&buf, "")
+	t.CmpNoError(w.WritePoints([]timeseries.Point{
+		{Time: time1, LocationID: 1, DeviceID: 2,
+			AttrID: vitotrol.AussenTemp, Value: "7", Ref: registry.Ref(vitotrol.AussenTemp)},
+		{Time: time1, LocationID: 1, DeviceID: 2,
+			AttrID: 0x4321, Value: "7", Ref: counter},
+		{Time: time1, LocationID: 1, DeviceID: 2,
+			AttrID: vitotrol.BrennerStatus, Value: "0", Ref: registry.Ref(vitotrol.BrennerStatus)},
+		{Time: time1, LocationID: 1, DeviceID: 2,
+			AttrID: 0x1234, Value: "7"},
+	}))
+	t.CmpDeeply(buf.String(), `vitotrol,location_id=1,device_id=2,attribute=AussenTemp value=7 1641038400000000000
+vitotrol,location_id=1,device_id=2,attribute=Counter value_int=7i 1641038400000000000
+vitotrol,location_id=1,device_id=2,attribute=BrennerStatus value_int=0i,state="Aus" 1641038400000000000
+vitotrol,location_id=1,device_id=2,attribute=0x1234 text="7" 1641038400000000000
+`)
+}
@@ -0,0 +1,125 @@
+// Package timeseries turns the attributes cache of Vitotrol™ devices
+// into time series points, written using InfluxDB line protocol or
+// CSV.
+//
+// A point is timestamped with the server-side time of the value
+// (vitotrol.Value.Time), and a Tracker only emits it once, so
+// polling the same value several times does not store duplicates.
+package timeseries
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/TomTom68/go-vitotrol"
+)
+
+// Point is the value of an attribute of a device at a given time.
+type Point struct {
+	Time       time.Time
+	LocationID uint32
+	DeviceID   uint32
+	DeviceName string
+	AttrID     vitotrol.AttrID
+	Value      string // Vitodata™ formatted value
+	// Ref is the reference of the attribute in the device registry,
+	// nil if it is unknown.
+	Ref *vitotrol.AttrRef
+}
+
+// Name returns the name of the point attribute.
+func (p *Point) Name() string {
+	if p.Ref != nil {
+		return p.Ref.Name
+	}
+	return fmt.Sprintf("0x%04x", uint16(p.AttrID))
+}
+
+// Native returns the point value converted to its native type (see
+// VitodataType.Vitodata2NativeValue) or, if it fails, as a string.
+func (p *Point) Native() interface{} {
+	if p.Ref != nil {
+		if native, err := p.Ref.Type.Vitodata2NativeValue(p.Value); err == nil {
+			return native
+		}
+	}
+	return p.Value
+}
+
+// Human returns the human representation of the point value (see
+// VitodataType.Vitodata2HumanValue) or, if it fails, the value as is.
+func (p *Point) Human() string {
+	if p.Ref != nil {
+		if human, err := p.Ref.Type.Vitodata2HumanValue(p.Value); err == nil {
+			return human
+		}
+	}
+	return p.Value
+}
+
+// A Writer writes points to a time series sink.
+type Writer interface {
+	WritePoints(points []Point) error
+}
+
+type pointKey struct {
+	locationID, deviceID uint32
+	attrID               vitotrol.AttrID
+}
+
+// Tracker remembers the server timestamp of the last point emitted
+// for each attribute of each device.
+type Tracker struct {
+	mu   sync.Mutex
+	last map[pointKey]time.Time
+}
+
+// NewTracker returns a new empty Tracker.
+func NewTracker() *Tracker {
+	return &Tracker{last: map[pointKey]time.Time{}}
+}
+
+// Points returns the points of attributes attrs of device d whose
+// server timestamp changed since the previous call, sorted by
+// timestamp then attribute ID. Attributes never read are ignored.
+func (t *Tracker) Points(d *vitotrol.Device, attrs []vitotrol.AttrID) []Point {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	registry := d.Registry()
+
+	var points []Point
+	for _, attrID := range attrs {
+		value, ok := d.Attribute(attrID)
+		if !ok {
+			continue
+		}
+
+		key := pointKey{locationID: d.LocationID, deviceID: d.DeviceID, attrID: attrID}
+		tm := time.Time(value.Time)
+		if last, ok := t.last[key]; ok && last.Equal(tm) {
+			continue
+		}
+		t.last[key] = tm
+
+		points = append(points, Point{
+			Time:       tm,
+			LocationID: d.LocationID,
+			DeviceID:   d.DeviceID,
+			DeviceName: d.DeviceName,
+			AttrID:     attrID,
+			Value:      value.Value,
+			Ref:        registry.Ref(attrID),
+		})
+	}
+
+	sort.SliceStable(points, func(i, j int) bool {
+		if points[i].Time.Equal(points[j].Time) {
+			return points[i].AttrID < points[j].AttrID
+		}
+		return points[i].Time.Before(points[j].Time)
+	})
+	return points
+}
@@ -0,0 +1,99 @@
+package timeseries_test
+
+import (
+	"testing"
+	"time"
+
+	td "github.com/maxatome/go-testdeep"
+
+	"github.com/TomTom68/go-vitotrol"
+	"github.com/TomTom68/go-vitotrol/timeseries"
+)
+
+var (
+	time1 = time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	time2 = time1.Add(10 * time.Minute)
+
+	registry = vitotrol.NewAttributeRegistry()
+)
+
+func newDevice(values map[vitotrol.AttrID]*vitotrol.Value) *vitotrol.Device {
+	return &vitotrol.Device{
+		LocationID: 5678,
+		DeviceID:   1234,
+		DeviceName: "Vitodens",
+		Attributes: values,
+	}
+}
+
+func TestTracker(tt *testing.T) {
+	t := td.NewT(tt)
+
+	d := newDevice(map[vitotrol.AttrID]*vitotrol.Value{
+		vitotrol.AussenTemp:    {Value: "-3.5", Time: vitotrol.Time(time2)},
+		vitotrol.BrennerStatus: {Value: "1", Time: vitotrol.Time(time1)},
+	})
+	attrs := []vitotrol.AttrID{vitotrol.AussenTemp, vitotrol.BrennerStatus, vitotrol.BoilerTemp}
+
+	tracker := timeseries.NewTracker()
+	t.CmpDeeply(tracker.Points(d, attrs), []timeseries.Point{
+		{
+			Time:       time1,
+			LocationID: 5678,
+			DeviceID:   1234,
+			DeviceName: "Vitodens",
+			AttrID:     vitotrol.BrennerStatus,
+			Value:      "1",
+			Ref:        vitotrol.AttributesRef[vitotrol.BrennerStatus],
+		},
+		{
+			Time:       time2,
+			LocationID: 5678,
+			DeviceID:   1234,
+			DeviceName: "Vitodens",
+			AttrID:     vitotrol.AussenTemp,
+			Value:      "-3.5",
+			Ref:        vitotrol.AttributesRef[vitotrol.AussenTemp],
+		},
+	})
+
+	// Same server timestamps: nothing new, even if the value changed
+	d.Attributes[vitotrol.AussenTemp].Value = "-4"
+	t.Empty(tracker.Points(d, attrs))
+
+	// New server timestamp
+	d.Attributes[vitotrol.BrennerStatus] = &vitotrol.Value{Value: "0", Time: vitotrol.Time(time2)}
+	t.CmpDeeply(tracker.Points(d, attrs), []timeseries.Point{
+		{
+			Time:       time2,
+			LocationID: 5678,
+			DeviceID:   1234,
+			DeviceName: "Vitodens",
+			AttrID:     vitotrol.BrennerStatus,
+			Value:      "0",
+			Ref:        vitotrol.AttributesRef[vitotrol.BrennerStatus],
+		},
+	})
+}
+
+func TestPoint(tt *testing.T) {
+	t := td.NewT(tt)
+
+	p := timeseries.Point{
+		AttrID: vitotrol.BrennerStatus,
+		Value:  "1",
+		Ref:    registry.Ref(vitotrol.BrennerStatus),
+	}
+	t.CmpDeeply(p.Name(), "BrennerStatus")
+	t.CmpDeeply(p.Native(), uint64(1))
+	t.CmpDeeply(p.Human(), "Ein")
+
+	p = timeseries.Point{AttrID: 0x1234, Value: "foo"}
+	t.CmpDeeply(p.Name(), "0x1234")
+	t.CmpDeeply(p.Native(), "foo")
+	t.CmpDeeply(p.Human(), "foo")
+
+	// Reference from a device registry
+	p.Ref = &vitotrol.AttrRef{Type: vitotrol.TypeString, Name: "Foo-0x1234"}
+	t.CmpDeeply(p.Name(), "Foo-0x1234")
+}
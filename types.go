@@ -188,6 +188,12 @@ func NewEnum(values []string) *VitodataEnum {
 	return pEnum
 }
 
+// Values returns the enum values, indexed by their numeric
+// counterpart.
+func (v *VitodataEnum) Values() []string {
+	return append([]string(nil), v.revValues...)
+}
+
 // Type returns the "human" name of the type.
 func (v *VitodataEnum) Type() string {
 	return fmt.Sprintf("Enum%d", len(v.revValues))
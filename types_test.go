@@ -143,6 +143,7 @@ func TestVitodataEnum(tt *testing.T) {
 	})
 
 	t.CmpDeeply(typeEnumTest.Type(), "Enum3")
+	t.CmpDeeply(typeEnumTest.Values(), []string{"zero", "one", "two"})
 
 	// Human2VitodataValue
 	str, err := typeEnumTest.Human2VitodataValue("one")
@@ -0,0 +1,159 @@
+package vitotrol
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Unit is the physical unit of an attribute value (see AttrRef.Unit).
+type Unit string
+
+// Known units.
+const (
+	NoUnit         Unit = ""
+	UnitCelsius    Unit = "°C"
+	UnitFahrenheit Unit = "°F"
+	UnitKelvin     Unit = "K" // temperature difference
+	UnitHour       Unit = "h"
+	UnitCount      Unit = "count"
+	UnitPercent    Unit = "%"
+)
+
+// Symbol returns the symbol displayed after a value of unit u. It is
+// empty for NoUnit and UnitCount.
+func (u Unit) Symbol() string {
+	if u == UnitCount {
+		return ""
+	}
+	return string(u)
+}
+
+// ConvertUnit converts value from unit from to unit to. Only
+// temperatures can be converted, between °C and °F, and temperature
+// differences, from K to °F. Converting a unit to itself always
+// succeeds.
+func ConvertUnit(value float64, from, to Unit) (float64, error) {
+	switch {
+	case from == to:
+		return value, nil
+	case from == UnitCelsius && to == UnitFahrenheit:
+		return value*9/5 + 32, nil
+	case from == UnitFahrenheit && to == UnitCelsius:
+		return (value - 32) * 5 / 9, nil
+	case from == UnitKelvin && to == UnitFahrenheit:
+		return value * 9 / 5, nil
+	}
+	return 0, fmt.Errorf("cannot convert %q to %q", from, to)
+}
+
+// Units maps units to the ones values have to be converted to before
+// being displayed, as in Units{UnitCelsius: UnitFahrenheit}. A nil
+// Units converts nothing.
+type Units map[Unit]Unit
+
+// Fahrenheit converts temperatures and temperature differences to °F.
+var Fahrenheit = Units{
+	UnitCelsius: UnitFahrenheit,
+	UnitKelvin:  UnitFahrenheit,
+}
+
+// Convert converts value of unit from as required by u, and returns
+// it with its new unit. If from is not converted by u, value and
+// from are returned as is.
+func (u Units) Convert(value float64, from Unit) (float64, Unit) {
+	to, ok := u[from]
+	if !ok {
+		return value, from
+	}
+	conv, err := ConvertUnit(value, from, to)
+	if err != nil {
+		return value, from
+	}
+	return conv, to
+}
+
+// ConvertValue is the same as Convert but works on the Vitodata™
+// formatted value of attribute ref. Non-numeric values, as well as
+// values of attributes without unit, are returned as is.
+func (u Units) ConvertValue(ref *AttrRef, value string) (string, Unit) {
+	if len(u) == 0 || ref.Unit == NoUnit {
+		return value, ref.Unit
+	}
+	num, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return value, ref.Unit
+	}
+	num, unit := u.Convert(num, ref.Unit)
+	return strconv.FormatFloat(num, 'f', -1, 64), unit
+}
+
+// Temperature is a temperature in °C.
+type Temperature float64
+
+// Celsius returns the temperature in °C.
+func (t Temperature) Celsius() float64 {
+	return float64(t)
+}
+
+// Fahrenheit returns the temperature in °F.
+func (t Temperature) Fahrenheit() float64 {
+	f, _ := ConvertUnit(float64(t), UnitCelsius, UnitFahrenheit)
+	return f
+}
+
+// String returns the temperature as a string, as in "21.5°C".
+func (t Temperature) String() string {
+	return strconv.FormatFloat(float64(t), 'f', -1, 64) + UnitCelsius.Symbol()
+}
+
+// numAttribute returns the last read value of attribute attrID as a
+// float64, checking its unit is unit.
+func (d *Device) numAttribute(attrID AttrID, unit Unit) (float64, error) {
+	pRef := d.Registry().Ref(attrID)
+	if pRef == nil {
+		return 0, fmt.Errorf("unknown attribute 0x%04x", uint16(attrID))
+	}
+	if pRef.Unit != unit {
+		return 0, fmt.Errorf("attribute %s unit is %q, not %q", pRef.Name, pRef.Unit, unit)
+	}
+
+	value, ok := d.Attribute(attrID)
+	if !ok {
+		return 0, fmt.Errorf("attribute %s has never been read", pRef.Name)
+	}
+	num, err := strconv.ParseFloat(value.Value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("attribute %s: %s", pRef.Name, err)
+	}
+	return num, nil
+}
+
+// Temperature returns the last read value of the °C attribute attrID
+// (as AussenTemp).
+func (d *Device) Temperature(attrID AttrID) (Temperature, error) {
+	num, err := d.numAttribute(attrID, UnitCelsius)
+	return Temperature(num), err
+}
+
+// Duration returns the last read value of the hours attribute attrID
+// (as AnzahlBrennerstunden).
+func (d *Device) Duration(attrID AttrID) (time.Duration, error) {
+	num, err := d.numAttribute(attrID, UnitHour)
+	return time.Duration(num * float64(time.Hour)), err
+}
+
+// Counter returns the last read value of the counter attribute
+// attrID (as AnzahlBrennerStarts).
+func (d *Device) Counter(attrID AttrID) (uint64, error) {
+	num, err := d.numAttribute(attrID, UnitCount)
+	if err != nil {
+		return 0, err
+	}
+	if num < 0 {
+		return 0, fmt.Errorf("attribute %s: negative counter %g",
+			d.Registry().Ref(attrID).Name, num)
+	}
+	return uint64(math.Round(num)), nil
+}
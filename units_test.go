@@ -0,0 +1,97 @@
+package vitotrol
+
+import (
+	"testing"
+	"time"
+
+	td "github.com/maxatome/go-testdeep"
+)
+
+func TestConvertUnit(tt *testing.T) {
+	t := td.NewT(tt)
+
+	for _, tc := range []struct {
+		value    float64
+		from, to Unit
+		expected float64
+	}{
+		{value: 100, from: UnitCelsius, to: UnitFahrenheit, expected: 212},
+		{value: -40, from: UnitCelsius, to: UnitFahrenheit, expected: -40},
+		{value: 212, from: UnitFahrenheit, to: UnitCelsius, expected: 100},
+		{value: 5, from: UnitKelvin, to: UnitFahrenheit, expected: 9},
+		{value: 12, from: UnitHour, to: UnitHour, expected: 12},
+	} {
+		got, err := ConvertUnit(tc.value, tc.from, tc.to)
+		if t.CmpNoError(err, "%s -> %s", tc.from, tc.to) {
+			t.Cmp(got, td.Between(tc.expected-1e-9, tc.expected+1e-9), "%s -> %s", tc.from, tc.to)
+		}
+	}
+
+	_, err := ConvertUnit(1, UnitHour, UnitCelsius)
+	t.Cmp(err, td.String(`cannot convert "h" to "°C"`))
+
+	// Units
+	num, unit := Fahrenheit.Convert(20, UnitCelsius)
+	t.Cmp(num, 68.0)
+	t.Cmp(unit, UnitFahrenheit)
+	num, unit = Fahrenheit.Convert(20, UnitPercent)
+	t.Cmp(num, 20.0)
+	t.Cmp(unit, UnitPercent)
+	num, unit = Units(nil).Convert(20, UnitCelsius)
+	t.Cmp(num, 20.0)
+	t.Cmp(unit, UnitCelsius)
+
+	value, unit := Fahrenheit.ConvertValue(AttributesRef[AussenTemp], "-5")
+	t.Cmp(value, "23")
+	t.Cmp(unit, UnitFahrenheit)
+	value, unit = Fahrenheit.ConvertValue(AttributesRef[AussenTemp], "foo")
+	t.Cmp(value, "foo")
+	t.Cmp(unit, UnitCelsius)
+
+	t.Cmp(UnitCelsius.Symbol(), "°C")
+	t.Cmp(UnitCount.Symbol(), "")
+	t.Cmp(NoUnit.Symbol(), "")
+
+	temp := Temperature(21.5)
+	t.Cmp(temp.Celsius(), 21.5)
+	t.Cmp(temp.Fahrenheit(), 70.7)
+	t.Cmp(temp.String(), "21.5°C")
+}
+
+func TestDeviceUnits(tt *testing.T) {
+	t := td.NewT(tt)
+
+	d := &Device{
+		Attributes: map[AttrID]*Value{
+			AussenTemp:           {Value: "-3.5", Time: testTime},
+			AnzahlBrennerstunden: {Value: "1234.5", Time: testTime},
+			AnzahlBrennerStarts:  {Value: "4321", Time: testTime},
+			HeisswasserTemp:      {Value: "bad", Time: testTime},
+		},
+	}
+
+	temp, err := d.Temperature(AussenTemp)
+	t.CmpNoError(err)
+	t.Cmp(temp, Temperature(-3.5))
+
+	dur, err := d.Duration(AnzahlBrennerstunden)
+	t.CmpNoError(err)
+	t.Cmp(dur, 1234*time.Hour+30*time.Minute)
+
+	count, err := d.Counter(AnzahlBrennerStarts)
+	t.CmpNoError(err)
+	t.Cmp(count, uint64(4321))
+
+	_, err = d.Temperature(AnzahlBrennerStarts)
+	t.Cmp(err, td.String(`attribute AnzahlBrennerStarts unit is "count", not "°C"`))
+	_, err = d.Temperature(BoilerTemp)
+	t.Cmp(err, td.String("attribute BoilerTemp has never been read"))
+	_, err = d.Temperature(HeisswasserTemp)
+	t.Cmp(err, td.HasPrefix("attribute HeisswasserTemp: "))
+	_, err = d.Counter(0x1234)
+	t.Cmp(err, td.String("unknown attribute 0x1234"))
+
+	t.Cmp(d.FormatAttributesUnits([]AttrID{AussenTemp, AnzahlBrennerStarts}, Fahrenheit),
+		"AussenTemp: 25.7°F@2016-10-30 12:13:14 (Außen Temperatur)\n"+
+			"AnzahlBrennerStarts: 4321@2016-10-30 12:13:14 (Anzahl von Brennerstarts)\n")
+}
@@ -0,0 +1,84 @@
+package vitotrol
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// ErrInvalidValue is matched, using errors.Is, by any
+// *ValidationError.
+var ErrInvalidValue = errors.New("invalid value")
+
+// ValidationError is returned when a value does not fit the
+// constraints of its attribute (see AttrRef.Validate). In this case,
+// nothing has been sent to the Vitodata™ server.
+type ValidationError struct {
+	Attribute string // attribute name
+	Value     string // rejected Vitodata™ formatted value
+	Reason    string
+}
+
+// Error returns the validation error as a string.
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("value `%s' of attribute %s is invalid: %s",
+		e.Value, e.Attribute, e.Reason)
+}
+
+// Is allows errors.Is to match ErrInvalidValue.
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrInvalidValue
+}
+
+// stepEpsilon is the tolerance used to check that a value is a
+// multiple of a step, to absorb float rounding errors.
+const stepEpsilon = 1e-6
+
+// Validate checks that the Vitodata™ formatted value fits the Min,
+// Max and Step constraints of r. Only Double and Integer attributes
+// are checked. A *ValidationError is returned if the value is
+// rejected.
+func (r *AttrRef) Validate(value string) error {
+	switch r.Type.(type) {
+	case *VitodataDouble, *VitodataInteger:
+	default:
+		return nil
+	}
+
+	num, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(num) || math.IsInf(num, 0) {
+		return r.invalid(value, "not a number")
+	}
+
+	if r.Min != nil && num < *r.Min {
+		return r.invalid(value, "lower than minimum "+formatFloat(*r.Min))
+	}
+	if r.Max != nil && num > *r.Max {
+		return r.invalid(value, "greater than maximum "+formatFloat(*r.Max))
+	}
+
+	if r.Step > 0 {
+		var base float64
+		if r.Min != nil {
+			base = *r.Min
+		}
+		steps := (num - base) / r.Step
+		if math.Abs(steps-math.Round(steps)) > stepEpsilon {
+			return r.invalid(value, "not a multiple of step "+formatFloat(r.Step))
+		}
+	}
+	return nil
+}
+
+func (r *AttrRef) invalid(value, reason string) error {
+	return &ValidationError{
+		Attribute: r.Name,
+		Value:     value,
+		Reason:    reason,
+	}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
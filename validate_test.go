@@ -0,0 +1,112 @@
+package vitotrol
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	td "github.com/maxatome/go-testdeep"
+)
+
+func TestValidate(tt *testing.T) {
+	t := td.NewT(tt)
+
+	ref := AttrRef{
+		Type: TypeDouble,
+		Name: "Foo",
+		Min:  limit(0.2),
+		Max:  limit(3.5),
+		Step: 0.1,
+	}
+
+	for _, value := range []string{"0.2", "1.4", "3.5", "2"} {
+		t.CmpNoError(ref.Validate(value), value)
+	}
+
+	for value, reason := range map[string]string{
+		"abc":  "not a number",
+		"NaN":  "not a number",
+		"0.1":  "lower than minimum 0.2",
+		"3.6":  "greater than maximum 3.5",
+		"1.45": "not a multiple of step 0.1",
+	} {
+		err := ref.Validate(value)
+		t.Cmp(err, &ValidationError{
+			Attribute: "Foo",
+			Value:     value,
+			Reason:    reason,
+		}, value)
+	}
+
+	err := ref.Validate("12")
+	t.True(errors.Is(err, ErrInvalidValue))
+	t.Cmp(err, td.String("value `12' of attribute Foo is invalid: greater than maximum 3.5"))
+
+	// Step without Min starts from 0
+	ref = AttrRef{Type: TypeInteger, Name: "Bar", Step: 5}
+	t.CmpNoError(ref.Validate("-10"))
+	t.Cmp(ref.Validate("12"), td.String("value `12' of attribute Bar is invalid: not a multiple of step 5"))
+
+	// Non numeric types are not checked
+	ref = AttrRef{Type: TypeString, Name: "Baz", Min: limit(1)}
+	t.CmpNoError(ref.Validate("abc"))
+
+	// Built-in constraints
+	t.CmpNoError(AttributesRef[HeisswasserSollTemp].Validate("55"))
+	t.Cmp(AttributesRef[HeisswasserSollTemp].Validate("95"),
+		td.String("value `95' of attribute HeisswasserSollTemp is invalid: greater than maximum 60"))
+}
+
+func TestWriteDataValidation(tt *testing.T) {
+	t := td.NewT(tt)
+
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprintln(w, respHeader+intoDeviceResponse("WriteData",
+			`<Ergebnis>0</Ergebnis><AktualisierungsId>7</AktualisierungsId>`)+respFooter)
+	}))
+	defer ts.Close()
+
+	v := NewSession(WithURL(ts.URL), WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+	d := &Device{DeviceID: testDeviceID, LocationID: testLocationID}
+
+	_, err := d.WriteData(v, HeisswasserSollTemp, "95")
+	t.Cmp(err, td.Isa(&ValidationError{}))
+	ch, err := d.WriteDataWait(v, HeizNormalTempM1, "2")
+	t.Nil(ch)
+	t.True(errors.Is(err, ErrInvalidValue))
+	t.Cmp(calls, 0, "no request sent")
+
+	refreshID, err := d.WriteData(v, HeisswasserSollTemp, "50")
+	t.CmpNoError(err)
+	t.Cmp(refreshID, "7")
+
+	// Unknown attributes are not checked
+	_, err = d.WriteData(v, 0x1234, "95")
+	t.CmpNoError(err)
+
+	// Constraints can be overridden
+	r := NewAttributeRegistry()
+	ref := *r.Ref(HeisswasserSollTemp)
+	ref.Max = limit(95)
+	r.Add(HeisswasserSollTemp, ref)
+	d.SetRegistry(r)
+	_, err = d.WriteData(v, HeisswasserSollTemp, "95")
+	t.CmpNoError(err)
+	t.Cmp(calls, 3)
+
+	// Equal bounds returned by GetTypeInfo are ignored
+	r.Merge([]*AttributeInfo{{
+		AttributeInfoBase: AttributeInfoBase{
+			AttributeName: "konf_ww_solltemp_rw",
+			AttributeType: "Double",
+			MinValue:      "0",
+			MaxValue:      "0",
+		},
+		AttributeID: HeisswasserSollTemp,
+	}})
+	t.Cmp(*r.Ref(HeisswasserSollTemp).Max, 95.0)
+}
@@ -0,0 +1,102 @@
+package vitotrol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrMismatch is matched, using errors.Is, by any *MismatchError.
+var ErrMismatch = errors.New("value mismatch")
+
+// MismatchError is the error of a verified write (see WithVerify
+// option) when the attribute value read back differs from the
+// written one, as when the boiler clamps or ignores it.
+type MismatchError struct {
+	Attribute string // attribute name
+	Expected  string // written value, normalized
+	Actual    string // value read back, normalized
+}
+
+// Error returns the mismatch error as a string.
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("value of attribute %s mismatch: expected `%s', got `%s'",
+		e.Attribute, e.Expected, e.Actual)
+}
+
+// Is allows errors.Is to match ErrMismatch.
+func (e *MismatchError) Is(target error) bool {
+	return target == ErrMismatch
+}
+
+// WithVerify tells whether, once the server reports a WriteData
+// operation as done, the written attribute is refreshed and read back
+// to check its value. If it differs, the operation fails with a
+// *MismatchError. It is ignored by other operations.
+//
+// The refresh operation gets the same options as the write one, so
+// it is recorded by WithJournal, follows WithOperationPollPolicy and
+// is reported to WithProgress callbacks.
+func WithVerify(verify bool) OperationOption {
+	return func(o *Operation) {
+		o.verify = verify
+	}
+}
+
+// withCheck sets the function called to verify the operation when
+// WithVerify option is enabled.
+func withCheck(check func(ctx context.Context) error) OperationOption {
+	return func(o *Operation) {
+		o.check = check
+	}
+}
+
+// verifyWrite refreshes and reads back attribute attrID, then checks
+// its value is the written one. Both values are normalized using the
+// attribute type, if known, so "21" and "21.0" are equal. The refresh
+// operation uses opts, the options of the write operation.
+func (d *Device) verifyWrite(ctx context.Context, v *Session, attrID AttrID, value string, opts []OperationOption) error {
+	op, err := d.RefreshDataAsync(ctx, v, []AttrID{attrID}, opts...)
+	if err != nil {
+		return fmt.Errorf("verification RefreshData error: %w", err)
+	}
+	err = op.Wait(ctx)
+	if err != nil {
+		return fmt.Errorf("verification RefreshData failed: %w", err)
+	}
+
+	values, err := d.getData(ctx, v, []AttrID{attrID})
+	if err != nil {
+		return fmt.Errorf("verification GetData error: %w", err)
+	}
+	read, ok := values[attrID]
+	if !ok {
+		return fmt.Errorf("verification GetData did not return attribute %s",
+			d.attrName(attrID))
+	}
+
+	pRef := d.Registry().Ref(attrID)
+	expected, actual := normalize(pRef, value), normalize(pRef, read.Value)
+	if expected != actual {
+		return &MismatchError{
+			Attribute: d.attrName(attrID),
+			Expected:  expected,
+			Actual:    actual,
+		}
+	}
+	return nil
+}
+
+// normalize returns the Vitodata™ formatted value in its human form
+// according to the type of attribute ref, or as is if ref is nil or
+// value cannot be converted.
+func normalize(ref *AttrRef, value string) string {
+	if ref == nil {
+		return value
+	}
+	human, err := ref.Type.Vitodata2HumanValue(value)
+	if err != nil {
+		return value
+	}
+	return human
+}
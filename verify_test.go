@@ -0,0 +1,201 @@
+package vitotrol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	td "github.com/maxatome/go-testdeep"
+)
+
+// verifyServer stores the written values, replacing those found in
+// stored, and returns them to GetData requests. Values replaced by
+// an empty string are not stored. Received actions are recorded.
+type verifyServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	values  map[string]string
+	actions []string
+}
+
+func newVerifyServer(stored map[string]string) *verifyServer {
+	s := &verifyServer{values: map[string]string{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action := r.Header.Get("SOAPAction")
+		action = action[strings.LastIndex(action, "/")+1:]
+		body, _ := io.ReadAll(r.Body)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.actions = append(s.actions, action)
+
+		var content string
+		switch action {
+		case "WriteData":
+			attrID := string(reDatapointID.FindSubmatch(body)[1])
+			value := string(reWert.FindSubmatch(body)[1])
+			if replaced, ok := stored[value]; ok {
+				value = replaced
+			}
+			if value != "" {
+				s.values[attrID] = value
+			}
+			content = `<Ergebnis>0</Ergebnis><AktualisierungsId>1</AktualisierungsId>`
+		case "RefreshData":
+			content = `<Ergebnis>0</Ergebnis><AktualisierungsId>2</AktualisierungsId>`
+		case "GetData":
+			content = `<Ergebnis>0</Ergebnis><DatenwerteListe>`
+			for attrID, value := range s.values {
+				content += fmt.Sprintf(`<WerteListe><DatenpunktId>%s</DatenpunktId><Wert>%s</Wert><Zeitstempel>%s</Zeitstempel></WerteListe>`,
+					attrID, value, testTimeStr)
+			}
+			content += `</DatenwerteListe>`
+		default:
+			content = `<Ergebnis>0</Ergebnis><Status>4</Status>`
+		}
+		fmt.Fprintln(w, respHeader+intoDeviceResponse(action, content)+respFooter)
+	}))
+	return s
+}
+
+func (s *verifyServer) Actions() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.actions
+}
+
+func TestWriteVerify(tt *testing.T) {
+	t := td.NewT(tt)
+
+	ctx := context.Background()
+	newDevice := func() *Device {
+		return &Device{DeviceID: testDeviceID, LocationID: testLocationID}
+	}
+
+	t.Run("verified", func(t *td.T) {
+		ts := newVerifyServer(map[string]string{"21": "21.0"})
+		defer ts.Close()
+		v := NewSession(WithURL(ts.URL), withTestPollPolicy(0, time.Minute))
+
+		d := newDevice()
+		op, err := d.WriteDataAsync(ctx, v, HeizNormalTempM1, "21", WithVerify(true))
+		t.FailureIsFatal().CmpNoError(err)
+		t.CmpNoError(op.Wait(ctx), "21.0 is 21")
+		t.Cmp(op.State(), OperationDone)
+		t.Cmp(ts.Actions(), []string{
+			"WriteData", "RequestWriteStatus",
+			"RefreshData", "RequestRefreshStatus", "GetData",
+		})
+
+		value, _ := d.Attribute(HeizNormalTempM1)
+		t.Cmp(value.Value, "21.0", "read back value is cached")
+	})
+
+	t.Run("mismatch", func(t *td.T) {
+		ts := newVerifyServer(map[string]string{"30": "25", "2": "1"})
+		defer ts.Close()
+		v := NewSession(WithURL(ts.URL), withTestPollPolicy(0, time.Minute))
+
+		op, err := newDevice().WriteDataAsync(ctx, v, HeizNormalTempM1, "30", WithVerify(true))
+		t.FailureIsFatal().CmpNoError(err)
+		err = op.Wait(ctx)
+		t.Cmp(err, &MismatchError{
+			Attribute: "HeizNormalTempM1",
+			Expected:  "30",
+			Actual:    "25",
+		})
+		t.True(errors.Is(err, ErrMismatch))
+		t.Cmp(err, td.String("value of attribute HeizNormalTempM1 mismatch: expected `30', got `25'"))
+		t.Cmp(op.State(), OperationFailed)
+		t.Cmp(op.Status(), 4)
+
+		// Enum values are compared using their names, through WriteDataWait
+		modes := AttributesRef[BetriebsartM1].Type.(*VitodataEnum).Values()
+		ch, err := newDevice().WriteDataWait(v, BetriebsartM1, "2", WithVerify(true))
+		t.FailureIsFatal().CmpNoError(err)
+		t.Cmp(<-ch, &MismatchError{
+			Attribute: "BetriebsartM1",
+			Expected:  modes[2],
+			Actual:    modes[1],
+		})
+	})
+
+	t.Run("not returned", func(t *td.T) {
+		ts := newVerifyServer(map[string]string{"21": ""})
+		defer ts.Close()
+		v := NewSession(WithURL(ts.URL), withTestPollPolicy(0, time.Minute))
+
+		// The cached value must not be used
+		d := newDevice()
+		d.Attributes = map[AttrID]*Value{HeizNormalTempM1: {Value: "21"}}
+		op, err := d.WriteDataAsync(ctx, v, HeizNormalTempM1, "21", WithVerify(true))
+		t.FailureIsFatal().CmpNoError(err)
+		t.Cmp(op.Wait(ctx),
+			td.String("verification GetData did not return attribute HeizNormalTempM1"))
+	})
+
+	t.Run("write options", func(t *td.T) {
+		ts := newVerifyServer(nil)
+		defer ts.Close()
+		// Session policy never polls the status
+		v := NewSession(WithURL(ts.URL), withTestPollPolicy(time.Hour, time.Hour))
+
+		var mu sync.Mutex
+		var progress []string
+		op, err := newDevice().WriteDataAsync(ctx, v, HeizNormalTempM1, "21",
+			WithVerify(true),
+			WithOperationPollPolicy(PollPolicy{MinInterval: time.Millisecond, Timeout: time.Minute}),
+			WithProgress(func(op *Operation) {
+				mu.Lock()
+				progress = append(progress, op.Kind().String()+":"+op.State().String())
+				mu.Unlock()
+			}))
+		t.FailureIsFatal().CmpNoError(err)
+
+		waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		t.CmpNoError(op.Wait(waitCtx))
+
+		mu.Lock()
+		t.Cmp(progress, []string{"refresh:done", "write:done"})
+		mu.Unlock()
+	})
+
+	t.Run("not verified", func(t *td.T) {
+		ts := newVerifyServer(map[string]string{"30": "25"})
+		defer ts.Close()
+		v := NewSession(WithURL(ts.URL), withTestPollPolicy(0, time.Minute))
+
+		ch, err := newDevice().WriteDataWait(v, HeizNormalTempM1, "30")
+		t.FailureIsFatal().CmpNoError(err)
+		t.CmpNoError(<-ch)
+		t.Cmp(ts.Actions(), []string{"WriteData", "RequestWriteStatus"})
+	})
+
+	t.Run("rollback", func(t *td.T) {
+		ts := newVerifyServer(map[string]string{"30": "25"})
+		defer ts.Close()
+		v := NewSession(WithURL(ts.URL), withTestPollPolicy(0, time.Minute))
+
+		d := newDevice()
+		d.Attributes = map[AttrID]*Value{
+			HeizReduziertTempM1: {Value: "16"},
+			HeizNormalTempM1:    {Value: "20"},
+		}
+		report, err := d.WriteMany(ctx, v, []AttrValue{
+			{AttrID: HeizReduziertTempM1, Value: "17"},
+			{AttrID: HeizNormalTempM1, Value: "30"},
+		}, WithRollback(true), WithOperationOptions(WithVerify(true)))
+		t.True(errors.Is(err, ErrMismatch))
+		t.True(report[0].RolledBack)
+		t.CmpNoError(report[0].RollbackErr)
+		t.False(report[1].RolledBack)
+	})
+}
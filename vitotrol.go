@@ -2,54 +2,248 @@ package vitotrol
 
 import (
 	"bytes"
+	"context"
 	"encoding/xml"
-	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"net/url"
 	"sort"
+	"sync"
+	"time"
 )
 
-// MainURL is the Viessmann Vitodata API URL.
+// MainURL is the Viessmann Vitodata API URL. It is the default
+// endpoint used by sessions not created with the WithURL option.
 var MainURL = `https://vitotrolapp.viessmann-climatesolutions.com/app_vitodata/VIIWebService-1.16.0.0/iPhoneWebService.asmx`
 
-const (
-	soapURL = `http://www.e-controlnet.de/services/vii/`
+const soapURL = `http://www.e-controlnet.de/services/vii/`
+
+// soapEnvelope is the SOAP envelope wrapping each request. Content
+// is marshaled using encoding/xml, so each request field is
+// correctly escaped.
+type soapEnvelope struct {
+	XMLName xml.Name `xml:"soap:Envelope"`
+	XSI     string   `xml:"xmlns:xsi,attr"`
+	XSD     string   `xml:"xmlns:xsd,attr"`
+	Soap    string   `xml:"xmlns:soap,attr"`
+	NS      string   `xml:"xmlns,attr"`
+	Body    struct {
+		Content interface{}
+	} `xml:"soap:Body"`
+}
 
-	reqHeader = `<?xml version="1.0" encoding="UTF-8"?><soap:Envelope xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema" xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns="` + soapURL + `">
-<soap:Body>
-`
-	reqFooter = `
-</soap:Body>
-</soap:Envelope>`
-)
+// marshalRequest returns the complete SOAP XML document embedding
+// reqBody.
+func marshalRequest(reqBody interface{}) ([]byte, error) {
+	env := soapEnvelope{
+		XSI:  "http://www.w3.org/2001/XMLSchema-instance",
+		XSD:  "http://www.w3.org/2001/XMLSchema",
+		Soap: "http://schemas.xmlsoap.org/soap/envelope/",
+		NS:   soapURL,
+	}
+	env.Body.Content = reqBody
+
+	buf := bytes.NewBufferString(`<?xml version="1.0" encoding="UTF-8"?>`)
+	err := xml.NewEncoder(buf).Encode(&env)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
 
 // Session keep a cache of all informations downloaded from the
 // Vitotrol™ server. See Login method as entry point.
+//
+// A zero Session is usable and talks to MainURL using
+// http.DefaultClient. Use NewSession to customize its endpoint and
+// HTTP transport.
+//
+// A Session can be used concurrently by several goroutines. In this
+// case, Cookies and Devices fields should not be accessed directly,
+// but using DeviceList method instead.
 type Session struct {
 	Cookies []string
 
 	Devices []Device
 
+	// Debug makes the session log debug events, including the
+	// response bodies, see WithLogger option.
 	Debug bool
+
+	mu           sync.RWMutex // protects Cookies, Devices, credentials & loginGen
+	loginMu      sync.Mutex   // serializes re-authentications
+	loginGen     uint64       // incremented after each successful Login
+	credentials  Credentials
+	fixedCreds   bool // credentials set by WithCredentials option
+	retryPolicy  *RetryPolicy
+	pollPolicies map[OperationKind]*PollPolicy
+	resultErrors map[int]error
+	url          string
+	client       *http.Client
+	userAgent    string
+	log          Logger
+	observers    []DataObserver
+}
+
+// A SessionOption allows to customize a Session created by NewSession.
+type SessionOption func(*sessionConfig)
+
+type sessionConfig struct {
+	url       string
+	client    *http.Client
+	transport http.RoundTripper
+	proxy     *url.URL
+	userAgent string
+	debug     bool
+	logger    Logger
+	observers []DataObserver
+
+	credentials  Credentials
+	retryPolicy  *RetryPolicy
+	pollPolicies map[OperationKind]*PollPolicy
+	resultErrors map[int]error
+}
+
+// WithURL sets the Vitodata™ endpoint URL of the session instead of
+// MainURL.
+func WithURL(endpoint string) SessionOption {
+	return func(c *sessionConfig) {
+		c.url = endpoint
+	}
+}
+
+// WithHTTPClient sets the HTTP client used by the session instead of
+// http.DefaultClient. The client is not modified, as a copy of it is
+// done if WithTransport or WithProxy options are also used.
+func WithHTTPClient(client *http.Client) SessionOption {
+	return func(c *sessionConfig) {
+		c.client = client
+	}
+}
+
+// WithTransport sets the HTTP transport used by the session. It takes
+// precedence over WithProxy option.
+func WithTransport(transport http.RoundTripper) SessionOption {
+	return func(c *sessionConfig) {
+		c.transport = transport
+	}
+}
+
+// WithProxy makes the session use the HTTP proxy proxyURL. Without
+// it, the proxy is determined by the environment (see
+// http.ProxyFromEnvironment) unless a specific HTTP client or
+// transport is used.
+func WithProxy(proxyURL *url.URL) SessionOption {
+	return func(c *sessionConfig) {
+		c.proxy = proxyURL
+	}
 }
 
-func (v *Session) sendRequest(soapAction string, reqBody string, respBody HasResultHeader) error {
-	client := &http.Client{}
+// WithUserAgent sets the User-Agent header sent with each request.
+func WithUserAgent(userAgent string) SessionOption {
+	return func(c *sessionConfig) {
+		c.userAgent = userAgent
+	}
+}
+
+// WithDebug sets the Debug field of the session.
+func WithDebug(debug bool) SessionOption {
+	return func(c *sessionConfig) {
+		c.debug = debug
+	}
+}
+
+// NewSession returns a new Session customized by opts. Login method
+// has to be called next.
+func NewSession(opts ...SessionOption) *Session {
+	var conf sessionConfig
+	for _, opt := range opts {
+		opt(&conf)
+	}
+
+	client := conf.client
+	if conf.transport != nil || conf.proxy != nil {
+		if client == nil {
+			client = &http.Client{}
+		} else {
+			clientCopy := *client
+			client = &clientCopy
+		}
+
+		if conf.transport != nil {
+			client.Transport = conf.transport
+		} else {
+			transport := http.DefaultTransport.(*http.Transport).Clone()
+			transport.Proxy = http.ProxyURL(conf.proxy)
+			client.Transport = transport
+		}
+	}
+
+	return &Session{
+		Debug:        conf.debug,
+		credentials:  conf.credentials,
+		fixedCreds:   conf.credentials != nil,
+		retryPolicy:  conf.retryPolicy,
+		pollPolicies: conf.pollPolicies,
+		resultErrors: conf.resultErrors,
+		url:          conf.url,
+		client:       client,
+		userAgent:    conf.userAgent,
+		log:          conf.logger,
+		observers:    conf.observers,
+	}
+}
+
+// URL returns the Vitodata™ endpoint URL used by the session.
+func (v *Session) URL() string {
+	if v.url != "" {
+		return v.url
+	}
+	return MainURL
+}
+
+func (v *Session) httpClient() *http.Client {
+	if v.client != nil {
+		return v.client
+	}
+	return http.DefaultClient
+}
+
+func (v *Session) sendRequest(ctx context.Context, soapAction string, reqBody interface{}, respBody HasResultHeader) (err error) {
+	reqBodyRaw, err := marshalRequest(reqBody)
+	if err != nil {
+		return err
+	}
 
-	req, err := http.NewRequest("POST", MainURL,
-		bytes.NewBuffer([]byte(reqHeader+reqBody+reqFooter)))
+	req, err := http.NewRequestWithContext(ctx, "POST", v.URL(),
+		bytes.NewReader(reqBodyRaw))
 	if err != nil {
 		return err
 	}
 
 	req.Header.Set("SOAPAction", soapURL+soapAction)
 	req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	if v.userAgent != "" {
+		req.Header.Set("User-Agent", v.userAgent)
+	}
+	v.mu.RLock()
 	for _, cookie := range v.Cookies {
 		req.Header.Add("Cookie", cookie)
 	}
+	v.mu.RUnlock()
+
+	start := time.Now()
+	logArgs := []interface{}{"action", soapAction}
+	defer func() {
+		logArgs = append(logArgs, "duration", time.Since(start))
+		logArgs = append(logArgs, requestLogArgs(reqBody, respBody)...)
+		if err != nil {
+			logArgs = append(logArgs, "error", err)
+		}
+		v.logger().Debug("soap request", logArgs...)
+	}()
 
-	resp, err := client.Do(req)
+	resp, err := v.httpClient().Do(req)
 	if err != nil {
 		return err
 	}
@@ -57,14 +251,17 @@ func (v *Session) sendRequest(soapAction string, reqBody string, respBody HasRes
 
 	respBodyRaw, _ := ioutil.ReadAll(resp.Body)
 
+	logArgs = append(logArgs, "status", resp.StatusCode)
+	if v.Debug {
+		logArgs = append(logArgs, "body", Redact(string(respBodyRaw)))
+	}
+
 	if resp.StatusCode == 200 {
 		cookies := resp.Header[http.CanonicalHeaderKey("Set-Cookie")]
 		if cookies != nil {
+			v.mu.Lock()
 			v.Cookies = cookies
-		}
-
-		if v.Debug {
-			log.Println(string(respBodyRaw))
+			v.mu.Unlock()
 		}
 
 		err = xml.Unmarshal(respBodyRaw, respBody)
@@ -72,21 +269,39 @@ func (v *Session) sendRequest(soapAction string, reqBody string, respBody HasRes
 			return err
 		}
 
-		// Applicative error
+		logArgs = append(logArgs, "result", respBody.ResultHeader().ErrorNum)
+
+		// Applicative error, copied as respBody can be reused by a retry
 		if respBody.ResultHeader().IsError() {
-			return respBody.ResultHeader()
+			result := *respBody.ResultHeader()
+			result.errors = v.resultErrors
+			err = &result
+			return err
 		}
 		return nil
 	}
 
-	return fmt.Errorf("HTTP error: [status=%d] %v (%+v)",
-		resp.StatusCode, respBodyRaw, resp.Header)
+	err = &HTTPError{
+		StatusCode: resp.StatusCode,
+		Body:       respBodyRaw,
+	}
+	return err
 }
 
 //
 // Login
 //
 
+// LoginRequest is a Login request.
+type LoginRequest struct {
+	XMLName    xml.Name `xml:"Login"`
+	AppID      string   `xml:"AppId"`
+	AppVersion string   `xml:"AppVersion"`
+	Password   string   `xml:"Passwort"`
+	System     string   `xml:"Betriebssystem"`
+	Login      string   `xml:"Benutzer"`
+}
+
 // LoginResponse is a response to a Login request.
 type LoginResponse struct {
 	LoginResult struct {
@@ -104,23 +319,54 @@ func (r *LoginResponse) ResultHeader() *ResultHeader {
 
 // Login authenticates the session on the Vitotrol™ server using the
 // Login request.
+//
+// Unless the session has been created with the WithCredentials
+// option, login and password are remembered to transparently
+// re-authenticate the session when the server reports it as expired.
 func (v *Session) Login(login, password string) error {
-	body := `<Login>
-<AppId>prod</AppId>
-<AppVersion>4.3.1</AppVersion>
-<Passwort>` + password + `</Passwort>
-<Betriebssystem>Android</Betriebssystem>
-<Benutzer>` + login + `</Benutzer>
-</Login>`
+	return v.LoginContext(context.Background(), login, password)
+}
+
+// LoginContext is the same as Login but honours ctx cancellation and
+// deadline.
+func (v *Session) LoginContext(ctx context.Context, login, password string) error {
+	err := v.login(ctx, login, password)
+	if err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	if !v.fixedCreds {
+		v.credentials = StaticCredentials(login, password)
+	}
+	v.mu.Unlock()
+
+	return nil
+}
+
+func (v *Session) login(ctx context.Context, login, password string) error {
+	body := LoginRequest{
+		AppID:      "prod",
+		AppVersion: "4.3.1",
+		Password:   password,
+		System:     "Android",
+		Login:      login,
+	}
 
+	v.mu.Lock()
 	v.Cookies = nil
+	v.mu.Unlock()
 
 	var resp LoginResponse
-	err := v.sendRequest("Login", body, &resp)
+	err := v.sendRequest(ctx, "Login", &body, &resp)
 	if err != nil {
 		return err
 	}
 
+	v.mu.Lock()
+	v.loginGen++
+	v.mu.Unlock()
+
 	return nil
 }
 
@@ -128,6 +374,11 @@ func (v *Session) Login(login, password string) error {
 // GetDevices
 //
 
+// GetDevicesRequest is a GetDevices request.
+type GetDevicesRequest struct {
+	XMLName xml.Name `xml:"GetDevices"`
+}
+
 type getDevicesDevices struct {
 	ID          uint32 `xml:"GeraetId"`
 	Name        string `xml:"GeraetName"`
@@ -157,18 +408,27 @@ func (r *GetDevicesResponse) ResultHeader() *ResultHeader {
 }
 
 // GetDevices launches the Vitotrol™ GetDevices request. Populates the
-// internal cache before returning (see Devices field).
+// internal cache before returning (see Devices field and DeviceList
+// method). Each call replaces the previously cached devices.
 func (v *Session) GetDevices() error {
+	return v.GetDevicesContext(context.Background())
+}
+
+// GetDevicesContext is the same as GetDevices but honours ctx
+// cancellation and deadline.
+func (v *Session) GetDevicesContext(ctx context.Context) error {
 	var resp GetDevicesResponse
-	err := v.sendRequest("GetDevices", "<GetDevices/>", &resp)
+	err := v.request(ctx, "GetDevices", &GetDevicesRequest{}, &resp, true)
 	if err != nil {
 		return err
 	}
 
+	var devices []Device
+
 	// 0 or 1 Location
 	for _, location := range resp.GetDevicesResult.Locations {
 		for _, device := range location.Devices {
-			v.Devices = append(v.Devices, Device{
+			devices = append(devices, Device{
 				LocationID:   location.ID,
 				LocationName: location.Name,
 				DeviceID:     device.ID,
@@ -182,20 +442,56 @@ func (v *Session) GetDevices() error {
 	}
 
 	// Make sure all devices are sorted
-	sort.SliceStable(v.Devices, func(i, j int) bool {
-		if v.Devices[i].LocationID == v.Devices[j].LocationID {
-			return v.Devices[i].DeviceID < v.Devices[j].DeviceID
+	sort.SliceStable(devices, func(i, j int) bool {
+		if devices[i].LocationID == devices[j].LocationID {
+			return devices[i].DeviceID < devices[j].DeviceID
 		}
-		return v.Devices[i].LocationID < v.Devices[j].LocationID
+		return devices[i].LocationID < devices[j].LocationID
 	})
 
+	v.mu.Lock()
+	// Keep the attribute registries of already known devices
+	for idx := range devices {
+		for jdx := range v.Devices {
+			old := &v.Devices[jdx]
+			if old.LocationID == devices[idx].LocationID && old.DeviceID == devices[idx].DeviceID {
+				old.mu.RLock()
+				devices[idx].registry = old.registry
+				old.mu.RUnlock()
+				break
+			}
+		}
+	}
+	v.Devices = devices
+	v.mu.Unlock()
+
 	return nil
 }
 
+// DeviceList returns the devices cached by the last GetDevices
+// call. Contrary to Devices field, it can be safely called while
+// GetDevices is running in another goroutine.
+func (v *Session) DeviceList() []*Device {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	list := make([]*Device, len(v.Devices))
+	for idx := range v.Devices {
+		list[idx] = &v.Devices[idx]
+	}
+	return list
+}
+
 //
 // RequestRefreshStatus
 //
 
+// RequestRefreshStatusRequest is a RequestRefreshStatus request.
+type RequestRefreshStatusRequest struct {
+	XMLName   xml.Name `xml:"RequestRefreshStatus"`
+	RefreshID string   `xml:"AktualisierungsId"`
+}
+
 // RequestRefreshStatusResponse is a response to a
 // RequestRefreshStatus request.
 type RequestRefreshStatusResponse struct {
@@ -214,12 +510,15 @@ func (r *RequestRefreshStatusResponse) ResultHeader() *ResultHeader {
 // request to follow the status of the RefreshData request matching
 // the passed refresh ID. Use RefreshDataWait instead.
 func (v *Session) RequestRefreshStatus(refreshID string) (int, error) {
+	return v.RequestRefreshStatusContext(context.Background(), refreshID)
+}
+
+// RequestRefreshStatusContext is the same as RequestRefreshStatus but
+// honours ctx cancellation and deadline.
+func (v *Session) RequestRefreshStatusContext(ctx context.Context, refreshID string) (int, error) {
 	var resp RequestRefreshStatusResponse
-	err := v.sendRequest("RequestRefreshStatus",
-		"<RequestRefreshStatus><AktualisierungsId>"+
-			refreshID+
-			"</AktualisierungsId></RequestRefreshStatus>",
-		&resp)
+	err := v.request(ctx, "RequestRefreshStatus",
+		&RequestRefreshStatusRequest{RefreshID: refreshID}, &resp, false)
 	if err != nil {
 		return 0, err
 	}
@@ -231,6 +530,12 @@ func (v *Session) RequestRefreshStatus(refreshID string) (int, error) {
 // RequestWriteStatus
 //
 
+// RequestWriteStatusRequest is a RequestWriteStatus request.
+type RequestWriteStatusRequest struct {
+	XMLName   xml.Name `xml:"RequestWriteStatus"`
+	RefreshID string   `xml:"AktualisierungsId"`
+}
+
 // RequestWriteStatusResponse is a response to a RequestWriteStatus request.
 type RequestWriteStatusResponse struct {
 	RequestWriteStatusResult struct {
@@ -248,12 +553,15 @@ func (r *RequestWriteStatusResponse) ResultHeader() *ResultHeader {
 // request to follow the status of the WriteData request matching
 // the passed refresh ID. Use WriteDataWait instead.
 func (v *Session) RequestWriteStatus(refreshID string) (int, error) {
+	return v.RequestWriteStatusContext(context.Background(), refreshID)
+}
+
+// RequestWriteStatusContext is the same as RequestWriteStatus but
+// honours ctx cancellation and deadline.
+func (v *Session) RequestWriteStatusContext(ctx context.Context, refreshID string) (int, error) {
 	var resp RequestWriteStatusResponse
-	err := v.sendRequest("RequestWriteStatus",
-		"<RequestWriteStatus><AktualisierungsId>"+
-			refreshID+
-			"</AktualisierungsId></RequestWriteStatus>",
-		&resp)
+	err := v.request(ctx, "RequestWriteStatus",
+		&RequestWriteStatusRequest{RefreshID: refreshID}, &resp, false)
 	if err != nil {
 		return 0, err
 	}
@@ -1,11 +1,14 @@
 package vitotrol
 
 import (
+	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"reflect"
 	"testing"
 
@@ -73,9 +76,80 @@ func testSendRequestAny(t *td.T,
 		}))
 	defer ts.Close()
 
-	MainURL = ts.URL
+	return sendReq(NewSession(WithURL(ts.URL)))
+}
+
+//
+// NewSession
+//
 
-	return sendReq(&Session{})
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestNewSession(tt *testing.T) {
+	t := td.NewT(tt)
+
+	// Zero Session & default NewSession use MainURL
+	t.CmpDeeply((&Session{}).URL(), MainURL)
+	t.CmpDeeply(NewSession().URL(), MainURL)
+	t.CmpDeeply(NewSession(WithDebug(true)).Debug, true)
+
+	// Two sessions, two endpoints
+	newServer := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				t.CmpDeeply(r.Header.Get("User-Agent"), "test-agent/"+name)
+				fmt.Fprintln(w, respHeader+`<TestResponse><TestResult>
+<Ergebnis>0</Ergebnis><Pipo>`+name+`</Pipo>
+</TestResult></TestResponse>`+respFooter)
+			}))
+	}
+	ts1 := newServer("one")
+	defer ts1.Close()
+	ts2 := newServer("two")
+	defer ts2.Close()
+
+	v1 := NewSession(WithURL(ts1.URL), WithUserAgent("test-agent/one"))
+	v2 := NewSession(WithURL(ts2.URL), WithUserAgent("test-agent/two"))
+	t.CmpDeeply(v1.URL(), ts1.URL)
+	t.CmpDeeply(v2.URL(), ts2.URL)
+
+	var resp1, resp2 TestResponse
+	if t.CmpNoError(v1.sendRequest(context.Background(), "foo", &testRequestBody{}, &resp1)) {
+		t.CmpDeeply(resp1.TestResult.Pipo, "one")
+	}
+	if t.CmpNoError(v2.sendRequest(context.Background(), "foo", &testRequestBody{}, &resp2)) {
+		t.CmpDeeply(resp2.TestResult.Pipo, "two")
+	}
+
+	// Custom transport, the passed client is not altered
+	var called bool
+	client := &http.Client{}
+	v := NewSession(
+		WithURL("http://vitodata.invalid/"),
+		WithHTTPClient(client),
+		WithTransport(roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			called = true
+			t.CmpDeeply(r.URL.String(), "http://vitodata.invalid/")
+			return nil, errors.New("no network")
+		})))
+	var resp TestResponse
+	t.CmpError(v.sendRequest(context.Background(), "foo", &testRequestBody{}, &resp))
+	t.True(called)
+	t.Nil(client.Transport)
+
+	// Proxy
+	proxyURL, _ := url.Parse("http://proxy.invalid:3128")
+	v = NewSession(WithProxy(proxyURL))
+	if t.Isa(v.httpClient().Transport, &http.Transport{}) {
+		req, _ := http.NewRequest("POST", MainURL, nil)
+		u, err := v.httpClient().Transport.(*http.Transport).Proxy(req)
+		t.CmpNoError(err)
+		t.CmpDeeply(u, proxyURL)
+	}
 }
 
 //
@@ -95,20 +169,65 @@ func (r *TestResponse) ResultHeader() *ResultHeader {
 	return &r.TestResult.ResultHeader
 }
 
-func TestSendRequestErrors(tt *testing.T) {
+type testRequestBody struct {
+	XMLName xml.Name `xml:"Test"`
+	Foo     string   `xml:"Foo"`
+	Bar     string   `xml:"Bar"`
+}
+
+func TestMarshalRequest(tt *testing.T) {
 	t := td.NewT(tt)
 
-	v := &Session{}
+	raw, err := marshalRequest(&LoginRequest{
+		AppID:    "prod",
+		Password: `a&b<c>d"e'f]]>`,
+		Login:    "</Benutzer><Benutzer>root",
+	})
+	if !t.CmpNoError(err) {
+		return
+	}
+
+	var envelope struct {
+		XMLName xml.Name
+		Body    struct {
+			XMLName xml.Name
+			Login   struct {
+				XMLName  xml.Name
+				AppID    string   `xml:"AppId"`
+				Password string   `xml:"Passwort"`
+				Login    []string `xml:"Benutzer"`
+			} `xml:"Login"`
+		} `xml:"Body"`
+	}
+	if !t.CmpNoError(xml.Unmarshal(raw, &envelope)) {
+		return
+	}
+
+	soapNS := "http://schemas.xmlsoap.org/soap/envelope/"
+	t.CmpDeeply(envelope.XMLName, xml.Name{Space: soapNS, Local: "Envelope"})
+	t.CmpDeeply(envelope.Body.XMLName, xml.Name{Space: soapNS, Local: "Body"})
+	t.CmpDeeply(envelope.Body.Login.XMLName, xml.Name{Space: soapURL, Local: "Login"})
+	t.CmpDeeply(envelope.Body.Login.AppID, "prod")
+	t.CmpDeeply(envelope.Body.Login.Password, `a&b<c>d"e'f]]>`)
+	t.CmpDeeply(envelope.Body.Login.Login, []string{"</Benutzer><Benutzer>root"})
+
+	// Unmarshalable content
+	_, err = marshalRequest(make(chan int))
+	t.CmpError(err)
+}
+
+func TestSendRequestErrors(tt *testing.T) {
+	t := td.NewT(tt)
 
 	// bad URL -> parse URL will fail
-	MainURL = ":"
+	v := NewSession(WithURL(":"))
 	var resp TestResponse
-	err := v.sendRequest("bad", `<xxx></xxx>`, &resp)
+	err := v.sendRequest(context.Background(), "bad", &testRequestBody{}, &resp)
 	t.CmpError(err)
 
 	// bad scheme -> Do request will fail
-	MainURL = "bad-scheme:..."
-	err = v.sendRequest("bad", `<xxx></xxx>`, &resp)
+	v = NewSession(WithURL("bad-scheme:..."))
+	err = v.sendRequest(context.Background(), "bad", &testRequestBody{}, &resp)
 	t.CmpError(err)
 
 	// HTTP status error
@@ -118,9 +237,9 @@ func TestSendRequestErrors(tt *testing.T) {
 		}))
 	defer ts.Close()
 
-	MainURL = ts.URL
-	err = v.sendRequest("bad", `<xxx></xxx>`, &resp)
-	t.CmpError(err)
+	v = NewSession(WithURL(ts.URL))
+	err = v.sendRequest(context.Background(), "bad", &testRequestBody{}, &resp)
+	t.CmpDeeply(err, &HTTPError{StatusCode: http.StatusInternalServerError, Body: []byte{}})
 }
 
 func TestSendRequest(tt *testing.T) {
@@ -138,11 +257,8 @@ func TestSendRequest(tt *testing.T) {
 			v.Cookies = []string{"foo=123", "bar=456"}
 
 			var resp TestResponse
-			err := v.sendRequest("foobar", `
-<Test>
-  <Foo>foo</Foo>
-  <Bar>bar</Bar>
-</Test>`, &resp)
+			err := v.sendRequest(context.Background(), "foobar",
+				&testRequestBody{Foo: "foo", Bar: "bar"}, &resp)
 			if !t.CmpNoError(err) {
 				return false
 			}
@@ -181,11 +297,8 @@ func TestSendRequest(tt *testing.T) {
 			v.Debug = true
 
 			var resp TestResponse
-			err := v.sendRequest("foobar", `
-<Test>
-  <Foo>foo</Foo>
-  <Bar>bar</Bar>
-</Test>`, &resp)
+			err := v.sendRequest(context.Background(), "foobar",
+				&testRequestBody{Foo: "foo", Bar: "bar"}, &resp)
 			return t.CmpError(err)
 		},
 		// SOAP action
@@ -204,11 +317,8 @@ func TestSendRequest(tt *testing.T) {
 		// Send request and check result
 		func(v *Session) bool {
 			var resp TestResponse
-			err := v.sendRequest("foobar", `
-<Test>
-  <Foo>foo</Foo>
-  <Bar>bar</Bar>
-</Test>`, &resp)
+			err := v.sendRequest(context.Background(), "foobar",
+				&testRequestBody{Foo: "foo", Bar: "bar"}, &resp)
 			if !t.CmpError(err) || !t.Isa(err, &ResultHeader{}) {
 				return false
 			}
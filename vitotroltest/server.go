@@ -0,0 +1,819 @@
+// Package vitotroltest provides a local Vitodata™ SOAP server
+// simulator, allowing to exercise the vitotrol package and the
+// vitotrol command without any network access.
+//
+//	srv := vitotroltest.NewServer(
+//	  vitotroltest.WithCredentials("login", "password"),
+//	  vitotroltest.WithDevice(vitotroltest.NewDevice(1234, 5678)))
+//	defer srv.Close()
+//
+//	v := vitotrol.NewSession(vitotrol.WithURL(srv.URL))
+//	err := v.Login("login", "password")
+//
+// Its errors use the ResultHeader error numbers assumed by the
+// vitotrol package (see vitotrol.ResultOK), not numbers checked
+// against the real server.
+package vitotroltest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TomTom68/go-vitotrol"
+)
+
+const (
+	soapURL    = `http://www.e-controlnet.de/services/vii/`
+	cookieName = "ASP.NET_SessionId"
+)
+
+// Asynchronous operation statuses as returned by RequestRefreshStatus
+// and RequestWriteStatus.
+const (
+	StatusInProgress = 1
+	StatusDone       = 4
+)
+
+// Server is a stateful simulated Vitodata™ server. Use NewServer to
+// create one.
+type Server struct {
+	// URL is the base URL of the server, to be used with vitotrol.WithURL.
+	URL string
+
+	srv *httptest.Server
+
+	mu             sync.Mutex
+	login          string
+	password       string
+	writeLatency   time.Duration
+	refreshLatency time.Duration
+	sessionTTL     time.Duration
+	devices        []*Device
+	sessions       map[string]time.Time // session ID -> expiration time
+	lastSession    int
+	operations     map[string]*operation
+	lastOperation  int
+	failures       map[string][]failure
+	calls          map[string]int
+}
+
+// Device is a simulated device.
+type Device struct {
+	LocationID   uint32
+	LocationName string
+	DeviceID     uint32
+	DeviceName   string
+	HasError     bool
+	IsConnected  bool
+
+	// Values contains the Vitodata™ formatted value of each attribute.
+	Values map[vitotrol.AttrID]string
+	// Timesheets contains the timesheets of the device.
+	Timesheets map[vitotrol.TimesheetID]map[string]vitotrol.TimeslotSlice
+	// Errors is the error history of the device.
+	Errors []vitotrol.ErrorHistoryEvent
+	// TypeInfo is returned by GetTypeInfo. If nil, it is computed
+	// from vitotrol.AttributesRef for each attribute of Values.
+	TypeInfo []*vitotrol.AttributeInfo
+
+	times map[vitotrol.AttrID]time.Time
+}
+
+// NewDevice returns a connected Device having all attributes of
+// vitotrol.AttributesRef set to a valid value and all timesheets of
+// vitotrol.TimesheetsRef set to 06:00 - 22:00 each day.
+func NewDevice(deviceID, locationID uint32) *Device {
+	d := &Device{
+		LocationID:   locationID,
+		LocationName: fmt.Sprintf("Location %d", locationID),
+		DeviceID:     deviceID,
+		DeviceName:   fmt.Sprintf("Device %d", deviceID),
+		IsConnected:  true,
+		Values:       make(map[vitotrol.AttrID]string, len(vitotrol.AttributesRef)),
+		Timesheets:   make(map[vitotrol.TimesheetID]map[string]vitotrol.TimeslotSlice, len(vitotrol.TimesheetsRef)),
+	}
+
+	for attrID, pRef := range vitotrol.AttributesRef {
+		var value string
+		switch pRef.Type.(type) {
+		case *vitotrol.VitodataDouble:
+			value = "20.5"
+		case *vitotrol.VitodataInteger:
+			value = "20"
+		case *vitotrol.VitodataDate:
+			value = vitotrol.Time(time.Date(2022, 1, 1, 12, 0, 0, 0, time.Local)).String()
+		case *vitotrol.VitodataEnum:
+			value = "0"
+		}
+		d.Values[attrID] = value
+	}
+
+	for tsID := range vitotrol.TimesheetsRef {
+		d.Timesheets[tsID] = map[string]vitotrol.TimeslotSlice{}
+		for _, day := range []string{"mon", "tue", "wed", "thu", "fri", "sat", "sun"} {
+			d.Timesheets[tsID][day] = vitotrol.TimeslotSlice{{From: 600, To: 2200}}
+		}
+	}
+
+	return d
+}
+
+type operation struct {
+	write bool
+	ready time.Time
+	apply func()
+	done  bool
+}
+
+type failure struct {
+	errorNum   int
+	httpStatus int
+}
+
+// An Option allows to customize a Server created by NewServer.
+type Option func(*Server)
+
+// WithCredentials makes the server only accept login and password in
+// Login requests. Without this option any credentials are accepted.
+func WithCredentials(login, password string) Option {
+	return func(s *Server) {
+		s.login = login
+		s.password = password
+	}
+}
+
+// WithDevice adds a device to the server.
+func WithDevice(d *Device) Option {
+	return func(s *Server) {
+		s.devices = append(s.devices, d)
+	}
+}
+
+// WithWriteLatency sets the time needed by WriteData and
+// WriteTimesheetData operations before being reported as done by
+// RequestWriteStatus. Written values are only visible after this
+// delay. Default is 0.
+func WithWriteLatency(latency time.Duration) Option {
+	return func(s *Server) {
+		s.writeLatency = latency
+	}
+}
+
+// WithRefreshLatency sets the time needed by RefreshData operations
+// before being reported as done by RequestRefreshStatus. Default is 0.
+func WithRefreshLatency(latency time.Duration) Option {
+	return func(s *Server) {
+		s.refreshLatency = latency
+	}
+}
+
+// WithSessionTTL sets the lifetime of sessions created by Login. 0,
+// the default, means sessions never expire. See also ExpireSessions.
+func WithSessionTTL(ttl time.Duration) Option {
+	return func(s *Server) {
+		s.sessionTTL = ttl
+	}
+}
+
+// NewServer starts and returns a new Server. The caller should call
+// Close when finished, to shut it down.
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		sessions:   map[string]time.Time{},
+		operations: map[string]*operation{},
+		failures:   map[string][]failure{},
+		calls:      map[string]int{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	for _, d := range s.devices {
+		d.init()
+	}
+
+	s.srv = httptest.NewServer(http.HandlerFunc(s.handle))
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+func (d *Device) init() {
+	if d.Values == nil {
+		d.Values = map[vitotrol.AttrID]string{}
+	}
+	if d.Timesheets == nil {
+		d.Timesheets = map[vitotrol.TimesheetID]map[string]vitotrol.TimeslotSlice{}
+	}
+	d.times = make(map[vitotrol.AttrID]time.Time, len(d.Values))
+	now := time.Now()
+	for attrID := range d.Values {
+		d.times[attrID] = now
+	}
+}
+
+// AddDevice adds a device to the server.
+func (s *Server) AddDevice(d *Device) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d.init()
+	s.devices = append(s.devices, d)
+}
+
+func (s *Server) device(deviceID, locationID uint32) *Device {
+	for _, d := range s.devices {
+		if d.DeviceID == deviceID && d.LocationID == locationID {
+			return d
+		}
+	}
+	return nil
+}
+
+// SetValue sets the Vitodata™ formatted value of attribute attrID of
+// device deviceID, as if the boiler changed it. It returns false if
+// the device does not exist.
+func (s *Server) SetValue(deviceID uint32, attrID vitotrol.AttrID, value string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range s.devices {
+		if d.DeviceID == deviceID {
+			d.Values[attrID] = value
+			d.times[attrID] = time.Now()
+			return true
+		}
+	}
+	return false
+}
+
+// Value returns the current Vitodata™ formatted value of attribute
+// attrID of device deviceID.
+func (s *Server) Value(deviceID uint32, attrID vitotrol.AttrID) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range s.devices {
+		if d.DeviceID == deviceID {
+			value, ok := d.Values[attrID]
+			return value, ok
+		}
+	}
+	return "", false
+}
+
+// Timesheet returns a copy of the timesheet id of device deviceID.
+func (s *Server) Timesheet(deviceID uint32, id vitotrol.TimesheetID) map[string]vitotrol.TimeslotSlice {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range s.devices {
+		if d.DeviceID == deviceID {
+			ts := make(map[string]vitotrol.TimeslotSlice, len(d.Timesheets[id]))
+			for day, slots := range d.Timesheets[id] {
+				ts[day] = append(vitotrol.TimeslotSlice(nil), slots...)
+			}
+			return ts
+		}
+	}
+	return nil
+}
+
+// Fail makes the next count calls of soapAction (for example
+// "GetData") fail with the ResultHeader error number errorNum.
+func (s *Server) Fail(soapAction string, errorNum, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for ; count > 0; count-- {
+		s.failures[soapAction] = append(s.failures[soapAction], failure{errorNum: errorNum})
+	}
+}
+
+// FailHTTP makes the next count calls of soapAction (for example
+// "GetData") fail with the HTTP status httpStatus.
+func (s *Server) FailHTTP(soapAction string, httpStatus, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for ; count > 0; count-- {
+		s.failures[soapAction] = append(s.failures[soapAction], failure{httpStatus: httpStatus})
+	}
+}
+
+// ExpireSessions invalidates all the sessions created until now, as
+// if their cookies expired.
+func (s *Server) ExpireSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions = map[string]time.Time{}
+}
+
+// Calls returns the number of times soapAction has been called.
+func (s *Server) Calls(soapAction string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.calls[soapAction]
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	soapAction := r.Header.Get("SOAPAction")
+	if !strings.HasPrefix(soapAction, soapURL) {
+		http.Error(w, "bad SOAPAction header", http.StatusBadRequest)
+		return
+	}
+	soapAction = strings.TrimPrefix(soapAction, soapURL)
+
+	var envelope struct {
+		Body struct {
+			Content []byte `xml:",innerxml"`
+		} `xml:"Body"`
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = xml.Unmarshal(body, &envelope)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls[soapAction]++
+
+	if fails := s.failures[soapAction]; len(fails) > 0 {
+		s.failures[soapAction] = fails[1:]
+		if fails[0].httpStatus != 0 {
+			http.Error(w, http.StatusText(fails[0].httpStatus), fails[0].httpStatus)
+			return
+		}
+		writeResponse(w, soapAction, fails[0].errorNum, "")
+		return
+	}
+
+	handler := handlers[soapAction]
+	if handler == nil {
+		http.Error(w, "unknown SOAPAction "+soapAction, http.StatusBadRequest)
+		return
+	}
+
+	if soapAction != "Login" && !s.checkSession(r) {
+		writeResponse(w, soapAction, vitotrol.ResultSessionExpired, "")
+		return
+	}
+
+	errorNum, content := handler(s, w, envelope.Body.Content)
+	writeResponse(w, soapAction, errorNum, content)
+}
+
+func (s *Server) checkSession(r *http.Request) bool {
+	cookie, err := r.Cookie(cookieName)
+	if err != nil {
+		return false
+	}
+
+	expires, ok := s.sessions[cookie.Value]
+	if !ok {
+		return false
+	}
+	if !expires.IsZero() && time.Now().After(expires) {
+		delete(s.sessions, cookie.Value)
+		return false
+	}
+	return true
+}
+
+func writeResponse(w http.ResponseWriter, soapAction string, errorNum int, content string) {
+	errorStr := "Kein Fehler"
+	if errorNum != vitotrol.ResultOK {
+		errorStr = fmt.Sprintf("Fehler %d", errorNum)
+	}
+
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>`+
+		`<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">`+
+		`<soap:Body><%[1]sResponse xmlns="%[2]s"><%[1]sResult>`+
+		`<Ergebnis>%[3]d</Ergebnis><ErgebnisText>%[4]s</ErgebnisText>%[5]s`+
+		`</%[1]sResult></%[1]sResponse></soap:Body></soap:Envelope>`,
+		soapAction, soapURL, errorNum, errorStr, content)
+}
+
+func escape(s string) string {
+	var buf strings.Builder
+	xml.EscapeText(&buf, []byte(s)) //nolint: errcheck
+	return buf.String()
+}
+
+type handler func(s *Server, w http.ResponseWriter, body []byte) (errorNum int, content string)
+
+var handlers map[string]handler
+
+func init() {
+	handlers = map[string]handler{
+		"Login":                (*Server).handleLogin,
+		"GetDevices":           (*Server).handleGetDevices,
+		"GetData":              (*Server).handleGetData,
+		"WriteData":            (*Server).handleWriteData,
+		"RefreshData":          (*Server).handleRefreshData,
+		"RequestRefreshStatus": (*Server).handleRequestRefreshStatus,
+		"RequestWriteStatus":   (*Server).handleRequestWriteStatus,
+		"GetErrorHistory":      (*Server).handleGetErrorHistory,
+		"GetTimesheetData":     (*Server).handleGetTimesheetData,
+		"WriteTimesheetData":   (*Server).handleWriteTimesheetData,
+		"GetTypeInfo":          (*Server).handleGetTypeInfo,
+	}
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, body []byte) (int, string) {
+	var req vitotrol.LoginRequest
+	if xml.Unmarshal(body, &req) != nil {
+		return vitotrol.ResultAuthFailed, ""
+	}
+
+	if s.login != "" && (req.Login != s.login || req.Password != s.password) {
+		return vitotrol.ResultAuthFailed, ""
+	}
+
+	s.lastSession++
+	sessionID := strconv.Itoa(s.lastSession)
+
+	var expires time.Time
+	if s.sessionTTL > 0 {
+		expires = time.Now().Add(s.sessionTTL)
+	}
+	s.sessions[sessionID] = expires
+
+	http.SetCookie(w, &http.Cookie{Name: cookieName, Value: sessionID})
+
+	return vitotrol.ResultOK,
+		`<TechVersion>2.5.6.0</TechVersion><Vorname>Test</Vorname><Nachname>User</Nachname>`
+}
+
+func (s *Server) handleGetDevices(w http.ResponseWriter, body []byte) (int, string) {
+	locations := map[uint32][]*Device{}
+	var locationIDs []uint32
+	for _, d := range s.devices {
+		if locations[d.LocationID] == nil {
+			locationIDs = append(locationIDs, d.LocationID)
+		}
+		locations[d.LocationID] = append(locations[d.LocationID], d)
+	}
+
+	var buf strings.Builder
+	buf.WriteString("<AnlageListe>")
+	for _, locationID := range locationIDs {
+		devices := locations[locationID]
+		fmt.Fprintf(&buf,
+			"<AnlageV2><AnlageId>%d</AnlageId><AnlageName>%s</AnlageName><GeraeteListe>",
+			locationID, escape(devices[0].LocationName))
+		for _, d := range devices {
+			fmt.Fprintf(&buf,
+				"<GeraetV2><GeraetId>%d</GeraetId><GeraetName>%s</GeraetName>"+
+					"<HatFehler>%t</HatFehler><IstVerbunden>%t</IstVerbunden></GeraetV2>",
+				d.DeviceID, escape(d.DeviceName), d.HasError, d.IsConnected)
+		}
+		buf.WriteString("</GeraeteListe><HatFehler>false</HatFehler>" +
+			"<IstVerbunden>true</IstVerbunden></AnlageV2>")
+	}
+	buf.WriteString("</AnlageListe>")
+
+	return vitotrol.ResultOK, buf.String()
+}
+
+func (s *Server) deviceFromHeader(h vitotrol.DeviceHeader) (*Device, int) {
+	d := s.device(h.DeviceID, h.LocationID)
+	if d == nil {
+		return nil, vitotrol.ResultUnknownDatapoint
+	}
+	return d, vitotrol.ResultOK
+}
+
+func (s *Server) handleGetData(w http.ResponseWriter, body []byte) (int, string) {
+	var req vitotrol.GetDataRequest
+	if err := xml.Unmarshal(body, &req); err != nil {
+		return vitotrol.ResultUnknownDatapoint, ""
+	}
+
+	d, errorNum := s.deviceFromHeader(req.DeviceHeader)
+	if d == nil {
+		return errorNum, ""
+	}
+
+	var buf strings.Builder
+	buf.WriteString("<DatenwerteListe>")
+	for _, attrID := range req.AttrIDs {
+		value, ok := d.Values[attrID]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&buf,
+			"<WerteListe><DatenpunktId>%d</DatenpunktId><Wert>%s</Wert>"+
+				"<Zeitstempel>%s</Zeitstempel></WerteListe>",
+			attrID, escape(value), vitotrol.Time(d.times[attrID]))
+	}
+	buf.WriteString("</DatenwerteListe>")
+
+	return vitotrol.ResultOK, buf.String()
+}
+
+func (s *Server) newOperation(write bool, apply func()) string {
+	latency := s.refreshLatency
+	if write {
+		latency = s.writeLatency
+	}
+
+	s.lastOperation++
+	refreshID := strconv.Itoa(s.lastOperation)
+	s.operations[refreshID] = &operation{
+		write: write,
+		ready: time.Now().Add(latency),
+		apply: apply,
+	}
+	return refreshID
+}
+
+func (s *Server) handleWriteData(w http.ResponseWriter, body []byte) (int, string) {
+	var req vitotrol.WriteDataRequest
+	if err := xml.Unmarshal(body, &req); err != nil {
+		return vitotrol.ResultUnknownDatapoint, ""
+	}
+
+	d, errorNum := s.deviceFromHeader(req.DeviceHeader)
+	if d == nil {
+		return errorNum, ""
+	}
+	if !d.IsConnected {
+		return vitotrol.ResultDeviceNotConnected, ""
+	}
+	if _, ok := d.Values[req.AttrID]; !ok {
+		return vitotrol.ResultUnknownDatapoint, ""
+	}
+
+	refreshID := s.newOperation(true, func() {
+		d.Values[req.AttrID] = req.Value
+		d.times[req.AttrID] = time.Now()
+	})
+	return vitotrol.ResultOK, "<AktualisierungsId>" + refreshID + "</AktualisierungsId>"
+}
+
+func (s *Server) handleRefreshData(w http.ResponseWriter, body []byte) (int, string) {
+	var req vitotrol.RefreshDataRequest
+	if err := xml.Unmarshal(body, &req); err != nil {
+		return vitotrol.ResultUnknownDatapoint, ""
+	}
+
+	d, errorNum := s.deviceFromHeader(req.DeviceHeader)
+	if d == nil {
+		return errorNum, ""
+	}
+	if !d.IsConnected {
+		return vitotrol.ResultDeviceNotConnected, ""
+	}
+
+	refreshID := s.newOperation(false, func() {
+		now := time.Now()
+		for _, attrID := range req.AttrIDs {
+			if _, ok := d.Values[attrID]; ok {
+				d.times[attrID] = now
+			}
+		}
+	})
+	return vitotrol.ResultOK, "<AktualisierungsId>" + refreshID + "</AktualisierungsId>"
+}
+
+func (s *Server) requestStatus(body []byte, write bool) (int, string) {
+	var req struct {
+		RefreshID string `xml:"AktualisierungsId"`
+	}
+	if err := xml.Unmarshal(body, &req); err != nil {
+		return vitotrol.ResultUnknownDatapoint, ""
+	}
+
+	op := s.operations[req.RefreshID]
+	if op == nil || op.write != write {
+		return vitotrol.ResultUnknownDatapoint, ""
+	}
+
+	status := StatusInProgress
+	if !time.Now().Before(op.ready) {
+		if !op.done {
+			op.apply()
+			op.done = true
+		}
+		status = StatusDone
+	}
+	return vitotrol.ResultOK, fmt.Sprintf("<Status>%d</Status>", status)
+}
+
+func (s *Server) handleRequestRefreshStatus(w http.ResponseWriter, body []byte) (int, string) {
+	return s.requestStatus(body, false)
+}
+
+func (s *Server) handleRequestWriteStatus(w http.ResponseWriter, body []byte) (int, string) {
+	return s.requestStatus(body, true)
+}
+
+func (s *Server) handleGetErrorHistory(w http.ResponseWriter, body []byte) (int, string) {
+	var req vitotrol.GetErrorHistoryRequest
+	if err := xml.Unmarshal(body, &req); err != nil {
+		return vitotrol.ResultUnknownDatapoint, ""
+	}
+
+	d, errorNum := s.deviceFromHeader(req.DeviceHeader)
+	if d == nil {
+		return errorNum, ""
+	}
+
+	var buf strings.Builder
+	buf.WriteString("<FehlerListe>")
+	for _, event := range d.Errors {
+		fmt.Fprintf(&buf,
+			"<FehlerHistorie><FehlerCode>%s</FehlerCode><FehlerMeldung>%s</FehlerMeldung>"+
+				"<Zeitstempel>%s</Zeitstempel><FehlerIstAktiv>%t</FehlerIstAktiv></FehlerHistorie>",
+			escape(event.Error), escape(event.Message), event.Time, event.IsActive)
+	}
+	buf.WriteString("</FehlerListe>")
+
+	return vitotrol.ResultOK, buf.String()
+}
+
+func (s *Server) handleGetTimesheetData(w http.ResponseWriter, body []byte) (int, string) {
+	var req vitotrol.GetTimesheetDataRequest
+	if err := xml.Unmarshal(body, &req); err != nil {
+		return vitotrol.ResultUnknownDatapoint, ""
+	}
+
+	d, errorNum := s.deviceFromHeader(req.DeviceHeader)
+	if d == nil {
+		return errorNum, ""
+	}
+
+	ts, ok := d.Timesheets[req.TimesheetID]
+	if !ok {
+		return vitotrol.ResultUnknownDatapoint, ""
+	}
+
+	days := make([]string, 0, len(ts))
+	for day := range ts {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "<SchaltsatzDaten><DatenpunktId>%d</DatenpunktId><Schaltzeiten>",
+		req.TimesheetID)
+	for _, day := range days {
+		for _, slot := range ts[day] {
+			fmt.Fprintf(&buf,
+				"<Schaltzeit><Wochentag>%s</Wochentag>"+
+					"<ZeitVon>%d</ZeitVon><ZeitBis>%d</ZeitBis></Schaltzeit>",
+				strings.ToUpper(day[:1])+day[1:], slot.From, slot.To)
+		}
+	}
+	buf.WriteString("</Schaltzeiten></SchaltsatzDaten>")
+
+	return vitotrol.ResultOK, buf.String()
+}
+
+func (s *Server) handleWriteTimesheetData(w http.ResponseWriter, body []byte) (int, string) {
+	var req vitotrol.WriteTimesheetDataRequest
+	if err := xml.Unmarshal(body, &req); err != nil {
+		return vitotrol.ResultUnknownDatapoint, ""
+	}
+
+	d, errorNum := s.deviceFromHeader(req.Data.DeviceHeader)
+	if d == nil {
+		return errorNum, ""
+	}
+	if !d.IsConnected {
+		return vitotrol.ResultDeviceNotConnected, ""
+	}
+
+	ts := map[string]vitotrol.TimeslotSlice{}
+	for _, slot := range req.Data.Slots {
+		from, err1 := strconv.ParseUint(slot.From, 10, 16)
+		to, err2 := strconv.ParseUint(slot.To, 10, 16)
+		if err1 != nil || err2 != nil {
+			return vitotrol.ResultUnknownDatapoint, ""
+		}
+		day := strings.ToLower(slot.Day)
+		ts[day] = append(ts[day], vitotrol.Timeslot{From: uint16(from), To: uint16(to)})
+	}
+
+	refreshID := s.newOperation(true, func() {
+		d.Timesheets[req.Data.TimesheetID] = ts
+	})
+	return vitotrol.ResultOK, "<AktualisierungsId>" + refreshID + "</AktualisierungsId>"
+}
+
+func (s *Server) handleGetTypeInfo(w http.ResponseWriter, body []byte) (int, string) {
+	var req vitotrol.GetTypeInfoRequest
+	if err := xml.Unmarshal(body, &req); err != nil {
+		return vitotrol.ResultUnknownDatapoint, ""
+	}
+
+	d, errorNum := s.deviceFromHeader(req.DeviceHeader)
+	if d == nil {
+		return errorNum, ""
+	}
+
+	infos := d.TypeInfo
+	if infos == nil {
+		infos = typeInfoFromRefs(d)
+	}
+
+	var buf strings.Builder
+	buf.WriteString("<TypeInfoListe>")
+	writeInfo := func(id string, info *vitotrol.AttributeInfo, minValue string) {
+		fmt.Fprintf(&buf,
+			"<DatenpunktTypInfo><AnlageId>%d</AnlageId><GeraetId>%d</GeraetId>"+
+				"<DatenpunktId>%s</DatenpunktId><DatenpunktName>%s</DatenpunktName>"+
+				"<DatenpunktTyp>%s</DatenpunktTyp><DatenpunktTypWert>%d</DatenpunktTypWert>"+
+				"<MinimalWert>%s</MinimalWert><MaximalWert>%s</MaximalWert>"+
+				"<DatenpunktGruppe>%s</DatenpunktGruppe><HeizkreisId>%d</HeizkreisId>"+
+				"<Auslieferungswert>%s</Auslieferungswert>"+
+				"<IstLesbar>%t</IstLesbar><IstSchreibbar>%t</IstSchreibbar></DatenpunktTypInfo>",
+			d.LocationID, d.DeviceID,
+			id, escape(info.AttributeName),
+			escape(info.AttributeType), info.AttributeTypeValue,
+			escape(minValue), escape(info.MaxValue),
+			escape(info.DataPointGroup), info.HeatingCircuitID,
+			escape(info.DefaultValue),
+			info.Readable, info.Writable)
+	}
+	for _, info := range infos {
+		id := strconv.Itoa(int(info.AttributeID))
+		writeInfo(id, info, info.MinValue)
+
+		// Enum values follow their enum attribute
+		idxs := make([]int, 0, len(info.EnumValues))
+		for idx := range info.EnumValues {
+			idxs = append(idxs, int(idx))
+		}
+		sort.Ints(idxs)
+		for _, idx := range idxs {
+			writeInfo(fmt.Sprintf("%s-%d", id, idx), info, info.EnumValues[uint32(idx)])
+		}
+	}
+	buf.WriteString("</TypeInfoListe>")
+
+	return vitotrol.ResultOK, buf.String()
+}
+
+func typeInfoFromRefs(d *Device) []*vitotrol.AttributeInfo {
+	attrIDs := make([]int, 0, len(d.Values))
+	for attrID := range d.Values {
+		attrIDs = append(attrIDs, int(attrID))
+	}
+	sort.Ints(attrIDs)
+
+	infos := make([]*vitotrol.AttributeInfo, 0, len(attrIDs))
+	for _, id := range attrIDs {
+		attrID := vitotrol.AttrID(id)
+		pRef := vitotrol.AttributesRef[attrID]
+		if pRef == nil {
+			continue
+		}
+
+		info := &vitotrol.AttributeInfo{
+			AttributeInfoBase: vitotrol.AttributeInfoBase{
+				AttributeName: pRef.Name,
+				AttributeType: pRef.Type.Type(),
+				Readable:      pRef.Access&vitotrol.ReadOnly != 0,
+				Writable:      pRef.Access&vitotrol.WriteOnly != 0,
+			},
+			AttributeID: attrID,
+		}
+
+		if _, ok := pRef.Type.(*vitotrol.VitodataEnum); ok {
+			info.AttributeType = "ENUM"
+			info.EnumValues = map[uint32]string{}
+			for idx := uint32(0); ; idx++ {
+				value, err := pRef.Type.Vitodata2HumanValue(strconv.Itoa(int(idx)))
+				if err != nil {
+					break
+				}
+				info.EnumValues[idx] = value
+			}
+		}
+
+		infos = append(infos, info)
+	}
+	return infos
+}
@@ -0,0 +1,159 @@
+package vitotroltest_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	td "github.com/maxatome/go-testdeep"
+
+	"github.com/TomTom68/go-vitotrol"
+	"github.com/TomTom68/go-vitotrol/vitotroltest"
+)
+
+const (
+	testDeviceID   = 1234
+	testLocationID = 5678
+)
+
+func newSession(t *td.T, srv *vitotroltest.Server) (*vitotrol.Session, *vitotrol.Device) {
+	t.Helper()
+
+	v := vitotrol.NewSession(vitotrol.WithURL(srv.URL),
+		vitotrol.WithRetryPolicy(vitotrol.RetryPolicy{MaxAttempts: 2}),
+		vitotrol.WithPollPolicy(vitotrol.PollPolicy{MinInterval: time.Millisecond, Timeout: time.Minute}))
+
+	require := t.FailureIsFatal()
+	require.CmpNoError(v.Login("login", "password"))
+	require.CmpNoError(v.GetDevices())
+	devices := v.DeviceList()
+	require.Len(devices, 1)
+	return v, devices[0]
+}
+
+func TestServer(tt *testing.T) {
+	t := td.NewT(tt)
+
+	errTime, _ := vitotrol.ParseVitotrolTime("2022-01-02 03:04:05")
+	errEvents := []vitotrol.ErrorHistoryEvent{
+		{Error: "F4", Message: "Kein Brenner", Time: errTime, IsActive: true},
+	}
+
+	dev := vitotroltest.NewDevice(testDeviceID, testLocationID)
+	dev.Errors = errEvents
+
+	srv := vitotroltest.NewServer(
+		vitotroltest.WithCredentials("login", "password"),
+		vitotroltest.WithWriteLatency(20*time.Millisecond),
+		vitotroltest.WithDevice(dev))
+	defer srv.Close()
+
+	// Bad credentials
+	v := vitotrol.NewSession(vitotrol.WithURL(srv.URL))
+	t.CmpDeeply(v.Login("login", "bad"),
+		td.Smuggle(func(err error) bool { return errors.Is(err, vitotrol.ErrAuthFailed) }, true))
+
+	v, d := newSession(t, srv)
+	t.CmpDeeply(d.DeviceID, uint32(testDeviceID))
+	t.CmpDeeply(d.LocationID, uint32(testLocationID))
+	t.True(d.IsConnected)
+
+	// GetData & WriteDataWait
+	t.CmpNoError(d.GetData(v, []vitotrol.AttrID{vitotrol.AussenTemp}))
+	value, _ := d.Attribute(vitotrol.AussenTemp)
+	t.CmpDeeply(value.Value, "20.5")
+
+	srv.SetValue(testDeviceID, vitotrol.AussenTemp, "-3.5")
+	t.CmpNoError(d.GetData(v, []vitotrol.AttrID{vitotrol.AussenTemp}))
+	value, _ = d.Attribute(vitotrol.AussenTemp)
+	t.CmpDeeply(value.Value, "-3.5")
+
+	ch, err := d.WriteDataWait(v, vitotrol.HeizNormalTempM1, "21")
+	if t.CmpNoError(err) {
+		// not yet applied
+		srvValue, _ := srv.Value(testDeviceID, vitotrol.HeizNormalTempM1)
+		t.CmpDeeply(srvValue, "20.5")
+
+		t.CmpNoError(<-ch)
+		srvValue, _ = srv.Value(testDeviceID, vitotrol.HeizNormalTempM1)
+		t.CmpDeeply(srvValue, "21")
+	}
+
+	// RefreshDataWait
+	ch, err = d.RefreshDataWait(v, []vitotrol.AttrID{vitotrol.AussenTemp})
+	if t.CmpNoError(err) {
+		t.CmpNoError(<-ch)
+	}
+
+	// Errors
+	t.CmpNoError(d.GetErrorHistory(v))
+	t.CmpDeeply(d.ErrorsSnapshot(), errEvents)
+
+	// Timesheets
+	t.CmpNoError(d.GetTimesheetData(v, vitotrol.HeatingTimesheet))
+	t.CmpDeeply(d.Timesheet(vitotrol.HeatingTimesheet)["mon"],
+		vitotrol.TimeslotSlice{{From: 600, To: 2200}})
+
+	ch, err = d.WriteTimesheetDataWait(v, vitotrol.HeatingTimesheet,
+		map[string]vitotrol.TimeslotSlice{"mon-sun": {{From: 700, To: 2100}}})
+	if t.CmpNoError(err) {
+		t.CmpNoError(<-ch)
+		t.CmpDeeply(srv.Timesheet(testDeviceID, vitotrol.HeatingTimesheet)["sun"],
+			vitotrol.TimeslotSlice{{From: 700, To: 2100}})
+	}
+
+	// GetTypeInfo
+	infos, err := d.GetTypeInfo(v)
+	if t.CmpNoError(err) {
+		t.Len(infos, len(vitotrol.AttributesRef))
+		t.CmpDeeply(infos, td.Contains(&vitotrol.AttributeInfo{
+			AttributeInfoBase: vitotrol.AttributeInfoBase{
+				AttributeName: "BrennerStatus",
+				AttributeType: "ENUM",
+				Readable:      true,
+			},
+			AttributeID: vitotrol.BrennerStatus,
+			EnumValues:  map[uint32]string{0: "Aus", 1: "Ein"},
+		}))
+	}
+
+	// Failures
+	srv.Fail("GetData", vitotrol.ResultDeviceNotConnected, 1)
+	err = d.GetData(v, []vitotrol.AttrID{vitotrol.AussenTemp})
+	t.True(errors.Is(err, vitotrol.ErrDeviceNotConnected))
+
+	srv.FailHTTP("GetData", 503, 1)
+	t.CmpNoError(d.GetData(v, []vitotrol.AttrID{vitotrol.AussenTemp})) // retried
+
+	// Expired session: transparently re-authenticated
+	logins := srv.Calls("Login")
+	srv.ExpireSessions()
+	t.CmpNoError(d.GetData(v, []vitotrol.AttrID{vitotrol.AussenTemp}))
+	t.CmpDeeply(srv.Calls("Login"), logins+1)
+
+	// Unknown datapoint
+	_, err = d.WriteData(v, 9999, "1")
+	t.True(errors.Is(err, vitotrol.ErrUnknownDatapoint))
+}
+
+func TestServerSessionTTL(tt *testing.T) {
+	t := td.NewT(tt)
+
+	srv := vitotroltest.NewServer(
+		vitotroltest.WithSessionTTL(10*time.Millisecond),
+		vitotroltest.WithDevice(vitotroltest.NewDevice(testDeviceID, testLocationID)))
+	defer srv.Close()
+
+	v := vitotrol.NewSession(vitotrol.WithURL(srv.URL),
+		vitotrol.WithRetryPolicy(vitotrol.RetryPolicy{MaxAttempts: 1}))
+	t.CmpNoError(v.Login("any", "thing"))
+	t.CmpNoError(v.GetDevices())
+
+	time.Sleep(20 * time.Millisecond)
+
+	// Re-authenticated but not replayed as MaxAttempts == 1
+	err := v.GetDevices()
+	t.True(errors.Is(err, vitotrol.ErrSessionExpired))
+	t.CmpNoError(v.GetDevices())
+	t.CmpDeeply(srv.Calls("Login"), 2)
+}
@@ -0,0 +1,290 @@
+package vitotrol
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultWatchInterval is the default duration between two polls of a
+// Watcher.
+const DefaultWatchInterval = time.Minute
+
+// ChangeEvent describes the change of the value of an attribute of a
+// device, as detected by a Watcher.
+type ChangeEvent struct {
+	Device  *Device
+	AttrID  AttrID
+	Ref     *AttrRef // nil if AttrID is not in the device registry
+	Old     Value    // zero if Initial is true
+	New     Value
+	Initial bool // first value read, see WithInitialEvents option
+}
+
+// Name returns the name of the changed attribute.
+func (e *ChangeEvent) Name() string {
+	if e.Ref != nil {
+		return e.Ref.Name
+	}
+	return fmt.Sprintf("0x%04x", uint16(e.AttrID))
+}
+
+func (e *ChangeEvent) native(value Value) interface{} {
+	if e.Ref == nil {
+		return nil
+	}
+	native, err := e.Ref.Type.Vitodata2NativeValue(value.Value)
+	if err != nil {
+		return nil
+	}
+	return native
+}
+
+// OldNative returns the old value converted to its native type (see
+// VitodataType.Vitodata2NativeValue), or nil if Initial is true, Ref
+// is nil or the conversion fails.
+func (e *ChangeEvent) OldNative() interface{} {
+	if e.Initial {
+		return nil
+	}
+	return e.native(e.Old)
+}
+
+// NewNative returns the new value converted to its native type (see
+// VitodataType.Vitodata2NativeValue), or nil if Ref is nil or the
+// conversion fails.
+func (e *ChangeEvent) NewNative() interface{} {
+	return e.native(e.New)
+}
+
+// String returns a string describing the change.
+func (e *ChangeEvent) String() string {
+	if e.Initial {
+		return fmt.Sprintf("%s: %s@%s", e.Name(), e.New.Value, e.New.Time)
+	}
+	return fmt.Sprintf("%s: %s@%s -> %s@%s",
+		e.Name(), e.Old.Value, e.Old.Time, e.New.Value, e.New.Time)
+}
+
+// Watcher polls attributes of a device and reports their changes as
+// ChangeEvent. Use Device.NewWatcher to create one.
+type Watcher struct {
+	session   *Session
+	device    *Device
+	attrs     []AttrID
+	interval  time.Duration
+	refresh   bool
+	initial   bool
+	deadbands map[AttrID]float64
+	handler   func(ChangeEvent)
+	events    chan ChangeEvent
+
+	mu   sync.Mutex // protects last
+	last map[AttrID]Value
+}
+
+// A WatcherOption allows to customize a Watcher created by
+// Device.NewWatcher.
+type WatcherOption func(*Watcher)
+
+// WithWatchInterval sets the duration between two polls of Run
+// method instead of DefaultWatchInterval.
+func WithWatchInterval(interval time.Duration) WatcherOption {
+	return func(w *Watcher) {
+		w.interval = interval
+	}
+}
+
+// WithWatchRefresh tells whether the attributes are refreshed, using
+// RefreshDataWait, before being read. It is true by default.
+func WithWatchRefresh(refresh bool) WatcherOption {
+	return func(w *Watcher) {
+		w.refresh = refresh
+	}
+}
+
+// WithDeadband makes the watcher ignore the changes of attribute
+// attrID less than deadband since the last reported value. It only
+// applies to VitodataDouble attributes.
+func WithDeadband(attrID AttrID, deadband float64) WatcherOption {
+	return func(w *Watcher) {
+		w.deadbands[attrID] = deadband
+	}
+}
+
+// WithChangeHandler makes the watcher call handler for each change,
+// instead of sending it to the Events channel. handler is called
+// from the goroutine calling Poll or Run.
+func WithChangeHandler(handler func(ChangeEvent)) WatcherOption {
+	return func(w *Watcher) {
+		w.handler = handler
+	}
+}
+
+// WithInitialEvents tells whether the first value read of each
+// attribute is reported, with Initial field set. It is false by
+// default.
+func WithInitialEvents(initial bool) WatcherOption {
+	return func(w *Watcher) {
+		w.initial = initial
+	}
+}
+
+// NewWatcher returns a new Watcher of attributes attrs of d using
+// session v.
+func (d *Device) NewWatcher(v *Session, attrs []AttrID, opts ...WatcherOption) *Watcher {
+	w := &Watcher{
+		session:   v,
+		device:    d,
+		attrs:     attrs,
+		interval:  DefaultWatchInterval,
+		refresh:   true,
+		deadbands: map[AttrID]float64{},
+		last:      make(map[AttrID]Value, len(attrs)),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	if w.handler == nil {
+		w.events = make(chan ChangeEvent, len(attrs))
+	}
+	return w
+}
+
+// Events returns the channel on which changes are sent, or nil if
+// WithChangeHandler option is used. It is closed when Run returns.
+//
+// As long as events are not received, Poll and Run are blocked.
+func (w *Watcher) Events() <-chan ChangeEvent {
+	return w.events
+}
+
+// Run polls the device immediately, then each interval until ctx is
+// done. Poll errors are logged by the session logger. Run closes the
+// Events channel before returning, so it can only be called once. It
+// always returns ctx.Err().
+func (w *Watcher) Run(ctx context.Context) error {
+	if w.events != nil {
+		defer close(w.events)
+	}
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		err := w.Poll(ctx)
+		if err != nil && ctx.Err() == nil {
+			w.session.logger().Warn("watch poll failed",
+				"device", w.device.DeviceID, "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll refreshes (see WithWatchRefresh option) then reads the watched
+// attributes once, and reports their changes. If the refresh fails,
+// the values cached by the server are read anyway, and the refresh
+// error is returned.
+func (w *Watcher) Poll(ctx context.Context) error {
+	var refreshErr error
+	if w.refresh {
+		ch, err := w.device.RefreshDataWaitContext(ctx, w.session, w.attrs)
+		if err == nil {
+			err = <-ch
+		}
+		refreshErr = err
+	}
+
+	err := w.device.GetDataContext(ctx, w.session, w.attrs)
+	if err != nil {
+		return err
+	}
+
+	for _, event := range w.changes() {
+		if w.handler != nil {
+			w.handler(event)
+			continue
+		}
+		select {
+		case w.events <- event:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return refreshErr
+}
+
+// changes compares the device cache with the last reported values.
+func (w *Watcher) changes() []ChangeEvent {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	registry := w.device.Registry()
+
+	var events []ChangeEvent
+	for _, attrID := range w.attrs {
+		value, ok := w.device.Attribute(attrID)
+		if !ok {
+			continue
+		}
+
+		ref := registry.Ref(attrID)
+		old, seen := w.last[attrID]
+		if !seen {
+			w.last[attrID] = value
+			if w.initial {
+				events = append(events, ChangeEvent{
+					Device:  w.device,
+					AttrID:  attrID,
+					Ref:     ref,
+					New:     value,
+					Initial: true,
+				})
+			}
+			continue
+		}
+
+		if value.Value == old.Value || w.inDeadband(attrID, ref, old, value) {
+			continue
+		}
+
+		w.last[attrID] = value
+		events = append(events, ChangeEvent{
+			Device: w.device,
+			AttrID: attrID,
+			Ref:    ref,
+			Old:    old,
+			New:    value,
+		})
+	}
+	return events
+}
+
+func (w *Watcher) inDeadband(attrID AttrID, ref *AttrRef, old, value Value) bool {
+	deadband, ok := w.deadbands[attrID]
+	if !ok || ref == nil {
+		return false
+	}
+	if _, ok = ref.Type.(*VitodataDouble); !ok {
+		return false
+	}
+
+	oldNum, err := strconv.ParseFloat(old.Value, 64)
+	if err != nil {
+		return false
+	}
+	num, err := strconv.ParseFloat(value.Value, 64)
+	if err != nil {
+		return false
+	}
+	return math.Abs(num-oldNum) < deadband
+}
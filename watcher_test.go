@@ -0,0 +1,224 @@
+package vitotrol
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	td "github.com/maxatome/go-testdeep"
+)
+
+// watchServer answers GetData requests with values that can be
+// changed during the test.
+type watchServer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	values map[AttrID]string
+	tm     Time
+}
+
+func newWatchServer(values map[AttrID]string) *watchServer {
+	ws := &watchServer{values: values, tm: testTime}
+	ws.Server = httptest.NewServer(http.HandlerFunc(ws.handle))
+	return ws
+}
+
+func (ws *watchServer) set(attrID AttrID, value string) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	ws.values[attrID] = value
+	ws.tm = Time(time.Time(ws.tm).Add(time.Minute))
+}
+
+func (ws *watchServer) handle(w http.ResponseWriter, r *http.Request) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	soapAction := r.Header.Get("SOAPAction")
+	soapAction = soapAction[strings.LastIndex(soapAction, "/")+1:]
+
+	content := `<Ergebnis>0</Ergebnis>`
+	switch soapAction {
+	case "GetData":
+		var buf strings.Builder
+		buf.WriteString(`<Ergebnis>0</Ergebnis><DatenwerteListe>`)
+		for attrID, value := range ws.values {
+			fmt.Fprintf(&buf,
+				`<WerteListe><DatenpunktId>%d</DatenpunktId><Wert>%s</Wert><Zeitstempel>%s</Zeitstempel></WerteListe>`,
+				attrID, value, ws.tm)
+		}
+		buf.WriteString(`</DatenwerteListe>`)
+		content = buf.String()
+
+	case "RefreshData":
+		content += `<AktualisierungsId>42</AktualisierungsId>`
+
+	case "RequestRefreshStatus":
+		content += `<Status>4</Status>`
+	}
+
+	fmt.Fprintln(w, respHeader+intoDeviceResponse(soapAction, content)+respFooter)
+}
+
+func TestWatcher(tt *testing.T) {
+	t := td.NewT(tt)
+
+	ws := newWatchServer(map[AttrID]string{
+		AussenTemp:      "10",
+		BrennerStatus:   "0",
+		AktuellerFehler: "",
+	})
+	defer ws.Close()
+
+	v := NewSession(WithURL(ws.URL))
+	d := &Device{DeviceID: testDeviceID, LocationID: testLocationID}
+	ctx := context.Background()
+
+	w := d.NewWatcher(v, []AttrID{AussenTemp, BrennerStatus, AktuellerFehler},
+		WithWatchRefresh(false),
+		WithDeadband(AussenTemp, 0.5),
+		WithDeadband(BrennerStatus, 10)) // ignored as not a double
+
+	recv := func() (events []ChangeEvent) {
+		for {
+			select {
+			case event := <-w.Events():
+				events = append(events, event)
+			default:
+				return
+			}
+		}
+	}
+
+	// First poll: no initial events by default
+	t.CmpNoError(w.Poll(ctx))
+	t.Nil(recv())
+
+	// No change
+	t.CmpNoError(w.Poll(ctx))
+	t.Nil(recv())
+
+	ws.set(BrennerStatus, "1")
+	ws.set(AussenTemp, "10.25") // in deadband
+	t.CmpNoError(w.Poll(ctx))
+	events := recv()
+	if t.Len(events, 1) {
+		event := events[0]
+		t.Cmp(event, ChangeEvent{
+			Device: d,
+			AttrID: BrennerStatus,
+			Ref:    AttributesRef[BrennerStatus],
+			Old:    Value{Value: "0", Time: testTime},
+			New:    Value{Value: "1", Time: Time(time.Time(testTime).Add(2 * time.Minute))},
+		})
+		t.Cmp(event.Name(), "BrennerStatus")
+		t.Cmp(event.OldNative(), uint64(0))
+		t.Cmp(event.NewNative(), uint64(1))
+		t.Cmp(event.String(),
+			"BrennerStatus: 0@2016-10-30 12:13:14 -> 1@2016-10-30 12:15:14")
+	}
+
+	// Deadband is relative to the last reported value
+	ws.set(AussenTemp, "10.5")
+	ws.set(AktuellerFehler, "F4")
+	t.CmpNoError(w.Poll(ctx))
+	t.Cmp(recv(), []ChangeEvent{
+		{
+			Device: d,
+			AttrID: AussenTemp,
+			Ref:    AttributesRef[AussenTemp],
+			Old:    Value{Value: "10", Time: testTime},
+			New:    Value{Value: "10.5", Time: Time(time.Time(testTime).Add(4 * time.Minute))},
+		},
+		{
+			Device: d,
+			AttrID: AktuellerFehler,
+			Ref:    AttributesRef[AktuellerFehler],
+			Old:    Value{Value: "", Time: testTime},
+			New:    Value{Value: "F4", Time: Time(time.Time(testTime).Add(4 * time.Minute))},
+		},
+	})
+}
+
+func TestWatcherRun(tt *testing.T) {
+	t := td.NewT(tt)
+
+	ws := newWatchServer(map[AttrID]string{AussenTemp: "10"})
+	defer ws.Close()
+
+	v := NewSession(WithURL(ws.URL), withTestPollPolicy(0, time.Minute))
+	d := &Device{DeviceID: testDeviceID, LocationID: testLocationID}
+
+	// Callback
+	var (
+		mu     sync.Mutex
+		events []ChangeEvent
+	)
+	w := d.NewWatcher(v, []AttrID{AussenTemp},
+		WithWatchInterval(time.Millisecond),
+		WithInitialEvents(true),
+		WithChangeHandler(func(event ChangeEvent) {
+			mu.Lock()
+			events = append(events, event)
+			mu.Unlock()
+		}))
+	t.Nil(w.Events())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Run(ctx) }()
+
+	waitEvents := func(n int) []ChangeEvent {
+		for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+			mu.Lock()
+			if len(events) >= n {
+				ret := append([]ChangeEvent(nil), events...)
+				mu.Unlock()
+				return ret
+			}
+			mu.Unlock()
+		}
+		return nil
+	}
+
+	got := waitEvents(1)
+	if t.Len(got, 1) {
+		t.True(got[0].Initial)
+		t.Nil(got[0].OldNative())
+		t.Cmp(got[0].NewNative(), 10.0)
+		t.Cmp(got[0].String(), "AussenTemp: 10@2016-10-30 12:13:14")
+	}
+
+	ws.set(AussenTemp, "11")
+	got = waitEvents(2)
+	if t.Len(got, 2) {
+		t.Cmp(got[1].Old.Value, "10")
+		t.Cmp(got[1].New.Value, "11")
+	}
+
+	cancel()
+	t.Cmp(<-done, context.Canceled)
+
+	// Channel, closed at the end of Run
+	w = d.NewWatcher(v, []AttrID{AussenTemp},
+		WithWatchInterval(time.Millisecond), WithInitialEvents(true))
+
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() { done <- w.Run(ctx) }()
+
+	event, ok := <-w.Events()
+	t.True(ok)
+	t.Cmp(event.New.Value, "11")
+
+	cancel()
+	t.Cmp(<-done, context.Canceled)
+	_, ok = <-w.Events()
+	t.False(ok)
+}
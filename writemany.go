@@ -0,0 +1,231 @@
+package vitotrol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultRollbackTimeout is the max duration of the roll back done by
+// Device.WriteMany when not set by WithRollbackTimeout option.
+const DefaultRollbackTimeout = 2 * time.Minute
+
+// ErrWriteSkipped is the error of the writes not issued by
+// Device.WriteMany because a previous one failed to be issued.
+var ErrWriteSkipped = errors.New("not written as a previous write failed")
+
+// AttrValue is an attribute and its Vitodata™ formatted value, as
+// written by Device.WriteMany.
+type AttrValue struct {
+	AttrID AttrID
+	Value  string
+}
+
+// WriteResult is the result of the write of one attribute by
+// Device.WriteMany.
+type WriteResult struct {
+	AttrID AttrID
+	Name   string // attribute name, or its hexadecimal ID if unknown
+	Value  string // written Vitodata™ formatted value
+	// Previous is the value of the attribute last read before the
+	// write, nil if it has never been read.
+	Previous *Value
+	// Operation follows the write, nil if it has not been issued.
+	Operation *Operation
+	// Err is the error of the write, nil if it has been successfully
+	// done.
+	Err error
+	// RolledBack is true if Previous value has been successfully
+	// written back (see WithRollback option).
+	RolledBack bool
+	// RollbackErr is the error of the roll back, if any.
+	RollbackErr error
+}
+
+// WriteReport is the per-attribute report of Device.WriteMany, in the
+// order of the written values.
+type WriteReport []WriteResult
+
+// Err returns the error of the first failed write, prefixed by its
+// attribute name, nil if all writes have been successfully done.
+func (r WriteReport) Err() error {
+	for _, res := range r {
+		if res.Err != nil {
+			return fmt.Errorf("%s: %w", res.Name, res.Err)
+		}
+	}
+	return nil
+}
+
+// A WriteManyOption allows to customize Device.WriteMany.
+type WriteManyOption func(*writeManyConfig)
+
+type writeManyConfig struct {
+	rollback        bool
+	rollbackTimeout time.Duration
+	opOpts          []OperationOption
+}
+
+// WithRollback tells whether, if any write fails, the already
+// successfully written attributes are written back to their previous
+// value. It is false by default.
+func WithRollback(rollback bool) WriteManyOption {
+	return func(c *writeManyConfig) {
+		c.rollback = rollback
+	}
+}
+
+// WithRollbackTimeout sets the max duration of the roll back (see
+// WithRollback option), instead of DefaultRollbackTimeout. As the
+// roll back is done even if the WriteMany context is done, it is
+// only bounded by this timeout.
+func WithRollbackTimeout(timeout time.Duration) WriteManyOption {
+	return func(c *writeManyConfig) {
+		c.rollbackTimeout = timeout
+	}
+}
+
+// WithOperationOptions sets the options of the Operation following
+// each write, including the roll back ones.
+func WithOperationOptions(opts ...OperationOption) WriteManyOption {
+	return func(c *writeManyConfig) {
+		c.opOpts = append(c.opOpts, opts...)
+	}
+}
+
+// WriteMany writes several attributes at once. All values are first
+// validated (see AttrRef.Validate), and if any is rejected, its
+// *ValidationError is returned before anything is written. Then the
+// WriteData requests are issued in order, and their completions are
+// waited concurrently.
+//
+// If a WriteData request fails, the following ones are not issued
+// (see ErrWriteSkipped). If any write fails and WithRollback option
+// is enabled, the already done writes are undone by writing back the
+// previous value of their attribute, as last read by GetData. The
+// roll back is done even if ctx is done meanwhile, during at most
+// DefaultRollbackTimeout (see WithRollbackTimeout option).
+//
+// The returned error is the first write error (see WriteReport.Err),
+// the report detailing the result of each write.
+func (d *Device) WriteMany(ctx context.Context, v *Session, values []AttrValue, opts ...WriteManyOption) (WriteReport, error) {
+	conf := writeManyConfig{rollbackTimeout: DefaultRollbackTimeout}
+	for _, opt := range opts {
+		opt(&conf)
+	}
+
+	registry := d.Registry()
+	seen := make(map[AttrID]bool, len(values))
+	for _, av := range values {
+		if seen[av.AttrID] {
+			return nil, fmt.Errorf("attribute %s written twice", d.attrName(av.AttrID))
+		}
+		seen[av.AttrID] = true
+
+		if pRef := registry.Ref(av.AttrID); pRef != nil {
+			err := pRef.Validate(av.Value)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	report := make(WriteReport, len(values))
+	var issueErr error
+	for idx, av := range values {
+		res := &report[idx]
+		res.AttrID = av.AttrID
+		res.Name = d.attrName(av.AttrID)
+		res.Value = av.Value
+		if prev, ok := d.Attribute(av.AttrID); ok {
+			res.Previous = &prev
+		}
+
+		if issueErr != nil {
+			res.Err = ErrWriteSkipped
+			continue
+		}
+		res.Operation, issueErr = d.WriteDataAsync(ctx, v, av.AttrID, av.Value, conf.opOpts...)
+		res.Err = issueErr
+	}
+
+	failed := waitWrites(report)
+
+	if failed && conf.rollback {
+		// Roll back even if ctx is done, as writes may have been done
+		rbCtx, cancel := context.WithTimeout(detachedContext{ctx}, conf.rollbackTimeout)
+		defer cancel()
+		d.rollback(rbCtx, v, report, conf.opOpts)
+	}
+	return report, report.Err()
+}
+
+// waitWrites waits for the end of all the issued writes of report,
+// setting their error. It returns true if any write failed. As the
+// operations are canceled with the WriteMany context, their end is
+// waited even if it is done, to know which ones are done.
+func waitWrites(report WriteReport) bool {
+	failed := false
+	for idx := range report {
+		res := &report[idx]
+		if res.Operation != nil {
+			<-res.Operation.Done()
+			res.Err = res.Operation.Err()
+		}
+		if res.Err != nil {
+			failed = true
+		}
+	}
+	return failed
+}
+
+// rollback writes back the previous value of the successfully written
+// attributes of report.
+func (d *Device) rollback(ctx context.Context, v *Session, report WriteReport, opts []OperationOption) {
+	ops := make([]*Operation, len(report))
+	for idx := range report {
+		res := &report[idx]
+		if res.Operation == nil || res.Err != nil {
+			continue
+		}
+		if res.Previous == nil {
+			res.RollbackErr = errors.New("no previous value to roll back to")
+			continue
+		}
+
+		v.logger().Info("rolling back attribute",
+			"attribute", res.Name, "value", res.Previous.Value)
+		ops[idx], res.RollbackErr = d.WriteDataAsync(ctx, v, res.AttrID, res.Previous.Value, opts...)
+	}
+
+	for idx, op := range ops {
+		if op != nil {
+			res := &report[idx]
+			res.RollbackErr = op.Wait(ctx)
+			res.RolledBack = res.RollbackErr == nil
+		}
+	}
+}
+
+// detachedContext is a context never done, but holding the values of
+// its parent.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
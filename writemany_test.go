@@ -0,0 +1,241 @@
+package vitotrol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	td "github.com/maxatome/go-testdeep"
+)
+
+var (
+	reDatapointID = regexp.MustCompile(`<DatapointId>(\d+)</DatapointId>`)
+	reWert        = regexp.MustCompile(`<Wert>([^<]*)</Wert>`)
+	reRefreshID   = regexp.MustCompile(`<AktualisierungsId>([^<]*)</AktualisierungsId>`)
+)
+
+// writeServer records the WriteData requests as "ATTR_ID=VALUE",
+// returning them as refresh IDs. RequestWriteStatus returns the
+// status of writes found in statuses, 4 (done) for others. Writes in
+// rejected are rejected by WriteData.
+type writeServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	writes []string
+}
+
+func newWriteServer(statuses map[string]int, rejected map[string]bool) *writeServer {
+	s := &writeServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action := r.Header.Get("SOAPAction")
+		action = action[strings.LastIndex(action, "/")+1:]
+		body, _ := io.ReadAll(r.Body)
+
+		var content string
+		switch action {
+		case "WriteData":
+			write := reDatapointID.FindSubmatch(body)[1]
+			write = append(append(write, '='), reWert.FindSubmatch(body)[1]...)
+			if rejected[string(write)] {
+				content = `<Ergebnis>42</Ergebnis><ErgebnisText>Rejected</ErgebnisText>`
+				break
+			}
+			s.mu.Lock()
+			s.writes = append(s.writes, string(write))
+			s.mu.Unlock()
+			content = fmt.Sprintf(`<Ergebnis>0</Ergebnis><AktualisierungsId>%s</AktualisierungsId>`, write)
+
+		default:
+			status, ok := statuses[string(reRefreshID.FindSubmatch(body)[1])]
+			if !ok {
+				status = 4
+			}
+			content = fmt.Sprintf(`<Ergebnis>0</Ergebnis><Status>%d</Status>`, status)
+		}
+		fmt.Fprintln(w, respHeader+intoDeviceResponse(action, content)+respFooter)
+	}))
+	return s
+}
+
+func (s *writeServer) Writes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writes
+}
+
+func TestWriteMany(tt *testing.T) {
+	t := td.NewT(tt)
+
+	ctx := context.Background()
+	values := []AttrValue{
+		{AttrID: BetriebsartM1, Value: "2"},
+		{AttrID: HeizNormalTempM1, Value: "21"},
+		{AttrID: HeizReduziertTempM1, Value: "17"},
+	}
+
+	newDevice := func() *Device {
+		return &Device{
+			DeviceID:   testDeviceID,
+			LocationID: testLocationID,
+			Attributes: map[AttrID]*Value{
+				BetriebsartM1:    {Value: "1"},
+				HeizNormalTempM1: {Value: "20"},
+			},
+		}
+	}
+
+	t.Run("done", func(t *td.T) {
+		ts := newWriteServer(nil, nil)
+		defer ts.Close()
+		v := NewSession(WithURL(ts.URL), withTestPollPolicy(0, time.Minute))
+
+		done := func(res WriteResult) td.TestDeep {
+			return td.Struct(res, td.StructFields{
+				"Operation": td.Smuggle(func(op *Operation) OperationState { return op.State() },
+					OperationDone),
+			})
+		}
+
+		report, err := newDevice().WriteMany(ctx, v, values, WithRollback(true))
+		t.CmpNoError(err)
+		t.Cmp(report, td.Slice(WriteReport{}, td.ArrayEntries{
+			0: done(WriteResult{
+				AttrID:   BetriebsartM1,
+				Name:     "BetriebsartM1",
+				Value:    "2",
+				Previous: &Value{Value: "1"},
+			}),
+			1: done(WriteResult{
+				AttrID:   HeizNormalTempM1,
+				Name:     "HeizNormalTempM1",
+				Value:    "21",
+				Previous: &Value{Value: "20"},
+			}),
+			2: done(WriteResult{
+				AttrID: HeizReduziertTempM1,
+				Name:   "HeizReduziertTempM1",
+				Value:  "17",
+			}),
+		}))
+		t.Cmp(ts.Writes(), []string{"92=2", "82=21", "85=17"})
+	})
+
+	t.Run("invalid", func(t *td.T) {
+		ts := newWriteServer(nil, nil)
+		defer ts.Close()
+		v := NewSession(WithURL(ts.URL))
+
+		report, err := newDevice().WriteMany(ctx, v, []AttrValue{
+			{AttrID: HeizNormalTempM1, Value: "21"},
+			{AttrID: HeizReduziertTempM1, Value: "95"},
+		})
+		t.Nil(report)
+		t.True(errors.Is(err, ErrInvalidValue))
+
+		_, err = newDevice().WriteMany(ctx, v, []AttrValue{
+			{AttrID: HeizNormalTempM1, Value: "21"},
+			{AttrID: HeizNormalTempM1, Value: "22"},
+		})
+		t.Cmp(err, td.String("attribute HeizNormalTempM1 written twice"))
+
+		t.Empty(ts.Writes(), "nothing written")
+	})
+
+	t.Run("rollback", func(t *td.T) {
+		ts := newWriteServer(map[string]int{"85=17": 7}, nil)
+		defer ts.Close()
+		v := NewSession(WithURL(ts.URL), withTestPollPolicy(0, time.Minute))
+
+		report, err := newDevice().WriteMany(ctx, v, values, WithRollback(true))
+		t.Cmp(err, td.String("HeizReduziertTempM1: Unexpected status 7"))
+		t.Cmp(report, td.Len(3))
+		t.True(report[0].RolledBack)
+		t.CmpNoError(report[0].RollbackErr)
+		t.True(report[1].RolledBack)
+		t.False(report[2].RolledBack)
+		t.CmpNoError(report[2].RollbackErr, "failed write, nothing to roll back")
+
+		t.Cmp(ts.Writes()[:3], []string{"92=2", "82=21", "85=17"})
+		t.Cmp(ts.Writes()[3:], td.Bag("92=1", "82=20"), "rolled back")
+
+		// Without previous value
+		d := newDevice()
+		delete(d.Attributes, BetriebsartM1)
+		report, err = d.WriteMany(ctx, v, values, WithRollback(true))
+		t.CmpError(err)
+		t.False(report[0].RolledBack)
+		t.Cmp(report[0].RollbackErr, td.String("no previous value to roll back to"))
+		t.True(report[1].RolledBack)
+	})
+
+	t.Run("rollback canceled", func(t *td.T) {
+		ts := newWriteServer(map[string]int{"82=21": 1}, nil) // pending forever
+		defer ts.Close()
+		v := NewSession(WithURL(ts.URL), withTestPollPolicy(0, time.Minute))
+
+		cancelCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		// Cancel as soon as the first write is done
+		report, err := newDevice().WriteMany(cancelCtx, v, values[:2],
+			WithRollback(true),
+			WithOperationOptions(WithProgress(func(op *Operation) {
+				if op.State() == OperationDone {
+					cancel()
+				}
+			})))
+		t.Cmp(err, td.String("HeizNormalTempM1: context canceled"))
+		t.CmpNoError(report[0].Err)
+		t.True(report[0].RolledBack, "rolled back despite cancellation")
+		t.CmpNoError(report[0].RollbackErr)
+		t.Cmp(report[1].Operation.State(), OperationCanceled)
+		t.False(report[1].RolledBack)
+		t.Cmp(ts.Writes(), []string{"92=2", "82=21", "92=1"})
+	})
+
+	t.Run("no rollback", func(t *td.T) {
+		ts := newWriteServer(map[string]int{"85=17": 7}, nil)
+		defer ts.Close()
+		v := NewSession(WithURL(ts.URL), withTestPollPolicy(0, time.Minute))
+
+		report, err := newDevice().WriteMany(ctx, v, values)
+		t.CmpError(err)
+		t.Cmp(report[2].Operation.State(), OperationFailed)
+		t.False(report[0].RolledBack)
+		t.Cmp(ts.Writes(), []string{"92=2", "82=21", "85=17"})
+	})
+
+	t.Run("skipped", func(t *td.T) {
+		ts := newWriteServer(nil, map[string]bool{"82=21": true})
+		defer ts.Close()
+		v := NewSession(WithURL(ts.URL), withTestPollPolicy(0, time.Minute))
+
+		var mu sync.Mutex
+		progress := 0
+		report, err := newDevice().WriteMany(ctx, v, values,
+			WithRollback(true),
+			WithOperationOptions(WithProgress(func(*Operation) {
+				mu.Lock()
+				progress++
+				mu.Unlock()
+			})))
+		t.Cmp(err, td.Re(`^HeizNormalTempM1: .*Rejected`))
+		t.Nil(report[1].Operation)
+		t.Nil(report[2].Operation)
+		t.Cmp(report[2].Err, ErrWriteSkipped)
+		t.True(report[0].RolledBack)
+		t.Cmp(ts.Writes(), []string{"92=2", "92=1"})
+
+		mu.Lock()
+		t.Cmp(progress, 2, "the write and its roll back")
+		mu.Unlock()
+	})
+}